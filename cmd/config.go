@@ -43,6 +43,7 @@ type ReceptorConfig struct {
 	TLSServer         []netceptor.TLSServerConfig      `mapstructure:"tls-servers"`
 	WorkCommands      []workceptor.CommandWorkerCfg    `mapstructure:"work-commands"`
 	WorkKubernetes    []*workceptor.KubeWorkerCfg      `mapstructure:"work-kubernetes"`
+	WorkContainers    []*workceptor.ContainerWorkerCfg `mapstructure:"work-containers"`
 	WorkSigning       workceptor.SigningKeyPrivateCfg  `mapstructure:"work-signing"`
 	WorkVerification  workceptor.VerifyingKeyPublicCfg `mapstructure:"work-verification"`
 	IPRouters         []services.IPRouterCfg
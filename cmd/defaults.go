@@ -113,6 +113,42 @@ func SetKubeWorkerDefaults(config *ReceptorConfig) {
 		if worker.StreamMethod == "" {
 			worker.StreamMethod = "logger"
 		}
+
+		if worker.StreamSidecarImage == "" {
+			worker.StreamSidecarImage = "busybox"
+		}
+
+		if worker.SecretMountPath == "" {
+			worker.SecretMountPath = "/receptor/secrets"
+		}
+
+		if worker.ConfigMountPath == "" {
+			worker.ConfigMountPath = "/receptor/config"
+		}
+
+		if worker.Placement == "" {
+			worker.Placement = "roundrobin"
+		}
+
+		if worker.OrphanPods == "" {
+			worker.OrphanPods = "ignore"
+		}
+	}
+}
+
+func SetContainerWorkerDefaults(config *ReceptorConfig) {
+	for _, worker := range config.WorkContainers {
+		if worker.Engine == "" {
+			worker.Engine = "podman"
+		}
+
+		if worker.Pull == "" {
+			worker.Pull = "missing"
+		}
+
+		if worker.StopTimeout == "" {
+			worker.StopTimeout = "10s"
+		}
 	}
 }
 
@@ -121,6 +157,7 @@ func SetReceptorConfigDefaults(config *ReceptorConfig) {
 	SetLogLevelDefaults(config)
 	SetNodeDefaults(config)
 	SetKubeWorkerDefaults(config)
+	SetContainerWorkerDefaults(config)
 }
 
 func SetBackendConfigDefaults(config *BackendConfig) {
@@ -105,6 +105,12 @@ func LoadFromPEMFile(filename string, osWrapper Oser) ([]interface{}, error) {
 				return nil, err
 			}
 			results = append(results, key)
+		case "EC PRIVATE KEY":
+			key, err := x509.ParseECPrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, key)
 		case "PRIVATE KEY":
 			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 			if err != nil {
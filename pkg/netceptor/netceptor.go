@@ -235,6 +235,8 @@ type WorkCommand struct {
 	WorkType string
 	// Secure true means receptor will verify the signature of the work submit payload
 	Secure bool
+	// InputFiles true means receptor accepts named input files alongside stdin in work submissions
+	InputFiles bool
 }
 
 // ServiceAdvertisement is the data associated with a service advertisement.
@@ -951,7 +953,7 @@ func (s *Netceptor) AddWorkCommand(command string, secure bool) error {
 	if command == "" {
 		return fmt.Errorf("must provide a name")
 	}
-	wC := WorkCommand{WorkType: command, Secure: secure}
+	wC := WorkCommand{WorkType: command, Secure: secure, InputFiles: true}
 	s.workCommandsLock.Lock()
 	defer s.workCommandsLock.Unlock()
 	s.workCommands = append(s.workCommands, wC)
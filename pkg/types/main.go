@@ -14,6 +14,8 @@ import (
 type NodeCfg struct {
 	ID                               string                       `description:"Node ID. Defaults to the local hostname." barevalue:"yes"`
 	DataDir                          string                       `description:"Directory in which to store node data." default:"/tmp/receptor"`
+	DataDirQuota                     int64                        `description:"Maximum size in bytes of work unit data in the data directory, or 0 for no limit." default:"0"`
+	CallbackHosts                    []string                     `description:"Hosts that http and https work callbacks may be posted to, besides loopback addresses."`
 	FirewallRules                    []netceptor.FirewallRuleData `description:"Firewall rules, see documentation for syntax."`
 	MaxIdleConnectionTimeout         string                       `description:"Maximum duration with no traffic before a backend connection is timed out and refreshed."`
 	ReceptorKubeSupportReconnect     string
@@ -66,6 +68,8 @@ func (cfg NodeCfg) Init() error {
 	if err != nil {
 		return err
 	}
+	workceptor.MainInstance.DataDirQuota = cfg.DataDirQuota
+	workceptor.MainInstance.CallbackHosts = cfg.CallbackHosts
 	controlsvc.MainInstance = controlsvc.New(true, netceptor.MainInstance)
 	err = workceptor.MainInstance.RegisterWithControlService(controlsvc.MainInstance)
 	if err != nil {
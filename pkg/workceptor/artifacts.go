@@ -0,0 +1,333 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UnitDirEnv is the environment variable giving a command the directory of its work unit.
+const UnitDirEnv = "RECEPTOR_UNIT_DIR"
+
+// artifactsDirName is the directory in a unit directory where artifacts fetched from a remote node are cached.
+const artifactsDirName = "artifacts"
+
+// artifactChunkSize is the size of the chunks in which an artifact is streamed.
+const artifactChunkSize = 64 * 1024
+
+// reservedUnitFiles are the files Receptor keeps in a unit directory, which are never collected as artifacts.
+var reservedUnitFiles = map[string]bool{
+	"stdin":       true,
+	"stdout":      true,
+	"stdout.idx":  true,
+	"stderr":      true,
+	"stderr.idx":  true,
+	"status":      true,
+	"status.lock": true,
+	"history":     true,
+	envFileName:   true,
+	// The state of a resumable upload, and the stdin it is receiving.
+	uploadFileName:      true,
+	uploadStateFileName: true,
+}
+
+// reservedUnitDirs are the directories Receptor keeps in a unit directory, whose files are never collected as
+// artifacts: the input files submitted with the unit, and artifacts cached from a remote node.
+var reservedUnitDirs = []string{inputsDirName, artifactsDirName}
+
+// isReservedUnitFile returns true if a file, named relative to the unit directory, is kept by Receptor.
+func isReservedUnitFile(name string) bool {
+	if reservedUnitFiles[name] {
+		return true
+	}
+	for _, dir := range reservedUnitDirs {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Artifact is a file produced by a work unit that can be retrieved once the unit completes.
+type Artifact struct {
+	Name string
+	Size int64
+}
+
+// checkArtifactGlob returns an error if a glob cannot be used to collect artifacts from a unit directory.
+func checkArtifactGlob(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	if filepath.IsAbs(pattern) {
+		return fmt.Errorf("artifacts glob %s must be relative to the unit directory", pattern)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(pattern), "/") {
+		if elem == ".." {
+			return fmt.Errorf("artifacts glob %s must not leave the unit directory", pattern)
+		}
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid artifacts glob %s: %s", pattern, err)
+	}
+
+	return nil
+}
+
+// collectArtifacts returns the regular files in a unit directory that match a glob, sorted by name.
+func collectArtifacts(unitdir string, pattern string) ([]Artifact, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(unitdir, pattern))
+	if err != nil {
+		return nil, err
+	}
+	realUnitDir, err := filepath.EvalSymlinks(unitdir)
+	if err != nil {
+		return nil, err
+	}
+	artifacts := make([]Artifact, 0, len(matches))
+	for _, match := range matches {
+		name, err := filepath.Rel(unitdir, match)
+		if err != nil {
+			return nil, err
+		}
+		name = filepath.ToSlash(name)
+		if isReservedUnitFile(name) {
+			continue
+		}
+		fi, err := os.Lstat(match)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() || !insideDir(realUnitDir, match) {
+			continue
+		}
+		artifacts = append(artifacts, Artifact{Name: name, Size: fi.Size()})
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].Name < artifacts[j].Name
+	})
+
+	return artifacts, nil
+}
+
+// insideDir returns true if a path, with any symbolic links resolved, is inside a directory whose symbolic links
+// have already been resolved.  Files a command can write may have been replaced by links leading elsewhere.
+func insideDir(realDir string, filename string) bool {
+	realFilename, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(realDir, realFilename)
+
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// openInsideDir opens a regular file in a directory, refusing files reached through symbolic links.
+func openInsideDir(dir string, name string) (*os.File, error) {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	filename := filepath.Join(realDir, name)
+	realFilename, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return nil, err
+	}
+	if realFilename != filename {
+		return nil, fmt.Errorf("%s is not a regular file in the unit directory", name)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := file.Stat()
+	if err == nil {
+		var current os.FileInfo
+		current, err = os.Lstat(filename)
+		if err == nil && (!opened.Mode().IsRegular() || !os.SameFile(opened, current)) {
+			err = fmt.Errorf("%s is not a regular file in the unit directory", name)
+		}
+	}
+	if err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// findArtifact returns the artifact of a unit with the given name.
+func findArtifact(status *StatusFileData, name string) (Artifact, error) {
+	for _, artifact := range status.Artifacts {
+		if artifact.Name == name {
+			return artifact, nil
+		}
+	}
+
+	return Artifact{}, fmt.Errorf("unknown artifact %s", name)
+}
+
+// UnitArtifacts returns the artifacts collected from a unit.
+func (w *Workceptor) UnitArtifacts(unitID string) ([]Artifact, error) {
+	status, err := w.UnitStatus(unitID)
+	if err != nil {
+		return nil, err
+	}
+	if status.Artifacts == nil {
+		return []Artifact{}, nil
+	}
+
+	return status.Artifacts, nil
+}
+
+// openArtifact opens an artifact of a unit for reading.  The artifacts of a remote unit are fetched from the
+// remote node the first time they are read.
+func (w *Workceptor) openArtifact(ctx context.Context, unitID string, name string) (*os.File, error) {
+	unit, err := w.findUnit(unitID)
+	if err != nil {
+		return nil, err
+	}
+	artifact, err := findArtifact(unit.Status(), name)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsLocal(artifact.Name) {
+		return nil, fmt.Errorf("invalid artifact name %s", artifact.Name)
+	}
+	rw, ok := unit.(*remoteUnit)
+	if !ok {
+		return openInsideDir(unit.UnitDir(), artifact.Name)
+	}
+	cached := path.Join(rw.UnitDir(), artifactsDirName, artifact.Name)
+	file, err := os.Open(cached)
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	err = rw.fetchArtifact(ctx, artifact, cached)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching artifact %s from remote node: %s", name, err)
+	}
+
+	return os.Open(cached)
+}
+
+// artifactStream streams the contents of an artifact.
+func (w *Workceptor) artifactStream(ctx context.Context, unitID string, name string) (chan []byte, error) {
+	file, err := w.openArtifact(ctx, unitID, name)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		defer file.Close()
+		for {
+			buf := make([]byte, artifactChunkSize)
+			n, err := file.Read(buf)
+			if n > 0 {
+				select {
+				case out <- buf[:n]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					w.nc.GetLogger().Error("Error reading artifact %s of %s: %s", name, unitID, err)
+				}
+
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// fetchArtifact downloads an artifact of the remote unit to a local file.
+func (rw *remoteUnit) fetchArtifact(ctx context.Context, artifact Artifact, filename string) error {
+	red, ok := rw.Status().ExtraData.(*RemoteExtraData)
+	if !ok {
+		return fmt.Errorf("remote ExtraData missing")
+	}
+	if red.RemoteUnitID == "" {
+		return fmt.Errorf("remote unit was never started")
+	}
+	conn, reader, err := rw.connectToRemote(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.(interface{ CloseConnection() error }).CloseConnection()
+	err = rw.requestArtifact(conn, red, artifact.Name)
+	if err != nil {
+		return err
+	}
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(header, "ERROR") {
+		return fmt.Errorf("%s", strings.TrimSpace(strings.TrimPrefix(header, "ERROR:")))
+	}
+	err = os.MkdirAll(path.Dir(filename), 0o700)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(path.Dir(filename), ".fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = io.CopyN(temp, reader, artifact.Size)
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), filename)
+}
+
+// requestArtifact sends the command asking the remote node for an artifact.
+func (rw *remoteUnit) requestArtifact(conn net.Conn, red *RemoteExtraData, name string) error {
+	cmd := map[string]interface{}{
+		"command":    "work",
+		"subcommand": "artifacts",
+		"unitid":     red.RemoteUnitID,
+		"name":       name,
+	}
+	if red.SignWork {
+		signature, err := rw.GetWorkceptor().createSignature(red.RemoteNode, red.RemoteWorkType,
+			workCommandSHA256("artifacts", red.RemoteUnitID))
+		if err != nil {
+			return err
+		}
+		cmd["signature"] = signature
+	}
+	cmdBytes, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(cmdBytes, '\n'))
+
+	return err
+}
@@ -0,0 +1,148 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestCheckArtifactGlob(t *testing.T) {
+	for _, good := range []string{"", "*.json", "out/*", "report-[0-9].txt"} {
+		if err := checkArtifactGlob(good); err != nil {
+			t.Errorf("unexpected error for %q: %s", good, err)
+		}
+	}
+	for _, bad := range []string{"/tmp/*", "../*", "out/../../x", "[", "a["} {
+		if err := checkArtifactGlob(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestCollectArtifacts(t *testing.T) {
+	unitdir := t.TempDir()
+	files := map[string]string{
+		"stdout":          "output",
+		"status":          "{}",
+		"report.json":     "{\"ok\":true}",
+		"out/log.txt":     "log",
+		"artifacts/x.txt": "cached",
+		"inputs/vars":     "input",
+		"upload":          "partial stdin",
+		"upload.json":     "{}",
+	}
+	for name, content := range files {
+		filename := path.Join(unitdir, name)
+		if err := os.MkdirAll(path.Dir(filename), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/etc/passwd", path.Join(unitdir, "link.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	artifacts, err := collectArtifacts(unitdir, "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(artifacts, []Artifact{{"report.json", 11}}) {
+		t.Errorf("unexpected artifacts %v", artifacts)
+	}
+	artifacts, err = collectArtifacts(unitdir, "*/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(artifacts, []Artifact{{"out/log.txt", 3}}) {
+		t.Errorf("unexpected artifacts %v", artifacts)
+	}
+	artifacts, err = collectArtifacts(unitdir, "inputs/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(artifacts) != 0 {
+		t.Errorf("expected no artifacts from the input files, got %v", artifacts)
+	}
+	artifacts, err = collectArtifacts(unitdir, "")
+	if err != nil || artifacts != nil {
+		t.Errorf("expected no artifacts without a glob, got %v, %v", artifacts, err)
+	}
+
+	// A directory replaced by a link must not expose the files it leads to.
+	if err := os.Symlink("/etc", path.Join(unitdir, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	artifacts, err = collectArtifacts(unitdir, "linked/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(artifacts) != 0 {
+		t.Errorf("expected no artifacts through a linked directory, got %v", artifacts)
+	}
+	if file, err := openInsideDir(unitdir, "linked/passwd"); err == nil {
+		file.Close()
+		t.Error("expected error opening a file through a linked directory")
+	}
+	file, err := openInsideDir(unitdir, "out/log.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+}
+
+func TestArtifactStream(t *testing.T) {
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	err = w.RegisterWorker("command", newCommandWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, artifactChunkSize+10)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	if err := os.WriteFile(path.Join(unit.UnitDir(), "report.bin"), content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateFullStatus(func(status *StatusFileData) {
+		status.Artifacts = []Artifact{{"report.bin", int64(len(content))}}
+	})
+
+	artifacts, err := w.UnitArtifacts(unit.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(artifacts, []Artifact{{"report.bin", int64(len(content))}}) {
+		t.Errorf("unexpected artifacts %v", artifacts)
+	}
+	stream, err := w.artifactStream(context.Background(), unit.ID(), "report.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make([]byte, 0)
+	for chunk := range stream {
+		received = append(received, chunk...)
+	}
+	if !reflect.DeepEqual(received, content) {
+		t.Errorf("streamed %d bytes that differ from the artifact", len(received))
+	}
+	if _, err := w.artifactStream(context.Background(), unit.ID(), "stdout"); err == nil {
+		t.Error("expected error for a file that is not an artifact")
+	}
+}
@@ -0,0 +1,321 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// CallbackSignatureHeader is the HTTP header carrying the signature of a callback, when work signing is configured.
+const CallbackSignatureHeader = "Receptor-Signature"
+
+var (
+	// callbackAttempts is how many times delivery of a callback is attempted before giving up.
+	callbackAttempts = 5
+	// callbackRetryDelay is the delay before the first retry, which doubles for each subsequent retry.
+	callbackRetryDelay = 2 * time.Second
+	// callbackTimeout bounds each delivery attempt.
+	callbackTimeout = 30 * time.Second
+)
+
+// CallbackPayload is the body of the request sent to a callback when a work unit completes.
+type CallbackPayload struct {
+	UnitID string
+	NodeID string
+	Status *StatusFileData
+}
+
+// CallbackClaims are the claims of the JWT signing a callback.
+type CallbackClaims struct {
+	PayloadSHA256 string `json:"payload_sha256"`
+	jwt.RegisteredClaims
+}
+
+// callbackTarget is a parsed callback address.  Node and service are set for callbacks over the Receptor mesh, and
+// host for http and https callbacks.
+type callbackTarget struct {
+	url     string
+	node    string
+	service string
+	host    string
+}
+
+// parseCallback parses a callback address, which is either an http or https URL, or
+// receptor://<node>/<service>[/<path>] to post to an HTTP server behind a service on the mesh.
+func parseCallback(callback string) (*callbackTarget, error) {
+	u, err := url.Parse(callback)
+	if err != nil {
+		return nil, fmt.Errorf("invalid callback %s: %s", callback, err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid callback %s: missing host", callback)
+		}
+
+		return &callbackTarget{url: u.String(), host: u.Hostname()}, nil
+	case "receptor":
+		service, rest, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+		if u.Host == "" || service == "" {
+			return nil, fmt.Errorf("invalid callback %s: must be receptor://<node>/<service>[/<path>]", callback)
+		}
+		target := &url.URL{Scheme: "http", Host: u.Host, Path: "/" + rest, RawQuery: u.RawQuery}
+
+		return &callbackTarget{url: target.String(), node: u.Host, service: service}, nil
+	}
+
+	return nil, fmt.Errorf("invalid callback %s: scheme must be http, https or receptor", callback)
+}
+
+// checkCallback checks a callback given with a work submission.  Submissions over the network may only give callbacks
+// to a service on the mesh, so that they cannot make the node post to addresses only it can reach.  Local submissions
+// may also give http and https callbacks to an allowed host.
+func (w *Workceptor) checkCallback(callback string, connIsUnix bool) error {
+	target, err := parseCallback(callback)
+	if err != nil {
+		return err
+	}
+	if target.node != "" {
+		return nil
+	}
+	if !connIsUnix {
+		return fmt.Errorf("invalid callback %s: only receptor:// callbacks can be submitted over the network", callback)
+	}
+
+	return w.checkCallbackHost(target.host)
+}
+
+// checkCallbackHost returns an error unless http and https callbacks may be posted to a host: a loopback address, or
+// one of the CallbackHosts.
+func (w *Workceptor) checkCallbackHost(host string) error {
+	if w.isCallbackHost(host) || isLoopbackHost(host) {
+		return nil
+	}
+
+	return fmt.Errorf("callback host %s is not a loopback address or an allowed callback host", host)
+}
+
+// isCallbackHost returns whether a host is one of the CallbackHosts.
+func (w *Workceptor) isCallbackHost(host string) bool {
+	for _, h := range w.CallbackHosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isLoopbackHost returns whether a host is localhost or a loopback IP address.
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// dialLoopbackOnly refuses connections to addresses other than loopback addresses, whatever a host name resolved to.
+func dialLoopbackOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("callback address %s is not a loopback address", address)
+	}
+
+	return nil
+}
+
+// monitorCallbacks delivers the callbacks of units as they complete.  Units that completed while the node was
+// down are picked up when their status is first loaded.
+func (w *Workceptor) monitorCallbacks(sub *Subscription) {
+	defer sub.Close()
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if event.Released || !IsComplete(event.State) {
+				continue
+			}
+			unit, err := w.findUnit(event.UnitID)
+			if err != nil {
+				continue
+			}
+			status := unit.Status()
+			if status.Callback == "" || status.CallbackDelivered || status.CallbackError != "" {
+				continue
+			}
+			w.callbacksLock.Lock()
+			if w.callbacksInFlight[event.UnitID] {
+				w.callbacksLock.Unlock()
+
+				continue
+			}
+			w.callbacksInFlight[event.UnitID] = true
+			w.callbacksLock.Unlock()
+			go func() {
+				w.deliverCallback(unit)
+				w.callbacksLock.Lock()
+				delete(w.callbacksInFlight, unit.ID())
+				w.callbacksLock.Unlock()
+			}()
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliverCallback sends the final status of a unit to its callback, retrying with backoff, and records the outcome
+// in the status of the unit.
+func (w *Workceptor) deliverCallback(unit WorkUnit) {
+	status := unit.Status()
+	payload, err := json.Marshal(&CallbackPayload{
+		UnitID: unit.ID(),
+		NodeID: w.nc.NodeID(),
+		Status: status,
+	})
+	if err == nil {
+		var target *callbackTarget
+		target, err = parseCallback(status.Callback)
+		if err == nil && target.node == "" {
+			// The allowed hosts may have changed since the unit was submitted.
+			err = w.checkCallbackHost(target.host)
+		}
+		if err == nil {
+			delay := callbackRetryDelay
+			for attempt := status.CallbackAttempts + 1; ; attempt++ {
+				err = w.sendCallback(target, unit.ID(), payload)
+				unit.UpdateFullStatus(func(s *StatusFileData) {
+					s.CallbackAttempts = attempt
+					s.CallbackDelivered = err == nil
+				})
+				if err == nil {
+					w.nc.GetLogger().Debug("Delivered callback for work unit %s to %s", unit.ID(), status.Callback)
+
+					return
+				}
+				if attempt >= callbackAttempts {
+					break
+				}
+				w.nc.GetLogger().Warning("Error delivering callback for work unit %s to %s, retrying in %s: %s",
+					unit.ID(), status.Callback, delay, err)
+				if sleepOrDone(w.ctx.Done(), delay) {
+					// Delivery resumes when the node restarts.
+					return
+				}
+				delay *= 2
+			}
+		}
+	}
+	w.nc.GetLogger().Error("Could not deliver callback for work unit %s to %s: %s", unit.ID(), status.Callback, err)
+	unit.UpdateFullStatus(func(s *StatusFileData) {
+		s.CallbackError = err.Error()
+	})
+}
+
+// sendCallback makes one attempt at posting a callback payload.
+func (w *Workceptor) sendCallback(target *callbackTarget, unitID string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(w.ctx, callbackTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.SigningKey != "" {
+		signature, err := w.signCallback(unitID, payload)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(CallbackSignatureHeader, signature)
+	}
+	transport := &http.Transport{}
+	switch {
+	case target.node != "":
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return w.nc.DialContext(ctx, target.node, target.service, nil)
+		}
+	case !w.isCallbackHost(target.host):
+		transport.DialContext = (&net.Dialer{Control: dialLoopbackOnly}).DialContext
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		// Redirects are not followed, as they could lead anywhere.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// signCallback returns a JWT binding a callback payload to the unit and node it came from.
+func (w *Workceptor) signCallback(unitID string, payload []byte) (string, error) {
+	digest := sha256.Sum256(payload)
+	now := time.Now()
+	claims := &CallbackClaims{
+		PayloadSHA256: hex.EncodeToString(digest[:]),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    w.nc.NodeID(),
+			Subject:   unitID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(w.SigningExpiration)),
+		},
+	}
+
+	return w.signClaims(claims)
+}
+
+// VerifyCallbackSignature checks the signature of a callback payload against the public key in verifyingKeyFile,
+// and returns its claims.  Receivers of callbacks can use it to check that a callback is genuine.
+func VerifyCallbackSignature(verifyingKeyFile string, signature string, payload []byte) (*CallbackClaims, error) {
+	publicKey, err := loadKeyFile(verifyingKeyFile, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not load verifying key file: %s", err.Error())
+	}
+	claims := &CallbackClaims{}
+	token, err := jwt.ParseWithClaims(signature, claims, verifyingKeyFunc(publicKey))
+	if err != nil {
+		return nil, fmt.Errorf("could not verify signature: %s", err.Error())
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("token not valid")
+	}
+	digest := sha256.Sum256(payload)
+	if claims.PayloadSHA256 != hex.EncodeToString(digest[:]) {
+		return nil, fmt.Errorf("signature does not match the callback payload")
+	}
+
+	return claims, nil
+}
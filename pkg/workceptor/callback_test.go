@@ -0,0 +1,224 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestParseCallback(t *testing.T) {
+	tests := []struct {
+		callback string
+		url      string
+		node     string
+		service  string
+		host     string
+		wantErr  bool
+	}{
+		{callback: "http://controller:8080/done?x=1", url: "http://controller:8080/done?x=1", host: "controller"},
+		{callback: "HTTPS://controller/done", url: "https://controller/done", host: "controller"},
+		{callback: "receptor://ctl/hook/work/done", url: "http://ctl/work/done", node: "ctl", service: "hook"},
+		{callback: "receptor://ctl/hook", url: "http://ctl/", node: "ctl", service: "hook"},
+		{callback: "receptor://ctl", wantErr: true},
+		{callback: "http:///done", wantErr: true},
+		{callback: "ftp://controller/done", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.callback, func(t *testing.T) {
+			got, err := parseCallback(tt.callback)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCallback() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.url != tt.url || got.node != tt.node || got.service != tt.service || got.host != tt.host) {
+				t.Errorf("parseCallback() = %+v", *got)
+			}
+		})
+	}
+}
+
+func writeTestSigningKeys(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	privateFile := path.Join(dir, "private.pem")
+	publicFile := path.Join(dir, "public.pem")
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes})
+	if err := os.WriteFile(privateFile, privatePEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicFile, publicPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return privateFile, publicFile
+}
+
+func TestDeliverCallback(t *testing.T) {
+	savedDelay := callbackRetryDelay
+	callbackRetryDelay = 10 * time.Millisecond
+	defer func() { callbackRetryDelay = savedDelay }()
+
+	privateFile, publicFile := writeTestSigningKeys(t)
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	failures := 2
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if failures > 0 {
+			failures--
+			rw.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	w.SigningKey = privateFile
+	err = w.RegisterWorker("command", newCommandWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateFullStatus(func(status *StatusFileData) {
+		status.Callback = server.URL + "/done"
+	})
+	unit.UpdateBasicStatus(WorkStateSucceeded, "exit status 0", 3)
+
+	var r *http.Request
+	var body []byte
+	select {
+	case r = <-requests:
+		body = <-bodies
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for callback")
+	}
+	if r.URL.Path != "/done" {
+		t.Errorf("expected callback to /done, got %s", r.URL.Path)
+	}
+	payload := CallbackPayload{}
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.UnitID != unit.ID() || payload.NodeID != "test" || payload.Status.State != WorkStateSucceeded {
+		t.Errorf("unexpected payload %s", body)
+	}
+	claims, err := VerifyCallbackSignature(publicFile, r.Header.Get(CallbackSignatureHeader), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != unit.ID() || claims.Issuer != "test" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	_, err = VerifyCallbackSignature(publicFile, r.Header.Get(CallbackSignatureHeader), append(body, ' '))
+	if err == nil {
+		t.Error("expected signature not to match a modified payload")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := unit.Status()
+		if status.CallbackDelivered {
+			if status.CallbackAttempts != 3 {
+				t.Errorf("expected 3 attempts, got %d", status.CallbackAttempts)
+			}
+
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("callback not recorded as delivered: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCheckCallback(t *testing.T) {
+	w := &Workceptor{CallbackHosts: []string{"controller.example.com"}}
+	tests := []struct {
+		callback   string
+		connIsUnix bool
+		wantErr    bool
+	}{
+		{callback: "receptor://ctl/hook/done"},
+		{callback: "receptor://ctl/hook/done", connIsUnix: true},
+		{callback: "http://localhost:8080/done", wantErr: true},
+		{callback: "http://localhost:8080/done", connIsUnix: true},
+		{callback: "http://127.0.0.1/done", connIsUnix: true},
+		{callback: "http://[::1]/done", connIsUnix: true},
+		{callback: "https://CONTROLLER.example.com/done", connIsUnix: true},
+		{callback: "http://169.254.169.254/latest/meta-data", connIsUnix: true, wantErr: true},
+		{callback: "http://internal.example.com/done", connIsUnix: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.callback, func(t *testing.T) {
+			err := w.checkCallback(tt.callback, tt.connIsUnix)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkCallback(%q, %t) error = %v, wantErr %v", tt.callback, tt.connIsUnix, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCallbackRedirect(t *testing.T) {
+	redirected := make(chan struct{}, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		redirected <- struct{}{}
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Redirect(rw, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	cbTarget, err := parseCallback(server.URL + "/done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.sendCallback(cbTarget, "unit", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "307") {
+		t.Errorf("expected the redirect to fail delivery, got %v", err)
+	}
+	select {
+	case <-redirected:
+		t.Error("redirect was followed")
+	default:
+	}
+}
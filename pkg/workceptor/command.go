@@ -6,6 +6,7 @@ package workceptor
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -13,6 +14,8 @@ import (
 	"os/exec"
 	"os/signal"
 	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -57,13 +60,48 @@ type commandUnit struct {
 	command            string
 	baseParams         string
 	allowRuntimeParams bool
+	separateStderr     bool
+	compression        string
+	maxStdoutSize      int64
+	stdoutOverflow     string
+	artifacts          string
+	cleanEnv           bool
+	inheritEnv         []string
+	fixedEnv           map[string]string
+	allowRuntimeEnv    bool
+	runtimeEnv         map[string]string
+	user               string
+	group              string
+	allowedUsers       []string
+	sandbox            bool
 	done               bool
 }
 
+// UnitIDEnv is the environment variable giving a command the ID of its work unit.
+const UnitIDEnv = "RECEPTOR_UNIT_ID"
+
+// NodeIDEnv is the environment variable giving a command the ID of the node running it.
+const NodeIDEnv = "RECEPTOR_NODE_ID"
+
+// runtimeEnvParamPrefix prefixes the work parameters that set environment variables for a command.
+const runtimeEnvParamPrefix = "env_"
+
+// reservedEnvPrefixes prefix the names of environment variables that submitters cannot set: those of Receptor, and
+// those that change how the dynamic linker loads programs.
+var reservedEnvPrefixes = []string{"RECEPTOR_", "LD_", "DYLD_"}
+
+// envFileName is the file in a unit directory passing the environment of the command to the command runner, which
+// removes it once read.  The command runner itself runs with the environment of Receptor.
+const envFileName = "env"
+
+// envNameRegex is the form of the name of an environment variable.
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // CommandExtraData is the content of the ExtraData JSON field for a command worker.
 type CommandExtraData struct {
 	Pid    int
 	Params string
+	User   string `json:",omitempty"`
 }
 
 func termThenKill(cmd *exec.Cmd, doneChan chan bool) {
@@ -91,11 +129,11 @@ func cmdWaiter(cmd *exec.Cmd, doneChan chan bool) {
 }
 
 // commandRunner is run in a separate process, to monitor the subprocess and report back metadata.
-func commandRunner(command string, params string, unitdir string) error {
+func commandRunner(command string, params string, unitdir string, separateStderr bool, compression string, maxStdoutSize int64, stdoutOverflow string, artifacts string, runAs string, sandbox bool) error {
 	status := StatusFileData{}
 	status.ExtraData = &CommandExtraData{}
 	statusFilename := path.Join(unitdir, "status")
-	err := status.UpdateBasicStatus(statusFilename, WorkStatePending, "Not started yet", 0)
+	err := status.updateUnitBasicStatus(unitdir, WorkStatePending, "Not started yet", 0)
 	if err != nil {
 		MainInstance.nc.GetLogger().Error("Error updating status file %s: %s", statusFilename, err)
 	}
@@ -109,6 +147,53 @@ func commandRunner(command string, params string, unitdir string) error {
 		}
 		cmd = exec.Command(command, paramList...)
 	}
+	inputFiles, err := listInputFiles(unitdir)
+	if err != nil {
+		return err
+	}
+	switch {
+	case sandbox:
+		// The root user of the sandbox is the user the command is run as, or else the user running Receptor.
+		// The command can only write to the work directory, so it cannot touch the status and output files of the unit.
+		uid, gid, workDir := os.Getuid(), os.Getgid(), path.Join(unitdir, workDirName)
+		if runAs != "" {
+			ruid, rgid, err := parseRunAs(runAs)
+			if err != nil {
+				return err
+			}
+			uid, gid = int(ruid), int(rgid)
+		} else {
+			err = os.Mkdir(workDir, 0o700)
+			if err != nil && !os.IsExist(err) {
+				return err
+			}
+		}
+		cmd, err = sandboxCommand(command, params, unitdir, workDir, uid, gid)
+		if err != nil {
+			return err
+		}
+	case runAs != "":
+		uid, gid, err := parseRunAs(runAs)
+		if err != nil {
+			return err
+		}
+		err = cmdSetCredential(cmd, uid, gid)
+		if err != nil {
+			return err
+		}
+		cmd.Dir = path.Join(unitdir, workDirName)
+	}
+	cmd.Env, err = readCommandEnv(unitdir)
+	if err != nil {
+		return err
+	}
+	env := inputFileEnv(path.Join(unitdir, inputsDirName), inputFiles)
+	if artifacts != "" {
+		env[UnitDirEnv] = unitdir
+	}
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
 	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
 	stdin, err := os.Open(path.Join(unitdir, "stdin"))
@@ -145,16 +230,55 @@ func commandRunner(command string, params string, unitdir string) error {
 	} else {
 		cmd.Stdin = stdin
 	}
-	stdout, err := os.OpenFile(path.Join(unitdir, "stdout"), os.O_CREATE+os.O_WRONLY+os.O_SYNC, 0o600)
+	stdout, err := openResultWriter(path.Join(unitdir, StreamStdout), compression)
 	if err != nil {
 		return err
 	}
+	outputs := []io.Closer{stdout}
+	exceededChan := make(chan struct{})
+	var onExceed func()
+	if strings.EqualFold(stdoutOverflow, StdoutOverflowFail) {
+		onExceed = func() {
+			close(exceededChan)
+		}
+	}
+	stdout = newLimitWriter(stdout, maxStdoutSize, onExceed)
+	closeOutputs := func() {
+		// Compressed outputs buffer data, which must be written out before sizes are reported.
+		for _, c := range outputs {
+			err := c.Close()
+			if err != nil {
+				MainInstance.nc.GetLogger().Error("Error closing output of %s: %s", unitdir, err)
+			}
+		}
+	}
 	cmd.Stdout = stdout
 	cmd.Stderr = stdout
+	if separateStderr {
+		stderr, err := openResultWriter(path.Join(unitdir, StreamStderr), compression)
+		if err != nil {
+			closeOutputs()
+
+			return err
+		}
+		outputs = append(outputs, stderr)
+		cmd.Stderr = stderr
+	}
 	err = cmd.Start()
 	if err != nil {
+		closeOutputs()
+		if sandbox {
+			return fmt.Errorf("could not create sandbox: %s", err)
+		}
+
 		return err
 	}
+	err = status.updateUnitStatus(unitdir, func(status *StatusFileData) {
+		status.beginAttempt()
+	})
+	if err != nil {
+		MainInstance.nc.GetLogger().Error("Error updating status file %s: %s", statusFilename, err)
+	}
 	doneChan := make(chan bool, 1)
 	go cmdWaiter(cmd, doneChan)
 	writeStatusFailures := 0
@@ -165,13 +289,39 @@ loop:
 			break loop
 		case <-termChan:
 			termThenKill(cmd, doneChan)
-			err = status.UpdateBasicStatus(statusFilename, WorkStateFailed, "Killed", stdoutSize(unitdir))
+			closeOutputs()
+			err = status.updateUnitStatus(unitdir, func(status *StatusFileData) {
+				status.State = WorkStateFailed
+				status.Detail = "Killed"
+				status.StdoutSize = stdoutSize(unitdir)
+				status.StderrSize = stderrSize(unitdir)
+				status.EndTime = time.Now()
+			})
+			if err != nil {
+				MainInstance.nc.GetLogger().Error("Error updating status file %s: %s", statusFilename, err)
+			}
+			os.Exit(-1)
+		case <-exceededChan:
+			termThenKill(cmd, doneChan)
+			closeOutputs()
+			err = status.updateUnitStatus(unitdir, func(status *StatusFileData) {
+				status.State = WorkStateFailed
+				status.Detail = stdoutExceededDetail(maxStdoutSize)
+				status.StdoutSize = stdoutSize(unitdir)
+				status.StderrSize = stderrSize(unitdir)
+				status.EndTime = time.Now()
+			})
 			if err != nil {
 				MainInstance.nc.GetLogger().Error("Error updating status file %s: %s", statusFilename, err)
 			}
 			os.Exit(-1)
 		case <-time.After(250 * time.Millisecond):
-			err = status.UpdateBasicStatus(statusFilename, WorkStateRunning, fmt.Sprintf("Running: PID %d", cmd.Process.Pid), stdoutSize(unitdir))
+			err = status.updateUnitStatus(unitdir, func(status *StatusFileData) {
+				status.State = WorkStateRunning
+				status.Detail = fmt.Sprintf("Running: PID %d", cmd.Process.Pid)
+				status.StdoutSize = stdoutSize(unitdir)
+				status.StderrSize = stderrSize(unitdir)
+			})
 			if err != nil {
 				MainInstance.nc.GetLogger().Error("Error updating status file %s: %s", statusFilename, err)
 				writeStatusFailures++
@@ -184,30 +334,63 @@ loop:
 			}
 		}
 	}
+	closeOutputs()
 	if err != nil {
-		err = status.UpdateBasicStatus(statusFilename, WorkStateFailed, fmt.Sprintf("Error: %s", err), stdoutSize(unitdir))
+		err = status.updateUnitBasicStatus(unitdir, WorkStateFailed, fmt.Sprintf("Error: %s", err), stdoutSize(unitdir))
 		if err != nil {
 			MainInstance.nc.GetLogger().Error("Error updating status file %s: %s", statusFilename, err)
 		}
 
 		return err
 	}
+	state := WorkStateFailed
 	if cmd.ProcessState.Success() {
-		err = status.UpdateBasicStatus(statusFilename, WorkStateSucceeded, cmd.ProcessState.String(), stdoutSize(unitdir))
-		if err != nil {
-			MainInstance.nc.GetLogger().Error("Error updating status file %s: %s", statusFilename, err)
-		}
-	} else {
-		err = status.UpdateBasicStatus(statusFilename, WorkStateFailed, cmd.ProcessState.String(), stdoutSize(unitdir))
-		if err != nil {
-			MainInstance.nc.GetLogger().Error("Error updating status file %s: %s", statusFilename, err)
-		}
+		state = WorkStateSucceeded
+	}
+	detail := cmd.ProcessState.String()
+	select {
+	case <-exceededChan:
+		// The command may have finished, or died writing to its closed stdout, before it could be killed.
+		state = WorkStateFailed
+		detail = stdoutExceededDetail(maxStdoutSize)
+	default:
+	}
+	collected, err := collectArtifacts(unitdir, artifacts)
+	if err != nil {
+		MainInstance.nc.GetLogger().Error("Error collecting artifacts from %s: %s", unitdir, err)
+	}
+	err = status.updateUnitStatus(unitdir, func(status *StatusFileData) {
+		status.Artifacts = collected
+		status.State = state
+		status.Detail = detail
+		status.StdoutSize = stdoutSize(unitdir)
+		status.StderrSize = stderrSize(unitdir)
+		status.setProcessExit(cmd.ProcessState)
+	})
+	if err != nil {
+		MainInstance.nc.GetLogger().Error("Error updating status file %s: %s", statusFilename, err)
 	}
 	os.Exit(cmd.ProcessState.ExitCode())
 
 	return nil
 }
 
+// setProcessExit records the exit code, terminating signal and resource usage of a finished process.
+func (sfd *StatusFileData) setProcessExit(ps *os.ProcessState) {
+	sfd.ExitCode = ps.ExitCode()
+	sfd.Signal = ""
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	if ok && ws.Signaled() {
+		sfd.Signal = ws.Signal().String()
+	}
+	sfd.EndTime = time.Now()
+	sfd.ResourceUsage = &ResourceUsage{
+		UserCPUSeconds:   ps.UserTime().Seconds(),
+		SystemCPUSeconds: ps.SystemTime().Seconds(),
+		MaxRSSKB:         maxRSSKB(ps),
+	}
+}
+
 func combineParams(baseParams string, userParams string) string {
 	var allParams string
 	switch {
@@ -231,11 +414,132 @@ func (cw *commandUnit) SetFromParams(params map[string]string) error {
 	if cmdParams != "" && !cw.allowRuntimeParams {
 		return fmt.Errorf("extra params provided but not allowed")
 	}
-	cw.GetStatusCopy().ExtraData.(*CommandExtraData).Params = combineParams(cw.baseParams, cmdParams)
+	runtimeEnv := make(map[string]string)
+	for k, v := range params {
+		name, ok := strings.CutPrefix(k, runtimeEnvParamPrefix)
+		if !ok {
+			continue
+		}
+		if !cw.allowRuntimeEnv {
+			return fmt.Errorf("environment variables provided but not allowed")
+		}
+		if err := checkEnvName(name); err != nil {
+			return err
+		}
+		for _, prefix := range reservedEnvPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				return fmt.Errorf("environment variable %s is reserved", name)
+			}
+		}
+		runtimeEnv[name] = v
+	}
+	runAs := cw.user
+	if requested := params["user"]; requested != "" {
+		allowed := false
+		for _, u := range cw.allowedUsers {
+			if u == requested {
+				allowed = true
+
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("running as user %s is not allowed", requested)
+		}
+		runAs = requested
+	}
+	ced := cw.GetStatusCopy().ExtraData.(*CommandExtraData)
+	ced.Params = combineParams(cw.baseParams, cmdParams)
+	ced.User = runAs
+	cw.runtimeEnv = runtimeEnv
 
 	return nil
 }
 
+// checkEnvName returns an error if a string cannot be used as the name of an environment variable.
+func checkEnvName(name string) error {
+	if !envNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid environment variable name %s", name)
+	}
+
+	return nil
+}
+
+// environment returns the environment the command runs with.  Variables set by the submitter override inherited
+// ones, the fixed variables of the work type override both, and the standard Receptor variables are always set.
+// A command run as another user is given that user's name and home directory, and a command run as another user or
+// in a sandbox is given the directory it can write to.
+func (cw *commandUnit) environment(ru *runAsUser) []string {
+	env := make(map[string]string)
+	if !cw.cleanEnv && len(cw.inheritEnv) == 0 {
+		for _, kv := range os.Environ() {
+			k, v, ok := strings.Cut(kv, "=")
+			if ok {
+				env[k] = v
+			}
+		}
+	}
+	for _, k := range cw.inheritEnv {
+		v, ok := os.LookupEnv(k)
+		if ok {
+			env[k] = v
+		}
+	}
+	for k, v := range cw.runtimeEnv {
+		env[k] = v
+	}
+	if ru != nil {
+		for k, v := range ru.env(cw.UnitDir()) {
+			env[k] = v
+		}
+	}
+	if cw.sandbox {
+		env[WorkDirEnv] = path.Join(cw.UnitDir(), workDirName)
+	}
+	for k, v := range cw.fixedEnv {
+		env[k] = v
+	}
+	env[UnitIDEnv] = cw.ID()
+	env[NodeIDEnv] = cw.GetWorkceptor().nc.NodeID()
+	kvs := make([]string, 0, len(env))
+	for k, v := range env {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+
+	return kvs
+}
+
+// writeCommandEnv writes the environment of a command for its command runner.
+func writeCommandEnv(unitdir string, env []string) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path.Join(unitdir, envFileName), data, 0o600)
+}
+
+// readCommandEnv reads and removes the environment of a command, falling back to the environment of the command
+// runner if there is none.
+func readCommandEnv(unitdir string) ([]string, error) {
+	filename := path.Join(unitdir, envFileName)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return os.Environ(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	err = json.Unmarshal(data, &env)
+	if err != nil {
+		return nil, fmt.Errorf("error reading command environment: %s", err)
+	}
+
+	return env, os.Remove(filename)
+}
+
 // Status returns a copy of the status currently loaded in memory.
 func (cw *commandUnit) Status() *StatusFileData {
 	return cw.UnredactedStatus()
@@ -301,12 +605,42 @@ func (cw *commandUnit) Start() error {
 		receptorBin = "receptor"
 	}
 
+	status := cw.Status()
+	unitdir := cw.UnitDir()
+	var ru *runAsUser
+	runAs := ""
+	if userSpec := status.ExtraData.(*CommandExtraData).User; userSpec != "" {
+		var err error
+		ru, err = lookupRunAsUser(userSpec, cw.group)
+		if err == nil {
+			err = ru.prepareUnitDir(cw.GetWorkceptor().dataDir, unitdir)
+		}
+		if err != nil {
+			cw.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Failed to run as user %s: %s", userSpec, err), 0)
+
+			return err
+		}
+		runAs = ru.String()
+	}
 	cmd := exec.Command(receptorBin, "--node", "id=worker",
 		"--log-level", levelName,
 		"--command-runner",
 		fmt.Sprintf("command=%s", cw.command),
-		fmt.Sprintf("params=%s", cw.Status().ExtraData.(*CommandExtraData).Params),
-		fmt.Sprintf("unitdir=%s", cw.UnitDir()))
+		fmt.Sprintf("params=%s", status.ExtraData.(*CommandExtraData).Params),
+		fmt.Sprintf("unitdir=%s", unitdir),
+		fmt.Sprintf("separatestderr=%t", cw.separateStderr),
+		fmt.Sprintf("compression=%s", cw.compression),
+		fmt.Sprintf("maxstdoutsize=%d", cw.maxStdoutSize),
+		fmt.Sprintf("stdoutoverflow=%s", cw.stdoutOverflow),
+		fmt.Sprintf("artifacts=%s", cw.artifacts),
+		fmt.Sprintf("runas=%s", runAs),
+		fmt.Sprintf("sandbox=%t", cw.sandbox))
+	err := writeCommandEnv(unitdir, cw.environment(ru))
+	if err != nil {
+		cw.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Failed to write command environment: %s", err), 0)
+
+		return err
+	}
 
 	return cw.runCommand(cmd)
 }
@@ -375,11 +709,25 @@ func (cw *commandUnit) Release(force bool) error {
 
 // CommandWorkerCfg is the cmdline configuration object for a worker that runs a command.
 type CommandWorkerCfg struct {
-	WorkType           string `required:"true" description:"Name for this worker type"`
-	Command            string `required:"true" description:"Command to run to process units of work"`
-	Params             string `description:"Command-line parameters"`
-	AllowRuntimeParams bool   `description:"Allow users to add more parameters" default:"false"`
-	VerifySignature    bool   `description:"Verify a signed work submission" default:"false"`
+	WorkType           string            `required:"true" description:"Name for this worker type"`
+	Command            string            `required:"true" description:"Command to run to process units of work"`
+	Params             string            `description:"Command-line parameters"`
+	AllowRuntimeParams bool              `description:"Allow users to add more parameters" default:"false"`
+	VerifySignature    bool              `description:"Verify a signed work submission" default:"false"`
+	SeparateStderr     bool              `description:"Capture stderr to a separate file instead of merging it into stdout" default:"false"`
+	Compression        string            `description:"Compress stored output with gzip or zstd" default:"none"`
+	MaxStdoutSize      int64             `description:"Maximum size in bytes of the stored stdout of a unit, or 0 for no limit" default:"0"`
+	StdoutOverflow     string            `description:"What to do when stdout exceeds maxstdoutsize: truncate or fail" default:"truncate"`
+	ReleaseAfter       string            `description:"Release completed units after this duration, e.g. 24h" default:""`
+	Artifacts          string            `description:"Glob of files, relative to the unit directory, to collect as artifacts when the command completes" default:""`
+	CleanEnv           bool              `description:"Run the command with an empty environment instead of inheriting Receptor's" default:"false"`
+	InheritEnv         []string          `description:"Variables to pass on from Receptor's environment, implies cleanenv"`
+	Env                map[string]string `description:"Environment variables to set for the command"`
+	AllowRuntimeEnv    bool              `description:"Allow users to set environment variables with env_ parameters" default:"false"`
+	User               string            `description:"User name or uid to run the command as"`
+	Group              string            `description:"Group name or gid to run the command as, instead of the primary group of the user"`
+	AllowedUsers       []string          `description:"Users that submitters may run the command as with the user parameter"`
+	Sandbox            bool              `description:"Run the command in new mount, PID, network and user namespaces (Linux only)" default:"false"`
 }
 
 func (cfg CommandWorkerCfg) NewWorker(bwu BaseWorkUnitForWorkUnit, w *Workceptor, unitID string, workType string) WorkUnit {
@@ -396,6 +744,19 @@ func (cfg CommandWorkerCfg) NewWorker(bwu BaseWorkUnitForWorkUnit, w *Workceptor
 		command:                 cfg.Command,
 		baseParams:              cfg.Params,
 		allowRuntimeParams:      cfg.AllowRuntimeParams,
+		separateStderr:          cfg.SeparateStderr,
+		compression:             cfg.Compression,
+		maxStdoutSize:           cfg.MaxStdoutSize,
+		stdoutOverflow:          cfg.StdoutOverflow,
+		artifacts:               cfg.Artifacts,
+		cleanEnv:                cfg.CleanEnv,
+		inheritEnv:              cfg.InheritEnv,
+		fixedEnv:                cfg.Env,
+		allowRuntimeEnv:         cfg.AllowRuntimeEnv,
+		user:                    cfg.User,
+		group:                   cfg.Group,
+		allowedUsers:            cfg.AllowedUsers,
+		sandbox:                 cfg.Sandbox,
 	}
 	cw.BaseWorkUnitForWorkUnit.Init(w, unitID, workType, FileSystem{}, nil)
 
@@ -412,27 +773,67 @@ func (cfg CommandWorkerCfg) GetVerifySignature() bool {
 
 // Run runs the action.
 func (cfg CommandWorkerCfg) Run() error {
-	if cfg.VerifySignature && MainInstance.VerifyingKey == "" {
+	if cfg.VerifySignature && !MainInstance.hasVerifyingKeys() {
 		return fmt.Errorf("VerifySignature for work command '%s' is true, but the work verification public key is not specified", cfg.WorkType)
 	}
-	err := MainInstance.RegisterWorker(cfg.WorkType, cfg.NewWorker, cfg.VerifySignature)
+	if err := checkCompression(cfg.Compression); err != nil {
+		return fmt.Errorf("work command '%s': %s", cfg.WorkType, err)
+	}
+	if err := checkArtifactGlob(cfg.Artifacts); err != nil {
+		return fmt.Errorf("work command '%s': %s", cfg.WorkType, err)
+	}
+	for _, name := range cfg.InheritEnv {
+		if err := checkEnvName(name); err != nil {
+			return fmt.Errorf("work command '%s': %s", cfg.WorkType, err)
+		}
+	}
+	for name := range cfg.Env {
+		if err := checkEnvName(name); err != nil {
+			return fmt.Errorf("work command '%s': %s", cfg.WorkType, err)
+		}
+	}
+	if cfg.User != "" {
+		if _, err := lookupRunAsUser(cfg.User, cfg.Group); err != nil {
+			return fmt.Errorf("work command '%s': %s", cfg.WorkType, err)
+		}
+	}
+	if cfg.Sandbox {
+		if err := sandboxSupported(); err != nil {
+			return fmt.Errorf("work command '%s': %s", cfg.WorkType, err)
+		}
+	}
+	policy, err := NewRetentionPolicy(cfg.MaxStdoutSize, cfg.StdoutOverflow, cfg.ReleaseAfter)
+	if err != nil {
+		return fmt.Errorf("work command '%s': %s", cfg.WorkType, err)
+	}
+	err = MainInstance.RegisterWorker(cfg.WorkType, cfg.NewWorker, cfg.VerifySignature)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return MainInstance.SetRetentionPolicy(cfg.WorkType, policy)
 }
 
 // commandRunnerCfg is a hidden command line option for a command runner process.
 type commandRunnerCfg struct {
-	Command string `required:"true"`
-	Params  string `required:"true"`
-	UnitDir string `required:"true"`
+	Command        string `required:"true"`
+	Params         string `required:"true"`
+	UnitDir        string `required:"true"`
+	SeparateStderr bool
+	Compression    string
+	MaxStdoutSize  int64
+	StdoutOverflow string
+	Artifacts      string
+	RunAs          string
+	Sandbox        bool
 }
 
 // Run runs the action.
 func (cfg commandRunnerCfg) Run() error {
-	err := commandRunner(cfg.Command, cfg.Params, cfg.UnitDir)
+	err := commandRunner(cfg.Command, cfg.Params, cfg.UnitDir, cfg.SeparateStderr, cfg.Compression, cfg.MaxStdoutSize, cfg.StdoutOverflow, cfg.Artifacts, cfg.RunAs, cfg.Sandbox)
 	if err != nil {
 		statusFilename := path.Join(cfg.UnitDir, "status")
-		err = (&StatusFileData{}).UpdateBasicStatus(statusFilename, WorkStateFailed, err.Error(), stdoutSize(cfg.UnitDir))
+		err = (&StatusFileData{}).updateUnitBasicStatus(cfg.UnitDir, WorkStateFailed, err.Error(), stdoutSize(cfg.UnitDir))
 		if err != nil {
 			MainInstance.nc.GetLogger().Error("Error updating status file %s: %s", statusFilename, err)
 		}
@@ -447,10 +848,15 @@ func (cfg commandRunnerCfg) Run() error {
 type SigningKeyPrivateCfg struct {
 	PrivateKey      string `description:"Private key to sign work submissions" barevalue:"yes" default:""`
 	TokenExpiration string `description:"Expiration of the signed json web token, e.g. 3h or 3h30m" default:""`
+	KeyID           string `description:"Key ID to put in the kid header of signatures, naming the verifying key to use" default:""`
 }
 
 type VerifyingKeyPublicCfg struct {
-	PublicKey string `description:"Public key to verify signed work submissions" barevalue:"yes" default:""`
+	PublicKey         string            `description:"Public key to verify signed work submissions" barevalue:"yes" default:""`
+	KeyID             string            `description:"Key ID of publickey, matched against the kid header of signatures" default:""`
+	Keys              map[string]string `description:"Additional public keys by key ID, to verify signatures during key rotation"`
+	Issuers           []string          `description:"Node IDs allowed to sign work, or empty to allow any"`
+	AllowLegacyTokens bool              `description:"Accept signatures without issuer, worktype and payload_sha256 claims, and submissions without stdin_sha256, as made by older nodes" default:"false"`
 }
 
 func filenameExists(filename string) error {
@@ -473,6 +879,7 @@ func (cfg SigningKeyPrivateCfg) Prepare() error {
 
 	MainInstance.SigningExpiration = *duration
 	MainInstance.SigningKey = cfg.PrivateKey
+	MainInstance.SigningKeyID = cfg.KeyID
 
 	return nil
 }
@@ -496,19 +903,34 @@ func (cfg SigningKeyPrivateCfg) PrepareSigningKeyPrivateCfg() (*time.Duration, e
 }
 
 func (cfg VerifyingKeyPublicCfg) Prepare() error {
-	err := filenameExists(cfg.PublicKey)
+	err := cfg.PrepareVerifyingKeyPublicCfg()
 	if err != nil {
 		return err
 	}
 	MainInstance.VerifyingKey = cfg.PublicKey
+	MainInstance.VerifyingKeyID = cfg.KeyID
+	MainInstance.VerifyingKeys = cfg.Keys
+	MainInstance.VerifyingIssuers = cfg.Issuers
+	MainInstance.AllowLegacySignatures = cfg.AllowLegacyTokens
 
 	return nil
 }
 
 func (cfg VerifyingKeyPublicCfg) PrepareVerifyingKeyPublicCfg() error {
-	err := filenameExists(cfg.PublicKey)
-	if err != nil {
-		return err
+	if cfg.PublicKey != "" || len(cfg.Keys) == 0 {
+		err := filenameExists(cfg.PublicKey)
+		if err != nil {
+			return err
+		}
+	}
+	for kid, filename := range cfg.Keys {
+		if kid == "" {
+			return fmt.Errorf("verifying keys must have a key ID")
+		}
+		err := filenameExists(filename)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -4,7 +4,9 @@
 package workceptor
 
 import (
+	"os"
 	"os/exec"
+	"runtime"
 	"syscall"
 )
 
@@ -13,3 +15,31 @@ func cmdSetDetach(cmd *exec.Cmd) {
 		Setsid: true,
 	}
 }
+
+// maxRSSKB returns the maximum resident set size of a finished process in kilobytes.
+func maxRSSKB(ps *os.ProcessState) int64 {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		// Darwin reports ru_maxrss in bytes rather than kilobytes
+		return int64(ru.Maxrss) / 1024
+	}
+
+	return int64(ru.Maxrss)
+}
+
+// cmdSetCredential makes a command run as the given user and group, with no supplementary groups.
+func cmdSetCredential(cmd *exec.Cmd, uid uint32, gid uint32) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    uid,
+		Gid:    gid,
+		Groups: []uint32{},
+	}
+
+	return nil
+}
@@ -4,9 +4,21 @@
 package workceptor
 
 import (
+	"fmt"
+	"os"
 	"os/exec"
 )
 
 func cmdSetDetach(cmd *exec.Cmd) {
 	// Do nothing
 }
+
+// maxRSSKB returns the maximum resident set size of a finished process, which is not available on Windows.
+func maxRSSKB(_ *os.ProcessState) int64 {
+	return 0
+}
+
+// cmdSetCredential would make a command run as another user, which is not supported on Windows.
+func cmdSetCredential(_ *exec.Cmd, _ uint32, _ uint32) error {
+	return fmt.Errorf("running commands as another user is not supported on Windows")
+}
@@ -0,0 +1,273 @@
+//go:build linux && !no_workceptor
+// +build linux,!no_workceptor
+
+package workceptor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/ghjm/cmdline"
+	"github.com/google/shlex"
+	"github.com/spf13/viper"
+	"golang.org/x/sys/unix"
+)
+
+// sandboxSetupFailed is the exit code of a sandbox that could not be set up.
+const sandboxSetupFailed = 125
+
+// sandboxSupported returns an error if the kernel cannot create the namespaces used by the sandbox.
+func sandboxSupported() error {
+	for _, ns := range []string{"mnt", "pid", "net", "user"} {
+		if _, err := os.Stat(path.Join("/proc/self/ns", ns)); err != nil {
+			return fmt.Errorf("the kernel does not support %s namespaces", ns)
+		}
+	}
+
+	return nil
+}
+
+// sandboxCommand returns a command that runs a command line in new mount, PID, network and user namespaces.  The
+// root user of the namespaces is mapped to the given uid and gid.
+func sandboxCommand(command string, params string, unitdir string, workDir string, uid int, gid int) (*exec.Cmd, error) {
+	cmd := exec.Command("/proc/self/exe", "--node", "id=worker",
+		"--log-level", "error",
+		"--sandbox-init",
+		fmt.Sprintf("command=%s", command),
+		fmt.Sprintf("params=%s", params),
+		fmt.Sprintf("unitdir=%s", unitdir),
+		fmt.Sprintf("workdir=%s", workDir))
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | syscall.CLONE_NEWUSER,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		// Switching to the mapped root keeps the capabilities needed to set up the mounts.
+		Credential: &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: true},
+	}
+
+	return cmd, nil
+}
+
+// sandboxInit sets up the mounts of a sandbox and runs a command line in it.  It runs as the first process of the
+// sandbox's PID namespace, and returns the exit code of the command.
+func sandboxInit(command string, params string, unitdir string, workDir string) (int, error) {
+	err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		return 0, fmt.Errorf("could not make mounts private: %s", err)
+	}
+	// Keep hold of the directories of the unit the command may use, so they can be mounted back once the node
+	// directory is hidden.
+	binds := []string{workDir}
+	if _, err := os.Stat(path.Join(unitdir, inputsDirName)); err == nil {
+		binds = append(binds, path.Join(unitdir, inputsDirName))
+	}
+	fds := make([]int, 0, len(binds))
+	defer func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}()
+	for _, dir := range binds {
+		fd, err := unix.Open(dir, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return 0, fmt.Errorf("could not open %s: %s", dir, err)
+		}
+		fds = append(fds, fd)
+	}
+	// Hide the node directory, including the status and output files of this unit, leaving only the directories of
+	// the unit the command may use in place.
+	nodeDir := path.Dir(unitdir)
+	err = syscall.Mount("tmpfs", nodeDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0711")
+	if err != nil {
+		return 0, fmt.Errorf("could not hide the node directory: %s", err)
+	}
+	for i, dir := range binds {
+		err = os.MkdirAll(dir, 0o700)
+		if err != nil {
+			return 0, err
+		}
+		err = syscall.Mount(fmt.Sprintf("/proc/self/fd/%d", fds[i]), dir, "", syscall.MS_BIND, "")
+		if err != nil {
+			return 0, fmt.Errorf("could not mount %s: %s", dir, err)
+		}
+	}
+	err = syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	if err != nil {
+		return 0, fmt.Errorf("could not mount /proc: %s", err)
+	}
+	err = remountReadOnly(workDir)
+	if err != nil {
+		return 0, err
+	}
+	err = os.Chdir(workDir)
+	if err != nil {
+		return 0, err
+	}
+
+	paramList, err := shlex.Split(params)
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(command, paramList...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	// Capabilities are per thread, so the command must be started from the thread that dropped them.
+	runtime.LockOSThread()
+	err = dropCapabilities()
+	if err == nil {
+		err = cmd.Start()
+	}
+	runtime.UnlockOSThread()
+	if err != nil {
+		return 0, err
+	}
+	go func() {
+		for sig := range sigChan {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return 0, err
+	}
+	ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if ok && ws.Signaled() {
+		return 128 + int(ws.Signal()), nil
+	}
+
+	return cmd.ProcessState.ExitCode(), nil
+}
+
+// remountReadOnly makes every mount of the sandbox read-only, except the one at the given directory.
+func remountReadOnly(except string) error {
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := unescapeMountPoint(fields[4])
+		if mountPoint == except {
+			continue
+		}
+		// Mounts hidden below other mounts, or below directories the command cannot enter, are out of its reach.
+		var stx unix.Statx_t
+		err = unix.Statx(unix.AT_FDCWD, mountPoint, unix.AT_NO_AUTOMOUNT, unix.STATX_MNT_ID, &stx)
+		if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.EACCES) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not make %s read-only: %s", mountPoint, err)
+		}
+		if stx.Mask&unix.STATX_MNT_ID != 0 && strconv.FormatUint(stx.Mnt_id, 10) != fields[0] {
+			continue
+		}
+		// Flags that are locked on a mount must be kept when it is remounted read-only.
+		var st syscall.Statfs_t
+		err = syscall.Statfs(mountPoint, &st)
+		if err != nil {
+			return fmt.Errorf("could not make %s read-only: %s", mountPoint, err)
+		}
+		locked := uintptr(st.Flags) & (syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+			syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME)
+		err = syscall.Mount("", mountPoint, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|locked, "")
+		if err != nil {
+			return fmt.Errorf("could not make %s read-only: %s", mountPoint, err)
+		}
+	}
+
+	return nil
+}
+
+// unescapeMountPoint decodes the octal escapes of a mount point in /proc/self/mountinfo.
+func unescapeMountPoint(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// Secure bits that stop root of the sandbox from regaining capabilities when it runs a program.
+const (
+	secbitNoRoot       = 1 << 0
+	secbitNoRootLocked = 1 << 1
+)
+
+// dropCapabilities drops the capabilities of the current thread, and of the programs it runs, so that the command
+// cannot undo the mounts of the sandbox.
+func dropCapabilities() error {
+	for c := 0; ; c++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+		if errors.Is(err, unix.EINVAL) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not drop capabilities: %s", err)
+		}
+	}
+	err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("could not drop capabilities: %s", err)
+	}
+	err = unix.Prctl(unix.PR_SET_SECUREBITS, secbitNoRoot|secbitNoRootLocked, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("could not drop capabilities: %s", err)
+	}
+
+	return unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+}
+
+// sandboxInitCfg is a hidden command line option for the first process of a sandbox.
+type sandboxInitCfg struct {
+	Command string `required:"true"`
+	Params  string
+	UnitDir string `required:"true"`
+	WorkDir string `required:"true"`
+}
+
+// Run runs the action.
+func (cfg sandboxInitCfg) Run() error {
+	code, err := sandboxInit(cfg.Command, cfg.Params, cfg.UnitDir, cfg.WorkDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Sandbox error: %s\n", err)
+		os.Exit(sandboxSetupFailed)
+	}
+	os.Exit(code)
+
+	return nil
+}
+
+func init() {
+	version := viper.GetInt("version")
+	if version > 1 {
+		return
+	}
+	cmdline.RegisterConfigTypeForApp("receptor-workers",
+		"sandbox-init", "First process of a command sandbox", sandboxInitCfg{}, cmdline.Hidden)
+}
@@ -0,0 +1,117 @@
+//go:build linux && !no_workceptor
+// +build linux,!no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"testing"
+)
+
+func TestSandboxCommand(t *testing.T) {
+	if err := sandboxSupported(); err != nil {
+		t.Skip(err)
+	}
+	cmd, err := sandboxCommand("sh", "-c 'echo hi'", "/data/node/unit", "/data/node/unit/work", 54321, 54322)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArgs := []string{
+		"/proc/self/exe", "--node", "id=worker", "--log-level", "error", "--sandbox-init",
+		"command=sh", "params=-c 'echo hi'", "unitdir=/data/node/unit", "workdir=/data/node/unit/work",
+	}
+	if len(cmd.Args) != len(wantArgs) {
+		t.Fatalf("expected args %v, got %v", wantArgs, cmd.Args)
+	}
+	for i := range wantArgs {
+		if cmd.Args[i] != wantArgs[i] {
+			t.Errorf("expected arg %d to be %s, got %s", i, wantArgs[i], cmd.Args[i])
+		}
+	}
+	attr := cmd.SysProcAttr
+	for _, flag := range []uintptr{syscall.CLONE_NEWNS, syscall.CLONE_NEWPID, syscall.CLONE_NEWNET, syscall.CLONE_NEWUSER} {
+		if attr.Cloneflags&flag == 0 {
+			t.Errorf("expected clone flag %#x to be set", flag)
+		}
+	}
+	if len(attr.UidMappings) != 1 || attr.UidMappings[0].HostID != 54321 || attr.UidMappings[0].ContainerID != 0 {
+		t.Errorf("unexpected uid mappings %v", attr.UidMappings)
+	}
+	if len(attr.GidMappings) != 1 || attr.GidMappings[0].HostID != 54322 || attr.GidMappings[0].ContainerID != 0 {
+		t.Errorf("unexpected gid mappings %v", attr.GidMappings)
+	}
+	if attr.GidMappingsEnableSetgroups {
+		t.Error("expected setgroups to be disabled in the sandbox")
+	}
+}
+
+func TestSandboxIsolation(t *testing.T) {
+	if err := sandboxSupported(); err != nil {
+		t.Skip(err)
+	}
+	// The sandbox is set up by the receptor binary, as for the command runner.
+	receptorBin, err := exec.LookPath("receptor")
+	if err != nil {
+		t.Skip("receptor binary not found")
+	}
+	unitdir := path.Join(t.TempDir(), "node", "unit")
+	workDir := path.Join(unitdir, workDirName)
+	for _, dir := range []string{workDir, path.Join(unitdir, inputsDirName)} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		path.Join(unitdir, "status"):                "status",
+		path.Join(unitdir, inputsDirName, "in.txt"): "input",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := `cat ../inputs/in.txt; echo; ls ..; echo output > result
+for f in ../status ../inputs/in.txt /dev/shm/receptor-sandbox-test /receptor-sandbox-test; do
+  (echo x > $f) 2>/dev/null && echo wrote $f
+done
+mount -o remount,bind,rw / 2>/dev/null && echo remounted
+exit 0`
+	cmd, err := sandboxCommand("sh", "-c '"+script+"'", unitdir, workDir, os.Getuid(), os.Getgid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Path = receptorBin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Start()
+	if err != nil {
+		t.Skipf("could not create the sandbox: %s", err)
+	}
+	err = cmd.Wait()
+	if err != nil {
+		t.Fatalf("sandbox failed: %s: %s", err, stderr.String())
+	}
+	want := "input\ninputs\nwork\n"
+	if stdout.String() != want {
+		t.Errorf("expected sandbox output %q, got %q", want, stdout.String())
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != content {
+			t.Errorf("expected %s to be unchanged, got %q, %v", name, data, err)
+		}
+	}
+	data, err := os.ReadFile(path.Join(workDir, "result"))
+	if err != nil || string(data) != "output\n" {
+		t.Errorf("expected the work directory to be writable, got %q, %v", data, err)
+	}
+	if _, err := os.Stat("/dev/shm/receptor-sandbox-test"); err == nil {
+		os.Remove("/dev/shm/receptor-sandbox-test")
+		t.Error("expected /dev/shm to be read-only in the sandbox")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !no_workceptor
+// +build !linux,!no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// sandboxSupported returns an error, as the sandbox relies on Linux namespaces.
+func sandboxSupported() error {
+	return fmt.Errorf("the sandbox is only supported on Linux")
+}
+
+// sandboxCommand would run a command line in new namespaces, which is only supported on Linux.
+func sandboxCommand(_ string, _ string, _ string, _ string, _ int, _ int) (*exec.Cmd, error) {
+	return nil, sandboxSupported()
+}
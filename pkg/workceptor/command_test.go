@@ -115,12 +115,14 @@ func TestUnredactedStatus(t *testing.T) {
 func TestStart(t *testing.T) {
 	wu, mockBaseWorkUnit, mockNetceptor, w := createCommandTestSetup(t)
 
-	mockBaseWorkUnit.EXPECT().GetWorkceptor().Return(w).Times(2)
+	mockBaseWorkUnit.EXPECT().GetWorkceptor().Return(w).Times(3)
 	mockNetceptor.EXPECT().GetLogger().Times(2)
+	mockNetceptor.EXPECT().NodeID().Return("NodeID")
+	mockBaseWorkUnit.EXPECT().ID().Return("UnitID")
 	mockBaseWorkUnit.EXPECT().UpdateBasicStatus(gomock.Any(), gomock.Any(), gomock.Any())
 	statusExpectCalls(mockBaseWorkUnit)
 
-	mockBaseWorkUnit.EXPECT().UnitDir()
+	mockBaseWorkUnit.EXPECT().UnitDir().Return(t.TempDir())
 	mockBaseWorkUnit.EXPECT().UpdateFullStatus(gomock.Any())
 	mockBaseWorkUnit.EXPECT().MonitorLocalStatus().AnyTimes()
 	mockBaseWorkUnit.EXPECT().UpdateFullStatus(gomock.Any()).AnyTimes()
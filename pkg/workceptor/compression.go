@@ -0,0 +1,532 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+
+	// frameIndexSuffix is appended to the name of a compressed result stream to get the name of its frame index.
+	frameIndexSuffix = ".idx"
+	// frameSize is the amount of uncompressed data stored in each compressed frame.
+	frameSize = 1024 * 1024
+	// frameFlushInterval is how long output may be buffered before a short frame is written.
+	frameFlushInterval = 1 * time.Second
+)
+
+// supportedCompression lists the compression algorithms this node can use, in order of preference.
+var supportedCompression = []string{compressionZstd, compressionGzip}
+
+// checkCompression returns an error if name is not a known compression algorithm.
+func checkCompression(name string) error {
+	switch name {
+	case "", compressionNone, compressionGzip, compressionZstd:
+		return nil
+	}
+
+	return fmt.Errorf("unknown compression %s: must be none, gzip or zstd", name)
+}
+
+// negotiateCompression picks the first algorithm from a comma separated list offered by a peer that this node
+// supports, or returns an empty string if there is none.
+func negotiateCompression(offered string) string {
+	for _, c := range strings.Split(offered, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		for _, s := range supportedCompression {
+			if c == s {
+				return c
+			}
+		}
+	}
+
+	return ""
+}
+
+// compressFrame compresses a single self-contained frame.
+func compressFrame(compression string, zenc *zstd.Encoder, data []byte) ([]byte, error) {
+	switch compression {
+	case compressionZstd:
+		return zenc.EncodeAll(data, nil), nil
+	case compressionGzip:
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		_, err := gw.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		err = gw.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
+
+// decompressFrame decompresses a single self-contained frame.
+func decompressFrame(compression string, zdec *zstd.Decoder, data []byte) ([]byte, error) {
+	switch compression {
+	case compressionZstd:
+		return zdec.DecodeAll(data, nil)
+	case compressionGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+
+		return io.ReadAll(gr)
+	}
+
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
+
+// frameIndexEntry describes one compressed frame of a result stream.
+type frameIndexEntry struct {
+	UncompressedOffset int64
+	UncompressedSize   int64
+	CompressedOffset   int64
+	CompressedSize     int64
+}
+
+// parseFrameIndexLine parses one line of a frame index file.
+func parseFrameIndexLine(line string) (frameIndexEntry, error) {
+	fie := frameIndexEntry{}
+	_, err := fmt.Sscan(line, &fie.UncompressedOffset, &fie.UncompressedSize, &fie.CompressedOffset, &fie.CompressedSize)
+
+	return fie, err
+}
+
+// indexedStreamSize returns the uncompressed size of a compressed result stream, and false if the stream
+// is not compressed.
+func indexedStreamSize(filename string) (int64, bool) {
+	index, err := os.Open(filename + frameIndexSuffix)
+	if err != nil {
+		return 0, false
+	}
+	defer index.Close()
+	var size int64
+	scanner := bufio.NewScanner(index)
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			continue
+		}
+		fie, err := parseFrameIndexLine(scanner.Text())
+		if err != nil {
+			break
+		}
+		size = fie.UncompressedOffset + fie.UncompressedSize
+	}
+
+	return size, true
+}
+
+// frameWriter stores a result stream as a sequence of independently compressed frames, together with
+// an index that allows readers to seek by uncompressed position.
+type frameWriter struct {
+	lock               sync.Mutex
+	compression        string
+	data               *os.File
+	index              *os.File
+	zenc               *zstd.Encoder
+	buf                bytes.Buffer
+	uncompressedOffset int64
+	compressedOffset   int64
+	err                error
+	done               chan struct{}
+	closeOnce          sync.Once
+}
+
+// newFrameWriter creates a compressed result stream file and its index.
+func newFrameWriter(filename string, compression string) (*frameWriter, error) {
+	fw := &frameWriter{
+		compression: compression,
+		done:        make(chan struct{}),
+	}
+	var err error
+	if compression == compressionZstd {
+		fw.zenc, err = zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// The index is created first, so readers that find the stream file always know it is compressed.
+	fw.index, err = os.OpenFile(filename+frameIndexSuffix, os.O_CREATE+os.O_TRUNC+os.O_WRONLY+os.O_SYNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fw.index.WriteString(compression + "\n")
+	if err != nil {
+		fw.index.Close()
+
+		return nil, err
+	}
+	fw.data, err = os.OpenFile(filename, os.O_CREATE+os.O_TRUNC+os.O_WRONLY+os.O_SYNC, 0o600)
+	if err != nil {
+		fw.index.Close()
+
+		return nil, err
+	}
+	go fw.flushLoop()
+
+	return fw, nil
+}
+
+// flushLoop periodically writes out buffered data, so readers can follow a slow producer.
+func (fw *frameWriter) flushLoop() {
+	for {
+		select {
+		case <-fw.done:
+			return
+		case <-time.After(frameFlushInterval):
+			_ = fw.Flush()
+		}
+	}
+}
+
+// writeFrame compresses and stores one frame, then records it in the index.  Must be called with the lock held.
+func (fw *frameWriter) writeFrame(frame []byte) error {
+	if fw.err != nil {
+		return fw.err
+	}
+	compressed, err := compressFrame(fw.compression, fw.zenc, frame)
+	if err == nil {
+		_, err = fw.data.Write(compressed)
+	}
+	if err == nil {
+		_, err = fw.index.WriteString(fmt.Sprintf("%d %d %d %d\n",
+			fw.uncompressedOffset, len(frame), fw.compressedOffset, len(compressed)))
+	}
+	if err != nil {
+		fw.err = err
+
+		return err
+	}
+	fw.uncompressedOffset += int64(len(frame))
+	fw.compressedOffset += int64(len(compressed))
+
+	return nil
+}
+
+// Write buffers data, writing out a frame each time enough has accumulated.
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+	if fw.err != nil {
+		return 0, fw.err
+	}
+	fw.buf.Write(p)
+	for fw.buf.Len() >= frameSize {
+		err := fw.writeFrame(fw.buf.Next(frameSize))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush writes out any buffered data as a short frame.
+func (fw *frameWriter) Flush() error {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+	if fw.buf.Len() == 0 {
+		return fw.err
+	}
+
+	return fw.writeFrame(fw.buf.Next(fw.buf.Len()))
+}
+
+// Close flushes buffered data and closes the stream and index files.
+func (fw *frameWriter) Close() error {
+	var err error
+	fw.closeOnce.Do(func() {
+		close(fw.done)
+		err = fw.Flush()
+		for _, f := range []*os.File{fw.data, fw.index} {
+			cerr := f.Close()
+			if err == nil {
+				err = cerr
+			}
+		}
+		if fw.zenc != nil {
+			_ = fw.zenc.Close()
+		}
+	})
+
+	return err
+}
+
+// frameReader reads a compressed result stream by uncompressed position.  It picks up frames that are
+// appended while it is open.
+type frameReader struct {
+	compression string
+	data        *os.File
+	index       *os.File
+	zdec        *zstd.Decoder
+	partial     string
+	frames      []frameIndexEntry
+	pos         int64
+	cur         int
+	curData     []byte
+}
+
+// size returns the uncompressed size of all frames known to the reader.
+func (fr *frameReader) size() int64 {
+	if len(fr.frames) == 0 {
+		return 0
+	}
+	last := fr.frames[len(fr.frames)-1]
+
+	return last.UncompressedOffset + last.UncompressedSize
+}
+
+// loadIndex reads any index entries written since the last call.
+func (fr *frameReader) loadIndex() error {
+	newData, err := io.ReadAll(fr.index)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(fr.partial+string(newData), "\n")
+	fr.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if fr.compression == "" {
+			fr.compression = line
+			if fr.compression == compressionZstd && fr.zdec == nil {
+				fr.zdec, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+				if err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+		fie, err := parseFrameIndexLine(line)
+		if err != nil {
+			return fmt.Errorf("corrupt frame index: %s", err)
+		}
+		fr.frames = append(fr.frames, fie)
+	}
+
+	return nil
+}
+
+// Read implements io.Reader, returning io.EOF when the reader has caught up with the writer.
+func (fr *frameReader) Read(p []byte) (int, error) {
+	if fr.pos >= fr.size() {
+		err := fr.loadIndex()
+		if err != nil {
+			return 0, err
+		}
+		if fr.pos >= fr.size() {
+			return 0, io.EOF
+		}
+	}
+	i := sort.Search(len(fr.frames), func(i int) bool {
+		return fr.frames[i].UncompressedOffset+fr.frames[i].UncompressedSize > fr.pos
+	})
+	if i != fr.cur {
+		fie := fr.frames[i]
+		compressed := make([]byte, fie.CompressedSize)
+		_, err := fr.data.ReadAt(compressed, fie.CompressedOffset)
+		if err != nil {
+			return 0, err
+		}
+		fr.curData, err = decompressFrame(fr.compression, fr.zdec, compressed)
+		if err != nil {
+			return 0, err
+		}
+		if int64(len(fr.curData)) != fie.UncompressedSize {
+			return 0, fmt.Errorf("frame at %d decompressed to %d bytes, expected %d",
+				fie.CompressedOffset, len(fr.curData), fie.UncompressedSize)
+		}
+		fr.cur = i
+	}
+	n := copy(p, fr.curData[fr.pos-fr.frames[i].UncompressedOffset:])
+	fr.pos += int64(n)
+
+	return n, nil
+}
+
+// Seek implements io.Seeker over uncompressed positions.
+func (fr *frameReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += fr.pos
+	case io.SeekEnd:
+		err := fr.loadIndex()
+		if err != nil {
+			return fr.pos, err
+		}
+		offset += fr.size()
+	default:
+		return fr.pos, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return fr.pos, fmt.Errorf("negative position %d", offset)
+	}
+	fr.pos = offset
+
+	return fr.pos, nil
+}
+
+// Close closes the stream and index files.
+func (fr *frameReader) Close() error {
+	if fr.zdec != nil {
+		fr.zdec.Close()
+	}
+	err := fr.index.Close()
+	cerr := fr.data.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// openResultStream opens a result stream for reading by uncompressed position, whether or not it is compressed.
+func openResultStream(filename string) (io.ReadSeekCloser, error) {
+	index, err := os.Open(filename + frameIndexSuffix)
+	if os.IsNotExist(err) {
+		data, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.Open(filename)
+	if err != nil {
+		index.Close()
+
+		return nil, err
+	}
+	fr := &frameReader{
+		data:  data,
+		index: index,
+		cur:   -1,
+	}
+	err = fr.loadIndex()
+	if err != nil {
+		fr.Close()
+
+		return nil, err
+	}
+
+	return fr, nil
+}
+
+// openResultWriter creates a result stream file, compressed if requested.
+func openResultWriter(filename string, compression string) (io.WriteCloser, error) {
+	if compression == "" || compression == compressionNone {
+		return os.OpenFile(filename, os.O_CREATE+os.O_WRONLY+os.O_SYNC, 0o600)
+	}
+
+	return newFrameWriter(filename, compression)
+}
+
+// streamEncoder is a compressing writer that can be flushed mid-stream.
+type streamEncoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// newStreamEncoder returns a compressing writer for results sent over the network.
+func newStreamEncoder(w io.Writer, compression string) (streamEncoder, error) {
+	switch compression {
+	case compressionZstd:
+		return zstd.NewWriter(w)
+	case compressionGzip:
+		return gzip.NewWriter(w), nil
+	}
+
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
+
+// newStreamDecoder returns a decompressing reader for results received over the network.
+func newStreamDecoder(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case compressionZstd:
+		zdec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		return zdec.IOReadCloser(), nil
+	case compressionGzip:
+		return gzip.NewReader(r)
+	}
+
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
+
+// compressResults returns a channel carrying the data from in, compressed and flushed chunk by chunk so
+// the receiver can follow a running unit.
+func compressResults(ctx context.Context, in chan []byte, compression string) (chan []byte, error) {
+	buf := &bytes.Buffer{}
+	enc, err := newStreamEncoder(buf, compression)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []byte)
+	go func() {
+		defer func() {
+			close(out)
+			// Let the producer finish if we stopped early.
+			for range in {
+			}
+		}()
+		send := func() bool {
+			if buf.Len() == 0 {
+				return true
+			}
+			data := make([]byte, buf.Len())
+			copy(data, buf.Bytes())
+			buf.Reset()
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- data:
+				return true
+			}
+		}
+		for data := range in {
+			_, err := enc.Write(data)
+			if err == nil {
+				err = enc.Flush()
+			}
+			if err != nil || !send() {
+				return
+			}
+		}
+		if enc.Close() == nil {
+			send()
+		}
+	}()
+
+	return out, nil
+}
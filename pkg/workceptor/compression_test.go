@@ -0,0 +1,137 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"testing"
+)
+
+func testPayload(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte('a' + (i/7)%26)
+	}
+
+	return data
+}
+
+func TestFrameWriterReader(t *testing.T) {
+	for _, compression := range supportedCompression {
+		t.Run(compression, func(t *testing.T) {
+			filename := path.Join(t.TempDir(), StreamStdout)
+			fw, err := newFrameWriter(filename, compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload := testPayload(frameSize*2 + frameSize/2)
+			_, err = fw.Write(payload[:100])
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = fw.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, err := openResultStream(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload[:100]) {
+				t.Fatal("data read while writing does not match")
+			}
+			_, err = fw.Write(payload[100:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = fw.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			size, ok := indexedStreamSize(filename)
+			if !ok || size != int64(len(payload)) {
+				t.Fatalf("expected indexed size %d, got %d", len(payload), size)
+			}
+			for _, pos := range []int64{0, 100, frameSize - 1, frameSize, frameSize*2 + 5} {
+				_, err = reader.Seek(pos, io.SeekStart)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err = io.ReadAll(reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, payload[pos:]) {
+					t.Fatalf("data read from position %d does not match", pos)
+				}
+			}
+			stat, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stat.Size() >= int64(len(payload)) {
+				t.Fatalf("stored size %d is not smaller than payload size %d", stat.Size(), len(payload))
+			}
+		})
+	}
+}
+
+func TestCompressResults(t *testing.T) {
+	for _, compression := range supportedCompression {
+		t.Run(compression, func(t *testing.T) {
+			payload := testPayload(200000)
+			in := make(chan []byte)
+			go func() {
+				for i := 0; i < len(payload); i += 30000 {
+					in <- payload[i:min(i+30000, len(payload))]
+				}
+				close(in)
+			}()
+			out, err := compressResults(context.Background(), in, compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compressed := &bytes.Buffer{}
+			for data := range out {
+				compressed.Write(data)
+			}
+			decoder, err := newStreamDecoder(compressed, compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer decoder.Close()
+			got, err := io.ReadAll(decoder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatal("decompressed results do not match")
+			}
+		})
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	tests := map[string]string{
+		"":          "",
+		"zstd,gzip": compressionZstd,
+		"GZIP":      compressionGzip,
+		"brotli":    "",
+		"lz4, gzip": compressionGzip,
+	}
+	for offered, want := range tests {
+		if got := negotiateCompression(offered); got != want {
+			t.Errorf("negotiateCompression(%q) = %q, want %q", offered, got, want)
+		}
+	}
+}
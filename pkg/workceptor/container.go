@@ -0,0 +1,558 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghjm/cmdline"
+	"github.com/google/shlex"
+	"github.com/spf13/viper"
+)
+
+// ContainerUnit implements the WorkUnit interface for work run in a container by a local container engine.
+type ContainerUnit struct {
+	BaseWorkUnitForWorkUnit
+	engine              ContainerEngine
+	baseParams          string
+	allowRuntimeCommand bool
+	allowRuntimeParams  bool
+	mounts              []string
+	env                 map[string]string
+	memory              int64
+	nanoCPUs            int64
+	pull                string
+	stopTimeout         time.Duration
+}
+
+// ContainerExtraData is the content of the ExtraData JSON field for a container worker.
+type ContainerExtraData struct {
+	Image         string
+	Command       string
+	Params        string
+	ContainerName string
+	ContainerID   string
+}
+
+// containerInputsDir is where the input files of a unit are mounted in the container.
+const containerInputsDir = "/receptor/inputs"
+
+// Image pull policies.
+const (
+	PullMissing = "missing"
+	PullAlways  = "always"
+	PullNever   = "never"
+)
+
+// containerLabelUnitID labels containers with the work unit they were created for.
+const containerLabelUnitID = "receptor.unitid"
+
+// containerLabelNode labels containers with the node they were created by.
+const containerLabelNode = "receptor.node"
+
+// ensureImage makes the image available according to the pull policy.
+func (cu *ContainerUnit) ensureImage(image string) error {
+	if cu.pull != PullAlways {
+		exists, err := cu.engine.ImageExists(cu.GetContext(), image)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		if cu.pull == PullNever {
+			return fmt.Errorf("image %s is not present and the pull policy is never", image)
+		}
+	}
+	cu.UpdateBasicStatus(WorkStatePending, fmt.Sprintf("Pulling image %s", image), 0)
+
+	return cu.engine.PullImage(cu.GetContext(), image)
+}
+
+// containerSpec returns the definition of the container for the unit.
+func (cu *ContainerUnit) containerSpec(ced *ContainerExtraData, stdin bool) (*ContainerSpec, error) {
+	spec := &ContainerSpec{
+		Name:  ced.ContainerName,
+		Image: ced.Image,
+		Env:   make(map[string]string),
+		Labels: map[string]string{
+			containerLabelUnitID: cu.ID(),
+			containerLabelNode:   cu.GetWorkceptor().nc.NodeID(),
+		},
+		Mounts:   append([]string{}, cu.mounts...),
+		Memory:   cu.memory,
+		NanoCPUs: cu.nanoCPUs,
+		Stdin:    stdin,
+	}
+	var err error
+	if ced.Command != "" {
+		spec.Entrypoint, err = shlex.Split(ced.Command)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ced.Params != "" {
+		spec.Cmd, err = shlex.Split(ced.Params)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range cu.env {
+		spec.Env[k] = v
+	}
+	files, err := listInputFiles(cu.UnitDir())
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		spec.Mounts = append(spec.Mounts, path.Join(cu.UnitDir(), inputsDirName)+":"+containerInputsDir+":ro")
+		for k, v := range inputFileEnv(containerInputsDir, files) {
+			spec.Env[k] = v
+		}
+	}
+
+	return spec, nil
+}
+
+// createContainer pulls the image if needed and creates the container, recording its ID.
+func (cu *ContainerUnit) createContainer(stdin bool) (string, error) {
+	ced := cu.Status().ExtraData.(*ContainerExtraData)
+	err := cu.ensureImage(ced.Image)
+	if err != nil {
+		return "", err
+	}
+	spec, err := cu.containerSpec(ced, stdin)
+	if err != nil {
+		return "", err
+	}
+	cu.UpdateBasicStatus(WorkStatePending, "Creating container", 0)
+	id, err := cu.engine.CreateContainer(cu.GetContext(), spec)
+	if err != nil {
+		return "", err
+	}
+	cu.UpdateFullStatus(func(status *StatusFileData) {
+		status.ExtraData.(*ContainerExtraData).ContainerID = id
+	})
+
+	return id, nil
+}
+
+// runContainer creates and starts the container if this has not been done yet, then streams its output to the
+// stdout of the unit until it exits.
+func (cu *ContainerUnit) runContainer() {
+	ced := cu.Status().ExtraData.(*ContainerExtraData)
+	id := ced.ContainerID
+	if id == "" {
+		stdin, err := NewStdinReader(FileSystem{}, cu.UnitDir())
+		if err != nil && !errors.Is(err, errFileSizeZero) {
+			cu.failed(fmt.Sprintf("Error opening stdin file: %s", err), 0)
+
+			return
+		}
+		id, err = cu.createContainer(stdin != nil)
+		if err != nil {
+			cu.failed(fmt.Sprintf("Error creating container: %s", err), 0)
+
+			return
+		}
+		if stdin != nil {
+			cu.UpdateBasicStatus(WorkStatePending, "Sending stdin to container", 0)
+			go func() {
+				select {
+				case <-cu.GetContext().Done():
+					stdin.reader.Close()
+				case <-stdin.Done():
+				}
+			}()
+			err = cu.engine.StartContainer(cu.GetContext(), id, stdin)
+		} else {
+			err = cu.engine.StartContainer(cu.GetContext(), id, nil)
+		}
+		if err != nil {
+			cu.failed(fmt.Sprintf("Error starting container: %s", err), 0)
+
+			return
+		}
+		cu.UpdateFullStatus(func(status *StatusFileData) {
+			status.beginAttempt()
+		})
+	}
+	cu.UpdateBasicStatus(WorkStateRunning, "Container Running", -1)
+
+	// Output is always read from the beginning, so on restart the stdout file is rewritten with the same data.
+	stdout, err := NewStdoutWriter(FileSystem{}, cu.UnitDir())
+	if err != nil {
+		cu.failed(fmt.Sprintf("Error opening stdout file: %s", err), -1)
+
+		return
+	}
+	defer stdout.writer.Close()
+	stdout.SetMaxSize(cu.GetWorkceptor().stdoutLimit(cu))
+	err = cu.engine.ContainerLogs(cu.GetContext(), id, stdout)
+	if err != nil {
+		cu.failed(fmt.Sprintf("Error streaming container output: %s", err), stdout.Size())
+
+		return
+	}
+	exit, err := cu.engine.WaitContainer(cu.GetContext(), id)
+	if err != nil {
+		cu.failed(fmt.Sprintf("Error waiting for container: %s", err), stdout.Size())
+
+		return
+	}
+	if cu.GetContext().Err() != nil {
+		return
+	}
+	cu.UpdateFullStatus(func(status *StatusFileData) {
+		status.ExitCode = exit.ExitCode
+		if !exit.StartedAt.IsZero() {
+			status.StartTime = exit.StartedAt
+		}
+		status.EndTime = exit.FinishedAt
+		if status.EndTime.IsZero() {
+			status.EndTime = time.Now()
+		}
+		status.State = WorkStateSucceeded
+		status.Detail = "Finished"
+		if exit.ExitCode != 0 {
+			status.State = WorkStateFailed
+			status.Detail = fmt.Sprintf("Container exited with code %d", exit.ExitCode)
+		}
+		status.StdoutSize = stdout.Size()
+	})
+}
+
+// failed marks the unit failed, unless it has been canceled.
+func (cu *ContainerUnit) failed(detail string, stdoutSize int64) {
+	if cu.GetContext().Err() != nil {
+		return
+	}
+	cu.GetWorkceptor().nc.GetLogger().Error("%s", detail)
+	cu.UpdateBasicStatus(WorkStateFailed, detail, stdoutSize)
+}
+
+// SetFromParams sets the in-memory state from parameters.
+func (cu *ContainerUnit) SetFromParams(params map[string]string) error {
+	ced := cu.GetStatusCopy().ExtraData.(*ContainerExtraData)
+	userParams := ""
+	values := []struct {
+		name       string
+		permission bool
+		target     *string
+	}{
+		{name: "container_image", permission: cu.allowRuntimeCommand, target: &ced.Image},
+		{name: "container_command", permission: cu.allowRuntimeCommand, target: &ced.Command},
+		{name: "container_params", permission: cu.allowRuntimeParams, target: &userParams},
+	}
+	for _, v := range values {
+		value, ok := params[v.name]
+		if ok && value != "" {
+			if !v.permission {
+				return fmt.Errorf("%s provided but not allowed", v.name)
+			}
+			*v.target = value
+		}
+	}
+	if ced.Image == "" {
+		return fmt.Errorf("no container image given")
+	}
+	if err := checkImage(ced.Image); err != nil {
+		return err
+	}
+	ced.Params = combineParams(cu.baseParams, userParams)
+	ced.ContainerName = fmt.Sprintf("receptor-%s", strings.ToLower(cu.ID()))
+
+	return nil
+}
+
+// Status returns a copy of the status currently loaded in memory.
+func (cu *ContainerUnit) Status() *StatusFileData {
+	return cu.UnredactedStatus()
+}
+
+// UnredactedStatus returns a copy of the status currently loaded in memory, including secrets.
+func (cu *ContainerUnit) UnredactedStatus() *StatusFileData {
+	cu.GetStatusLock().RLock()
+	defer cu.GetStatusLock().RUnlock()
+	status := cu.GetStatusWithoutExtraData()
+	ced, ok := cu.GetStatusCopy().ExtraData.(*ContainerExtraData)
+	if ok {
+		cedCopy := *ced
+		status.ExtraData = &cedCopy
+	}
+
+	return status
+}
+
+// Start launches a job with given parameters.
+func (cu *ContainerUnit) Start() error {
+	cu.UpdateBasicStatus(WorkStatePending, "Starting container", 0)
+	go cu.runContainer()
+	go cu.MonitorLocalStatus()
+
+	return nil
+}
+
+// Restart resumes monitoring a job after a Receptor restart.  As with Kubernetes units, a running container is
+// followed again, and a container that had not started running is removed and the unit failed.
+func (cu *ContainerUnit) Restart() error {
+	status := cu.Status()
+	if IsComplete(status.State) {
+		return nil
+	}
+	ced := status.ExtraData.(*ContainerExtraData)
+	if status.State == WorkStateRunning && ced.ContainerID != "" {
+		go cu.runContainer()
+		go cu.MonitorLocalStatus()
+
+		return nil
+	}
+	if ced.ContainerID != "" {
+		err := cu.engine.RemoveContainer(context.Background(), ced.ContainerID)
+		if err != nil && !errors.Is(err, ErrContainerNotFound) {
+			cu.GetWorkceptor().nc.GetLogger().Warning("Container %s could not be removed: %s", ced.ContainerName, err)
+		}
+	}
+
+	return fmt.Errorf("work unit is not in running state, cannot be restarted")
+}
+
+// Cancel stops the container, if running, and removes it.
+func (cu *ContainerUnit) Cancel() error {
+	cu.CancelContext()
+	cu.UpdateBasicStatus(WorkStateCanceled, "Canceled", -1)
+	ced := cu.Status().ExtraData.(*ContainerExtraData)
+	if ced.ContainerID == "" {
+		return nil
+	}
+	err := cu.engine.StopContainer(context.Background(), ced.ContainerID, cu.stopTimeout)
+	if err != nil && !errors.Is(err, ErrContainerNotFound) {
+		cu.GetWorkceptor().nc.GetLogger().Warning("Error stopping container %s: %s", ced.ContainerName, err)
+	}
+	err = cu.engine.RemoveContainer(context.Background(), ced.ContainerID)
+	if err != nil && !errors.Is(err, ErrContainerNotFound) {
+		cu.GetWorkceptor().nc.GetLogger().Error("Error removing container %s: %s", ced.ContainerName, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// Release releases resources associated with a job.  Implies Cancel.
+func (cu *ContainerUnit) Release(force bool) error {
+	err := cu.Cancel()
+	if err != nil && !force {
+		return err
+	}
+
+	return cu.BaseWorkUnitForWorkUnit.Release(force)
+}
+
+// **************************************************************************
+// Command line
+// **************************************************************************
+
+// ContainerWorkerCfg is the cmdline configuration object for a worker that runs containers.
+type ContainerWorkerCfg struct {
+	WorkType            string            `required:"true" description:"Name for this worker type"`
+	Engine              string            `description:"Container engine command to use when no socket is given: podman or docker" default:"podman"`
+	Socket              string            `description:"Path of the container engine's Docker-compatible API socket, used instead of the command"`
+	Image               string            `description:"Container image to run"`
+	Command             string            `description:"Command to run in the container (overrides entrypoint)"`
+	Params              string            `description:"Command-line parameters to pass to the entrypoint"`
+	AllowRuntimeCommand bool              `description:"Allow specifying image & command at runtime" default:"false"`
+	AllowRuntimeParams  bool              `description:"Allow adding command parameters at runtime" default:"false"`
+	Mounts              []string          `description:"Host paths to mount, as /host/path:/container/path[:ro]"`
+	Env                 map[string]string `description:"Environment variables to set in the container"`
+	Memory              string            `description:"Memory limit, in bytes or with a k, m or g suffix"`
+	CPUs                string            `description:"Number of CPUs the container may use, e.g. 1.5"`
+	Pull                string            `description:"When to pull the image: missing, always or never" default:"missing"`
+	StopTimeout         string            `description:"Time to wait for the container to stop before killing it when canceled" default:"10s"`
+	VerifySignature     bool              `description:"Verify a signed work submission" default:"false"`
+	MaxStdoutSize       int64             `description:"Maximum size in bytes of the stored stdout of a unit, or 0 for no limit" default:"0"`
+	StdoutOverflow      string            `description:"What to do when stdout exceeds maxstdoutsize: truncate or fail" default:"truncate"`
+	ReleaseAfter        string            `description:"Release completed units after this duration, e.g. 24h" default:""`
+}
+
+// NewWorker is a factory to produce worker instances.
+func (cfg ContainerWorkerCfg) NewWorker(bwu BaseWorkUnitForWorkUnit, w *Workceptor, unitID string, workType string) WorkUnit {
+	var engine ContainerEngine
+	if cfg.Socket != "" {
+		engine = newSocketEngine(cfg.Socket)
+	} else {
+		engine = &cliEngine{command: strings.ToLower(cfg.Engine)}
+	}
+
+	return cfg.NewContainerWorker(bwu, w, unitID, workType, engine)
+}
+
+// NewContainerWorker produces a worker instance using the given container engine.
+func (cfg ContainerWorkerCfg) NewContainerWorker(bwu BaseWorkUnitForWorkUnit, w *Workceptor, unitID string, workType string, engine ContainerEngine) WorkUnit {
+	if bwu == nil {
+		bwu = &BaseWorkUnit{
+			status: StatusFileData{
+				ExtraData: &ContainerExtraData{
+					Image:   cfg.Image,
+					Command: cfg.Command,
+				},
+			},
+		}
+	}
+	// Limits and durations have been validated by Prepare.
+	memory, _ := parseMemory(cfg.Memory)
+	nanoCPUs, _ := parseCPUs(cfg.CPUs)
+	stopTimeout, err := time.ParseDuration(cfg.StopTimeout)
+	if err != nil {
+		stopTimeout = 10 * time.Second
+	}
+	pull := strings.ToLower(cfg.Pull)
+	if pull == "" {
+		pull = PullMissing
+	}
+	cu := &ContainerUnit{
+		BaseWorkUnitForWorkUnit: bwu,
+		engine:                  engine,
+		baseParams:              cfg.Params,
+		allowRuntimeCommand:     cfg.AllowRuntimeCommand,
+		allowRuntimeParams:      cfg.AllowRuntimeParams,
+		mounts:                  cfg.Mounts,
+		env:                     cfg.Env,
+		memory:                  memory,
+		nanoCPUs:                nanoCPUs,
+		pull:                    pull,
+		stopTimeout:             stopTimeout,
+	}
+	cu.BaseWorkUnitForWorkUnit.Init(w, unitID, workType, FileSystem{}, nil)
+
+	return cu
+}
+
+// parseMemory parses a memory limit given in bytes or with a k, m or g suffix.
+func parseMemory(memory string) (int64, error) {
+	if memory == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	number := strings.ToLower(memory)
+	for suffix, m := range map[string]int64{"k": 1 << 10, "m": 1 << 20, "g": 1 << 30} {
+		if trimmed, ok := strings.CutSuffix(number, suffix); ok {
+			number = trimmed
+			multiplier = m
+		}
+	}
+	value, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || value <= 0 || value > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid memory limit %s", memory)
+	}
+
+	return value * multiplier, nil
+}
+
+// parseCPUs parses a number of CPUs into billionths of a CPU.
+func parseCPUs(cpus string) (int64, error) {
+	if cpus == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(cpus, 64)
+	if err != nil || value <= 0 || value > 1e6 {
+		return 0, fmt.Errorf("invalid number of CPUs %s", cpus)
+	}
+
+	return int64(value * 1e9), nil
+}
+
+// checkMount returns an error if a mount is not of the form /host/path:/container/path[:options].
+func checkMount(mount string) error {
+	parts := strings.Split(mount, ":")
+	if len(parts) < 2 || len(parts) > 3 || !filepath.IsAbs(parts[0]) || !path.IsAbs(parts[1]) {
+		return fmt.Errorf("mount %s must be /host/path:/container/path[:options]", mount)
+	}
+
+	return nil
+}
+
+// Prepare inspects the configuration for validity.
+func (cfg ContainerWorkerCfg) Prepare() error {
+	if cfg.Socket == "" {
+		engine := strings.ToLower(cfg.Engine)
+		if engine != "podman" && engine != "docker" {
+			return fmt.Errorf("container engine must be podman or docker")
+		}
+	}
+	if cfg.Image == "" && !cfg.AllowRuntimeCommand {
+		return fmt.Errorf("must specify a container image to run")
+	}
+	if cfg.Image != "" {
+		if err := checkImage(cfg.Image); err != nil {
+			return err
+		}
+	}
+	for _, m := range cfg.Mounts {
+		if err := checkMount(m); err != nil {
+			return err
+		}
+	}
+	for name := range cfg.Env {
+		if err := checkEnvName(name); err != nil {
+			return err
+		}
+	}
+	if _, err := parseMemory(cfg.Memory); err != nil {
+		return err
+	}
+	if _, err := parseCPUs(cfg.CPUs); err != nil {
+		return err
+	}
+	switch strings.ToLower(cfg.Pull) {
+	case "", PullMissing, PullAlways, PullNever:
+	default:
+		return fmt.Errorf("pull policy must be missing, always or never")
+	}
+	if _, err := time.ParseDuration(cfg.StopTimeout); err != nil && cfg.StopTimeout != "" {
+		return fmt.Errorf("invalid stop timeout %s: %s", cfg.StopTimeout, err)
+	}
+	_, err := NewRetentionPolicy(cfg.MaxStdoutSize, cfg.StdoutOverflow, cfg.ReleaseAfter)
+
+	return err
+}
+
+func (cfg ContainerWorkerCfg) GetWorkType() string {
+	return cfg.WorkType
+}
+
+func (cfg ContainerWorkerCfg) GetVerifySignature() bool {
+	return cfg.VerifySignature
+}
+
+// Run runs the action.
+func (cfg ContainerWorkerCfg) Run() error {
+	policy, err := NewRetentionPolicy(cfg.MaxStdoutSize, cfg.StdoutOverflow, cfg.ReleaseAfter)
+	if err != nil {
+		return err
+	}
+	err = MainInstance.RegisterWorker(cfg.WorkType, cfg.NewWorker, cfg.VerifySignature)
+	if err != nil {
+		return err
+	}
+
+	return MainInstance.SetRetentionPolicy(cfg.WorkType, policy)
+}
+
+func init() {
+	version := viper.GetInt("version")
+	if version > 1 {
+		return
+	}
+	cmdline.RegisterConfigTypeForApp("receptor-workers",
+		"work-container", "Run a worker in a local Podman or Docker container", ContainerWorkerCfg{}, cmdline.Section(workersSection))
+}
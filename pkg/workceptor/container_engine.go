@@ -0,0 +1,615 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContainerSpec describes a container to be created by a container engine.
+type ContainerSpec struct {
+	Name       string
+	Image      string
+	Entrypoint []string
+	Cmd        []string
+	Env        map[string]string
+	Mounts     []string
+	Labels     map[string]string
+	Memory     int64
+	NanoCPUs   int64
+	Stdin      bool
+}
+
+// ContainerExit describes how a container exited.
+type ContainerExit struct {
+	ExitCode   int
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// ContainerEngine is the interface to a local container engine such as Podman or Docker.
+type ContainerEngine interface {
+	ImageExists(ctx context.Context, image string) (bool, error)
+	PullImage(ctx context.Context, image string) error
+	CreateContainer(ctx context.Context, spec *ContainerSpec) (string, error)
+	// StartContainer starts a container, and returns once stdin, if any, has been sent to it.
+	StartContainer(ctx context.Context, id string, stdin io.Reader) error
+	// ContainerLogs writes the output of a container, from the beginning, until the container exits.
+	ContainerLogs(ctx context.Context, id string, stdout io.Writer) error
+	WaitContainer(ctx context.Context, id string) (*ContainerExit, error)
+	StopContainer(ctx context.Context, id string, timeout time.Duration) error
+	RemoveContainer(ctx context.Context, id string) error
+}
+
+// ErrContainerNotFound is returned when a container or image does not exist.
+var ErrContainerNotFound = fmt.Errorf("no such container or image")
+
+// sortedEnv returns environment variables as a sorted list of NAME=value strings.
+func sortedEnv(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// demuxContainerOutput copies a multiplexed stdout and stderr stream, as returned by the logs and attach endpoints of
+// the Docker API for containers without a TTY, to a single writer.
+func demuxContainerOutput(dst io.Writer, src io.Reader) error {
+	header := make([]byte, 8)
+	for {
+		_, err := io.ReadFull(src, header)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		_, err = io.CopyN(dst, src, size)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// **************************************************************************
+// REST API over a socket
+// **************************************************************************
+
+// socketEngine drives a container engine through the Docker-compatible REST API on a Unix socket, which both
+// Docker and Podman provide.
+type socketEngine struct {
+	socket string
+	client *http.Client
+}
+
+// newSocketEngine returns an engine using the API socket at the given path.
+func newSocketEngine(socket string) *socketEngine {
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+
+		return d.DialContext(ctx, "unix", socket)
+	}
+
+	return &socketEngine{
+		socket: socket,
+		client: &http.Client{Transport: &http.Transport{DialContext: dial}},
+	}
+}
+
+// request sends a request to the API and returns the response, which is an error unless its status is below 400.
+func (se *socketEngine) request(ctx context.Context, method string, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	u := url.URL{Scheme: "http", Host: "engine", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := se.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+
+		return nil, se.responseError(resp)
+	}
+
+	return resp, nil
+}
+
+// responseError returns the error reported in a failed API response.
+func (se *socketEngine) responseError(resp *http.Response) error {
+	msg := struct{ Message string }{}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &msg) != nil || msg.Message == "" {
+		msg.Message = strings.TrimSpace(string(data))
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrContainerNotFound, msg.Message)
+	}
+
+	return fmt.Errorf("container engine returned %s: %s", resp.Status, msg.Message)
+}
+
+// do sends a request to the API and discards the response.
+func (se *socketEngine) do(ctx context.Context, method string, path string, query url.Values, body interface{}) error {
+	resp, err := se.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(io.Discard, resp.Body)
+
+	return err
+}
+
+// ImageExists returns true if an image is present in the local store.
+func (se *socketEngine) ImageExists(ctx context.Context, image string) (bool, error) {
+	err := se.do(ctx, http.MethodGet, "/images/"+image+"/json", nil, nil)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrContainerNotFound) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// PullImage pulls an image from its registry.
+func (se *socketEngine) PullImage(ctx context.Context, image string) error {
+	resp, err := se.request(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {image}}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// The progress of the pull is streamed as JSON messages, and errors during the pull are reported in them.
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		msg := struct {
+			Error string `json:"error"`
+		}{}
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("error pulling image %s: %s", image, msg.Error)
+		}
+	}
+}
+
+// CreateContainer creates a container and returns its ID.
+func (se *socketEngine) CreateContainer(ctx context.Context, spec *ContainerSpec) (string, error) {
+	body := map[string]interface{}{
+		"Image":       spec.Image,
+		"Env":         sortedEnv(spec.Env),
+		"Labels":      spec.Labels,
+		"OpenStdin":   spec.Stdin,
+		"StdinOnce":   spec.Stdin,
+		"AttachStdin": spec.Stdin,
+		"HostConfig": map[string]interface{}{
+			"Binds":    spec.Mounts,
+			"Memory":   spec.Memory,
+			"NanoCpus": spec.NanoCPUs,
+		},
+	}
+	if len(spec.Entrypoint) > 0 {
+		body["Entrypoint"] = spec.Entrypoint
+	}
+	if len(spec.Cmd) > 0 {
+		body["Cmd"] = spec.Cmd
+	}
+	resp, err := se.request(ctx, http.MethodPost, "/containers/create", url.Values{"name": {spec.Name}}, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	created := struct{ ID string }{}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	if err != nil {
+		return "", err
+	}
+	if created.ID == "" {
+		return "", fmt.Errorf("container engine did not return a container ID")
+	}
+
+	return created.ID, nil
+}
+
+// StartContainer starts a container, first attaching to its stdin if there is any to send.
+func (se *socketEngine) StartContainer(ctx context.Context, id string, stdin io.Reader) error {
+	if stdin == nil {
+		return se.do(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil)
+	}
+	conn, err := se.attachStdin(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	err = se.do(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(conn, stdin)
+	if err != nil {
+		return err
+	}
+
+	// Closing our side of the connection closes the stdin of the container.
+	return conn.(*net.UnixConn).CloseWrite()
+}
+
+// attachStdin attaches to the stdin of a container, returning the hijacked connection to write it to.
+func (se *socketEngine) attachStdin(ctx context.Context, id string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", se.socket)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"stream": {"1"}, "stdin": {"1"}}
+	u := url.URL{Scheme: "http", Host: "engine", Path: "/containers/" + id + "/attach", RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		defer conn.Close()
+
+		return nil, se.responseError(resp)
+	}
+
+	return conn, nil
+}
+
+// ContainerLogs follows the combined stdout and stderr of a container.
+func (se *socketEngine) ContainerLogs(ctx context.Context, id string, stdout io.Writer) error {
+	query := url.Values{"follow": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	resp, err := se.request(ctx, http.MethodGet, "/containers/"+id+"/logs", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return demuxContainerOutput(stdout, resp.Body)
+}
+
+// WaitContainer waits for a container to exit.
+func (se *socketEngine) WaitContainer(ctx context.Context, id string) (*ContainerExit, error) {
+	resp, err := se.request(ctx, http.MethodPost, "/containers/"+id+"/wait", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	waited := struct{ StatusCode int }{}
+	err = json.NewDecoder(resp.Body).Decode(&waited)
+	if err != nil {
+		return nil, err
+	}
+	exit := &ContainerExit{ExitCode: waited.StatusCode}
+	resp, err = se.request(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil)
+	if err != nil {
+		return exit, nil
+	}
+	defer resp.Body.Close()
+	inspect := struct {
+		State struct {
+			StartedAt  time.Time
+			FinishedAt time.Time
+		}
+	}{}
+	if json.NewDecoder(resp.Body).Decode(&inspect) == nil {
+		exit.StartedAt = inspect.State.StartedAt
+		exit.FinishedAt = inspect.State.FinishedAt
+	}
+
+	return exit, nil
+}
+
+// StopContainer stops a container, killing it if it does not exit within the timeout.
+func (se *socketEngine) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	query := url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}}
+
+	return se.do(ctx, http.MethodPost, "/containers/"+id+"/stop", query, nil)
+}
+
+// RemoveContainer removes a container, killing it if it is still running.
+func (se *socketEngine) RemoveContainer(ctx context.Context, id string) error {
+	return se.do(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"1"}}, nil)
+}
+
+// **************************************************************************
+// Command line interface
+// **************************************************************************
+
+// imageReferenceRegex is the form of an image reference: an optional registry host and port, a lower case repository
+// path, and an optional tag and digest.
+var imageReferenceRegex = regexp.MustCompile(`^` +
+	`(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*` +
+	`(?::[0-9]+)?/)?` +
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	`(?::[\w][\w.-]{0,127})?` +
+	`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?$`)
+
+// checkImage returns an error if a string is not a valid image reference.
+func checkImage(image string) error {
+	if !imageReferenceRegex.MatchString(image) {
+		return fmt.Errorf("invalid container image %s", image)
+	}
+
+	return nil
+}
+
+// cliEngine drives a container engine through its command line interface.  The docker and podman commands take
+// the same options for everything used here.
+type cliEngine struct {
+	command string
+}
+
+// run runs the engine command and returns its trimmed stdout.
+func (ce *cliEngine) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, ce.command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if strings.Contains(strings.ToLower(msg), "no such") {
+			return "", fmt.Errorf("%w: %s", ErrContainerNotFound, msg)
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+
+		return "", fmt.Errorf("%s %s failed: %s", ce.command, args[0], msg)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// ImageExists returns true if an image is present in the local store.
+func (ce *cliEngine) ImageExists(ctx context.Context, image string) (bool, error) {
+	if err := checkImage(image); err != nil {
+		return false, err
+	}
+	_, err := ce.run(ctx, "image", "inspect", "--", image)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrContainerNotFound) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// PullImage pulls an image from its registry.
+func (ce *cliEngine) PullImage(ctx context.Context, image string) error {
+	if err := checkImage(image); err != nil {
+		return err
+	}
+	_, err := ce.run(ctx, "pull", "--quiet", "--", image)
+
+	return err
+}
+
+// createArgs returns the arguments of the create command for a container.
+func (ce *cliEngine) createArgs(spec *ContainerSpec) ([]string, error) {
+	if err := checkImage(spec.Image); err != nil {
+		return nil, err
+	}
+	args := []string{"create", "--name", spec.Name}
+	if spec.Stdin {
+		args = append(args, "--interactive")
+	}
+	labels := make([]string, 0, len(spec.Labels))
+	for k, v := range spec.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		args = append(args, "--label", l)
+	}
+	for _, e := range sortedEnv(spec.Env) {
+		args = append(args, "--env", e)
+	}
+	for _, m := range spec.Mounts {
+		args = append(args, "--volume", m)
+	}
+	if spec.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(spec.Memory, 10))
+	}
+	if spec.NanoCPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(float64(spec.NanoCPUs)/1e9, 'f', -1, 64))
+	}
+	if len(spec.Entrypoint) > 0 {
+		if strings.HasPrefix(spec.Entrypoint[0], "-") {
+			return nil, fmt.Errorf("invalid container entrypoint %s", spec.Entrypoint[0])
+		}
+		// The CLI takes a single entrypoint; the rest of the command is passed on as arguments.
+		args = append(args, "--entrypoint", spec.Entrypoint[0])
+	}
+	// Nothing after the image may be taken for an option of the engine.
+	args = append(args, "--", spec.Image)
+	if len(spec.Entrypoint) > 1 {
+		args = append(args, spec.Entrypoint[1:]...)
+	}
+
+	return append(args, spec.Cmd...), nil
+}
+
+// CreateContainer creates a container and returns its ID.
+func (ce *cliEngine) CreateContainer(ctx context.Context, spec *ContainerSpec) (string, error) {
+	args, err := ce.createArgs(spec)
+	if err != nil {
+		return "", err
+	}
+
+	return ce.run(ctx, args...)
+}
+
+// StartContainer starts a container.  With stdin, the container is started attached, and the attached command is
+// left to finish in the background once all of stdin has been read.
+func (ce *cliEngine) StartContainer(ctx context.Context, id string, stdin io.Reader) error {
+	if stdin == nil {
+		_, err := ce.run(ctx, "start", id)
+
+		return err
+	}
+	cmd := exec.CommandContext(ctx, ce.command, "start", "--attach", "--interactive", id)
+	sent := &eofSignalReader{reader: stdin, eof: make(chan struct{})}
+	cmd.Stdin = sent
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	select {
+	case <-sent.eof:
+		return nil
+	case err := <-exited:
+		if err != nil && !sent.done() {
+			return fmt.Errorf("%s start failed: %s", ce.command, strings.TrimSpace(stderr.String()))
+		}
+
+		return nil
+	}
+}
+
+// eofSignalReader closes a channel once its reader returns EOF.
+type eofSignalReader struct {
+	reader io.Reader
+	eof    chan struct{}
+	once   sync.Once
+}
+
+func (er *eofSignalReader) Read(p []byte) (int, error) {
+	n, err := er.reader.Read(p)
+	if err == io.EOF {
+		er.once.Do(func() {
+			close(er.eof)
+		})
+	}
+
+	return n, err
+}
+
+// done returns true if all of the reader has been read.
+func (er *eofSignalReader) done() bool {
+	select {
+	case <-er.eof:
+		return true
+	default:
+		return false
+	}
+}
+
+// ContainerLogs follows the combined stdout and stderr of a container.
+func (ce *cliEngine) ContainerLogs(ctx context.Context, id string, stdout io.Writer) error {
+	cmd := exec.CommandContext(ctx, ce.command, "logs", "--follow", id)
+	cmd.Stdout = stdout
+	cmd.Stderr = stdout
+
+	return cmd.Run()
+}
+
+// WaitContainer waits for a container to exit.
+func (ce *cliEngine) WaitContainer(ctx context.Context, id string) (*ContainerExit, error) {
+	out, err := ce.run(ctx, "wait", id)
+	if err != nil {
+		return nil, err
+	}
+	code, err := strconv.Atoi(out)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected exit code %q from %s wait", out, ce.command)
+	}
+	exit := &ContainerExit{ExitCode: code}
+	out, err = ce.run(ctx, "inspect", "--format", "{{json .State}}", id)
+	if err == nil {
+		state := struct {
+			StartedAt  time.Time
+			FinishedAt time.Time
+		}{}
+		if json.Unmarshal([]byte(out), &state) == nil {
+			exit.StartedAt = state.StartedAt
+			exit.FinishedAt = state.FinishedAt
+		}
+	}
+
+	return exit, nil
+}
+
+// StopContainer stops a container, killing it if it does not exit within the timeout.
+func (ce *cliEngine) StopContainer(ctx context.Context, id string, timeout time.Duration) error {
+	_, err := ce.run(ctx, "stop", "--time", strconv.Itoa(int(timeout.Seconds())), id)
+
+	return err
+}
+
+// RemoveContainer removes a container, killing it if it is still running.
+func (ce *cliEngine) RemoveContainer(ctx context.Context, id string) error {
+	_, err := ce.run(ctx, "rm", "--force", id)
+
+	return err
+}
@@ -0,0 +1,466 @@
+//go:build !windows && !no_workceptor
+// +build !windows,!no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// fakeContainer is a container in a fakeEngine.
+type fakeContainer struct {
+	create    map[string]interface{}
+	stdin     []byte
+	stdinDone chan struct{}
+	started   bool
+	stopped   bool
+}
+
+// fakeEngine serves enough of the Docker-compatible API on a Unix socket to run containers that echo their stdin.
+type fakeEngine struct {
+	sync.Mutex
+	images     map[string]bool
+	pulls      []string
+	containers map[string]*fakeContainer
+	removed    []string
+	exitCode   int
+	started    chan struct{}
+	release    chan struct{}
+}
+
+func newFakeEngine(t *testing.T) (*fakeEngine, string) {
+	fe := &fakeEngine{
+		images:     make(map[string]bool),
+		containers: make(map[string]*fakeContainer),
+		started:    make(chan struct{}, 10),
+	}
+	socket := path.Join(t.TempDir(), "engine.sock")
+	li, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: fe, ReadHeaderTimeout: 5 * time.Second}
+	go srv.Serve(li)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	return fe, socket
+}
+
+func writeFrame(w io.Writer, stream byte, data string) {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	w.Write(header)
+	io.WriteString(w, data)
+}
+
+func (fe *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fe.Lock()
+	defer fe.Unlock()
+	p := r.URL.Path
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(p, "/images/"):
+		if !fe.images[strings.TrimSuffix(strings.TrimPrefix(p, "/images/"), "/json")] {
+			http.Error(w, `{"message":"no such image"}`, http.StatusNotFound)
+		}
+	case p == "/images/create":
+		image := r.URL.Query().Get("fromImage")
+		fe.pulls = append(fe.pulls, image)
+		if image == "missing:latest" {
+			io.WriteString(w, `{"status":"Pulling"}`+"\n"+`{"error":"manifest unknown"}`+"\n")
+
+			return
+		}
+		fe.images[image] = true
+		io.WriteString(w, `{"status":"Pulled"}`+"\n")
+	case p == "/containers/create":
+		create := make(map[string]interface{})
+		json.NewDecoder(r.Body).Decode(&create)
+		id := fmt.Sprintf("id-%s", r.URL.Query().Get("name"))
+		fe.containers[id] = &fakeContainer{create: create}
+		fmt.Fprintf(w, `{"Id":%q}`, id)
+	default:
+		fe.serveContainer(w, r)
+	}
+}
+
+func (fe *fakeEngine) serveContainer(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/containers/"), "/")
+	c, ok := fe.containers[parts[0]]
+	if !ok {
+		http.Error(w, `{"message":"no such container"}`, http.StatusNotFound)
+
+		return
+	}
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	switch action {
+	case "attach":
+		conn, buf, _ := w.(http.Hijacker).Hijack()
+		buf.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		buf.Flush()
+		c.stdinDone = make(chan struct{})
+		go func() {
+			data, _ := io.ReadAll(buf)
+			fe.Lock()
+			c.stdin = data
+			fe.Unlock()
+			conn.Close()
+			close(c.stdinDone)
+		}()
+	case "start":
+		c.started = true
+		fe.started <- struct{}{}
+		w.WriteHeader(http.StatusNoContent)
+	case "logs":
+		release, stdinDone := fe.release, c.stdinDone
+		fe.Unlock()
+		if release != nil {
+			<-release
+		}
+		if stdinDone != nil {
+			<-stdinDone
+		}
+		fe.Lock()
+		writeFrame(w, 1, "stdin: "+string(c.stdin))
+		writeFrame(w, 2, "done\n")
+	case "wait":
+		fmt.Fprintf(w, `{"StatusCode":%d}`, fe.exitCode)
+	case "json":
+		io.WriteString(w, `{"State":{"StartedAt":"2024-01-02T03:04:05Z","FinishedAt":"2024-01-02T03:04:06Z"}}`)
+	case "stop":
+		c.stopped = true
+		w.WriteHeader(http.StatusNoContent)
+	case "":
+		delete(fe.containers, parts[0])
+		fe.removed = append(fe.removed, parts[0])
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func waitForComplete(t *testing.T, unit WorkUnit) *StatusFileData {
+	for i := 0; i < 100; i++ {
+		status := unit.Status()
+		if IsComplete(status.State) {
+			return status
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("unit did not complete: %+v", unit.Status())
+
+	return nil
+}
+
+func newContainerTestWorkceptor(t *testing.T, cfg ContainerWorkerCfg) *Workceptor {
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Cancel)
+	if err := cfg.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.RegisterWorker(cfg.WorkType, cfg.NewWorker, false); err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+// startContainerUnit allocates and starts a unit with empty stdin.
+func startContainerUnit(t *testing.T, w *Workceptor, workType string, params map[string]string) WorkUnit {
+	unit, err := w.AllocateUnit(workType, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(unit.UnitDir(), "stdin"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := unit.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	return unit
+}
+
+func TestContainerUnit(t *testing.T) {
+	fe, socket := newFakeEngine(t)
+	cfg := ContainerWorkerCfg{
+		WorkType:           "container",
+		Socket:             socket,
+		Image:              "alpine:latest",
+		Command:            "/bin/sh -c",
+		Params:             "cat",
+		AllowRuntimeParams: true,
+		Mounts:             []string{"/srv/data:/data:ro"},
+		Env:                map[string]string{"FIXED": "value"},
+		Memory:             "64m",
+		CPUs:               "0.5",
+	}
+	w := newContainerTestWorkceptor(t, cfg)
+	unit, err := w.AllocateUnit("container", map[string]string{"container_params": "-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(unit.UnitDir(), "stdin"), []byte("hello\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := unit.Start(); err != nil {
+		t.Fatal(err)
+	}
+	status := waitForComplete(t, unit)
+	if status.State != WorkStateSucceeded || status.ExitCode != 0 {
+		t.Errorf("unexpected status %s: %s", WorkStateToString(status.State), status.Detail)
+	}
+	stdout, err := os.ReadFile(path.Join(unit.UnitDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stdout) != "stdin: hello\ndone\n" {
+		t.Errorf("unexpected stdout %q", stdout)
+	}
+	ced := status.ExtraData.(*ContainerExtraData)
+	fe.Lock()
+	defer fe.Unlock()
+	if !reflect.DeepEqual(fe.pulls, []string{"alpine:latest"}) {
+		t.Errorf("expected the missing image to be pulled, got %v", fe.pulls)
+	}
+	c := fe.containers[ced.ContainerID]
+	if c == nil || !c.started {
+		t.Fatalf("container %s was not started", ced.ContainerID)
+	}
+	want := map[string]interface{}{
+		"Image":      "alpine:latest",
+		"Entrypoint": []interface{}{"/bin/sh", "-c"},
+		"Cmd":        []interface{}{"cat", "-"},
+		"Env":        []interface{}{"FIXED=value"},
+		"OpenStdin":  true,
+		"StdinOnce":  true,
+		"Labels":     map[string]interface{}{"receptor.unitid": unit.ID(), "receptor.node": "test"},
+		"HostConfig": map[string]interface{}{
+			"Binds":    []interface{}{"/srv/data:/data:ro"},
+			"Memory":   float64(64 << 20),
+			"NanoCpus": float64(5e8),
+		},
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(c.create[k], v) {
+			t.Errorf("expected %s to be %v, got %v", k, v, c.create[k])
+		}
+	}
+	if !status.EndTime.Equal(time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC)) {
+		t.Errorf("unexpected end time %s", status.EndTime)
+	}
+}
+
+func TestContainerUnitFailures(t *testing.T) {
+	fe, socket := newFakeEngine(t)
+	fe.exitCode = 3
+	cfg := ContainerWorkerCfg{WorkType: "container", Socket: socket, Image: "alpine:latest", AllowRuntimeCommand: true, Pull: "never"}
+	w := newContainerTestWorkceptor(t, cfg)
+
+	unit := startContainerUnit(t, w, "container", map[string]string{})
+	status := waitForComplete(t, unit)
+	if status.State != WorkStateFailed || !strings.Contains(status.Detail, "pull policy is never") {
+		t.Errorf("expected pull policy failure, got %s: %s", WorkStateToString(status.State), status.Detail)
+	}
+
+	fe.Lock()
+	fe.images["alpine:latest"] = true
+	fe.Unlock()
+	unit = startContainerUnit(t, w, "container", map[string]string{})
+	status = waitForComplete(t, unit)
+	if status.State != WorkStateFailed || status.ExitCode != 3 {
+		t.Errorf("expected failure with exit code 3, got %s: %s", WorkStateToString(status.State), status.Detail)
+	}
+
+	always := ContainerWorkerCfg{WorkType: "always", Socket: socket, AllowRuntimeCommand: true, Pull: "always"}
+	if err := w.RegisterWorker(always.WorkType, always.NewWorker, false); err != nil {
+		t.Fatal(err)
+	}
+	unit = startContainerUnit(t, w, "always", map[string]string{"container_image": "missing:latest"})
+	status = waitForComplete(t, unit)
+	if status.State != WorkStateFailed || !strings.Contains(status.Detail, "manifest unknown") {
+		t.Errorf("expected pull failure, got %s: %s", WorkStateToString(status.State), status.Detail)
+	}
+}
+
+func TestContainerUnitCancelAndRestart(t *testing.T) {
+	fe, socket := newFakeEngine(t)
+	fe.images["alpine:latest"] = true
+	fe.release = make(chan struct{})
+	cfg := ContainerWorkerCfg{WorkType: "container", Socket: socket, Image: "alpine:latest"}
+	w := newContainerTestWorkceptor(t, cfg)
+
+	unit := startContainerUnit(t, w, "container", map[string]string{})
+	<-fe.started
+	id := ""
+	for i := 0; i < 50 && id == ""; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if unit.Status().State == WorkStateRunning {
+			id = unit.Status().ExtraData.(*ContainerExtraData).ContainerID
+		}
+	}
+	if id == "" {
+		t.Fatal("unit did not start running")
+	}
+
+	// A new instance of the unit, as after a restart, follows the running container.
+	restarted := cfg.NewWorker(nil, w, unit.ID(), "container")
+	if err := restarted.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unit.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	if state := unit.Status().State; state != WorkStateCanceled {
+		t.Errorf("expected canceled state, got %s", WorkStateToString(state))
+	}
+	fe.Lock()
+	if !reflect.DeepEqual(fe.removed, []string{id}) {
+		t.Errorf("expected container %s to be removed, got %v", id, fe.removed)
+	}
+	fe.Unlock()
+	restarted.(*ContainerUnit).CancelContext()
+	close(fe.release)
+
+	// A unit that never started running is failed on restart, and its container removed.
+	pending := cfg.NewWorker(nil, w, "pending", "container")
+	if err := os.MkdirAll(pending.UnitDir(), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	pending.UpdateFullStatus(func(status *StatusFileData) {
+		status.State = WorkStatePending
+		status.ExtraData.(*ContainerExtraData).ContainerID = "id-pending"
+	})
+	fe.Lock()
+	fe.containers["id-pending"] = &fakeContainer{}
+	fe.Unlock()
+	if err := pending.Restart(); err == nil {
+		t.Error("expected error restarting a pending unit")
+	}
+	fe.Lock()
+	defer fe.Unlock()
+	if _, ok := fe.containers["id-pending"]; ok {
+		t.Error("expected the container of the pending unit to be removed")
+	}
+}
+
+func TestContainerConfig(t *testing.T) {
+	good := ContainerWorkerCfg{WorkType: "c", Engine: "docker", Image: "alpine", StopTimeout: "10s"}
+	if err := good.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	bad := []ContainerWorkerCfg{
+		{WorkType: "c", Engine: "rkt", Image: "alpine"},
+		{WorkType: "c", Engine: "podman"},
+		{WorkType: "c", Engine: "podman", Image: "alpine", Mounts: []string{"relative:/data"}},
+		{WorkType: "c", Engine: "podman", Image: "alpine", Memory: "lots"},
+		{WorkType: "c", Engine: "podman", Image: "alpine", CPUs: "-1"},
+		{WorkType: "c", Engine: "podman", Image: "alpine", Pull: "sometimes"},
+		{WorkType: "c", Engine: "podman", Image: "alpine", Env: map[string]string{"BAD-NAME": "x"}},
+	}
+	for _, cfg := range bad {
+		if err := cfg.Prepare(); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+	for memory, want := range map[string]int64{"1024": 1024, "2k": 2048, "64m": 64 << 20, "1G": 1 << 30} {
+		if got, err := parseMemory(memory); err != nil || got != want {
+			t.Errorf("parseMemory(%s) returned %d, %v", memory, got, err)
+		}
+	}
+}
+
+func TestContainerCLIArgs(t *testing.T) {
+	ce := &cliEngine{command: "podman"}
+	args, err := ce.createArgs(&ContainerSpec{
+		Name:       "receptor-abc",
+		Image:      "alpine",
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Cmd:        []string{"cat"},
+		Env:        map[string]string{"B": "2", "A": "1"},
+		Mounts:     []string{"/srv:/srv:ro"},
+		Labels:     map[string]string{"receptor.unitid": "abc"},
+		Memory:     1024,
+		NanoCPUs:   1500000000,
+		Stdin:      true,
+	})
+	want := []string{
+		"create", "--name", "receptor-abc", "--interactive", "--label", "receptor.unitid=abc",
+		"--env", "A=1", "--env", "B=2", "--volume", "/srv:/srv:ro", "--memory", "1024", "--cpus", "1.5",
+		"--entrypoint", "/bin/sh", "--", "alpine", "-c", "cat",
+	}
+	if err != nil || !reflect.DeepEqual(args, want) {
+		t.Errorf("expected %v, got %v, %v", want, args, err)
+	}
+
+	for _, spec := range []*ContainerSpec{
+		{Name: "receptor-abc", Image: "--privileged"},
+		{Name: "receptor-abc", Image: "alpine", Entrypoint: []string{"--privileged"}},
+	} {
+		if _, err := ce.createArgs(spec); err == nil {
+			t.Errorf("expected error for image %s and entrypoint %v", spec.Image, spec.Entrypoint)
+		}
+	}
+}
+
+func TestCheckImage(t *testing.T) {
+	for _, image := range []string{
+		"alpine",
+		"alpine:3.18",
+		"quay.io/example/analyze:latest",
+		"localhost:5000/team/app_name:v1.2-rc.1",
+		"registry.example.com/app@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+	} {
+		if err := checkImage(image); err != nil {
+			t.Errorf("unexpected error for image %s: %s", image, err)
+		}
+	}
+	for _, image := range []string{"", "-alpine", "--pull=always", "Alpine", "alpine:", "../../containers/json", "a b"} {
+		if err := checkImage(image); err == nil {
+			t.Errorf("expected error for image %q", image)
+		}
+	}
+}
+
+func TestDemuxContainerOutput(t *testing.T) {
+	var src, dst bytes.Buffer
+	writeFrame(&src, 1, "out")
+	writeFrame(&src, 2, "err")
+	if err := demuxContainerOutput(&dst, &src); err != nil {
+		t.Fatal(err)
+	}
+	if dst.String() != "outerr" {
+		t.Errorf("unexpected output %q", dst.String())
+	}
+	src.Reset()
+	writeFrame(&src, 1, "truncated")
+	if err := demuxContainerOutput(&dst, bytes.NewReader(src.Bytes()[:10])); err == nil {
+		t.Error("expected error for a truncated frame")
+	}
+}
@@ -6,6 +6,7 @@ package workceptor
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"strconv"
@@ -45,23 +46,70 @@ func (t *workceptorCommandType) InitFromString(params string) (controlsvc.Contro
 			c.params["params"] = strings.Join(tokens[3:], " ")
 		}
 	case "list":
-		if len(tokens) > 1 {
-			c.params["unitid"] = tokens[1]
+		unitIDs, filter, err := filterFromTokens(tokens[1:])
+		if err != nil {
+			return nil, err
+		}
+		if len(unitIDs) > 1 {
+			return nil, fmt.Errorf("work list takes at most one unit ID")
 		}
+		if len(unitIDs) == 1 {
+			c.params["unitid"] = unitIDs[0]
+		}
+		c.params["filter"] = filter
 	case "status", "cancel", "release", "force-release":
 		if len(tokens) < 2 {
 			return nil, fmt.Errorf("work %s requires a unit ID", c.subcommand)
 		}
+		unitIDs, filter, err := filterFromTokens(tokens[1:])
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(unitIDs) == 1 && !filter.selects():
+			c.params["unitid"] = unitIDs[0]
+		case len(unitIDs) > 0 || filter.selects():
+			c.params["unitids"] = unitIDs
+			c.params["filter"] = filter
+		default:
+			return nil, fmt.Errorf("work %s requires a unit ID or selector", c.subcommand)
+		}
+	case "history":
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("work history requires a unit ID")
+		}
+		if len(tokens) > 3 {
+			return nil, fmt.Errorf("work history only takes a unit ID and optional starting event index")
+		}
+		c.params["unitid"] = tokens[1]
 		if len(tokens) > 2 {
-			return nil, fmt.Errorf("work %s does not take parameters after the unit ID", c.subcommand)
+			var err error
+			c.params["since"], err = strconv.ParseInt(tokens[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("error converting starting event index to integer: %s", err)
+			}
+		}
+	case "watch":
+		if len(tokens) > 1 {
+			c.params["unitids"] = tokens[1:]
+		}
+	case "artifacts":
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("work artifacts requires a unit ID")
+		}
+		if len(tokens) > 3 {
+			return nil, fmt.Errorf("work artifacts only takes a unit ID and optional artifact name")
 		}
 		c.params["unitid"] = tokens[1]
+		if len(tokens) > 2 {
+			c.params["name"] = tokens[2]
+		}
 	case "results":
 		if len(tokens) < 2 {
 			return nil, fmt.Errorf("work results requires a unit ID")
 		}
-		if len(tokens) > 3 {
-			return nil, fmt.Errorf("work results only takes a unit ID and optional start position")
+		if len(tokens) > 4 {
+			return nil, fmt.Errorf("work results only takes a unit ID, optional start position and optional stream")
 		}
 		c.params["unitid"] = tokens[1]
 		if len(tokens) > 2 {
@@ -73,6 +121,41 @@ func (t *workceptorCommandType) InitFromString(params string) (controlsvc.Contro
 		} else {
 			c.params["startpos"] = int64(0)
 		}
+		if len(tokens) > 3 {
+			c.params["stream"] = strings.ToLower(tokens[3])
+		}
+	case "upload":
+		if len(tokens) != 3 {
+			return nil, fmt.Errorf("work upload requires a unit ID and offset")
+		}
+		c.params["unitid"] = tokens[1]
+		var err error
+		c.params["offset"], err = strconv.ParseInt(tokens[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error converting offset to integer: %s", err)
+		}
+	case "upload-status":
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("work upload-status requires a unit ID")
+		}
+		c.params["unitid"] = tokens[1]
+	case "reconcile":
+		for _, token := range tokens[1:] {
+			if token == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(token, "=")
+			name = strings.ToLower(name)
+			if !ok || (name != "worktype" && name != "dryrun") {
+				return nil, fmt.Errorf("work reconcile only takes worktype=<name> and dryrun=<true|false>")
+			}
+			c.params[name] = value
+		}
+		if _, ok := c.params["dryrun"]; ok {
+			if _, err := boolFromMap(c.params, "dryrun"); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return c, nil
@@ -136,6 +219,75 @@ func boolFromMap(config map[string]interface{}, name string) (bool, error) {
 	return false, fmt.Errorf("field %s value %s is not convertible to a bool", name, value)
 }
 
+// filterFromTokens splits the tokens of a command into unit IDs and a filter built from name=value options.
+func filterFromTokens(tokens []string) ([]string, *UnitFilter, error) {
+	unitIDs := make([]string, 0)
+	options := make(map[string]interface{})
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(token, "=")
+		if !ok {
+			unitIDs = append(unitIDs, token)
+
+			continue
+		}
+		options[strings.ToLower(name)] = value
+	}
+	filter, err := unitFilterFromMap(options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return unitIDs, filter, nil
+}
+
+// unitIDsFromJSON reads the unit IDs given as unitid and unitids in a JSON command.
+func unitIDsFromJSON(config map[string]interface{}) ([]string, error) {
+	unitIDs := make([]string, 0)
+	unitID, err := strFromMap(config, "unitid")
+	if err == nil {
+		unitIDs = append(unitIDs, unitID)
+	}
+	value, ok := config["unitids"]
+	if ok {
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unitids must be a list")
+		}
+		for _, v := range list {
+			unitID, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("unitids must all be strings")
+			}
+			unitIDs = append(unitIDs, unitID)
+		}
+	}
+
+	return unitIDs, nil
+}
+
+// filterFromJSON reads unit IDs and a unit filter from a JSON command.
+func filterFromJSON(config map[string]interface{}) ([]string, *UnitFilter, error) {
+	unitIDs, err := unitIDsFromJSON(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	options := make(map[string]interface{})
+	for _, name := range filterOptionNames {
+		if value, ok := config[name]; ok {
+			options[name] = value
+		}
+	}
+	filter, err := unitFilterFromMap(options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return unitIDs, filter, nil
+}
+
 func (t *workceptorCommandType) InitFromJSON(config map[string]interface{}) (controlsvc.ControlCommand, error) {
 	subCmd, err := strFromMap(config, "subcommand")
 	if err != nil {
@@ -164,18 +316,66 @@ func (t *workceptorCommandType) InitFromJSON(config map[string]interface{}) (con
 			return nil, err
 		}
 	case "status", "cancel", "release", "force-release":
-		c.params["unitid"], err = strFromMap(config, "unitid")
+		unitIDs, filter, err := filterFromJSON(config)
 		if err != nil {
 			return nil, err
 		}
+		_, bulk := config["unitids"]
+		switch {
+		case len(unitIDs) == 1 && !bulk && !filter.selects():
+			c.params["unitid"] = unitIDs[0]
+		case len(unitIDs) > 0 || filter.selects():
+			c.params["unitids"] = unitIDs
+			c.params["filter"] = filter
+			c.params["selector"], err = workSelectorTarget(config)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("work %s requires a unit ID or selector", c.subcommand)
+		}
 		signature, err := strFromMap(config, "signature")
 		if err == nil {
 			c.params["signature"] = signature
 		}
 	case "list":
-		unitID, err := strFromMap(config, "unitid")
+		unitIDs, filter, err := filterFromJSON(config)
+		if err != nil {
+			return nil, err
+		}
+		if len(unitIDs) > 0 {
+			c.params["unitid"] = unitIDs[0]
+		}
+		c.params["filter"] = filter
+	case "history":
+		c.params["unitid"], err = strFromMap(config, "unitid")
+		if err != nil {
+			return nil, err
+		}
+		since, err := intFromMap(config, "since")
+		if err == nil {
+			c.params["since"] = since
+		}
+	case "watch":
+		unitIDs, err := unitIDsFromJSON(config)
+		if err != nil {
+			return nil, err
+		}
+		if len(unitIDs) > 0 {
+			c.params["unitids"] = unitIDs
+		}
+	case "artifacts":
+		c.params["unitid"], err = strFromMap(config, "unitid")
+		if err != nil {
+			return nil, err
+		}
+		name, err := strFromMap(config, "name")
+		if err == nil {
+			c.params["name"] = name
+		}
+		signature, err := strFromMap(config, "signature")
 		if err == nil {
-			c.params["unitid"] = unitID
+			c.params["signature"] = signature
 		}
 	case "results":
 		c.params["unitid"], err = strFromMap(config, "unitid")
@@ -186,28 +386,205 @@ func (t *workceptorCommandType) InitFromJSON(config map[string]interface{}) (con
 		if err != nil {
 			return nil, err
 		}
+		stream, err := strFromMap(config, "stream")
+		if err == nil {
+			c.params["stream"] = strings.ToLower(stream)
+		}
+		compression, err := strFromMap(config, "compression")
+		if err == nil {
+			c.params["compression"] = compression
+		}
+		signature, err := strFromMap(config, "signature")
+		if err == nil {
+			c.params["signature"] = signature
+		}
+	case "upload":
+		c.params["unitid"], err = strFromMap(config, "unitid")
+		if err != nil {
+			return nil, err
+		}
+		c.params["offset"], err = intFromMap(config, "offset")
+		if err != nil {
+			return nil, err
+		}
+		signature, err := strFromMap(config, "signature")
+		if err == nil {
+			c.params["signature"] = signature
+		}
+	case "upload-status":
+		c.params["unitid"], err = strFromMap(config, "unitid")
+		if err != nil {
+			return nil, err
+		}
 		signature, err := strFromMap(config, "signature")
 		if err == nil {
 			c.params["signature"] = signature
 		}
+	case "reconcile":
+		workType, err := strFromMap(config, "worktype")
+		if err == nil {
+			c.params["worktype"] = workType
+		}
+		if _, ok := config["dryrun"]; ok {
+			c.params["dryrun"] = config["dryrun"]
+			if _, err := boolFromMap(c.params, "dryrun"); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return c, nil
 }
 
-func (c *workceptorCommand) processSignature(workType, signature string, connIsUnix, signWork bool) error {
+// processSignature checks the signature of a command on a unit of a work type, or submitting work of the work type.
+// target is the unit ID, and is unused for submissions, which are checked against all of their parameters.  The
+// claims of the signature are returned if it was verified.
+func (c *workceptorCommand) processSignature(workType, target, signature string, connIsUnix, signWork bool,
+) (*WorkClaims, error) {
 	shouldVerifySignature := c.w.ShouldVerifySignature(workType, signWork)
 	if !shouldVerifySignature && signature != "" {
-		return fmt.Errorf("work type did not expect a signature")
+		return nil, fmt.Errorf("work type did not expect a signature")
+	}
+	if !shouldVerifySignature || connIsUnix {
+		return nil, nil
+	}
+	var payloadSHA256 string
+	switch c.subcommand {
+	case "submit":
+		params := make(map[string]string, len(c.params))
+		for k, v := range c.params {
+			params[k], _ = v.(string)
+		}
+		var err error
+		payloadSHA256, err = workSubmitSHA256(params)
+		if err != nil {
+			return nil, err
+		}
+	case "force-release":
+		payloadSHA256 = workCommandSHA256("release", target)
+	default:
+		payloadSHA256 = workCommandSHA256(c.subcommand, target)
+	}
+	claims, err := c.w.verifyWorkClaims(signature, workType, payloadSHA256)
+	if err != nil {
+		return nil, err
+	}
+	if c.subcommand == "submit" && !c.w.AllowLegacySignatures && claims.StdinSHA256 == "" {
+		return nil, fmt.Errorf("token is missing the stdin_sha256 claim")
+	}
+
+	return claims, nil
+}
+
+// stdinChecker is implemented by work units that cannot be given stdin of every size.
+type stdinChecker interface {
+	checkStdinSize(size int64) error
+}
+
+// checkSubmittedStdin returns an error if a unit cannot be given stdin of a size.
+func checkSubmittedStdin(worker WorkUnit, size int64) error {
+	sc, ok := worker.(stdinChecker)
+	if !ok {
+		return nil
 	}
-	if shouldVerifySignature && !connIsUnix {
-		err := c.w.VerifySignature(signature)
+
+	return sc.checkStdinSize(size)
+}
+
+// startSubmittedUnit starts a unit once all of its input data has been received.
+func startSubmittedUnit(worker WorkUnit) (map[string]interface{}, error) {
+	cfr := make(map[string]interface{})
+	cfr["unitid"] = worker.ID()
+	fi, err := os.Stat(path.Join(worker.UnitDir(), "stdin"))
+	if err == nil {
+		err = checkSubmittedStdin(worker, fi.Size())
+		if err != nil {
+			worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error starting worker: %s", err), 0)
+
+			return cfr, err
+		}
+	}
+	worker.UpdateBasicStatus(WorkStatePending, "Starting Worker", 0)
+	err = worker.Start()
+	if err != nil && !IsPending(err) {
+		worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error starting worker: %s", err), 0)
+
+		return cfr, err
+	}
+	if IsPending(err) {
+		cfr["result"] = "Job Submitted"
+	} else {
+		cfr["result"] = "Job Started"
+	}
+
+	return cfr, nil
+}
+
+// receiveUpload receives the input data of a resumable submission from an offset, and starts the unit once all of it
+// has arrived.  If the connection ends early, the data received so far is kept, and the unit keeps waiting for the
+// rest.
+func (c *workceptorCommand) receiveUpload(cfo controlsvc.ControlFuncOperations, worker WorkUnit, offset int64,
+	message string,
+) (map[string]interface{}, error) {
+	unitdir := worker.UnitDir()
+	c.w.beginUpload(worker.ID(), cfo)
+	defer c.w.endUpload(worker.ID())
+	// Another upload may have completed or failed the unit while this one waited for it.
+	_, state, err := c.w.findUpload(worker.ID())
+	if err != nil {
+		return nil, err
+	}
+	received, err := uploadReceived(unitdir)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || offset > received {
+		return nil, fmt.Errorf("offset %d is outside the %d bytes received", offset, received)
+	}
+	upload, err := os.OpenFile(path.Join(unitdir, uploadFileName), os.O_CREATE+os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	err = upload.Truncate(offset)
+	if err == nil {
+		_, err = upload.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		err = cfo.ReadFromConn(message, upload, &controlsvc.SocketConnIO{})
+	}
+	closeErr := upload.Close()
+	if err == nil {
+		err = closeErr
+	}
+	received, statErr := uploadReceived(unitdir)
+	if statErr != nil {
+		return nil, statErr
+	}
+	switch {
+	case received > state.Size:
+		worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Received more than the %d bytes of input data expected", state.Size), 0)
+
+		return nil, fmt.Errorf("received %d bytes of input data, more than the %d expected", received, state.Size)
+	case received < state.Size:
 		if err != nil {
-			return err
+			return nil, err
 		}
+
+		return nil, fmt.Errorf("input data incomplete: received %d of %d bytes", received, state.Size)
+	}
+	err = finishUpload(unitdir, state)
+	if err == errInputMismatch {
+		worker.UpdateBasicStatus(WorkStateFailed, "Input data does not match the signature", 0)
+
+		return nil, err
+	}
+	if err != nil {
+		worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error reading input data: %s", err), 0)
+
+		return nil, err
 	}
 
-	return nil
+	return startSubmittedUnit(worker)
 }
 
 func getSignWorkFromStatus(status *StatusFileData) bool {
@@ -252,8 +629,42 @@ func (c *workceptorCommand) ControlFunc(ctx context.Context, nc controlsvc.Netce
 		if err != nil {
 			signature = ""
 		}
+		callback, err := strFromMap(c.params, "callback")
+		if err != nil {
+			callback = ""
+		}
+		if callback != "" {
+			err = c.w.checkCallback(callback, connIsUnix)
+			if err != nil {
+				return nil, err
+			}
+		}
+		labelsStr, err := strFromMap(c.params, "labels")
+		if err != nil {
+			labelsStr = ""
+		}
+		labels, err := ParseLabels(labelsStr)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := strFromMap(c.params, "inputfiles")
+		if err != nil {
+			manifest = ""
+		}
+		inputFiles, err := ParseInputManifest(manifest)
+		if err != nil {
+			return nil, err
+		}
 		workParams := make(map[string]string)
-		nonParams := []string{"command", "subcommand", "node", "worktype", "tlsclient", "ttl", "signwork", "signature"}
+		stdinSize := int64(-1)
+		stdinSizeStr, err := strFromMap(c.params, "stdinsize")
+		if err == nil {
+			stdinSize, err = strconv.ParseInt(stdinSizeStr, 10, 64)
+			if err != nil || stdinSize < 0 {
+				return nil, fmt.Errorf("invalid stdinsize %s", stdinSizeStr)
+			}
+		}
+		nonParams := []string{"command", "subcommand", "node", "worktype", "tlsclient", "ttl", "signwork", "signature", "callback", "labels", "inputfiles", "stdinsize"}
 		inNonParams := func(p string) bool {
 			for _, nonparam := range nonParams {
 				if p == nonparam {
@@ -273,7 +684,7 @@ func (c *workceptorCommand) ControlFunc(ctx context.Context, nc controlsvc.Netce
 			}
 			workParams[k] = vStr
 		}
-		err = c.processSignature(workType, signature, connIsUnix, signWork)
+		claims, err := c.processSignature(workType, "", signature, connIsUnix, signWork)
 		if err != nil {
 			return nil, err
 		}
@@ -285,50 +696,87 @@ func (c *workceptorCommand) ControlFunc(ctx context.Context, nc controlsvc.Netce
 			}
 			worker, err = c.w.AllocateUnit(workType, workParams)
 		} else {
+			if len(labels) > 0 {
+				// Label the unit on the remote node too, so it can be found there.
+				workParams["labels"] = labelsStr
+			}
+			if len(inputFiles) > 0 {
+				err = c.w.checkRemoteInputFiles(workNode, workType)
+				if err != nil {
+					return nil, err
+				}
+			}
 			worker, err = c.w.AllocateRemoteUnit(workNode, workType, tlsClient, ttl, signWork, workParams)
 		}
 		if err != nil {
 			return nil, err
 		}
-		cfr := make(map[string]interface{})
-		cfr["unitid"] = worker.ID()
-		stdin, err := os.OpenFile(path.Join(worker.UnitDir(), "stdin"), os.O_CREATE+os.O_WRONLY, 0o600)
-		if err != nil {
-			return nil, err
+		if callback != "" || len(labels) > 0 {
+			worker.UpdateFullStatus(func(status *StatusFileData) {
+				status.Callback = callback
+				if len(labels) > 0 {
+					status.Labels = labels
+				}
+			})
 		}
 		worker.UpdateBasicStatus(WorkStatePending, "Waiting for Input Data", 0)
-		err = cfo.ReadFromConn(fmt.Sprintf("Work unit created with ID %s. Send stdin data and EOF.\n", worker.ID()), stdin, &controlsvc.SocketConnIO{})
+		stdinSHA256 := ""
+		if claims != nil {
+			stdinSHA256 = claims.StdinSHA256
+		}
+		message := fmt.Sprintf("Work unit created with ID %s. Send stdin data and EOF.\n", worker.ID())
+		if stdinSize >= 0 {
+			// The declared size lets units that cannot take stdin be rejected before any input data is sent.
+			stdinOnlySize := stdinSize
+			for _, f := range inputFiles {
+				stdinOnlySize -= f.Size
+			}
+			err = checkSubmittedStdin(worker, stdinOnlySize)
+			if err != nil {
+				worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error starting worker: %s", err), 0)
+
+				return nil, err
+			}
+			err = writeUploadState(worker.UnitDir(), &uploadState{Size: stdinSize, InputFiles: manifest, StdinSHA256: stdinSHA256})
+			if err != nil {
+				worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error preparing upload: %s", err), 0)
+
+				return nil, err
+			}
+
+			return c.receiveUpload(cfo, worker, 0, message)
+		}
+		// The contents of the input files come first, followed by stdin.
+		input, err := openUnitInput(worker.UnitDir(), inputFiles, stdinSHA256)
 		if err != nil {
-			worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error reading input data: %s", err), 0)
+			return nil, err
+		}
+		err = cfo.ReadFromConn(message, input, &controlsvc.SocketConnIO{})
+		if err == nil {
+			err = input.Close()
+		}
+		if err == errInputMismatch {
+			worker.UpdateBasicStatus(WorkStateFailed, "Input data does not match the signature", 0)
 
 			return nil, err
 		}
-		err = stdin.Close()
 		if err != nil {
 			worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error reading input data: %s", err), 0)
 
 			return nil, err
 		}
-		worker.UpdateBasicStatus(WorkStatePending, "Starting Worker", 0)
-		err = worker.Start()
-		if err != nil && !IsPending(err) {
-			worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Error starting worker: %s", err), 0)
-
-			return cfr, err
-		}
-		if IsPending(err) {
-			cfr["result"] = "Job Submitted"
-		} else {
-			cfr["result"] = "Job Started"
-		}
 
-		return cfr, nil
+		return startSubmittedUnit(worker)
 	case "list":
 		var unitList []string
 		targetUnitID, ok := c.params["unitid"].(string)
-		if ok {
+		filter, _ := c.params["filter"].(*UnitFilter)
+		switch {
+		case ok:
 			unitList = append(unitList, targetUnitID)
-		} else {
+		case filter != nil:
+			unitList = c.w.ListUnits(filter)
+		default:
 			unitList = c.w.ListKnownUnitIDs()
 		}
 		cfr := make(map[string]interface{})
@@ -343,6 +791,9 @@ func (c *workceptorCommand) ControlFunc(ctx context.Context, nc controlsvc.Netce
 
 		return cfr, nil
 	case "status":
+		if _, ok := c.params["unitids"]; ok {
+			return c.bulkControlFunc(connIsUnix)
+		}
 		unitid, err := strFromMap(c.params, "unitid")
 		if err != nil {
 			return nil, err
@@ -352,8 +803,35 @@ func (c *workceptorCommand) ControlFunc(ctx context.Context, nc controlsvc.Netce
 			return nil, err
 		}
 
+		return cfr, nil
+	case "history":
+		unitid, err := strFromMap(c.params, "unitid")
+		if err != nil {
+			return nil, err
+		}
+		since, err := intFromMap(c.params, "since")
+		if err != nil {
+			since = 0
+		}
+		events, err := c.w.UnitHistory(unitid)
+		if err != nil {
+			return nil, err
+		}
+		if since < 0 {
+			since = 0
+		}
+		if since > int64(len(events)) {
+			since = int64(len(events))
+		}
+		cfr := make(map[string]interface{})
+		cfr["UnitID"] = unitid
+		cfr["Events"] = events[since:]
+
 		return cfr, nil
 	case "cancel", "release", "force-release":
+		if _, ok := c.params["unitids"]; ok {
+			return c.bulkControlFunc(connIsUnix)
+		}
 		unitid, err := strFromMap(c.params, "unitid")
 		if err != nil {
 			return nil, err
@@ -363,40 +841,152 @@ func (c *workceptorCommand) ControlFunc(ctx context.Context, nc controlsvc.Netce
 			signature = ""
 		}
 		cfr := make(map[string]interface{})
-		var pendingMsg string
-		var completeMsg string
-		if c.subcommand == "cancel" {
-			pendingMsg = "cancel pending"
-			completeMsg = "cancelled"
-		} else {
-			pendingMsg = "release pending"
-			completeMsg = "released"
+		if _, err := c.w.findUnit(unitid); err != nil {
+			cfr["unit not found"] = unitid
+
+			return cfr, err
 		}
-		unit, err := c.w.findUnit(unitid)
+		result, err := c.cancelOrRelease(unitid, unitid, signature, connIsUnix)
 		if err != nil {
-			cfr["unit not found"] = unitid
+			return nil, err
+		}
+		cfr[result] = unitid
 
+		return cfr, nil
+	case "reconcile":
+		workType, _ := c.params["worktype"].(string)
+		dryRun, _ := boolFromMap(c.params, "dryrun")
+		if !dryRun && !connIsUnix {
+			return nil, fmt.Errorf("work reconcile can only remove or adopt pods over a local control socket")
+		}
+		results, err := c.w.ReconcileOrphans(workType, dryRun)
+		cfr := make(map[string]interface{})
+		for name, actions := range results {
+			cfr[name] = actions
+		}
+		if err != nil {
 			return cfr, err
 		}
+
+		return cfr, nil
+	case "watch":
+		unitIDs, _ := c.params["unitids"].([]string)
+		watchCtx, watchCancel := context.WithCancel(ctx)
+		defer watchCancel()
+		eventChan, err := c.w.watchUnits(watchCtx, unitIDs)
+		if err != nil {
+			return nil, err
+		}
+		header := "Watching all work units\n"
+		if len(unitIDs) > 0 {
+			header = fmt.Sprintf("Watching work units %s\n", strings.Join(unitIDs, " "))
+		}
+		err = cfo.WriteToConn(header, eventChan)
+		if err != nil {
+			return nil, err
+		}
+
+		err = cfo.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	case "artifacts":
+		unitid, err := strFromMap(c.params, "unitid")
+		if err != nil {
+			return nil, err
+		}
+		signature, err := strFromMap(c.params, "signature")
+		if err != nil {
+			signature = ""
+		}
+		status, err := c.w.UnitStatus(unitid)
+		if err != nil {
+			return nil, err
+		}
+		_, err = c.processSignature(status.WorkType, unitid, signature, connIsUnix, getSignWorkFromStatus(status))
+		if err != nil {
+			return nil, err
+		}
+		name, err := strFromMap(c.params, "name")
+		if err != nil {
+			artifacts, err := c.w.UnitArtifacts(unitid)
+			if err != nil {
+				return nil, err
+			}
+			cfr := make(map[string]interface{})
+			cfr["UnitID"] = unitid
+			cfr["Artifacts"] = artifacts
+
+			return cfr, nil
+		}
+		artifactChan, err := c.w.artifactStream(ctx, unitid, name)
+		if err != nil {
+			return nil, err
+		}
+		err = cfo.WriteToConn(fmt.Sprintf("Streaming artifact %s of work unit %s\n", name, unitid), artifactChan)
+		if err != nil {
+			return nil, err
+		}
+
+		err = cfo.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	case "upload":
+		unitid, err := strFromMap(c.params, "unitid")
+		if err != nil {
+			return nil, err
+		}
+		offset, err := intFromMap(c.params, "offset")
+		if err != nil {
+			return nil, err
+		}
+		signature, err := strFromMap(c.params, "signature")
+		if err != nil {
+			signature = ""
+		}
+		unit, _, err := c.w.findUpload(unitid)
+		if err != nil {
+			return nil, err
+		}
 		status := unit.Status()
-		signWork := getSignWorkFromStatus(status)
-		err = c.processSignature(status.WorkType, signature, connIsUnix, signWork)
+		_, err = c.processSignature(status.WorkType, unitid, signature, connIsUnix, getSignWorkFromStatus(status))
 		if err != nil {
 			return nil, err
 		}
-		if c.subcommand == "cancel" {
-			err = unit.Cancel()
-		} else {
-			err = unit.Release(c.subcommand == "force-release")
+
+		return c.receiveUpload(cfo, unit, offset,
+			fmt.Sprintf("Resuming upload to work unit %s at offset %d. Send stdin data and EOF.\n", unitid, offset))
+	case "upload-status":
+		unitid, err := strFromMap(c.params, "unitid")
+		if err != nil {
+			return nil, err
+		}
+		signature, err := strFromMap(c.params, "signature")
+		if err != nil {
+			signature = ""
+		}
+		unit, state, err := c.w.findUpload(unitid)
+		if err != nil {
+			return nil, err
 		}
-		if err != nil && !IsPending(err) {
+		status := unit.Status()
+		_, err = c.processSignature(status.WorkType, unitid, signature, connIsUnix, getSignWorkFromStatus(status))
+		if err != nil {
 			return nil, err
 		}
-		if IsPending(err) {
-			cfr[pendingMsg] = unitid
-		} else {
-			cfr[completeMsg] = unitid
+		received, err := uploadReceived(unit.UnitDir())
+		if err != nil {
+			return nil, err
 		}
+		cfr := make(map[string]interface{})
+		cfr["unitid"] = unitid
+		cfr["received"] = received
+		cfr["size"] = state.Size
 
 		return cfr, nil
 	case "results":
@@ -408,6 +998,14 @@ func (c *workceptorCommand) ControlFunc(ctx context.Context, nc controlsvc.Netce
 		if err != nil {
 			return nil, err
 		}
+		stream, err := strFromMap(c.params, "stream")
+		if err != nil {
+			stream = StreamStdout
+		}
+		offered, err := strFromMap(c.params, "compression")
+		if err != nil {
+			offered = ""
+		}
 		signature, err := strFromMap(c.params, "signature")
 		if err != nil {
 			signature = ""
@@ -418,16 +1016,25 @@ func (c *workceptorCommand) ControlFunc(ctx context.Context, nc controlsvc.Netce
 		}
 		status := unit.Status()
 		signWork := getSignWorkFromStatus(status)
-		err = c.processSignature(status.WorkType, signature, connIsUnix, signWork)
+		_, err = c.processSignature(status.WorkType, unitid, signature, connIsUnix, signWork)
 		if err != nil {
 			return nil, err
 		}
 
-		resultChan, err := c.w.GetResults(ctx, unitid, startPos)
+		resultChan, err := c.w.GetResultsStream(ctx, unitid, stream, startPos)
 		if err != nil {
 			return nil, err
 		}
-		err = cfo.WriteToConn(fmt.Sprintf("Streaming results for work unit %s\n", unitid), resultChan)
+		header := fmt.Sprintf("Streaming results for work unit %s\n", unitid)
+		compression := negotiateCompression(offered)
+		if compression != "" {
+			resultChan, err = compressResults(ctx, resultChan, compression)
+			if err != nil {
+				return nil, err
+			}
+			header = fmt.Sprintf("Streaming results for work unit %s with %s compression\n", unitid, compression)
+		}
+		err = cfo.WriteToConn(header, resultChan)
 		if err != nil {
 			return nil, err
 		}
@@ -442,3 +1049,97 @@ func (c *workceptorCommand) ControlFunc(ctx context.Context, nc controlsvc.Netce
 
 	return nil, fmt.Errorf("bad command")
 }
+
+// cancelOrRelease cancels or releases a unit, after checking the signature if its work type requires one.  target is
+// what the signature was made for: the unit ID, or the selector of a bulk command.  It returns whether the operation
+// completed or is pending.
+func (c *workceptorCommand) cancelOrRelease(unitID string, target string, signature string, connIsUnix bool,
+) (string, error) {
+	status, err := c.w.UnitStatus(unitID)
+	if err != nil {
+		return "", err
+	}
+	_, err = c.processSignature(status.WorkType, target, signature, connIsUnix, getSignWorkFromStatus(status))
+	if err != nil {
+		return "", err
+	}
+	if c.subcommand == "cancel" {
+		err = c.w.CancelUnit(unitID)
+	} else {
+		err = c.w.ReleaseUnit(unitID, c.subcommand == "force-release")
+	}
+	switch {
+	case IsPending(err) && c.subcommand == "cancel":
+		return "cancel pending", nil
+	case IsPending(err):
+		return "release pending", nil
+	case err != nil:
+		return "", err
+	case c.subcommand == "cancel":
+		return "cancelled", nil
+	}
+
+	return "released", nil
+}
+
+// selectUnits returns the units a bulk command applies to.  Units given by ID are kept if they match the filter,
+// or if they are unknown so that the error is reported.  Without unit IDs, all units matching the filter are selected.
+func (c *workceptorCommand) selectUnits() []string {
+	unitIDs, _ := c.params["unitids"].([]string)
+	filter, _ := c.params["filter"].(*UnitFilter)
+	if filter == nil {
+		filter = &UnitFilter{}
+	}
+	if len(unitIDs) == 0 {
+		return c.w.ListUnits(filter)
+	}
+	selected := make([]string, 0, len(unitIDs))
+	seen := make(map[string]bool)
+	for _, unitID := range unitIDs {
+		if seen[unitID] {
+			continue
+		}
+		seen[unitID] = true
+		unit, err := c.w.findUnit(unitID)
+		if err == nil {
+			status := unit.Status()
+			if !filter.matches(status, unitCreateTime(unit, status)) {
+				continue
+			}
+		}
+		selected = append(selected, unitID)
+	}
+
+	return selected
+}
+
+// bulkControlFunc runs a status, cancel or release command on several units, returning a result or error for each.
+// A signature of a bulk command is made for its selector, as given by workSelectorTarget, so one signature covers all
+// of the units it selects but cannot be reused for other units.
+func (c *workceptorCommand) bulkControlFunc(connIsUnix bool) (map[string]interface{}, error) {
+	signature, err := strFromMap(c.params, "signature")
+	if err != nil {
+		signature = ""
+	}
+	selector, err := strFromMap(c.params, "selector")
+	if err != nil {
+		selector = ""
+	}
+	cfr := make(map[string]interface{})
+	for _, unitID := range c.selectUnits() {
+		var result map[string]interface{}
+		if c.subcommand == "status" {
+			result, err = c.w.unitStatusForCFR(unitID)
+		} else {
+			var r string
+			r, err = c.cancelOrRelease(unitID, selector, signature, connIsUnix)
+			result = map[string]interface{}{"Result": r}
+		}
+		if err != nil {
+			result = map[string]interface{}{"Error": err.Error()}
+		}
+		cfr[unitID] = result
+	}
+
+	return cfr, nil
+}
@@ -4,9 +4,19 @@
 package workceptor
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
 	"testing"
 
 	"github.com/ansible/receptor/pkg/controlsvc"
+	"github.com/ansible/receptor/pkg/netceptor"
 )
 
 func Test_workceptorCommandTypeInitFromString(t *testing.T) {
@@ -43,6 +53,36 @@ func Test_workceptorCommandTypeInitFromString(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Positive history",
+			fields: fields{
+				w: nil,
+			},
+			args: args{
+				params: "history u 2",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Positive watch",
+			fields: fields{
+				w: nil,
+			},
+			args: args{
+				params: "watch u1 u2",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Positive artifacts",
+			fields: fields{
+				w: nil,
+			},
+			args: args{
+				params: "artifacts u report.json",
+			},
+			wantErr: false,
+		},
 		{
 			name: "Positive list",
 			fields: fields{
@@ -53,6 +93,36 @@ func Test_workceptorCommandTypeInitFromString(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Positive list with filters",
+			fields: fields{
+				w: nil,
+			},
+			args: args{
+				params: "list labels=tenant=a worktype=command state=Running,Failed olderthan=1h limit=10",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Positive bulk cancel",
+			fields: fields{
+				w: nil,
+			},
+			args: args{
+				params: "cancel u1 u2 u3",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Positive bulk release with selector",
+			fields: fields{
+				w: nil,
+			},
+			args: args{
+				params: "release labels=tenant=a state=Succeeded",
+			},
+			wantErr: false,
+		},
 		{
 			name: "Positive release",
 			fields: fields{
@@ -73,6 +143,16 @@ func Test_workceptorCommandTypeInitFromString(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Positive results with stream",
+			fields: fields{
+				w: nil,
+			},
+			args: args{
+				params: "results u 0 stderr",
+			},
+			wantErr: false,
+		},
 		{
 			name: "Positive status",
 			fields: fields{
@@ -83,6 +163,26 @@ func Test_workceptorCommandTypeInitFromString(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Positive reconcile",
+			fields: fields{
+				w: nil,
+			},
+			args: args{
+				params: "reconcile worktype=kube dryrun=true",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Negative reconcile",
+			fields: fields{
+				w: nil,
+			},
+			args: args{
+				params: "reconcile kube",
+			},
+			wantErr: true,
+		},
 		{
 			name: "Positive submit",
 			fields: fields{
@@ -105,9 +205,465 @@ func Test_workceptorCommandTypeInitFromString(t *testing.T) {
 
 				return
 			}
-			if got == nil {
+			if got == nil && !tt.wantErr {
 				t.Errorf("workceptorCommandType.InitFromString() returned nil")
 			}
 		})
 	}
 }
+
+func TestBulkControlFunc(t *testing.T) {
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	err = w.RegisterWorker("command", newCommandWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labelled := make([]string, 0)
+	for _, tenant := range []string{"a", "a", "b"} {
+		unit, err := w.AllocateUnit("command", make(map[string]string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		unit.UpdateFullStatus(func(status *StatusFileData) {
+			status.State = WorkStateSucceeded
+			status.Labels = map[string]string{"tenant": tenant}
+		})
+		if tenant == "a" {
+			labelled = append(labelled, unit.ID())
+		}
+	}
+	ct := &workceptorCommandType{w: w}
+
+	cmd, err := ct.InitFromJSON(map[string]interface{}{
+		"subcommand": "status",
+		"unitids":    []interface{}{labelled[0], "nonexistent"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfr, err := cmd.(*workceptorCommand).bulkControlFunc(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfr) != 2 {
+		t.Fatalf("expected results for 2 units, got %v", cfr)
+	}
+	if state := cfr[labelled[0]].(map[string]interface{})["StateName"]; state != "Succeeded" {
+		t.Errorf("expected Succeeded, got %v", state)
+	}
+	if _, ok := cfr["nonexistent"].(map[string]interface{})["Error"]; !ok {
+		t.Errorf("expected an error for an unknown unit, got %v", cfr["nonexistent"])
+	}
+
+	cmd, err = ct.InitFromString("force-release labels=tenant=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfr, err = cmd.(*workceptorCommand).bulkControlFunc(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfr) != 2 {
+		t.Fatalf("expected 2 units released, got %v", cfr)
+	}
+	for _, unitID := range labelled {
+		if result := cfr[unitID].(map[string]interface{})["Result"]; result != "released" {
+			t.Errorf("expected unit %s released, got %v", unitID, cfr[unitID])
+		}
+	}
+	if remaining := w.ListKnownUnitIDs(); len(remaining) != 1 {
+		t.Errorf("expected 1 unit left, got %v", remaining)
+	}
+
+	_, err = ct.InitFromString("release limit=5")
+	if err == nil {
+		t.Error("expected error releasing without a unit ID or selector")
+	}
+}
+
+func TestReconcileOrphans(t *testing.T) {
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	for _, name := range []string{"command", "other"} {
+		if err := w.RegisterWorker(name, newCommandWorker, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.unitDirExists(unit.ID()) || w.unitDirExists("nonexistent") {
+		t.Error("unexpected result of unitDirExists")
+	}
+	var gotDryRun bool
+	err = w.SetOrphanReconciler("command", func(dryRun bool) ([]string, error) {
+		gotDryRun = dryRun
+
+		return []string{"deleted pod p"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetOrphanReconciler("unknown", nil); err == nil {
+		t.Error("expected error setting the reconciler of an unknown work type")
+	}
+
+	ct := &workceptorCommandType{w: w}
+	cmd, err := ct.InitFromJSON(map[string]interface{}{"subcommand": "reconcile", "dryrun": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := w.ReconcileOrphans("", cmd.(*workceptorCommand).params["dryrun"] == "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotDryRun || len(results) != 1 || len(results["command"]) != 1 {
+		t.Errorf("unexpected reconcile results %v", results)
+	}
+	if _, err := w.ReconcileOrphans("other", false); err == nil {
+		t.Error("expected error reconciling a work type without a reconciler")
+	}
+	if _, err := ct.InitFromJSON(map[string]interface{}{"subcommand": "reconcile", "dryrun": "maybe"}); err == nil {
+		t.Error("expected error for an invalid dryrun value")
+	}
+}
+
+// fakeSubmitConn is a non-unix control connection that sends stdin to a work submission.
+type fakeSubmitConn struct {
+	controlsvc.ControlFuncOperations
+	stdin   string
+	message string
+}
+
+func (f *fakeSubmitConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (f *fakeSubmitConn) ReadFromConn(message string, out io.Writer, _ controlsvc.Copier) error {
+	f.message = message
+	_, err := io.WriteString(out, f.stdin)
+
+	return err
+}
+
+// startedUnit is a command unit that succeeds as soon as it is started.
+type startedUnit struct {
+	WorkUnit
+}
+
+func (u *startedUnit) Start() error {
+	u.UpdateBasicStatus(WorkStateSucceeded, "Started", 0)
+
+	return nil
+}
+
+func TestSignedSubmit(t *testing.T) {
+	private, public := writeTestSigningKeys(t)
+	signer := newSigningTestWorkceptor(t, "signer")
+	signer.SigningKey = private
+	w := newSigningTestWorkceptor(t, "test")
+	w.VerifyingKey = public
+	err := w.RegisterWorker("signed", func(bwu BaseWorkUnitForWorkUnit, w *Workceptor, unitID, workType string) WorkUnit {
+		return &startedUnit{newCommandWorker(bwu, w, unitID, workType)}
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"command": "work", "subcommand": "submit", "node": "test", "worktype": "signed", "param": "x"}
+	digest := sha256.Sum256([]byte("hello"))
+	signature, err := signer.createSubmitSignature("test", "signed", params, hex.EncodeToString(digest[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := &workceptorCommandType{w: w}
+	tests := []struct {
+		name     string
+		param    string
+		stdin    string
+		errorMsg string
+	}{
+		{name: "matching payload", param: "x", stdin: "hello"},
+		{name: "other stdin", param: "x", stdin: "goodbye", errorMsg: "input data does not match the signature"},
+		{name: "other params", param: "y", stdin: "hello", errorMsg: "token was issued for a different command"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := map[string]interface{}{"signature": signature}
+			for k, v := range params {
+				config[k] = v
+			}
+			config["param"] = tc.param
+			cmd, err := ct.InitFromJSON(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfr, err := cmd.ControlFunc(context.Background(), netceptor.New(context.Background(), "test"), &fakeSubmitConn{stdin: tc.stdin})
+			if tc.errorMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %s", err)
+				}
+				status, err := w.unitStatusForCFR(cfr["unitid"].(string))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if status["StateName"] != "Succeeded" {
+					t.Errorf("expected the unit to start, got %v", status)
+				}
+
+				return
+			}
+			if err == nil || err.Error() != tc.errorMsg {
+				t.Errorf("expected error %s, got %v", tc.errorMsg, err)
+			}
+		})
+	}
+	for _, unitID := range w.ListKnownUnitIDs() {
+		status, err := w.unitStatusForCFR(unitID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status["StateName"] == "Failed" && status["Detail"] != "Input data does not match the signature" {
+			t.Errorf("unexpected failure of unit %s: %v", unitID, status["Detail"])
+		}
+	}
+
+	signature, err = signer.createSubmitSignature("test", "signed", params, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := map[string]interface{}{"signature": signature}
+	for k, v := range params {
+		config[k] = v
+	}
+	cmd, err := ct.InitFromJSON(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cmd.ControlFunc(context.Background(), netceptor.New(context.Background(), "test"), &fakeSubmitConn{})
+	if err == nil || err.Error() != "token is missing the stdin_sha256 claim" {
+		t.Errorf("expected error submitting work without a stdin_sha256 claim, got %v", err)
+	}
+	w.AllowLegacySignatures = true
+	if _, err := cmd.ControlFunc(context.Background(), netceptor.New(context.Background(), "test"), &fakeSubmitConn{}); err != nil {
+		t.Errorf("expected a legacy signature to be allowed, got %v", err)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	w := newSigningTestWorkceptor(t, "test")
+	err := w.RegisterWorker("started", func(bwu BaseWorkUnitForWorkUnit, w *Workceptor, unitID, workType string) WorkUnit {
+		return &startedUnit{newCommandWorker(bwu, w, unitID, workType)}
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := &workceptorCommandType{w: w}
+	run := func(config map[string]interface{}, conn *fakeSubmitConn) (map[string]interface{}, error) {
+		cmd, err := ct.InitFromJSON(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cmd.ControlFunc(context.Background(), netceptor.New(context.Background(), "test"), conn)
+	}
+
+	conn := &fakeSubmitConn{stdin: "abcst"}
+	_, err = run(map[string]interface{}{
+		"command": "work", "subcommand": "submit", "node": "test", "worktype": "started",
+		"inputfiles": "a=3", "stdinsize": "8",
+	}, conn)
+	if err == nil || err.Error() != "input data incomplete: received 5 of 8 bytes" {
+		t.Fatalf("expected an incomplete upload, got %v", err)
+	}
+	var unitID string
+	if _, err := fmt.Sscanf(conn.message, "Work unit created with ID %s", &unitID); err != nil {
+		t.Fatal(err)
+	}
+	unitID = strings.TrimSuffix(unitID, ".")
+	cfr, err := run(map[string]interface{}{"command": "work", "subcommand": "upload-status", "unitid": unitID}, &fakeSubmitConn{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfr["received"] != int64(5) || cfr["size"] != int64(8) {
+		t.Errorf("unexpected upload status %v", cfr)
+	}
+	if _, err := run(map[string]interface{}{"command": "work", "subcommand": "upload", "unitid": unitID, "offset": float64(6)}, &fakeSubmitConn{}); err == nil {
+		t.Error("expected error uploading from beyond the data received")
+	}
+
+	// Resume from before the end of the data received, as if the last bytes were lost.
+	cfr, err = run(map[string]interface{}{"command": "work", "subcommand": "upload", "unitid": unitID, "offset": float64(4)}, &fakeSubmitConn{stdin: "tdin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfr["result"] != "Job Started" {
+		t.Errorf("expected the unit to start, got %v", cfr)
+	}
+	unit, err := w.findUnit(unitID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"inputs/a": "abc", "stdin": "stdin"} {
+		data, err := os.ReadFile(path.Join(unit.UnitDir(), name))
+		if err != nil || string(data) != content {
+			t.Errorf("expected %s to be %q, got %q, %v", name, content, data, err)
+		}
+	}
+	for _, name := range []string{uploadFileName, uploadStateFileName} {
+		if _, err := os.Stat(path.Join(unit.UnitDir(), name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", name, err)
+		}
+	}
+	if _, err := run(map[string]interface{}{"command": "work", "subcommand": "upload-status", "unitid": unitID}, &fakeSubmitConn{}); err == nil {
+		t.Error("expected error for a unit that is not waiting for input data")
+	}
+}
+
+func TestSignedUploadStatus(t *testing.T) {
+	private, public := writeTestSigningKeys(t)
+	signer := newSigningTestWorkceptor(t, "signer")
+	signer.SigningKey = private
+	w := newSigningTestWorkceptor(t, "test")
+	w.VerifyingKey = public
+	err := w.RegisterWorker("signed", func(bwu BaseWorkUnitForWorkUnit, w *Workceptor, unitID, workType string) WorkUnit {
+		return &startedUnit{newCommandWorker(bwu, w, unitID, workType)}
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("signed", map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateBasicStatus(WorkStatePending, "Waiting for Input Data", 0)
+	if err := writeUploadState(unit.UnitDir(), &uploadState{Size: 8}); err != nil {
+		t.Fatal(err)
+	}
+	ct := &workceptorCommandType{w: w}
+	tests := []struct {
+		name     string
+		payload  string
+		errorMsg string
+	}{
+		{name: "upload-status signature", payload: workCommandSHA256("upload-status", unit.ID())},
+		{name: "no signature", errorMsg: "signature is empty"},
+		{name: "upload signature", payload: workCommandSHA256("upload", unit.ID()), errorMsg: "token was issued for a different command"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := map[string]interface{}{"command": "work", "subcommand": "upload-status", "unitid": unit.ID()}
+			if tc.payload != "" {
+				signature, err := signer.createSignature("test", "signed", tc.payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				config["signature"] = signature
+			}
+			cmd, err := ct.InitFromJSON(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfr, err := cmd.ControlFunc(context.Background(), netceptor.New(context.Background(), "test"), &fakeSubmitConn{})
+			if tc.errorMsg == "" {
+				if err != nil {
+					t.Fatalf("unexpected error %s", err)
+				}
+				if cfr["size"] != int64(8) {
+					t.Errorf("unexpected upload status %v", cfr)
+				}
+
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.errorMsg) {
+				t.Errorf("expected error containing %q, got %v", tc.errorMsg, err)
+			}
+		})
+	}
+}
+
+func TestSignedBulkRelease(t *testing.T) {
+	private, public := writeTestSigningKeys(t)
+	signer := newSigningTestWorkceptor(t, "signer")
+	signer.SigningKey = private
+	w := newSigningTestWorkceptor(t, "test")
+	w.VerifyingKey = public
+	if err := w.RegisterWorker("signed", newCommandWorker, true); err != nil {
+		t.Fatal(err)
+	}
+	unitIDs := make([]interface{}, 0)
+	for i := 0; i < 2; i++ {
+		unit, err := w.AllocateUnit("signed", map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		unit.UpdateBasicStatus(WorkStateSucceeded, "Finished", 0)
+		unitIDs = append(unitIDs, unit.ID())
+	}
+	ct := &workceptorCommandType{w: w}
+	release := func(payload string) map[string]interface{} {
+		signature, err := signer.createSignature("test", "signed", payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd, err := ct.InitFromJSON(map[string]interface{}{
+			"command": "work", "subcommand": "force-release", "unitids": unitIDs, "signature": signature,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfr, err := cmd.ControlFunc(context.Background(), netceptor.New(context.Background(), "test"), &fakeSubmitConn{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return cfr
+	}
+
+	cfr := release(workCommandSHA256("release", unitIDs[0].(string)))
+	for _, unitID := range unitIDs {
+		if _, ok := cfr[unitID.(string)].(map[string]interface{})["Error"]; !ok {
+			t.Errorf("expected a signature for one unit to be rejected for unit %s, got %v", unitID, cfr[unitID.(string)])
+		}
+	}
+
+	selector, err := workSelectorTarget(map[string]interface{}{"unitids": unitIDs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfr = release(workCommandSHA256("release", selector))
+	for _, unitID := range unitIDs {
+		if result := cfr[unitID.(string)].(map[string]interface{})["Result"]; result != "released" {
+			t.Errorf("expected unit %s released, got %v", unitID, cfr[unitID.(string)])
+		}
+	}
+}
+
+func TestCheckSubmittedStdin(t *testing.T) {
+	tests := []struct {
+		name    string
+		worker  WorkUnit
+		size    int64
+		wantErr bool
+	}{
+		{name: "retrying job with stdin", worker: &KubeUnit{job: true, backoffLimit: 2}, size: 4, wantErr: true},
+		{name: "retrying job without stdin", worker: &KubeUnit{job: true, backoffLimit: 2}},
+		{name: "job with stdin", worker: &KubeUnit{job: true}, size: 4},
+		{name: "command with stdin", worker: &commandUnit{}, size: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSubmittedStdin(tt.worker, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkSubmittedStdin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// labelKeyRegex is the form of a label key.
+var labelKeyRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// ParseLabels parses labels given as key=value pairs separated by commas.
+func ParseLabels(labels string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(labels, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("label %s must be in key=value format", pair)
+		}
+		key = strings.TrimSpace(key)
+		if !labelKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid label key %s", key)
+		}
+		result[key] = strings.TrimSpace(value)
+	}
+
+	return result, nil
+}
+
+// ParseWorkState parses a work state given by name, case insensitively, or by number.
+func ParseWorkState(state string) (int, error) {
+	for s := WorkStatePending; s <= WorkStateCanceled; s++ {
+		if strings.EqualFold(state, WorkStateToString(s)) {
+			return s, nil
+		}
+	}
+	s, err := strconv.Atoi(state)
+	if err == nil && s >= WorkStatePending && s <= WorkStateCanceled {
+		return s, nil
+	}
+
+	return 0, fmt.Errorf("unknown work state %s", state)
+}
+
+// UnitFilter selects work units.  Fields left at their zero value match every unit.
+type UnitFilter struct {
+	// Labels must all be present on a unit with the given values.
+	Labels map[string]string
+	// WorkType matches the work type of a unit, or for a remote unit, the work type it runs on the remote node.
+	WorkType string
+	// States matches units in any of the given states.
+	States []int
+	// OlderThan matches units created at least this long ago.
+	OlderThan time.Duration
+	// NewerThan matches units created less than this long ago.
+	NewerThan time.Duration
+	// After skips units whose IDs sort before or equal to it, to continue from a previous page.
+	After string
+	// Limit is the maximum number of units to return.
+	Limit int
+}
+
+// selects returns true if the filter narrows down the units by some property, rather than only paging through them.
+func (f *UnitFilter) selects() bool {
+	return len(f.Labels) > 0 || f.WorkType != "" || len(f.States) > 0 || f.OlderThan > 0 || f.NewerThan > 0
+}
+
+// matches returns true if a unit with the given status, created at the given time, is selected by the filter.
+func (f *UnitFilter) matches(status *StatusFileData, created time.Time) bool {
+	for k, v := range f.Labels {
+		if value, ok := status.Labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	if f.WorkType != "" && f.WorkType != status.WorkType {
+		red, ok := status.ExtraData.(*RemoteExtraData)
+		if !ok || f.WorkType != red.RemoteWorkType {
+			return false
+		}
+	}
+	if len(f.States) > 0 {
+		found := false
+		for _, s := range f.States {
+			if s == status.State {
+				found = true
+
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	age := time.Since(created)
+	if f.OlderThan > 0 && age < f.OlderThan {
+		return false
+	}
+	if f.NewerThan > 0 && age >= f.NewerThan {
+		return false
+	}
+
+	return true
+}
+
+// unitCreateTime returns when a unit was created.  Units created before the creation time was recorded fall back
+// to the start of their latest attempt, or the modification time of their directory.
+func unitCreateTime(unit WorkUnit, status *StatusFileData) time.Time {
+	if !status.CreateTime.IsZero() {
+		return status.CreateTime
+	}
+	if !status.StartTime.IsZero() {
+		return status.StartTime
+	}
+	fi, err := os.Stat(unit.UnitDir())
+	if err != nil {
+		return time.Now()
+	}
+
+	return fi.ModTime()
+}
+
+// ListUnits returns the IDs of the units selected by a filter, in sorted order.
+func (w *Workceptor) ListUnits(filter *UnitFilter) []string {
+	ids := w.ListKnownUnitIDs()
+	sort.Strings(ids)
+	result := make([]string, 0)
+	for _, id := range ids {
+		if filter.Limit > 0 && len(result) >= filter.Limit {
+			break
+		}
+		if filter.After != "" && id <= filter.After {
+			continue
+		}
+		unit, err := w.findUnit(id)
+		if err != nil {
+			continue
+		}
+		status := unit.Status()
+		if filter.matches(status, unitCreateTime(unit, status)) {
+			result = append(result, id)
+		}
+	}
+
+	return result
+}
+
+// filterOptionNames are the names of the options of a unit filter.
+var filterOptionNames = []string{"labels", "worktype", "state", "olderthan", "newerthan", "after", "limit"}
+
+// unitFilterFromMap builds a unit filter from the options of a list command.  Options can be strings, as given on
+// the command line, or the equivalent JSON types.
+func unitFilterFromMap(options map[string]interface{}) (*UnitFilter, error) {
+	filter := &UnitFilter{}
+	for name, value := range options {
+		var err error
+		switch name {
+		case "labels":
+			switch v := value.(type) {
+			case string:
+				filter.Labels, err = ParseLabels(v)
+			case map[string]interface{}:
+				filter.Labels = make(map[string]string)
+				for k, lv := range v {
+					s, ok := lv.(string)
+					if !ok {
+						return nil, fmt.Errorf("label %s must be a string", k)
+					}
+					filter.Labels[k] = s
+				}
+			default:
+				err = fmt.Errorf("labels must be a string or an object")
+			}
+		case "worktype":
+			filter.WorkType, err = strFromMap(options, name)
+		case "state":
+			var states []string
+			switch v := value.(type) {
+			case string:
+				states = strings.Split(v, ",")
+			case []interface{}:
+				for _, s := range v {
+					states = append(states, fmt.Sprint(s))
+				}
+			default:
+				err = fmt.Errorf("state must be a string or a list")
+			}
+			for _, s := range states {
+				state, serr := ParseWorkState(strings.TrimSpace(s))
+				if serr != nil {
+					return nil, serr
+				}
+				filter.States = append(filter.States, state)
+			}
+		case "olderthan", "newerthan":
+			var s string
+			var d time.Duration
+			s, err = strFromMap(options, name)
+			if err == nil {
+				d, err = time.ParseDuration(s)
+			}
+			if name == "olderthan" {
+				filter.OlderThan = d
+			} else {
+				filter.NewerThan = d
+			}
+		case "after":
+			filter.After, err = strFromMap(options, name)
+		case "limit":
+			var limit int64
+			switch v := value.(type) {
+			case string:
+				limit, err = strconv.ParseInt(v, 10, 64)
+			case float64:
+				limit = int64(v)
+			default:
+				err = fmt.Errorf("limit must be an integer")
+			}
+			if err == nil && limit < 0 {
+				err = fmt.Errorf("limit must not be negative")
+			}
+			filter.Limit = int(limit)
+		default:
+			return nil, fmt.Errorf("unknown list option %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid list option %s: %s", name, err)
+		}
+	}
+
+	return filter, nil
+}
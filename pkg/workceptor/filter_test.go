@@ -0,0 +1,144 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		labels  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{labels: "", want: map[string]string{}},
+		{labels: "team=a, env = prod", want: map[string]string{"team": "a", "env": "prod"}},
+		{labels: "example.com/owner=ctl,empty=", want: map[string]string{"example.com/owner": "ctl", "empty": ""}},
+		{labels: "team", wantErr: true},
+		{labels: "=a", wantErr: true},
+		{labels: "bad key=a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.labels, func(t *testing.T) {
+			got, err := ParseLabels(tt.labels)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWorkState(t *testing.T) {
+	for _, s := range []string{"running", "Running", "1"} {
+		state, err := ParseWorkState(s)
+		if err != nil || state != WorkStateRunning {
+			t.Errorf("ParseWorkState(%s) = %d, %v", s, state, err)
+		}
+	}
+	for _, s := range []string{"", "7", "done"} {
+		if _, err := ParseWorkState(s); err == nil {
+			t.Errorf("expected error parsing state %q", s)
+		}
+	}
+}
+
+func TestListUnits(t *testing.T) {
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	err = w.RegisterWorker("command", newCommandWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newUnit := func(labels map[string]string, state int, age time.Duration) string {
+		unit, err := w.AllocateUnit("command", make(map[string]string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		unit.UpdateFullStatus(func(status *StatusFileData) {
+			status.Labels = labels
+			status.State = state
+			status.CreateTime = time.Now().Add(-age)
+		})
+
+		return unit.ID()
+	}
+	a := newUnit(map[string]string{"tenant": "a"}, WorkStateRunning, time.Minute)
+	b := newUnit(map[string]string{"tenant": "a", "env": "prod"}, WorkStateSucceeded, 2*time.Hour)
+	c := newUnit(map[string]string{"tenant": "b"}, WorkStateFailed, time.Minute)
+	sorted := func(ids ...string) []string {
+		sort.Strings(ids)
+
+		return ids
+	}
+
+	tests := []struct {
+		name   string
+		filter UnitFilter
+		want   []string
+	}{
+		{name: "all", filter: UnitFilter{}, want: sorted(a, b, c)},
+		{name: "label", filter: UnitFilter{Labels: map[string]string{"tenant": "a"}}, want: sorted(a, b)},
+		{name: "labels", filter: UnitFilter{Labels: map[string]string{"tenant": "a", "env": "prod"}}, want: []string{b}},
+		{name: "worktype", filter: UnitFilter{WorkType: "other"}, want: []string{}},
+		{name: "states", filter: UnitFilter{States: []int{WorkStateRunning, WorkStateFailed}}, want: sorted(a, c)},
+		{name: "older", filter: UnitFilter{OlderThan: time.Hour}, want: []string{b}},
+		{name: "newer", filter: UnitFilter{NewerThan: time.Hour}, want: sorted(a, c)},
+		{name: "first page", filter: UnitFilter{Limit: 2}, want: sorted(a, b, c)[:2]},
+		{name: "next page", filter: UnitFilter{Limit: 2, After: sorted(a, b, c)[1]}, want: sorted(a, b, c)[2:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := w.ListUnits(&tt.filter)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListUnits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnitFilterFromMap(t *testing.T) {
+	filter, err := unitFilterFromMap(map[string]interface{}{
+		"labels":    map[string]interface{}{"tenant": "a"},
+		"worktype":  "command",
+		"state":     []interface{}{"Running", "failed"},
+		"olderthan": "1h",
+		"limit":     float64(5),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &UnitFilter{
+		Labels:    map[string]string{"tenant": "a"},
+		WorkType:  "command",
+		States:    []int{WorkStateRunning, WorkStateFailed},
+		OlderThan: time.Hour,
+		Limit:     5,
+	}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("unitFilterFromMap() = %+v, want %+v", filter, want)
+	}
+	for _, options := range []map[string]interface{}{
+		{"state": "Done"},
+		{"olderthan": "soon"},
+		{"limit": "-1"},
+		{"colour": "red"},
+	} {
+		if _, err := unitFilterFromMap(options); err == nil {
+			t.Errorf("expected error for options %v", options)
+		}
+	}
+}
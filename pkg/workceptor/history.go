@@ -0,0 +1,97 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+)
+
+// historyFilename is the name of the append-only event log in a unit directory.
+const historyFilename = "history"
+
+// WorkEvent is a state transition recorded in the event history of a work unit.
+type WorkEvent struct {
+	Time       time.Time
+	State      int
+	StateName  string
+	Detail     string
+	RemoteNode string `json:",omitempty"`
+}
+
+// newWorkEvent returns an event for a unit entering a state, timestamped now.
+func newWorkEvent(state int, detail string) WorkEvent {
+	return WorkEvent{
+		Time:      time.Now(),
+		State:     state,
+		StateName: WorkStateToString(state),
+		Detail:    detail,
+	}
+}
+
+// appendHistory appends events to the history file in a unit directory.
+func appendHistory(unitdir string, events ...WorkEvent) error {
+	file, err := os.OpenFile(path.Join(unitdir, historyFilename), os.O_CREATE+os.O_APPEND+os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	for i := range events {
+		eventBytes, err := json.Marshal(&events[i])
+		if err != nil {
+			file.Close()
+
+			return err
+		}
+		data = append(data, eventBytes...)
+		data = append(data, '\n')
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	return file.Close()
+}
+
+// readHistory reads the history file in a unit directory.  A unit without a history file has no events.
+func readHistory(unitdir string) ([]WorkEvent, error) {
+	events := make([]WorkEvent, 0)
+	file, err := os.Open(path.Join(unitdir, historyFilename))
+	if os.IsNotExist(err) {
+		return events, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		event := WorkEvent{}
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			// A partially written final line is skipped rather than failing the whole history.
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events, scanner.Err()
+}
+
+// UnitHistory returns the event history of a unit, oldest first.
+func (w *Workceptor) UnitHistory(unitID string) ([]WorkEvent, error) {
+	unit, err := w.findUnit(unitID)
+	if err != nil {
+		return nil, err
+	}
+
+	return readHistory(unit.UnitDir())
+}
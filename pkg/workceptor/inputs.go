@@ -0,0 +1,269 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// inputsDirName is the directory in a unit directory holding the input files submitted with the unit.
+const inputsDirName = "inputs"
+
+// InputDirEnv is the environment variable giving a command the directory holding its input files.
+const InputDirEnv = "RECEPTOR_INPUT_DIR"
+
+// inputFileEnvPrefix prefixes the environment variables giving a command the path of each input file.
+const inputFileEnvPrefix = "RECEPTOR_INPUT_"
+
+// inputNameRegex is the form of the name of an input file.
+var inputNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// InputFile is a named file submitted with a work unit, ahead of its stdin.
+type InputFile struct {
+	Name string
+	Size int64
+}
+
+// ParseInputManifest parses a manifest of input files given as name=size pairs separated by commas.  The contents
+// of the files follow in the order of the manifest, before stdin.
+func ParseInputManifest(manifest string) ([]InputFile, error) {
+	files := make([]InputFile, 0)
+	envNames := make(map[string]string)
+	for _, entry := range strings.Split(manifest, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, sizeStr, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("input file %s must be in name=size format", entry)
+		}
+		if !inputNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid input file name %s", name)
+		}
+		size, err := strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size %s for input file %s", sizeStr, name)
+		}
+		envName := inputFileEnvName(name)
+		if other, ok := envNames[envName]; ok {
+			return nil, fmt.Errorf("input files %s and %s would use the same environment variable %s", other, name, envName)
+		}
+		envNames[envName] = name
+		files = append(files, InputFile{Name: name, Size: size})
+	}
+
+	return files, nil
+}
+
+// FormatInputManifest returns the manifest describing a list of input files.
+func FormatInputManifest(files []InputFile) string {
+	entries := make([]string, 0, len(files))
+	for _, f := range files {
+		entries = append(entries, fmt.Sprintf("%s=%d", f.Name, f.Size))
+	}
+
+	return strings.Join(entries, ",")
+}
+
+// inputFileEnvName returns the environment variable giving the path of an input file.
+func inputFileEnvName(name string) string {
+	envName := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+
+		return '_'
+	}, name)
+
+	return inputFileEnvPrefix + envName
+}
+
+// inputFileEnv returns the environment variables exposing input files placed in a directory.
+func inputFileEnv(dir string, files []InputFile) map[string]string {
+	env := make(map[string]string)
+	if len(files) == 0 {
+		return env
+	}
+	env[InputDirEnv] = dir
+	for _, f := range files {
+		env[inputFileEnvName(f.Name)] = path.Join(dir, f.Name)
+	}
+
+	return env
+}
+
+// checkRemoteInputFiles returns an error unless a remote node is known to accept input files for a work type.  Nodes
+// that predate input files would take their contents as stdin, so they are only sent to nodes advertising support.
+func (w *Workceptor) checkRemoteInputFiles(node string, workType string) error {
+	for _, ad := range w.nc.Status().Advertisements {
+		if ad.NodeID != node {
+			continue
+		}
+		for _, wc := range ad.WorkCommands {
+			if wc.WorkType == workType && wc.InputFiles {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("node %s is not known to accept input files for work type %s", node, workType)
+}
+
+// listInputFiles returns the input files of a unit, sorted by name.
+func listInputFiles(unitdir string) ([]InputFile, error) {
+	entries, err := os.ReadDir(path.Join(unitdir, inputsDirName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := make([]InputFile, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, InputFile{Name: entry.Name(), Size: fi.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	return files, nil
+}
+
+// inputWriter splits a submission stream into the input files of its manifest, followed by stdin.
+type inputWriter struct {
+	dir     string
+	files   []InputFile
+	index   int
+	current *os.File
+	written int64
+	stdin   io.Writer
+}
+
+// newInputWriter returns a writer that stores the input files of a manifest in a unit directory, and passes
+// whatever follows them on to stdin.
+func newInputWriter(unitdir string, files []InputFile, stdin io.Writer) (*inputWriter, error) {
+	dir := path.Join(unitdir, inputsDirName)
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &inputWriter{dir: dir, files: files, stdin: stdin}, nil
+}
+
+// Write implements io.Writer.
+func (iw *inputWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 && iw.index < len(iw.files) {
+		f := iw.files[iw.index]
+		if iw.current == nil {
+			var err error
+			iw.current, err = os.OpenFile(path.Join(iw.dir, f.Name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return total, err
+			}
+		}
+		chunk := p
+		if remaining := f.Size - iw.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := iw.current.Write(chunk)
+		total += n
+		iw.written += int64(n)
+		p = p[n:]
+		if err != nil {
+			return total, err
+		}
+		if iw.written == f.Size {
+			if err := iw.nextFile(); err != nil {
+				return total, err
+			}
+		}
+	}
+	if len(p) > 0 {
+		n, err := iw.stdin.Write(p)
+
+		return total + n, err
+	}
+
+	return total, nil
+}
+
+// nextFile closes the current input file and moves on to the next one.
+func (iw *inputWriter) nextFile() error {
+	var err error
+	if iw.current != nil {
+		err = iw.current.Close()
+		iw.current = nil
+	}
+	iw.index++
+	iw.written = 0
+
+	return err
+}
+
+// Close finishes storing the input files, creating any empty ones, and returns an error if the stream ended
+// before all of them were complete.
+func (iw *inputWriter) Close() error {
+	for iw.index < len(iw.files) {
+		f := iw.files[iw.index]
+		if iw.written < f.Size {
+			if iw.current != nil {
+				iw.current.Close()
+			}
+
+			return fmt.Errorf("input ended after %d of %d bytes of input file %s", iw.written, f.Size, f.Name)
+		}
+		if iw.current == nil {
+			file, err := os.OpenFile(path.Join(iw.dir, f.Name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return err
+			}
+			iw.current = file
+		}
+		if err := iw.nextFile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendInputFiles writes the contents of input files, in manifest order, to a submission stream.
+func sendInputFiles(w io.Writer, unitdir string, files []InputFile) error {
+	for _, f := range files {
+		file, err := os.Open(path.Join(unitdir, inputsDirName, f.Name))
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(w, io.LimitReader(file, f.Size))
+		file.Close()
+		if err != nil {
+			return err
+		}
+		if n != f.Size {
+			return fmt.Errorf("input file %s is shorter than its manifest size", f.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestParseInputManifest(t *testing.T) {
+	files, err := ParseInputManifest("config.yml=12, data=0,extra-vars.json=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InputFile{{"config.yml", 12}, {"data", 0}, {"extra-vars.json", 7}}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got %v, want %v", files, want)
+	}
+	if got := FormatInputManifest(files); got != "config.yml=12,data=0,extra-vars.json=7" {
+		t.Errorf("unexpected manifest %s", got)
+	}
+	for _, bad := range []string{"nosize", "a=-1", "a=x", "../etc=1", ".hidden=1", "a.b=1,a-b=2"} {
+		if _, err := ParseInputManifest(bad); err == nil {
+			t.Errorf("expected error for manifest %q", bad)
+		}
+	}
+}
+
+func TestInputWriter(t *testing.T) {
+	unitdir := t.TempDir()
+	files := []InputFile{{"first", 5}, {"empty", 0}, {"second", 3}}
+	stdin := &bytes.Buffer{}
+	iw, err := newInputWriter(unitdir, files, stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range []string{"hel", "lobye", "stdin", " data"} {
+		if _, err := iw.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := iw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"first": "hello", "empty": "", "second": "bye"} {
+		data, err := os.ReadFile(path.Join(unitdir, inputsDirName, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("input file %s contains %q, want %q", name, data, want)
+		}
+	}
+	if stdin.String() != "stdin data" {
+		t.Errorf("stdin contains %q", stdin.String())
+	}
+
+	listed, err := listInputFiles(unitdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(listed, []InputFile{{"empty", 0}, {"first", 5}, {"second", 3}}) {
+		t.Errorf("unexpected input files %v", listed)
+	}
+	sent := &bytes.Buffer{}
+	if err := sendInputFiles(sent, unitdir, listed); err != nil {
+		t.Fatal(err)
+	}
+	if sent.String() != "hellobye" {
+		t.Errorf("sent %q", sent.String())
+	}
+
+	iw, err = newInputWriter(t.TempDir(), files, stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iw.Write([]byte("hel")); err != nil {
+		t.Fatal(err)
+	}
+	if err := iw.Close(); err == nil || !strings.Contains(err.Error(), "first") {
+		t.Errorf("expected error for incomplete input, got %v", err)
+	}
+}
+
+func TestInputFileEnv(t *testing.T) {
+	env := inputFileEnv("/in", []InputFile{{"config.yml", 1}, {"extra-vars", 2}})
+	want := map[string]string{
+		InputDirEnv:                 "/in",
+		"RECEPTOR_INPUT_CONFIG_YML": "/in/config.yml",
+		"RECEPTOR_INPUT_EXTRA_VARS": "/in/extra-vars",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("got %v, want %v", env, want)
+	}
+	if len(inputFileEnv("/in", nil)) != 0 {
+		t.Error("expected no environment without input files")
+	}
+}
+
+// advertisingNetceptor is a Netceptor reporting fixed service advertisements.
+type advertisingNetceptor struct {
+	*netceptor.Netceptor
+	ads []*netceptor.ServiceAdvertisement
+}
+
+func (n *advertisingNetceptor) Status() netceptor.Status {
+	return netceptor.Status{Advertisements: n.ads}
+}
+
+func TestCheckRemoteInputFiles(t *testing.T) {
+	nc := &advertisingNetceptor{
+		Netceptor: netceptor.New(context.Background(), "test"),
+		ads: []*netceptor.ServiceAdvertisement{
+			{NodeID: "new", WorkCommands: []netceptor.WorkCommand{{WorkType: "echo", InputFiles: true}}},
+			{NodeID: "old", WorkCommands: []netceptor.WorkCommand{{WorkType: "echo"}}},
+		},
+	}
+	w, err := New(context.Background(), nc, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	tests := []struct {
+		node     string
+		workType string
+		wantErr  bool
+	}{
+		{node: "new", workType: "echo"},
+		{node: "new", workType: "other", wantErr: true},
+		{node: "old", workType: "echo", wantErr: true},
+		{node: "unknown", workType: "echo", wantErr: true},
+	}
+	for _, tt := range tests {
+		err := w.checkRemoteInputFiles(tt.node, tt.workType)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkRemoteInputFiles(%s, %s) error = %v, wantErr %v", tt.node, tt.workType, err, tt.wantErr)
+		}
+	}
+}
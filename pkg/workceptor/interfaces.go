@@ -1,5 +1,7 @@
 package workceptor
 
+import "time"
+
 // WorkUnit represents a local unit of work.
 type WorkUnit interface {
 	ID() string
@@ -32,9 +34,32 @@ type NewWorkerFunc func(bwu BaseWorkUnitForWorkUnit, w *Workceptor, unitID strin
 // StatusFileData is the structure of the JSON data saved to a status file.
 // This struct should only contain value types, except for ExtraData.
 type StatusFileData struct {
-	State      int
-	Detail     string
-	StdoutSize int64
-	WorkType   string
-	ExtraData  interface{}
+	State         int
+	Detail        string
+	StdoutSize    int64
+	StderrSize    int64
+	WorkType      string
+	ExitCode      int
+	Signal        string
+	StartTime     time.Time
+	EndTime       time.Time
+	Attempt       int
+	ResourceUsage *ResourceUsage `json:",omitempty"`
+	CreateTime    time.Time
+	Labels        map[string]string `json:",omitempty"`
+	Artifacts     []Artifact        `json:",omitempty"`
+	// Callback is where the final status is sent when the unit completes, and the outcome of sending it.
+	Callback          string `json:",omitempty"`
+	CallbackAttempts  int    `json:",omitempty"`
+	CallbackDelivered bool   `json:",omitempty"`
+	CallbackError     string `json:",omitempty"`
+	ExtraData         interface{}
+}
+
+// ResourceUsage is the resources consumed by the process of a work unit.  Only command work units record it, and it
+// is absent for other work types.
+type ResourceUsage struct {
+	UserCPUSeconds   float64
+	SystemCPUSeconds float64
+	MaxRSSKB         int64
 }
@@ -6,6 +6,9 @@ package workceptor
 import (
 	"context"
 	"os"
+	"os/exec"
+	"path"
+	"strings"
 	"testing"
 
 	"github.com/ansible/receptor/pkg/netceptor"
@@ -70,3 +73,274 @@ func TestWorkceptorJson(t *testing.T) {
 		t.Fatal("PID did not make it through")
 	}
 }
+
+func TestStatusRunInfo(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	cmd := exec.Command("sh", "-c", "exit 3")
+	err = cmd.Run()
+	if err == nil {
+		t.Fatal("expected command to fail")
+	}
+	statusFilename := path.Join(tmpdir, "status")
+	sfd := &StatusFileData{ExitCode: -1}
+	err = sfd.UpdateFullStatus(statusFilename, func(status *StatusFileData) {
+		status.beginAttempt()
+		status.setProcessExit(cmd.ProcessState)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := &StatusFileData{}
+	err = loaded.Load(statusFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ExitCode != 3 || loaded.Signal != "" || loaded.Attempt != 1 {
+		t.Fatalf("unexpected exit information: %+v", loaded)
+	}
+	if loaded.StartTime.IsZero() || loaded.EndTime.Before(loaded.StartTime) {
+		t.Fatalf("unexpected timestamps: %s, %s", loaded.StartTime, loaded.EndTime)
+	}
+	if loaded.ResourceUsage == nil {
+		t.Fatal("expected resource usage of the command")
+	}
+	mirror := &StatusFileData{}
+	mirror.mirrorRunInfo(loaded)
+	if mirror.ExitCode != 3 || mirror.Attempt != 1 || !mirror.EndTime.Equal(loaded.EndTime) {
+		t.Fatalf("run information was not mirrored: %+v", mirror)
+	}
+
+	// Work that does not report resource usage leaves it absent rather than zero.
+	err = sfd.UpdateFullStatus(statusFilename, func(status *StatusFileData) {
+		status.beginAttempt()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(statusFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "ResourceUsage") {
+		t.Errorf("expected no resource usage in %s", data)
+	}
+	err = loaded.Load(statusFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ResourceUsage != nil {
+		t.Errorf("expected no resource usage, got %+v", loaded.ResourceUsage)
+	}
+}
+
+func TestGetResultsStream(t *testing.T) {
+	tmpdir, err := os.MkdirTemp(os.TempDir(), "receptor-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	nc := netceptor.New(context.TODO(), "test")
+	w, err := New(context.Background(), nc, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.RegisterWorker("command", newCommandWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path.Join(cw.UnitDir(), StreamStdout), []byte("out\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path.Join(cw.UnitDir(), StreamStderr), []byte("err\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw.UpdateFullStatus(func(status *StatusFileData) {
+		status.State = WorkStateSucceeded
+		status.StdoutSize = 4
+		status.StderrSize = 4
+	})
+	_, err = w.GetResultsStream(context.Background(), cw.ID(), "bogus", 0)
+	if err == nil {
+		t.Fatal("expected error for unknown stream")
+	}
+	for stream, want := range map[string]string{StreamStdout: "out\n", StreamStderr: "err\n"} {
+		resultChan, err := w.GetResultsStream(context.Background(), cw.ID(), stream, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for data := range resultChan {
+			got += string(data)
+		}
+		if got != want {
+			t.Fatalf("%s: expected %q, got %q", stream, want, got)
+		}
+	}
+}
+
+func TestStatusHistory(t *testing.T) {
+	tmpdir := t.TempDir()
+	sfd := &StatusFileData{}
+	updates := []struct {
+		state  int
+		detail string
+	}{
+		{WorkStatePending, "Waiting for Input Data"},
+		{WorkStateRunning, "Running: PID 1"},
+		{WorkStateRunning, "Running: PID 1"},
+		{WorkStateSucceeded, "exit status 0"},
+	}
+	for _, u := range updates {
+		err := sfd.updateUnitBasicStatus(tmpdir, u.state, u.detail, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, err := readHistory(tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %+v", len(events), events)
+	}
+	if events[2].State != WorkStateSucceeded || events[2].StateName != "Succeeded" || events[2].Detail != "exit status 0" {
+		t.Errorf("unexpected final event %+v", events[2])
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Time.Before(events[i-1].Time) {
+			t.Errorf("events out of order: %+v", events)
+		}
+	}
+
+	// A status file updated by path alone, outside of a unit, has no history.
+	otherdir := t.TempDir()
+	err = sfd.UpdateBasicStatus(path.Join(otherdir, "status"), WorkStateRunning, "Running", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(path.Join(otherdir, historyFilename))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no history beside a status file outside a unit, got %v", err)
+	}
+}
+
+func TestCommandEnvironment(t *testing.T) {
+	t.Setenv("RECEPTOR_TEST_SECRET", "hunter2")
+	t.Setenv("RECEPTOR_TEST_KEEP", "kept")
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	cfg := CommandWorkerCfg{
+		Command:         "echo",
+		InheritEnv:      []string{"RECEPTOR_TEST_KEEP", "RECEPTOR_TEST_UNSET"},
+		Env:             map[string]string{"FIXED": "fixed", "OVERRIDE": "fixed"},
+		AllowRuntimeEnv: true,
+	}
+	err = w.RegisterWorker("command", cfg.NewWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("command", map[string]string{"env_FROMUSER": "user", "env_OVERRIDE": "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := unit.(*commandUnit).environment(nil)
+	want := []string{
+		"FIXED=fixed",
+		"FROMUSER=user",
+		"OVERRIDE=fixed",
+		"RECEPTOR_NODE_ID=test",
+		"RECEPTOR_TEST_KEEP=kept",
+		"RECEPTOR_UNIT_ID=" + unit.ID(),
+	}
+	if strings.Join(env, " ") != strings.Join(want, " ") {
+		t.Errorf("got environment %v, want %v", env, want)
+	}
+
+	for _, params := range []map[string]string{
+		{"env_RECEPTOR_UNIT_ID": "x"},
+		{"env_LD_PRELOAD": "x"},
+		{"env_DYLD_INSERT_LIBRARIES": "x"},
+		{"env_1BAD": "x"},
+	} {
+		if _, err := w.AllocateUnit("command", params); err == nil {
+			t.Errorf("expected error for params %v", params)
+		}
+	}
+	cfg.AllowRuntimeEnv = false
+	err = w.RegisterWorker("restricted", cfg.NewWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.AllocateUnit("restricted", map[string]string{"env_FROMUSER": "user"}); err == nil {
+		t.Error("expected error for environment variables without allowruntimeenv")
+	}
+
+	cfg = CommandWorkerCfg{Command: "echo"}
+	err = w.RegisterWorker("inherit", cfg.NewWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err = w.AllocateUnit("inherit", map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env = unit.(*commandUnit).environment(nil)
+	if !strings.Contains(strings.Join(env, "\n"), "RECEPTOR_TEST_SECRET=hunter2") {
+		t.Error("expected the environment to be inherited by default")
+	}
+
+	err = writeCommandEnv(unit.UnitDir(), want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err = readCommandEnv(unit.UnitDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(env, " ") != strings.Join(want, " ") {
+		t.Errorf("got command runner environment %v, want %v", env, want)
+	}
+	if _, err := os.Stat(path.Join(unit.UnitDir(), envFileName)); !os.IsNotExist(err) {
+		t.Error("expected the command runner to remove the environment file")
+	}
+}
+
+func TestRemoteStatusWithoutHistory(t *testing.T) {
+	w := newSigningTestWorkceptor(t, "test")
+	unit, err := w.AllocateUnit("remote", map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := unit.(*remoteUnit)
+	before, err := readHistory(rw.UnitDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.updateStatusWithoutHistory(func(status *StatusFileData) {
+		status.State = WorkStateRunning
+		status.Detail = "Running: PID 1"
+	})
+	if status := rw.Status(); status.State != WorkStateRunning || status.Detail != "Running: PID 1" {
+		t.Errorf("status was not updated: %s: %s", WorkStateToString(status.State), status.Detail)
+	}
+	events, err := readHistory(rw.UnitDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(before) {
+		t.Errorf("expected no new events, got %+v", events[len(before):])
+	}
+}
@@ -6,26 +6,32 @@ package workceptor
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/url"
 	"os"
+	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ghjm/cmdline"
 	"github.com/google/shlex"
 	"github.com/spf13/viper"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
@@ -43,6 +49,7 @@ type KubeUnit struct {
 	BaseWorkUnitForWorkUnit
 	authMethod          string
 	streamMethod        string
+	streamSidecarImage  string
 	baseParams          string
 	allowRuntimeAuth    bool
 	allowRuntimeCommand bool
@@ -54,19 +61,54 @@ type KubeUnit struct {
 	clientset           *kubernetes.Clientset
 	pod                 *corev1.Pod
 	podPendingTimeout   time.Duration
+	job                 bool
+	backoffLimit        int32
+	activeDeadline      int64
+	ttlAfterFinished    int32
+	podPatchPaths       []podPatchPath
+	secretInputs        []string
+	secretMountPath     string
+	configInputs        []string
+	configMountPath     string
+	clusters            []*kubeCluster
+	placement           string
 }
 
 // kubeExtraData is the content of the ExtraData JSON field for a Kubernetes worker.
 type KubeExtraData struct {
-	Image         string
-	Command       string
-	Params        string
-	KubeNamespace string
-	KubeConfig    string
-	KubePod       string
-	PodName       string
+	Image           string
+	Command         string
+	Params          string
+	KubeNamespace   string
+	KubeConfig      string
+	KubePod         string
+	PodName         string
+	InputsSecret    string            `json:",omitempty"`
+	JobName         string            `json:",omitempty"`
+	PodPatch        string            `json:",omitempty"`
+	Termination     *KubeTermination  `json:",omitempty"`
+	Events          []KubeEvent       `json:",omitempty"`
+	SecretData      map[string]string `json:",omitempty"`
+	ConfigData      map[string]string `json:",omitempty"`
+	UnitSecret      string            `json:",omitempty"`
+	UnitConfigMap   string            `json:",omitempty"`
+	Cluster         string            `json:",omitempty"`
+	ClusterSelector string            `json:",omitempty"`
 }
 
+// kubeInputsDir is where the input files of a unit are mounted in the worker container.
+const kubeInputsDir = "/receptor/inputs"
+
+// kubeSecretParamPrefix and kubeConfigParamPrefix prefix the submit params giving the entries of the per-unit Secret
+// and ConfigMap.  Params with other names, such as the secret_ params of other workers, are left alone.
+const (
+	kubeSecretParamPrefix = "kube_secret_"
+	kubeConfigParamPrefix = "kube_config_"
+)
+
+// kubeInputsMaxSize is the largest total size of input files that can be passed to a pod in a Secret.
+const kubeInputsMaxSize = 1024 * 1024
+
 type KubeAPIer interface {
 	NewNotFound(schema.GroupResource, string) *apierrors.StatusError
 	OneTermEqualSelector(string, string) fields.Selector
@@ -77,6 +119,18 @@ type KubeAPIer interface {
 	List(context.Context, *kubernetes.Clientset, string, metav1.ListOptions) (*corev1.PodList, error)
 	Watch(context.Context, *kubernetes.Clientset, string, metav1.ListOptions) (watch.Interface, error)
 	Delete(context.Context, *kubernetes.Clientset, string, string, metav1.DeleteOptions) error
+	Patch(context.Context, *kubernetes.Clientset, string, string, types.PatchType, []byte, metav1.PatchOptions) (*corev1.Pod, error)
+	CreateSecret(context.Context, *kubernetes.Clientset, string, *corev1.Secret, metav1.CreateOptions) (*corev1.Secret, error)
+	DeleteSecret(context.Context, *kubernetes.Clientset, string, string, metav1.DeleteOptions) error
+	ListEvents(context.Context, *kubernetes.Clientset, string, metav1.ListOptions) (*corev1.EventList, error)
+	PatchSecret(context.Context, *kubernetes.Clientset, string, string, types.PatchType, []byte, metav1.PatchOptions) (*corev1.Secret, error)
+	CreateConfigMap(context.Context, *kubernetes.Clientset, string, *corev1.ConfigMap, metav1.CreateOptions) (*corev1.ConfigMap, error)
+	PatchConfigMap(context.Context, *kubernetes.Clientset, string, string, types.PatchType, []byte, metav1.PatchOptions) (*corev1.ConfigMap, error)
+	DeleteConfigMap(context.Context, *kubernetes.Clientset, string, string, metav1.DeleteOptions) error
+	CreateJob(context.Context, *kubernetes.Clientset, string, *batchv1.Job, metav1.CreateOptions) (*batchv1.Job, error)
+	GetJob(context.Context, *kubernetes.Clientset, string, string, metav1.GetOptions) (*batchv1.Job, error)
+	PatchJob(context.Context, *kubernetes.Clientset, string, string, types.PatchType, []byte, metav1.PatchOptions) (*batchv1.Job, error)
+	DeleteJob(context.Context, *kubernetes.Clientset, string, string, metav1.DeleteOptions) error
 	SubResource(*kubernetes.Clientset, string, string) *rest.Request
 	InClusterConfig() (*rest.Config, error)
 	NewDefaultClientConfigLoadingRules() *clientcmd.ClientConfigLoadingRules
@@ -127,6 +181,54 @@ func (ku KubeAPIWrapper) Delete(ctx context.Context, clientset *kubernetes.Clien
 	return clientset.CoreV1().Pods(namespace).Delete(ctx, name, opts)
 }
 
+func (ku KubeAPIWrapper) CreateSecret(ctx context.Context, clientset *kubernetes.Clientset, namespace string, secret *corev1.Secret, opts metav1.CreateOptions) (*corev1.Secret, error) {
+	return clientset.CoreV1().Secrets(namespace).Create(ctx, secret, opts)
+}
+
+func (ku KubeAPIWrapper) DeleteSecret(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, opts metav1.DeleteOptions) error {
+	return clientset.CoreV1().Secrets(namespace).Delete(ctx, name, opts)
+}
+
+func (ku KubeAPIWrapper) Patch(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions) (*corev1.Pod, error) {
+	return clientset.CoreV1().Pods(namespace).Patch(ctx, name, pt, data, opts)
+}
+
+func (ku KubeAPIWrapper) PatchSecret(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions) (*corev1.Secret, error) {
+	return clientset.CoreV1().Secrets(namespace).Patch(ctx, name, pt, data, opts)
+}
+
+func (ku KubeAPIWrapper) CreateConfigMap(ctx context.Context, clientset *kubernetes.Clientset, namespace string, configMap *corev1.ConfigMap, opts metav1.CreateOptions) (*corev1.ConfigMap, error) {
+	return clientset.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, opts)
+}
+
+func (ku KubeAPIWrapper) PatchConfigMap(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions) (*corev1.ConfigMap, error) {
+	return clientset.CoreV1().ConfigMaps(namespace).Patch(ctx, name, pt, data, opts)
+}
+
+func (ku KubeAPIWrapper) DeleteConfigMap(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, opts metav1.DeleteOptions) error {
+	return clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, name, opts)
+}
+
+func (ku KubeAPIWrapper) ListEvents(ctx context.Context, clientset *kubernetes.Clientset, namespace string, opts metav1.ListOptions) (*corev1.EventList, error) {
+	return clientset.CoreV1().Events(namespace).List(ctx, opts)
+}
+
+func (ku KubeAPIWrapper) CreateJob(ctx context.Context, clientset *kubernetes.Clientset, namespace string, job *batchv1.Job, opts metav1.CreateOptions) (*batchv1.Job, error) {
+	return clientset.BatchV1().Jobs(namespace).Create(ctx, job, opts)
+}
+
+func (ku KubeAPIWrapper) GetJob(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, opts metav1.GetOptions) (*batchv1.Job, error) {
+	return clientset.BatchV1().Jobs(namespace).Get(ctx, name, opts)
+}
+
+func (ku KubeAPIWrapper) PatchJob(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions) (*batchv1.Job, error) {
+	return clientset.BatchV1().Jobs(namespace).Patch(ctx, name, pt, data, opts)
+}
+
+func (ku KubeAPIWrapper) DeleteJob(ctx context.Context, clientset *kubernetes.Clientset, namespace string, name string, opts metav1.DeleteOptions) error {
+	return clientset.BatchV1().Jobs(namespace).Delete(ctx, name, opts)
+}
+
 func (ku KubeAPIWrapper) SubResource(clientset *kubernetes.Clientset, podName string, podNamespace string) *rest.Request {
 	return clientset.CoreV1().RESTClient().Post().Resource("pods").Name(podName).Namespace(podNamespace).SubResource("attach")
 }
@@ -409,15 +511,16 @@ func (kw *KubeUnit) kubeLoggingWithReconnect(streamWait *sync.WaitGroup, stdout
 	}
 }
 
-func (kw *KubeUnit) createPod(env map[string]string) error {
-	ked := kw.UnredactedStatus().ExtraData.(*KubeExtraData)
+// buildPod returns the pod to run for a unit, from its pod definition or its image and command, with the runtime
+// pod patch applied.
+func (kw *KubeUnit) buildPod(ked *KubeExtraData) (*corev1.Pod, error) {
 	command, err := shlex.Split(ked.Command)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	params, err := shlex.Split(ked.Params)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	pod := &corev1.Pod{}
@@ -427,7 +530,7 @@ func (kw *KubeUnit) createPod(env map[string]string) error {
 		decode := scheme.Codecs.UniversalDeserializer().Decode
 		_, _, err := decode([]byte(ked.KubePod), nil, pod)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		foundWorker := false
 		spec = &pod.Spec
@@ -441,7 +544,7 @@ func (kw *KubeUnit) createPod(env map[string]string) error {
 			}
 		}
 		if !foundWorker {
-			return fmt.Errorf("at least one container must be named worker")
+			return nil, fmt.Errorf("at least one container must be named worker")
 		}
 		spec.RestartPolicy = corev1.RestartPolicyNever
 		userNamespace := pod.ObjectMeta.Namespace
@@ -479,6 +582,28 @@ func (kw *KubeUnit) createPod(env map[string]string) error {
 		ObjectMeta: *objectMeta,
 		Spec:       *spec,
 	}
+	if ked.PodPatch != "" {
+		pod, err = applyPodPatch(pod, ked.PodPatch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	kw.labelPod(pod)
+	if kw.streamMethod == "sidecar" {
+		if err := addStreamSidecar(pod, kw.streamSidecarImage); err != nil {
+			return nil, err
+		}
+	}
+
+	return pod, nil
+}
+
+func (kw *KubeUnit) createPod(env map[string]string) error {
+	ked := kw.UnredactedStatus().ExtraData.(*KubeExtraData)
+	pod, err := kw.buildPod(ked)
+	if err != nil {
+		return err
+	}
 
 	if env != nil {
 		evs := make([]corev1.EnvVar, 0)
@@ -491,35 +616,86 @@ func (kw *KubeUnit) createPod(env map[string]string) error {
 		pod.Spec.Containers[0].Env = evs
 	}
 
-	// get pod and store to kw.pod
-	kw.pod, err = KubeAPIWrapperInstance.Create(kw.GetContext(), kw.clientset, ked.KubeNamespace, pod, metav1.CreateOptions{})
+	err = kw.mountInputFiles(pod, ked)
 	if err != nil {
 		return err
 	}
-
-	select {
-	case <-kw.GetContext().Done():
-		return fmt.Errorf("cancelled")
-	default:
+	err = kw.mountUnitVolumes(pod, ked)
+	if err != nil {
+		return err
 	}
 
-	kw.UpdateFullStatus(func(status *StatusFileData) {
-		status.State = WorkStatePending
-		status.Detail = "Pod created"
-		status.StdoutSize = 0
-		status.ExtraData.(*KubeExtraData).PodName = kw.pod.Name
-	})
+	var fieldSelector, labelSelector string
+	if kw.job {
+		err = kw.checkJobStdin()
+		if err != nil {
+			return err
+		}
+		job, err := kw.createJob(pod)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-kw.GetContext().Done():
+			return fmt.Errorf("cancelled")
+		default:
+		}
+
+		kw.UpdateFullStatus(func(status *StatusFileData) {
+			status.State = WorkStatePending
+			status.Detail = "Job created"
+			status.StdoutSize = 0
+			status.ExtraData.(*KubeExtraData).JobName = job.Name
+			status.beginAttempt()
+		})
+		kw.setUnitObjectsOwner(ked, metav1.OwnerReference{
+			APIVersion: "batch/v1",
+			Kind:       "Job",
+			Name:       job.Name,
+			UID:        job.UID,
+		})
+		labelSelector = jobPodSelector(job.Name)
+	} else {
+		// get pod and store to kw.pod
+		kw.pod, err = KubeAPIWrapperInstance.Create(kw.GetContext(), kw.clientset, ked.KubeNamespace, pod, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-kw.GetContext().Done():
+			return fmt.Errorf("cancelled")
+		default:
+		}
+
+		kw.UpdateFullStatus(func(status *StatusFileData) {
+			status.State = WorkStatePending
+			status.Detail = "Pod created"
+			status.StdoutSize = 0
+			status.ExtraData.(*KubeExtraData).PodName = kw.pod.Name
+			status.beginAttempt()
+		})
+		kw.setUnitObjectsOwner(ked, metav1.OwnerReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       kw.pod.Name,
+			UID:        kw.pod.UID,
+		})
+		fieldSelector = KubeAPIWrapperInstance.OneTermEqualSelector("metadata.name", kw.pod.Name).String()
+	}
 
 	// Wait for the pod to be running
-	fieldSelector := KubeAPIWrapperInstance.OneTermEqualSelector("metadata.name", kw.pod.Name).String()
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
 			options.FieldSelector = fieldSelector
+			options.LabelSelector = labelSelector
 
 			return KubeAPIWrapperInstance.List(kw.GetContext(), kw.clientset, ked.KubeNamespace, options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 			options.FieldSelector = fieldSelector
+			options.LabelSelector = labelSelector
 
 			return KubeAPIWrapperInstance.Watch(kw.GetContext(), kw.clientset, ked.KubeNamespace, options)
 		},
@@ -543,17 +719,27 @@ func (kw *KubeUnit) createPod(env map[string]string) error {
 	if !ok {
 		return fmt.Errorf("watch did not return a pod")
 	}
+	if kw.job {
+		kw.UpdateFullStatus(func(status *StatusFileData) {
+			status.ExtraData.(*KubeExtraData).PodName = kw.pod.Name
+		})
+	}
+	if term := workerTerminatedState(kw.pod); term != nil {
+		kw.UpdateFullStatus(func(status *StatusFileData) {
+			status.setContainerExit(term)
+		})
+	}
+
+	if kw.job && (err == ErrPodCompleted || err == ErrPodFailed) {
+		// The Job decides whether a finished pod is retried, so its output is streamed
+		// and the outcome is taken from the Job conditions.
+		return ErrPodCompleted
+	}
 
 	if err == ErrPodCompleted {
 		// Hao: shouldn't we also call kw.Cancel() in these cases?
-		for _, cstat := range kw.pod.Status.ContainerStatuses {
-			if cstat.Name == "worker" {
-				if cstat.State.Terminated != nil && cstat.State.Terminated.ExitCode != 0 {
-					return fmt.Errorf("container failed with exit code %d: %s", cstat.State.Terminated.ExitCode, cstat.State.Terminated.Message)
-				}
-
-				break
-			}
+		if term := kw.recordPodDiagnostics(kw.pod); term != nil && term.ExitCode > 0 {
+			return fmt.Errorf("container failed: %s", term)
 		}
 
 		return err
@@ -566,31 +752,268 @@ func (kw *KubeUnit) createPod(env map[string]string) error {
 
 			return fmt.Errorf(errMsg) //nolint:govet,staticcheck
 		}
+		stdout.SetMaxSize(kw.GetWorkceptor().stdoutLimit(kw))
 		var stdoutErr error
 		var streamWait sync.WaitGroup
 		streamWait.Add(1)
 		go kw.kubeLoggingNoReconnect(&streamWait, stdout, &stdoutErr)
 		streamWait.Wait()
+		term := kw.recordPodDiagnostics(kw.pod)
 		kw.Cancel()
-		if len(kw.pod.Status.ContainerStatuses) == 1 {
-			if kw.pod.Status.ContainerStatuses[0].State.Waiting != nil {
-				return fmt.Errorf("%s, %s", err.Error(), kw.pod.Status.ContainerStatuses[0].State.Waiting.Reason)
+		if term != nil {
+			return fmt.Errorf("%s, %s", err.Error(), term)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// workerTerminatedState returns the terminated state of the worker container of a pod, or nil if it is still running.
+func workerTerminatedState(pod *corev1.Pod) *corev1.ContainerStateTerminated {
+	for _, cstat := range pod.Status.ContainerStatuses {
+		if cstat.Name == "worker" {
+			return cstat.State.Terminated
+		}
+	}
+
+	return nil
+}
+
+// setContainerExit records the exit code, terminating signal and timestamps of a terminated container.
+func (sfd *StatusFileData) setContainerExit(term *corev1.ContainerStateTerminated) {
+	sfd.ExitCode = int(term.ExitCode)
+	sfd.Signal = ""
+	if term.Signal != 0 {
+		sfd.Signal = syscall.Signal(term.Signal).String()
+	}
+	if !term.StartedAt.IsZero() {
+		sfd.StartTime = term.StartedAt.Time
+	}
+	sfd.EndTime = term.FinishedAt.Time
+}
+
+// updateContainerExit waits briefly for the worker container or the pod to terminate, and records how it exited
+// and why.  It returns the termination, or nil if it could not be determined.
+func (kw *KubeUnit) updateContainerExit() *KubeTermination {
+	if kw.pod == nil {
+		return nil
+	}
+	for retries := 5; retries > 0; retries-- {
+		pod, err := KubeAPIWrapperInstance.Get(kw.GetContext(), kw.clientset, kw.pod.Namespace, kw.pod.Name, metav1.GetOptions{})
+		if err == nil {
+			term := workerTerminatedState(pod)
+			if term != nil || pod.Status.Phase == corev1.PodFailed {
+				if term != nil {
+					kw.UpdateFullStatus(func(status *StatusFileData) {
+						status.setContainerExit(term)
+					})
+				}
+
+				return kw.recordPodDiagnostics(pod)
 			}
+		}
+		if sleepOrDone(kw.GetContext().Done(), time.Second) {
+			return nil
+		}
+	}
+	kw.GetWorkceptor().nc.GetLogger().Warning("Could not determine exit status of pod %s/%s", kw.pod.Namespace, kw.pod.Name)
 
-			for _, cstat := range kw.pod.Status.ContainerStatuses {
-				if cstat.Name == "worker" {
-					if cstat.State.Waiting != nil {
-						return fmt.Errorf("%s, %s", err.Error(), cstat.State.Waiting.Reason)
-					}
+	return nil
+}
+
+// jobPodSelector returns the label selector matching the pods of a Job.
+func jobPodSelector(jobName string) string {
+	return "job-name=" + jobName
+}
+
+// checkStdinSize returns an error if the unit is given stdin and its Job may retry failed pods.  Stdin is only sent to
+// the first pod, so a retried pod would wait for stdin forever.  It is checked when work is submitted, before the
+// stdin is received if its size is declared.
+func (kw *KubeUnit) checkStdinSize(size int64) error {
+	if kw.job && kw.backoffLimit > 0 && size > 0 {
+		return fmt.Errorf("a job with a backofflimit cannot be given stdin, as retried pods would not receive it")
+	}
+
+	return nil
+}
+
+// checkJobStdin returns an error if the stdin the unit was given cannot be sent to its Job.
+func (kw *KubeUnit) checkJobStdin() error {
+	fi, err := os.Stat(path.Join(kw.UnitDir(), "stdin"))
+	if err != nil {
+		return nil
+	}
+
+	return kw.checkStdinSize(fi.Size())
+}
+
+// createJob creates a Job that runs the given pod as its template.
+func (kw *KubeUnit) createJob(pod *corev1.Pod) (*batchv1.Job, error) {
+	backoffLimit := kw.backoffLimit
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: pod.GenerateName,
+			Namespace:    pod.Namespace,
+			Labels:       pod.Labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      pod.Labels,
+					Annotations: pod.Annotations,
+				},
+				Spec: pod.Spec,
+			},
+		},
+	}
+	if kw.activeDeadline > 0 {
+		activeDeadline := kw.activeDeadline
+		job.Spec.ActiveDeadlineSeconds = &activeDeadline
+	}
+	if kw.ttlAfterFinished > 0 {
+		ttl := kw.ttlAfterFinished
+		job.Spec.TTLSecondsAfterFinished = &ttl
+	}
 
-					if cstat.State.Terminated != nil && cstat.State.Terminated.ExitCode != 0 {
-						return fmt.Errorf("%s, exit code %d: %s", err.Error(), cstat.State.Terminated.ExitCode, cstat.State.Terminated.Message)
+	return KubeAPIWrapperInstance.CreateJob(kw.GetContext(), kw.clientset, pod.Namespace, job, metav1.CreateOptions{})
+}
+
+// jobPods returns the pods of a Job, oldest first.
+func (kw *KubeUnit) jobPods(namespace string, jobName string) ([]corev1.Pod, error) {
+	podList, err := KubeAPIWrapperInstance.List(kw.GetContext(), kw.clientset, namespace, metav1.ListOptions{
+		LabelSelector: jobPodSelector(jobName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	pods := podList.Items
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+
+	return pods, nil
+}
+
+// latestJobPod returns the most recently created pod of a Job.
+func (kw *KubeUnit) latestJobPod(namespace string, jobName string) (*corev1.Pod, error) {
+	pods, err := kw.jobPods(namespace, jobName)
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, KubeAPIWrapperInstance.NewNotFound(schema.GroupResource{Resource: "pods"}, jobPodSelector(jobName))
+	}
+
+	return &pods[len(pods)-1], nil
+}
+
+// jobFinishedCondition returns the Complete or Failed condition of a Job, or nil if it is still active.
+func jobFinishedCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		cond := &job.Status.Conditions[i]
+		if (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) && cond.Status == corev1.ConditionTrue {
+			return cond
+		}
+	}
+
+	return nil
+}
+
+// followJob streams the output of each pod the Job starts after the current one, until the Job completes or fails.
+func (kw *KubeUnit) followJob(stdout *STDoutWriter) {
+	ked := kw.UnredactedStatus().ExtraData.(*KubeExtraData)
+	seen := map[string]bool{kw.pod.Name: true}
+	remainingRetries := 5
+	for {
+		job, err := KubeAPIWrapperInstance.GetJob(kw.GetContext(), kw.clientset, ked.KubeNamespace, ked.JobName, metav1.GetOptions{})
+		if kw.GetContext().Err() != nil {
+			return
+		}
+		if err != nil {
+			remainingRetries--
+			if remainingRetries == 0 {
+				errMsg := fmt.Sprintf("Error getting job %s/%s. Error: %s", ked.KubeNamespace, ked.JobName, err)
+				kw.GetWorkceptor().nc.GetLogger().Error(errMsg) //nolint:govet
+				kw.UpdateBasicStatus(WorkStateFailed, errMsg, stdout.Size())
+
+				return
+			}
+			kw.GetWorkceptor().nc.GetLogger().Warning(
+				"Error getting job %s/%s. Will retry %d more times. Error: %s",
+				ked.KubeNamespace,
+				ked.JobName,
+				remainingRetries,
+				err,
+			)
+		} else {
+			remainingRetries = 5
+			if cond := jobFinishedCondition(job); cond != nil {
+				term := kw.updateContainerExit()
+				if cond.Type == batchv1.JobComplete {
+					kw.UpdateBasicStatus(WorkStateSucceeded, "Job completed", stdout.Size())
+				} else {
+					detail := fmt.Sprintf("Job failed: %s: %s", cond.Reason, cond.Message)
+					if term != nil {
+						detail = fmt.Sprintf("%s; last pod: %s", detail, term)
 					}
+					kw.UpdateBasicStatus(WorkStateFailed, detail, stdout.Size())
+				}
 
-					break
+				return
+			}
+			pods, err := kw.jobPods(ked.KubeNamespace, ked.JobName)
+			if err != nil {
+				kw.GetWorkceptor().nc.GetLogger().Warning("Error listing pods of job %s/%s: %s", ked.KubeNamespace, ked.JobName, err)
+			}
+			for i := range pods {
+				if seen[pods[i].Name] || pods[i].Status.Phase == corev1.PodPending {
+					continue
 				}
+				seen[pods[i].Name] = true
+				kw.pod = &pods[i]
+				kw.UpdateFullStatus(func(status *StatusFileData) {
+					status.State = WorkStateRunning
+					status.Detail = fmt.Sprintf("Job pod running after %d failed attempts", job.Status.Failed)
+					status.StdoutSize = stdout.Size()
+					status.ExtraData.(*KubeExtraData).PodName = kw.pod.Name
+					status.beginAttempt()
+				})
+				var stdoutErr error
+				var streamWait sync.WaitGroup
+				streamWait.Add(1)
+				kw.kubeLoggingNoReconnect(&streamWait, stdout, &stdoutErr)
+				if stdoutErr != nil && kw.GetContext().Err() == nil {
+					errMsg := fmt.Sprintf("Error with pod's stdout: %s", stdoutErr)
+					kw.UpdateBasicStatus(WorkStateFailed, errMsg, stdout.Size())
+
+					return
+				}
+
+				break
 			}
 		}
+		if sleepOrDone(kw.GetContext().Done(), time.Second) {
+			return
+		}
+	}
+}
+
+// deleteJob deletes the Job of the unit along with its pods.
+func (kw *KubeUnit) deleteJob(ked *KubeExtraData) error {
+	if kw.clientset == nil {
+		if err := kw.connectToKube(); err != nil {
+			return err
+		}
+	}
+	propagation := metav1.DeletePropagationBackground
+	err := KubeAPIWrapperInstance.DeleteJob(context.Background(), kw.clientset, ked.KubeNamespace, ked.JobName, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		kw.GetWorkceptor().nc.GetLogger().Error("Error deleting job %s: %s", ked.JobName, err)
 
 		return err
 	}
@@ -649,7 +1072,12 @@ func (kw *KubeUnit) runWorkUsingLogger() {
 			default:
 			}
 
-			kw.pod, err = KubeAPIWrapperInstance.Get(kw.GetContext(), kw.clientset, podNamespace, podName, metav1.GetOptions{})
+			if ked.JobName != "" {
+				// the Job may have replaced the pod while we were not watching
+				kw.pod, err = kw.latestJobPod(podNamespace, ked.JobName)
+			} else {
+				kw.pod, err = KubeAPIWrapperInstance.Get(kw.GetContext(), kw.clientset, podNamespace, podName, metav1.GetOptions{})
+			}
 			if err == nil {
 				break
 			}
@@ -669,6 +1097,13 @@ func (kw *KubeUnit) runWorkUsingLogger() {
 
 			return
 		}
+		if kw.pod.Name != podName {
+			podName = kw.pod.Name
+			kw.UpdateFullStatus(func(status *StatusFileData) {
+				status.ExtraData.(*KubeExtraData).PodName = podName
+				status.beginAttempt()
+			})
+		}
 	}
 
 	// Attach stdin stream to the pod
@@ -738,8 +1173,15 @@ func (kw *KubeUnit) runWorkUsingLogger() {
 		}
 	}
 
-	// open stdout writer that writes to work unit's data directory
-	stdout, err := NewStdoutWriter(FileSystem{}, kw.UnitDir())
+	// open stdout writer that writes to work unit's data directory, continuing the stored output when it is read
+	// from the stream sidecar
+	var stdout *STDoutWriter
+	var err error
+	if kw.streamMethod == "sidecar" {
+		stdout, err = NewAppendingStdoutWriter(FileSystem{}, kw.UnitDir())
+	} else {
+		stdout, err = NewStdoutWriter(FileSystem{}, kw.UnitDir())
+	}
 	if err != nil {
 		errMsg := fmt.Sprintf("Error opening stdout file: %s", err)
 		kw.GetWorkceptor().nc.GetLogger().Error(errMsg) //nolint:govet
@@ -747,6 +1189,7 @@ func (kw *KubeUnit) runWorkUsingLogger() {
 
 		return
 	}
+	stdout.SetMaxSize(kw.GetWorkceptor().stdoutLimit(kw))
 
 	// goroutine to cancel stdout stream
 	go func() {
@@ -828,11 +1271,14 @@ func (kw *KubeUnit) runWorkUsingLogger() {
 		}()
 	}
 
-	stdoutWithReconnect := ShouldUseReconnect(kw)
-	if stdoutWithReconnect && stdoutErr == nil {
+	switch {
+	case kw.streamMethod == "sidecar":
+		kw.GetWorkceptor().nc.GetLogger().Debug("streaming stdout from the stream sidecar")
+		go kw.kubeStreamFromSidecar(&streamWait, stdout, &stdinErr, &stdoutErr)
+	case ShouldUseReconnect(kw) && stdoutErr == nil:
 		kw.GetWorkceptor().nc.GetLogger().Debug("streaming stdout with reconnect support")
 		go kw.kubeLoggingWithReconnect(&streamWait, stdout, &stdinErr, &stdoutErr)
-	} else {
+	default:
 		kw.GetWorkceptor().nc.GetLogger().Debug("streaming stdout with no reconnect support")
 		go kw.kubeLoggingNoReconnect(&streamWait, stdout, &stdoutErr)
 	}
@@ -860,7 +1306,12 @@ func (kw *KubeUnit) runWorkUsingLogger() {
 
 	// only transition from WorkStateRunning to WorkStateSucceeded if WorkStateFailed is set we do not override
 	if kw.GetContext().Err() != context.Canceled && kw.Status().State == WorkStateRunning {
-		kw.UpdateBasicStatus(WorkStateSucceeded, "Finished", stdout.Size())
+		if kw.UnredactedStatus().ExtraData.(*KubeExtraData).JobName != "" {
+			kw.followJob(stdout)
+
+			return
+		}
+		kw.UpdateBasicStatus(WorkStateSucceeded, finishedDetail(kw.updateContainerExit()), stdout.Size())
 	}
 }
 
@@ -1083,6 +1534,7 @@ func (kw *KubeUnit) runWorkUsingTCP() {
 
 		return
 	}
+	stdout.SetMaxSize(kw.GetWorkceptor().stdoutLimit(kw))
 
 	kw.UpdateBasicStatus(WorkStatePending, "Sending stdin to pod", 0)
 
@@ -1135,7 +1587,7 @@ func (kw *KubeUnit) runWorkUsingTCP() {
 	}
 
 	if ctx.Err() == nil {
-		kw.UpdateBasicStatus(WorkStateSucceeded, "Finished", stdout.Size())
+		kw.UpdateBasicStatus(WorkStateSucceeded, finishedDetail(kw.updateContainerExit()), stdout.Size())
 	}
 }
 
@@ -1196,7 +1648,10 @@ func (kw *KubeUnit) connectUsingIncluster() error {
 }
 
 func (kw *KubeUnit) connectToKube() error {
-	var err error
+	err := kw.applyCluster()
+	if err != nil {
+		return err
+	}
 	switch {
 	case kw.authMethod == "kubeconfig" || kw.authMethod == "runtime":
 		err = kw.connectUsingKubeconfig()
@@ -1340,6 +1795,8 @@ func (kw *KubeUnit) SetFromParams(params map[string]string) error {
 	userImage := ""
 	userPod := ""
 	podPendingTimeoutString := ""
+	podPatch := ""
+	clusterSelector := ""
 	values := []value{
 		{name: "kube_command", permission: kw.allowRuntimeCommand, setter: setString(&userCommand)},
 		{name: "kube_image", permission: kw.allowRuntimeCommand, setter: setString(&userImage)},
@@ -1348,6 +1805,8 @@ func (kw *KubeUnit) SetFromParams(params map[string]string) error {
 		{name: "secret_kube_config", permission: kw.allowRuntimeAuth, setter: setString(&ked.KubeConfig)},
 		{name: "secret_kube_pod", permission: kw.allowRuntimePod, setter: setString(&userPod)},
 		{name: "pod_pending_timeout", permission: kw.allowRuntimeParams, setter: setString(&podPendingTimeoutString)},
+		{name: "kube_pod_patch", permission: len(kw.podPatchPaths) > 0, setter: setString(&podPatch)},
+		{name: "kube_cluster_selector", permission: len(kw.clusters) > 0, setter: setString(&clusterSelector)},
 	}
 	for i := range values {
 		v := values[i]
@@ -1368,6 +1827,16 @@ func (kw *KubeUnit) SetFromParams(params map[string]string) error {
 	if userPod != "" && (userParams != "" || userCommand != "" || userImage != "") {
 		return fmt.Errorf("params kube_command, kube_image, kube_params not compatible with secret_kube_pod")
 	}
+	if clusterSelector != "" {
+		selector, err := parseClusterSelector(clusterSelector)
+		if err != nil {
+			return err
+		}
+		if len(selectClusters(kw.clusters, selector)) == 0 {
+			return fmt.Errorf("no cluster matches selector %s", clusterSelector)
+		}
+		ked.ClusterSelector = clusterSelector
+	}
 
 	if podPendingTimeoutString != "" {
 		podPendingTimeout, err := time.ParseDuration(podPendingTimeoutString)
@@ -1393,6 +1862,46 @@ func (kw *KubeUnit) SetFromParams(params map[string]string) error {
 	} else {
 		ked.Params = combineParams(kw.baseParams, userParams)
 	}
+	for k, v := range params {
+		var name string
+		var allowed []string
+		var target *map[string]string
+		switch {
+		case strings.HasPrefix(k, kubeSecretParamPrefix):
+			name, allowed, target = strings.TrimPrefix(k, kubeSecretParamPrefix), kw.secretInputs, &ked.SecretData
+		case strings.HasPrefix(k, kubeConfigParamPrefix):
+			name, allowed, target = strings.TrimPrefix(k, kubeConfigParamPrefix), kw.configInputs, &ked.ConfigData
+		default:
+			continue
+		}
+		found := false
+		for _, n := range allowed {
+			if n == name {
+				found = true
+
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s provided but not allowed", k)
+		}
+		if *target == nil {
+			*target = make(map[string]string)
+		}
+		(*target)[name] = v
+	}
+	if podPatch != "" {
+		err := validatePodPatch(podPatch, kw.podPatchPaths)
+		if err != nil {
+			return err
+		}
+		ked.PodPatch = podPatch
+		kedCopy := *ked
+		_, err = kw.buildPod(&kedCopy)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -1404,6 +1913,8 @@ func (kw *KubeUnit) Status() *StatusFileData {
 	if ok {
 		ed.KubeConfig = ""
 		ed.KubePod = ""
+		ed.SecretData = nil
+		ed.ConfigData = nil
 	}
 
 	return status
@@ -1452,7 +1963,12 @@ func (kw *KubeUnit) Restart() error {
 		return kw.startOrRestart()
 	}
 	// Work unit is in Pending state
-	if kw.deletePodOnRestart {
+	if kw.deletePodOnRestart && ked.JobName != "" {
+		err := kw.deleteJob(ked)
+		if err != nil {
+			kw.GetWorkceptor().nc.GetLogger().Warning("Job %s could not be deleted: %s", ked.JobName, err.Error())
+		}
+	} else if kw.deletePodOnRestart {
 		err := kw.connectToKube()
 		if err != nil {
 			kw.GetWorkceptor().nc.GetLogger().Warning("Pod %s could not be deleted: %s", ked.PodName, err.Error())
@@ -1473,6 +1989,11 @@ func (kw *KubeUnit) Restart() error {
 // Start launches a job with given parameters.
 func (kw *KubeUnit) Start() error {
 	kw.UpdateBasicStatus(WorkStatePending, "Connecting to Kubernetes", 0)
+	if len(kw.clusters) > 0 {
+		if err := kw.placeUnit(); err != nil {
+			return err
+		}
+	}
 
 	return kw.startOrRestart()
 }
@@ -1481,7 +2002,10 @@ func (kw *KubeUnit) Start() error {
 func (kw *KubeUnit) Cancel() error {
 	kw.CancelContext()
 	kw.UpdateBasicStatus(WorkStateCanceled, "Canceled", -1)
-	if kw.pod != nil {
+	if ked, ok := kw.UnredactedStatus().ExtraData.(*KubeExtraData); ok && ked.JobName != "" {
+		// deleting the Job also deletes its pods
+		_ = kw.deleteJob(ked)
+	} else if kw.pod != nil {
 		err := KubeAPIWrapperInstance.Delete(context.Background(), kw.clientset, kw.pod.Namespace, kw.pod.Name, metav1.DeleteOptions{})
 		if err != nil {
 			kw.GetWorkceptor().nc.GetLogger().Error("Error deleting pod %s: %s", kw.pod.Name, err)
@@ -1494,12 +2018,260 @@ func (kw *KubeUnit) Cancel() error {
 	return nil
 }
 
+// mountInputFiles places the input files of the unit, if any, in a Secret mounted in the worker container.
+func (kw *KubeUnit) mountInputFiles(pod *corev1.Pod, ked *KubeExtraData) error {
+	files, err := listInputFiles(kw.UnitDir())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	if ked.InputsSecret == "" {
+		data := make(map[string][]byte)
+		var total int64
+		for _, f := range files {
+			total += f.Size
+			if total > kubeInputsMaxSize {
+				return fmt.Errorf("input files exceed the %d byte limit for a Kubernetes work unit", kubeInputsMaxSize)
+			}
+			data[f.Name], err = os.ReadFile(path.Join(kw.UnitDir(), inputsDirName, f.Name))
+			if err != nil {
+				return err
+			}
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: kw.namePrefix + "inputs-",
+				Namespace:    ked.KubeNamespace,
+			},
+			Data: data,
+		}
+		secret, err = KubeAPIWrapperInstance.CreateSecret(kw.GetContext(), kw.clientset, ked.KubeNamespace, secret, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("error creating secret for input files: %w", err)
+		}
+		ked.InputsSecret = secret.Name
+		kw.UpdateFullStatus(func(status *StatusFileData) {
+			status.ExtraData.(*KubeExtraData).InputsSecret = secret.Name
+		})
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: "receptor-inputs",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: ked.InputsSecret},
+		},
+	})
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if container.Name != "worker" {
+			continue
+		}
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "receptor-inputs",
+			MountPath: kubeInputsDir,
+			ReadOnly:  true,
+		})
+		env := inputFileEnv(kubeInputsDir, files)
+		names := make([]string, 0, len(env))
+		for k := range env {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			container.Env = append(container.Env, corev1.EnvVar{Name: k, Value: env[k]})
+		}
+	}
+
+	return nil
+}
+
+// unitVolumeData returns the entries of a per-unit Secret or ConfigMap, taking each name from the input file of the
+// unit with that name, or else from the value submitted for it.  Names given neither way are left out.
+func (kw *KubeUnit) unitVolumeData(names []string, values map[string]string) (map[string][]byte, error) {
+	data := make(map[string][]byte)
+	var total int64
+	for _, name := range names {
+		content, err := os.ReadFile(path.Join(kw.UnitDir(), inputsDirName, name))
+		if errors.Is(err, os.ErrNotExist) {
+			value, ok := values[name]
+			if !ok {
+				continue
+			}
+			content, err = []byte(value), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		total += int64(len(content))
+		if total > kubeInputsMaxSize {
+			return nil, fmt.Errorf("entries exceed the %d byte limit for a Kubernetes Secret or ConfigMap", kubeInputsMaxSize)
+		}
+		data[name] = content
+	}
+
+	return data, nil
+}
+
+// mountWorkerVolume adds a volume to a pod and mounts it read-only in the worker container.
+func mountWorkerVolume(pod *corev1.Pod, name string, source corev1.VolumeSource, mountPath string) {
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: name, VolumeSource: source})
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if container.Name == "worker" {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      name,
+				MountPath: mountPath,
+				ReadOnly:  true,
+			})
+		}
+	}
+}
+
+// mountUnitVolumes places the configured secret and config entries of the unit in a Secret and a ConfigMap created
+// for the unit, and mounts them in the worker container.
+func (kw *KubeUnit) mountUnitVolumes(pod *corev1.Pod, ked *KubeExtraData) error {
+	if len(kw.secretInputs) > 0 && ked.UnitSecret == "" {
+		data, err := kw.unitVolumeData(kw.secretInputs, ked.SecretData)
+		if err != nil {
+			return err
+		}
+		if len(data) > 0 {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: kw.namePrefix + "secret-",
+					Namespace:    ked.KubeNamespace,
+				},
+				Data: data,
+			}
+			secret, err = KubeAPIWrapperInstance.CreateSecret(kw.GetContext(), kw.clientset, ked.KubeNamespace, secret, metav1.CreateOptions{})
+			if err != nil {
+				return fmt.Errorf("error creating secret for unit: %w", err)
+			}
+			ked.UnitSecret = secret.Name
+			kw.UpdateFullStatus(func(status *StatusFileData) {
+				status.ExtraData.(*KubeExtraData).UnitSecret = secret.Name
+			})
+		}
+	}
+	if ked.UnitSecret != "" {
+		mountWorkerVolume(pod, "receptor-secret", corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: ked.UnitSecret},
+		}, kw.secretMountPath)
+	}
+	if len(kw.configInputs) > 0 && ked.UnitConfigMap == "" {
+		data, err := kw.unitVolumeData(kw.configInputs, ked.ConfigData)
+		if err != nil {
+			return err
+		}
+		if len(data) > 0 {
+			configMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: kw.namePrefix + "config-",
+					Namespace:    ked.KubeNamespace,
+				},
+				BinaryData: data,
+			}
+			configMap, err = KubeAPIWrapperInstance.CreateConfigMap(kw.GetContext(), kw.clientset, ked.KubeNamespace, configMap, metav1.CreateOptions{})
+			if err != nil {
+				return fmt.Errorf("error creating config map for unit: %w", err)
+			}
+			ked.UnitConfigMap = configMap.Name
+			kw.UpdateFullStatus(func(status *StatusFileData) {
+				status.ExtraData.(*KubeExtraData).UnitConfigMap = configMap.Name
+			})
+		}
+	}
+	if ked.UnitConfigMap != "" {
+		mountWorkerVolume(pod, "receptor-config", corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: ked.UnitConfigMap},
+			},
+		}, kw.configMountPath)
+	}
+
+	return nil
+}
+
+// setUnitObjectsOwner makes the pod or Job of the unit the owner of the Secrets and ConfigMap created for the unit,
+// so that the cluster deletes them along with it even if the unit is never released.
+func (kw *KubeUnit) setUnitObjectsOwner(ked *KubeExtraData, owner metav1.OwnerReference) {
+	if ked.InputsSecret == "" && ked.UnitSecret == "" && ked.UnitConfigMap == "" {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"ownerReferences": []metav1.OwnerReference{owner},
+		},
+	})
+	if err != nil {
+		kw.GetWorkceptor().nc.GetLogger().Error("Error creating owner reference patch: %s", err)
+
+		return
+	}
+	for _, name := range []string{ked.InputsSecret, ked.UnitSecret} {
+		if name == "" {
+			continue
+		}
+		_, err := KubeAPIWrapperInstance.PatchSecret(kw.GetContext(), kw.clientset, ked.KubeNamespace, name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			kw.GetWorkceptor().nc.GetLogger().Warning("Could not set the owner of secret %s: %s", name, err)
+		}
+	}
+	if ked.UnitConfigMap != "" {
+		_, err := KubeAPIWrapperInstance.PatchConfigMap(kw.GetContext(), kw.clientset, ked.KubeNamespace, ked.UnitConfigMap, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			kw.GetWorkceptor().nc.GetLogger().Warning("Could not set the owner of config map %s: %s", ked.UnitConfigMap, err)
+		}
+	}
+}
+
+// deleteUnitObjects deletes the Secrets and ConfigMap created for the unit.
+func (kw *KubeUnit) deleteUnitObjects(ked *KubeExtraData) error {
+	if kw.clientset == nil {
+		if err := kw.connectToKube(); err != nil {
+			return err
+		}
+	}
+	var firstErr error
+	for _, name := range []string{ked.InputsSecret, ked.UnitSecret} {
+		if name == "" {
+			continue
+		}
+		err := KubeAPIWrapperInstance.DeleteSecret(context.Background(), kw.clientset, ked.KubeNamespace, name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			kw.GetWorkceptor().nc.GetLogger().Error("Error deleting secret %s: %s", name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if ked.UnitConfigMap != "" {
+		err := KubeAPIWrapperInstance.DeleteConfigMap(context.Background(), kw.clientset, ked.KubeNamespace, ked.UnitConfigMap, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			kw.GetWorkceptor().nc.GetLogger().Error("Error deleting config map %s: %s", ked.UnitConfigMap, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
 // Release releases resources associated with a job.  Implies Cancel.
 func (kw *KubeUnit) Release(force bool) error {
 	err := kw.Cancel()
 	if err != nil && !force {
 		return err
 	}
+	ked := kw.UnredactedStatus().ExtraData.(*KubeExtraData)
+	if ked.InputsSecret != "" || ked.UnitSecret != "" || ked.UnitConfigMap != "" {
+		err = kw.deleteUnitObjects(ked)
+		if err != nil && !force {
+			return err
+		}
+	}
 
 	return kw.BaseWorkUnitForWorkUnit.Release(force)
 }
@@ -1510,21 +2282,39 @@ func (kw *KubeUnit) Release(force bool) error {
 
 // KubeWorkerCfg is the cmdline configuration object for a Kubernetes worker plugin.
 type KubeWorkerCfg struct {
-	WorkType            string `required:"true" description:"Name for this worker type"`
-	Namespace           string `description:"Kubernetes namespace to create pods in"`
-	Image               string `description:"Container image to use for the worker pod"`
-	Command             string `description:"Command to run in the container (overrides entrypoint)"`
-	Params              string `description:"Command-line parameters to pass to the entrypoint"`
-	AuthMethod          string `description:"One of: kubeconfig, incluster" default:"incluster"`
-	KubeConfig          string `description:"Kubeconfig filename (for authmethod=kubeconfig)"`
-	Pod                 string `description:"Pod definition filename, in json or yaml format"`
-	AllowRuntimeAuth    bool   `description:"Allow passing API parameters at runtime" default:"false"`
-	AllowRuntimeCommand bool   `description:"Allow specifying image & command at runtime" default:"false"`
-	AllowRuntimeParams  bool   `description:"Allow adding command parameters at runtime" default:"false"`
-	AllowRuntimePod     bool   `description:"Allow passing Pod at runtime" default:"false"`
-	DeletePodOnRestart  bool   `description:"On restart, delete the pod if in pending state" default:"true"`
-	StreamMethod        string `description:"Method for connecting to worker pods: logger or tcp" default:"logger"`
-	VerifySignature     bool   `description:"Verify a signed work submission" default:"false"`
+	WorkType                string   `required:"true" description:"Name for this worker type"`
+	Namespace               string   `description:"Kubernetes namespace to create pods in"`
+	Image                   string   `description:"Container image to use for the worker pod"`
+	Command                 string   `description:"Command to run in the container (overrides entrypoint)"`
+	Params                  string   `description:"Command-line parameters to pass to the entrypoint"`
+	AuthMethod              string   `description:"One of: kubeconfig, incluster" default:"incluster"`
+	KubeConfig              string   `description:"Kubeconfig filename (for authmethod=kubeconfig)"`
+	Pod                     string   `description:"Pod definition filename, in json or yaml format"`
+	AllowRuntimeAuth        bool     `description:"Allow passing API parameters at runtime" default:"false"`
+	AllowRuntimeCommand     bool     `description:"Allow specifying image & command at runtime" default:"false"`
+	AllowRuntimeParams      bool     `description:"Allow adding command parameters at runtime" default:"false"`
+	AllowRuntimePod         bool     `description:"Allow passing Pod at runtime" default:"false"`
+	DeletePodOnRestart      bool     `description:"On restart, delete the pod if in pending state" default:"true"`
+	StreamMethod            string   `description:"Method for connecting to worker pods: logger, tcp or sidecar" default:"logger"`
+	StreamSidecarImage      string   `description:"Container image of the sidecar serving the output of the worker pod, pinned to a digest (for streammethod=sidecar)"`
+	VerifySignature         bool     `description:"Verify a signed work submission" default:"false"`
+	MaxStdoutSize           int64    `description:"Maximum size in bytes of the stored stdout of a unit, or 0 for no limit" default:"0"`
+	StdoutOverflow          string   `description:"What to do when stdout exceeds maxstdoutsize: truncate or fail" default:"truncate"`
+	ReleaseAfter            string   `description:"Release completed units after this duration, e.g. 24h" default:""`
+	Job                     bool     `description:"Run each work unit as a Kubernetes Job instead of a bare pod" default:"false"`
+	BackoffLimit            int      `description:"Number of times a Job retries a failed pod" default:"0"`
+	ActiveDeadlineSeconds   int      `description:"Seconds a Job may run before it is failed, or 0 for no limit" default:"0"`
+	TTLSecondsAfterFinished int      `description:"Seconds a finished Job is kept before the cluster deletes it, or 0 to keep it until the unit is released" default:"0"`
+	AllowRuntimePodPatch    []string `description:"Pod paths that may be patched at runtime, as JSON pointers such as /spec/nodeSelector"`
+	SecretInputs            []string `description:"Names of input files or kube_secret_<name> params to place in a Secret created for each unit"`
+	SecretMountPath         string   `description:"Where the Secret created for each unit is mounted in the worker container" default:"/receptor/secrets"`
+	ConfigInputs            []string `description:"Names of input files or kube_config_<name> params to place in a ConfigMap created for each unit"`
+	ConfigMountPath         string   `description:"Where the ConfigMap created for each unit is mounted in the worker container" default:"/receptor/config"`
+	Clusters                []string `description:"Clusters to place units on, each as name=<name>,kubeconfig=<file>,namespace=<namespace>,authmethod=<method>,label.<key>=<value>"`
+	Placement               string   `description:"How to choose the cluster of a unit: roundrobin, leastpending or failover" default:"roundrobin"`
+	OrphanPods              string   `description:"What to do with pods of this work type whose unit no longer exists: ignore, delete or adopt" default:"ignore"`
+	OrphanDryRun            bool     `description:"Only log what would be done with orphaned pods" default:"false"`
+	ReconcileInterval       string   `description:"How often to look for orphaned pods after startup, e.g. 1h, or empty for startup only" default:""`
 }
 
 // NewWorker is a factory to produce worker instances.
@@ -1559,6 +2349,7 @@ func (cfg KubeWorkerCfg) NewkubeWorker(bwu BaseWorkUnitForWorkUnit, w *Workcepto
 		BaseWorkUnitForWorkUnit: bwu,
 		authMethod:              strings.ToLower(cfg.AuthMethod),
 		streamMethod:            strings.ToLower(cfg.StreamMethod),
+		streamSidecarImage:      cfg.StreamSidecarImage,
 		baseParams:              cfg.Params,
 		allowRuntimeAuth:        cfg.AllowRuntimeAuth,
 		allowRuntimeCommand:     cfg.AllowRuntimeCommand,
@@ -1566,7 +2357,18 @@ func (cfg KubeWorkerCfg) NewkubeWorker(bwu BaseWorkUnitForWorkUnit, w *Workcepto
 		allowRuntimePod:         cfg.AllowRuntimePod,
 		deletePodOnRestart:      cfg.DeletePodOnRestart,
 		namePrefix:              fmt.Sprintf("%s-", strings.ToLower(cfg.WorkType)),
-	}
+		job:                     cfg.Job,
+		backoffLimit:            int32(cfg.BackoffLimit),
+		activeDeadline:          int64(cfg.ActiveDeadlineSeconds),
+		ttlAfterFinished:        int32(cfg.TTLSecondsAfterFinished),
+	}
+	ku.podPatchPaths, _ = parsePodPatchPaths(cfg.AllowRuntimePodPatch)
+	ku.secretInputs = cfg.SecretInputs
+	ku.secretMountPath = cfg.SecretMountPath
+	ku.configInputs = cfg.ConfigInputs
+	ku.configMountPath = cfg.ConfigMountPath
+	ku.clusters, _ = parseKubeClusters(cfg.Clusters, ku.authMethod, cfg.Namespace)
+	ku.placement = strings.ToLower(cfg.Placement)
 	ku.BaseWorkUnitForWorkUnit.Init(w, unitID, workType, FileSystem{}, nil)
 
 	return ku
@@ -1578,7 +2380,7 @@ func (cfg KubeWorkerCfg) Prepare() error {
 	if lcAuth != "kubeconfig" && lcAuth != "incluster" && lcAuth != "runtime" {
 		return fmt.Errorf("invalid AuthMethod: %s", cfg.AuthMethod)
 	}
-	if cfg.Namespace == "" && !(lcAuth == "kubeconfig" || cfg.AllowRuntimeAuth) {
+	if cfg.Namespace == "" && !(lcAuth == "kubeconfig" || cfg.AllowRuntimeAuth || len(cfg.Clusters) > 0) {
 		return fmt.Errorf("must provide namespace when AuthMethod is not kubeconfig")
 	}
 	if cfg.KubeConfig != "" {
@@ -1597,8 +2399,49 @@ func (cfg KubeWorkerCfg) Prepare() error {
 		return fmt.Errorf("must specify a container image to run")
 	}
 	method := strings.ToLower(cfg.StreamMethod)
-	if method != "logger" && method != "tcp" {
-		return fmt.Errorf("stream mode must be logger or tcp")
+	if method != "logger" && method != "tcp" && method != "sidecar" {
+		return fmt.Errorf("stream mode must be logger, tcp or sidecar")
+	}
+	if method == "sidecar" && cfg.StreamSidecarImage == "" {
+		return fmt.Errorf("must specify a streamsidecarimage for stream mode sidecar")
+	}
+	if method == "sidecar" {
+		if err := checkPinnedImage(cfg.StreamSidecarImage); err != nil {
+			return fmt.Errorf("invalid streamsidecarimage: %s", err)
+		}
+	}
+	if cfg.BackoffLimit < 0 || cfg.ActiveDeadlineSeconds < 0 || cfg.TTLSecondsAfterFinished < 0 {
+		return fmt.Errorf("backofflimit, activedeadlineseconds and ttlsecondsafterfinished must not be negative")
+	}
+	if !cfg.Job && (cfg.BackoffLimit != 0 || cfg.ActiveDeadlineSeconds != 0 || cfg.TTLSecondsAfterFinished != 0) {
+		return fmt.Errorf("backofflimit, activedeadlineseconds and ttlsecondsafterfinished can only be used with job")
+	}
+	if cfg.Job && method != "logger" {
+		return fmt.Errorf("job is only supported with stream mode logger")
+	}
+	if _, err := parsePodPatchPaths(cfg.AllowRuntimePodPatch); err != nil {
+		return err
+	}
+	for _, name := range append(append([]string{}, cfg.SecretInputs...), cfg.ConfigInputs...) {
+		if !inputNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid secret or config input name %q", name)
+		}
+	}
+	if len(cfg.SecretInputs) > 0 && !path.IsAbs(cfg.SecretMountPath) {
+		return fmt.Errorf("secretmountpath must be an absolute path")
+	}
+	if len(cfg.ConfigInputs) > 0 && !path.IsAbs(cfg.ConfigMountPath) {
+		return fmt.Errorf("configmountpath must be an absolute path")
+	}
+	if err := cfg.validateKubeClusters(); err != nil {
+		return err
+	}
+	if err := cfg.validateOrphanPods(); err != nil {
+		return err
+	}
+	_, err := NewRetentionPolicy(cfg.MaxStdoutSize, cfg.StdoutOverflow, cfg.ReleaseAfter)
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -1614,9 +2457,23 @@ func (cfg KubeWorkerCfg) GetVerifySignature() bool {
 
 // Run runs the action.
 func (cfg KubeWorkerCfg) Run() error {
-	err := MainInstance.RegisterWorker(cfg.WorkType, cfg.NewWorker, cfg.VerifySignature)
+	policy, err := NewRetentionPolicy(cfg.MaxStdoutSize, cfg.StdoutOverflow, cfg.ReleaseAfter)
+	if err != nil {
+		return err
+	}
+	err = MainInstance.RegisterWorker(cfg.WorkType, cfg.NewWorker, cfg.VerifySignature)
+	if err != nil {
+		return err
+	}
+	err = MainInstance.SetRetentionPolicy(cfg.WorkType, policy)
+	if err != nil {
+		return err
+	}
+	if action := strings.ToLower(cfg.OrphanPods); action == "" || action == "ignore" {
+		return nil
+	}
 
-	return err
+	return cfg.startOrphanReconciler(MainInstance)
 }
 
 func init() {
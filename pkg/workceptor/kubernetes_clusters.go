@@ -0,0 +1,300 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// kubeCluster is one of the clusters a Kubernetes work type can place its units on.
+type kubeCluster struct {
+	name       string
+	authMethod string
+	kubeConfig string
+	namespace  string
+	labels     map[string]string
+}
+
+// kubeLabelPrefix prefixes the keys of a cluster definition giving the labels of the cluster.
+const kubeLabelPrefix = "label."
+
+// kubePlacements are the strategies for choosing the cluster of a unit.
+var kubePlacements = []string{"roundrobin", "leastpending", "failover"}
+
+// kubeRoundRobin holds the index of the next cluster of each work type placing its units round-robin.
+var kubeRoundRobin = struct {
+	sync.Mutex
+	next map[string]int
+}{next: make(map[string]int)}
+
+// parseKubeCluster parses a cluster definition of the form name=<name>,kubeconfig=<file>,namespace=<namespace>,
+// authmethod=<method>,label.<key>=<value>.  The auth method and namespace default to those of the work type.
+func parseKubeCluster(def string, authMethod string, namespace string) (*kubeCluster, error) {
+	c := &kubeCluster{
+		authMethod: authMethod,
+		namespace:  namespace,
+		labels:     make(map[string]string),
+	}
+	for _, field := range strings.Split(def, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid cluster %q: expected key=value, got %q", def, field)
+		}
+		switch {
+		case key == "name":
+			c.name = value
+		case key == "authmethod":
+			c.authMethod = strings.ToLower(value)
+		case key == "kubeconfig":
+			c.kubeConfig = value
+		case key == "namespace":
+			c.namespace = value
+		case strings.HasPrefix(key, kubeLabelPrefix) && len(key) > len(kubeLabelPrefix):
+			c.labels[strings.TrimPrefix(key, kubeLabelPrefix)] = value
+		default:
+			return nil, fmt.Errorf("invalid cluster %q: unknown key %s", def, key)
+		}
+	}
+	if c.name == "" {
+		return nil, fmt.Errorf("invalid cluster %q: name is required", def)
+	}
+	if c.authMethod != "kubeconfig" && c.authMethod != "incluster" {
+		return nil, fmt.Errorf("invalid cluster %s: authmethod must be kubeconfig or incluster", c.name)
+	}
+	if c.kubeConfig != "" && c.authMethod != "kubeconfig" {
+		return nil, fmt.Errorf("invalid cluster %s: can only provide kubeconfig when authmethod=kubeconfig", c.name)
+	}
+	if c.namespace == "" && c.authMethod != "kubeconfig" {
+		return nil, fmt.Errorf("invalid cluster %s: must provide namespace when authmethod is not kubeconfig", c.name)
+	}
+
+	return c, nil
+}
+
+// parseKubeClusters parses the cluster definitions of a work type.
+func parseKubeClusters(defs []string, authMethod string, namespace string) ([]*kubeCluster, error) {
+	clusters := make([]*kubeCluster, 0, len(defs))
+	names := make(map[string]bool)
+	for _, def := range defs {
+		c, err := parseKubeCluster(def, authMethod, namespace)
+		if err != nil {
+			return nil, err
+		}
+		if names[c.name] {
+			return nil, fmt.Errorf("duplicate cluster name %s", c.name)
+		}
+		names[c.name] = true
+		clusters = append(clusters, c)
+	}
+
+	return clusters, nil
+}
+
+// parseClusterSelector parses a selector of the form key=value,key=value, which matches the clusters having all
+// the given labels.
+func parseClusterSelector(selector string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if strings.TrimSpace(selector) == "" {
+		return labels, nil
+	}
+	for _, field := range strings.Split(selector, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid cluster selector %q: expected key=value, got %q", selector, field)
+		}
+		labels[key] = value
+	}
+
+	return labels, nil
+}
+
+// matches returns true if the cluster has all the labels of a selector.
+func (c *kubeCluster) matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if c.labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// selectClusters returns the clusters matching a selector.
+func selectClusters(clusters []*kubeCluster, selector map[string]string) []*kubeCluster {
+	selected := make([]*kubeCluster, 0, len(clusters))
+	for _, c := range clusters {
+		if c.matches(selector) {
+			selected = append(selected, c)
+		}
+	}
+
+	return selected
+}
+
+// placementOrder returns the clusters in the order they are tried.  Round-robin placement, the default, starts
+// with the next cluster of the work type, and the other strategies with the first cluster.
+func placementOrder(clusters []*kubeCluster, placement string, workType string) []*kubeCluster {
+	if (placement != "" && placement != "roundrobin") || len(clusters) < 2 {
+		return clusters
+	}
+	kubeRoundRobin.Lock()
+	start := kubeRoundRobin.next[workType] % len(clusters)
+	kubeRoundRobin.next[workType] = start + 1
+	kubeRoundRobin.Unlock()
+	order := make([]*kubeCluster, 0, len(clusters))
+	order = append(order, clusters[start:]...)
+
+	return append(order, clusters[:start]...)
+}
+
+// findCluster returns the configured cluster with a given name, or nil if there is none.
+func (kw *KubeUnit) findCluster(name string) *kubeCluster {
+	for _, c := range kw.clusters {
+		if c.name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// useCluster records a cluster in the status of the unit, so that it connects to the cluster from now on.
+func (kw *KubeUnit) useCluster(c *kubeCluster) error {
+	kubeConfig, err := readFileToString(c.kubeConfig)
+	if err != nil {
+		return fmt.Errorf("could not read kubeconfig: %s", err)
+	}
+	kw.authMethod = c.authMethod
+	kw.UpdateFullStatus(func(status *StatusFileData) {
+		ked := status.ExtraData.(*KubeExtraData)
+		ked.Cluster = c.name
+		ked.KubeConfig = kubeConfig
+		ked.KubeNamespace = c.namespace
+	})
+
+	return nil
+}
+
+// applyCluster sets the auth method of the cluster recorded in the status of the unit, if any.  A unit placed on a
+// cluster that is no longer configured keeps using the kubeconfig recorded when it was placed.
+func (kw *KubeUnit) applyCluster() error {
+	ked, ok := kw.UnredactedStatus().ExtraData.(*KubeExtraData)
+	if !ok || ked.Cluster == "" {
+		return nil
+	}
+	c := kw.findCluster(ked.Cluster)
+	switch {
+	case c != nil:
+		kw.authMethod = c.authMethod
+	case ked.KubeConfig != "":
+		kw.authMethod = "kubeconfig"
+	default:
+		return fmt.Errorf("cluster %s is no longer configured", ked.Cluster)
+	}
+
+	return nil
+}
+
+// probeCluster connects to a cluster and returns the number of pending pods in the namespace of the unit.
+func (kw *KubeUnit) probeCluster(c *kubeCluster) (int, error) {
+	if err := kw.useCluster(c); err != nil {
+		return 0, err
+	}
+	if err := kw.connectToKube(); err != nil {
+		return 0, err
+	}
+	ked := kw.UnredactedStatus().ExtraData.(*KubeExtraData)
+	pods, err := KubeAPIWrapperInstance.List(kw.GetContext(), kw.clientset, ked.KubeNamespace, metav1.ListOptions{
+		FieldSelector: "status.phase=Pending",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(pods.Items), nil
+}
+
+// placeUnit chooses the cluster of the unit among those matching its cluster selector and records it in the status
+// of the unit.  Clusters whose API cannot be reached are skipped in favour of the next one.
+func (kw *KubeUnit) placeUnit() error {
+	ked := kw.UnredactedStatus().ExtraData.(*KubeExtraData)
+	selector, err := parseClusterSelector(ked.ClusterSelector)
+	if err != nil {
+		return err
+	}
+	candidates := selectClusters(kw.clusters, selector)
+	if len(candidates) == 0 {
+		return fmt.Errorf("no cluster matches selector %s", ked.ClusterSelector)
+	}
+	var chosen *kubeCluster
+	best := 0
+	failures := make([]string, 0)
+	for _, c := range placementOrder(candidates, kw.placement, kw.Status().WorkType) {
+		pending, err := kw.probeCluster(c)
+		if err != nil {
+			kw.GetWorkceptor().nc.GetLogger().Warning("Could not place unit %s on cluster %s: %s", kw.ID(), c.name, err)
+			failures = append(failures, fmt.Sprintf("%s: %s", c.name, err))
+
+			continue
+		}
+		if kw.placement != "leastpending" {
+			chosen = c
+
+			break
+		}
+		if chosen == nil || pending < best {
+			chosen, best = c, pending
+		}
+	}
+	if chosen == nil {
+		return fmt.Errorf("could not reach any cluster: %s", strings.Join(failures, "; "))
+	}
+	if err := kw.useCluster(chosen); err != nil {
+		return err
+	}
+	kw.GetWorkceptor().nc.GetLogger().Info("Placing unit %s on cluster %s", kw.ID(), chosen.name)
+
+	return nil
+}
+
+// validateKubeClusters checks the cluster definitions and placement strategy of a work type.
+func (cfg KubeWorkerCfg) validateKubeClusters() error {
+	placement := strings.ToLower(cfg.Placement)
+	valid := placement == ""
+	for _, p := range kubePlacements {
+		if p == placement {
+			valid = true
+
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("placement must be one of %s", strings.Join(kubePlacements, ", "))
+	}
+	if len(cfg.Clusters) == 0 {
+		return nil
+	}
+	if cfg.KubeConfig != "" || cfg.AllowRuntimeAuth || strings.ToLower(cfg.AuthMethod) == "runtime" {
+		return fmt.Errorf("clusters cannot be used with kubeconfig, allowruntimeauth or authmethod=runtime")
+	}
+	clusters, err := parseKubeClusters(cfg.Clusters, strings.ToLower(cfg.AuthMethod), cfg.Namespace)
+	if err != nil {
+		return err
+	}
+	for _, c := range clusters {
+		if c.kubeConfig == "" {
+			continue
+		}
+		if _, err := os.Stat(c.kubeConfig); err != nil {
+			return fmt.Errorf("error accessing kubeconfig file of cluster %s: %s", c.name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"testing"
+)
+
+func TestParseKubeClusters(t *testing.T) {
+	clusters, err := parseKubeClusters([]string{
+		"name=east,kubeconfig=/etc/receptor/east.yml,label.region=us-east,label.gpu=true",
+		"name=local,authmethod=incluster",
+	}, "kubeconfig", "awx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	east, local := clusters[0], clusters[1]
+	if east.authMethod != "kubeconfig" || east.kubeConfig != "/etc/receptor/east.yml" || east.namespace != "awx" {
+		t.Errorf("unexpected cluster east %+v", east)
+	}
+	if !east.matches(map[string]string{"region": "us-east", "gpu": "true"}) || east.matches(map[string]string{"region": "us-west"}) {
+		t.Errorf("unexpected labels of cluster east %v", east.labels)
+	}
+	if local.authMethod != "incluster" || local.namespace != "awx" {
+		t.Errorf("unexpected cluster local %+v", local)
+	}
+
+	invalid := map[string][]string{
+		"missing name":              {"namespace=awx"},
+		"duplicate name":            {"name=a", "name=a"},
+		"unknown key":               {"name=a,context=b"},
+		"kubeconfig with incluster": {"name=a,authmethod=incluster,kubeconfig=/x"},
+		"incluster without ns":      {"name=a,authmethod=incluster,namespace="},
+		"runtime auth":              {"name=a,authmethod=runtime"},
+	}
+	for name, defs := range invalid {
+		if _, err := parseKubeClusters(defs, "kubeconfig", ""); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := parseClusterSelector("region"); err == nil {
+		t.Error("expected an error for a selector without a value")
+	}
+}
+
+func TestPlacementOrder(t *testing.T) {
+	clusters, err := parseKubeClusters([]string{"name=a", "name=b", "name=c"}, "kubeconfig", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(order []*kubeCluster) string {
+		s := ""
+		for _, c := range order {
+			s += c.name
+		}
+
+		return s
+	}
+	for _, want := range []string{"abc", "bca", "cab", "abc"} {
+		if got := names(placementOrder(clusters, "roundrobin", "TestPlacementOrder")); got != want {
+			t.Errorf("expected round-robin order %s, got %s", want, got)
+		}
+	}
+	for _, placement := range []string{"failover", "leastpending"} {
+		if got := names(placementOrder(clusters, placement, "TestPlacementOrder")); got != "abc" {
+			t.Errorf("expected %s order abc, got %s", placement, got)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// kubeEventsMax is the number of most recent warning events of a pod kept in the status of a unit.
+const kubeEventsMax = 10
+
+// KubeTermination records why the worker container of a pod stopped or could not start, and why the pod itself
+// failed, if it did.
+type KubeTermination struct {
+	Reason       string `json:",omitempty"`
+	Message      string `json:",omitempty"`
+	ExitCode     int
+	RestartCount int32
+	PodReason    string `json:",omitempty"`
+	PodMessage   string `json:",omitempty"`
+}
+
+// KubeEvent is a warning event reported by Kubernetes for the pod of a unit.
+type KubeEvent struct {
+	Reason   string
+	Message  string
+	Count    int32
+	LastSeen time.Time
+}
+
+// String describes the termination, giving the reason of the pod failure over that of the container.
+func (t *KubeTermination) String() string {
+	reason, message := t.Reason, t.Message
+	if t.PodReason != "" {
+		reason, message = t.PodReason, t.PodMessage
+	}
+	desc := reason
+	if message != "" {
+		desc = fmt.Sprintf("%s: %s", reason, message)
+	}
+	if t.ExitCode >= 0 {
+		desc = fmt.Sprintf("%s, exit code %d", desc, t.ExitCode)
+	}
+	if t.RestartCount > 0 {
+		desc = fmt.Sprintf("%s, restarted %d times", desc, t.RestartCount)
+	}
+
+	return desc
+}
+
+// podTermination returns why the worker container of a pod stopped or cannot start and why the pod failed, or
+// nil if neither has happened.
+func podTermination(pod *corev1.Pod) *KubeTermination {
+	var term *KubeTermination
+	for _, cstat := range pod.Status.ContainerStatuses {
+		if cstat.Name != "worker" {
+			continue
+		}
+		switch {
+		case cstat.State.Terminated != nil:
+			term = &KubeTermination{
+				Reason:   cstat.State.Terminated.Reason,
+				Message:  cstat.State.Terminated.Message,
+				ExitCode: int(cstat.State.Terminated.ExitCode),
+			}
+		case cstat.State.Waiting != nil && cstat.State.Waiting.Reason != "" && cstat.State.Waiting.Reason != "ContainerCreating":
+			term = &KubeTermination{
+				Reason:   cstat.State.Waiting.Reason,
+				Message:  cstat.State.Waiting.Message,
+				ExitCode: -1,
+			}
+		case cstat.LastTerminationState.Terminated != nil:
+			term = &KubeTermination{
+				Reason:   cstat.LastTerminationState.Terminated.Reason,
+				Message:  cstat.LastTerminationState.Terminated.Message,
+				ExitCode: int(cstat.LastTerminationState.Terminated.ExitCode),
+			}
+		}
+		if term != nil {
+			term.RestartCount = cstat.RestartCount
+		}
+
+		break
+	}
+	if pod.Status.Phase == corev1.PodFailed && pod.Status.Reason != "" {
+		if term == nil {
+			term = &KubeTermination{ExitCode: -1}
+		}
+		term.PodReason = pod.Status.Reason
+		term.PodMessage = pod.Status.Message
+	}
+
+	return term
+}
+
+// warningEvents returns the most recent warning events in a list, oldest first.
+func warningEvents(list *corev1.EventList) []KubeEvent {
+	events := make([]KubeEvent, 0)
+	for i := range list.Items {
+		ev := &list.Items[i]
+		if ev.Type != corev1.EventTypeWarning {
+			continue
+		}
+		lastSeen := ev.LastTimestamp.Time
+		if lastSeen.IsZero() {
+			lastSeen = ev.EventTime.Time
+		}
+		count := ev.Count
+		if count == 0 {
+			count = 1
+		}
+		events = append(events, KubeEvent{
+			Reason:   ev.Reason,
+			Message:  ev.Message,
+			Count:    count,
+			LastSeen: lastSeen,
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastSeen.Before(events[j].LastSeen)
+	})
+	if len(events) > kubeEventsMax {
+		events = events[len(events)-kubeEventsMax:]
+	}
+
+	return events
+}
+
+// recordPodDiagnostics records in the status of the unit why the worker container of a pod stopped or cannot
+// start, and the warning events reported for the pod.  It returns the termination, or nil if there is none.
+func (kw *KubeUnit) recordPodDiagnostics(pod *corev1.Pod) *KubeTermination {
+	term := podTermination(pod)
+	var events []KubeEvent
+	list, err := KubeAPIWrapperInstance.ListEvents(kw.GetContext(), kw.clientset, pod.Namespace, metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=Pod,involvedObject.name=" + pod.Name,
+	})
+	if err != nil {
+		kw.GetWorkceptor().nc.GetLogger().Warning("Could not list events of pod %s/%s: %s", pod.Namespace, pod.Name, err)
+	} else if list != nil {
+		events = warningEvents(list)
+	}
+	if term == nil && len(events) == 0 {
+		return nil
+	}
+	kw.UpdateFullStatus(func(status *StatusFileData) {
+		ked := status.ExtraData.(*KubeExtraData)
+		if term != nil {
+			ked.Termination = term
+		}
+		if len(events) > 0 {
+			ked.Events = events
+		}
+	})
+
+	return term
+}
+
+// finishedDetail returns the detail of a unit whose pod has stopped.  The unit succeeds once its output has been
+// streamed, as it always has, but the detail tells if the pod failed, for example because it was evicted, or if the
+// worker container was killed or exited with an error.
+func finishedDetail(term *KubeTermination) string {
+	switch {
+	case term == nil:
+	case term.PodReason != "":
+		return fmt.Sprintf("Finished, pod failed: %s", term)
+	case term.ExitCode != 0 || (term.Reason != "" && term.Reason != "Completed"):
+		return fmt.Sprintf("Finished, worker container failed: %s", term)
+	}
+
+	return "Finished"
+}
@@ -0,0 +1,164 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodTermination(t *testing.T) {
+	tests := []struct {
+		name       string
+		pod        corev1.Pod
+		want       string
+		wantDetail string
+	}{
+		{
+			name: "running",
+			pod: corev1.Pod{Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "worker",
+					State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+				}},
+			}},
+		},
+		{
+			name: "OOM killed",
+			pod: corev1.Pod{Status: corev1.PodStatus{
+				Phase: corev1.PodFailed,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "worker",
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+				}},
+			}},
+			want:       "OOMKilled, exit code 137",
+			wantDetail: "Finished, worker container failed: OOMKilled, exit code 137",
+		},
+		{
+			name: "completed",
+			pod: corev1.Pod{Status: corev1.PodStatus{
+				Phase: corev1.PodSucceeded,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "worker",
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed"}},
+				}},
+			}},
+			want:       "Completed, exit code 0",
+			wantDetail: "Finished",
+		},
+		{
+			name: "image pull back-off",
+			pod: corev1.Pod{Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "sidecar", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+					{
+						Name:  "worker",
+						State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: `Back-off pulling image "nope"`}},
+					},
+				},
+			}},
+			want:       `ImagePullBackOff: Back-off pulling image "nope"`,
+			wantDetail: `Finished, worker container failed: ImagePullBackOff: Back-off pulling image "nope"`,
+		},
+		{
+			name: "creating",
+			pod: corev1.Pod{Status: corev1.PodStatus{
+				Phase: corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "worker",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+				}},
+			}},
+		},
+		{
+			name: "restarted after being killed",
+			pod: corev1.Pod{Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:                 "worker",
+					RestartCount:         2,
+					State:                corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+					LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+				}},
+			}},
+			want:       "OOMKilled, exit code 137, restarted 2 times",
+			wantDetail: "Finished, worker container failed: OOMKilled, exit code 137, restarted 2 times",
+		},
+		{
+			name: "evicted",
+			pod: corev1.Pod{Status: corev1.PodStatus{
+				Phase:   corev1.PodFailed,
+				Reason:  "Evicted",
+				Message: "The node was low on resource: memory.",
+			}},
+			want:       "Evicted: The node was low on resource: memory.",
+			wantDetail: "Finished, pod failed: Evicted: The node was low on resource: memory.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := podTermination(&tt.pod)
+			if tt.want == "" {
+				if term != nil {
+					t.Errorf("expected no termination, got %s", term)
+				}
+
+				return
+			}
+			if term == nil {
+				t.Fatalf("expected termination %s, got none", tt.want)
+			}
+			if term.String() != tt.want {
+				t.Errorf("expected termination %s, got %s", tt.want, term)
+			}
+			if detail := finishedDetail(term); detail != tt.wantDetail {
+				t.Errorf("expected detail %s, got %s", tt.wantDetail, detail)
+			}
+		})
+	}
+}
+
+func TestWarningEvents(t *testing.T) {
+	now := time.Now()
+	list := &corev1.EventList{}
+	for i := 0; i < kubeEventsMax+2; i++ {
+		list.Items = append(list.Items, corev1.Event{
+			Type:          corev1.EventTypeWarning,
+			Reason:        "BackOff",
+			Message:       "Back-off restarting failed container",
+			LastTimestamp: metav1.NewTime(now.Add(time.Duration(kubeEventsMax+2-i) * time.Second)),
+			Count:         int32(i),
+		})
+	}
+	list.Items = append(list.Items, corev1.Event{
+		Type:      corev1.EventTypeNormal,
+		Reason:    "Pulled",
+		EventTime: metav1.NewMicroTime(now.Add(time.Minute)),
+	}, corev1.Event{
+		Type:      corev1.EventTypeWarning,
+		Reason:    "Failed",
+		Message:   `Failed to pull image "nope"`,
+		EventTime: metav1.NewMicroTime(now.Add(time.Minute)),
+	})
+
+	events := warningEvents(list)
+	if len(events) != kubeEventsMax {
+		t.Fatalf("expected %d events, got %d", kubeEventsMax, len(events))
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].LastSeen.Before(events[i-1].LastSeen) {
+			t.Errorf("events are not in order: %v", events)
+		}
+	}
+	last := events[len(events)-1]
+	if last.Reason != "Failed" || last.Count != 1 {
+		t.Errorf("expected the most recent event to be the failed pull, got %v", last)
+	}
+}
@@ -0,0 +1,213 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// podPatchPath is a path in a pod that may be patched at runtime, as the segments of a JSON pointer.  A segment
+// of * matches any single segment.
+type podPatchPath []string
+
+// splitJSONPointer splits a JSON pointer into its unescaped segments.
+func splitJSONPointer(pointer string) []string {
+	segments := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i := range segments {
+		segments[i] = strings.ReplaceAll(strings.ReplaceAll(segments[i], "~1", "/"), "~0", "~")
+	}
+
+	return segments
+}
+
+// joinJSONPointer joins segments into a JSON pointer.
+func joinJSONPointer(segments []string) string {
+	escaped := make([]string, len(segments))
+	for i := range segments {
+		escaped[i] = strings.ReplaceAll(strings.ReplaceAll(segments[i], "~", "~0"), "/", "~1")
+	}
+
+	return "/" + strings.Join(escaped, "/")
+}
+
+// parsePodPatchPaths parses the JSON pointers of the pod paths that may be patched at runtime.
+func parsePodPatchPaths(pointers []string) ([]podPatchPath, error) {
+	paths := make([]podPatchPath, 0, len(pointers))
+	for _, pointer := range pointers {
+		if !strings.HasPrefix(pointer, "/") || len(pointer) < 2 {
+			return nil, fmt.Errorf("pod patch path %q must be a JSON pointer such as /spec/nodeSelector", pointer)
+		}
+		paths = append(paths, splitJSONPointer(pointer))
+	}
+
+	return paths, nil
+}
+
+// podPatchAllowed returns true if the path, or one of its parents, is in the allowed paths.
+func podPatchAllowed(allowed []podPatchPath, segments []string) bool {
+	for _, prefix := range allowed {
+		if len(segments) < len(prefix) {
+			continue
+		}
+		match := true
+		for i := range prefix {
+			if prefix[i] != "*" && prefix[i] != segments[i] {
+				match = false
+
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isJSONPatch returns true if a pod patch is a JSON patch rather than a strategic merge patch.
+func isJSONPatch(patch string) bool {
+	return strings.HasPrefix(strings.TrimSpace(patch), "[")
+}
+
+// validatePodPatch checks that a pod patch only changes the allowed paths.  A JSON patch (RFC 6902) is checked
+// by the path of each operation.  A strategic merge patch is checked by the path of each value it sets, with the
+// elements of a list identified by their name.
+func validatePodPatch(patch string, allowed []podPatchPath) error {
+	if isJSONPatch(patch) {
+		ops, err := jsonpatch.DecodePatch([]byte(patch))
+		if err != nil {
+			return fmt.Errorf("invalid JSON patch: %s", err)
+		}
+		for _, op := range ops {
+			pointers := make([]string, 0, 2)
+			pointer, err := op.Path()
+			if err != nil {
+				return fmt.Errorf("invalid JSON patch: %s", err)
+			}
+			pointers = append(pointers, pointer)
+			if op.Kind() == "move" || op.Kind() == "copy" {
+				from, err := op.From()
+				if err != nil {
+					return fmt.Errorf("invalid JSON patch: %s", err)
+				}
+				pointers = append(pointers, from)
+			}
+			for _, pointer := range pointers {
+				if !podPatchAllowed(allowed, splitJSONPointer(pointer)) {
+					return fmt.Errorf("pod patch path %s is not allowed", pointer)
+				}
+			}
+		}
+
+		return nil
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(patch), &doc); err != nil {
+		return fmt.Errorf("pod patch must be a JSON patch or a strategic merge patch: %s", err)
+	}
+
+	return validateMergePatch(doc, nil, allowed)
+}
+
+// validateMergePatch checks a value of a strategic merge patch found at the given path.
+func validateMergePatch(value interface{}, segments []string, allowed []podPatchPath) error {
+	if len(segments) > 0 && podPatchAllowed(allowed, segments) {
+		return nil
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if strings.HasPrefix(k, "$") {
+				// patch directives are only allowed within an allowed path
+				return fmt.Errorf("pod patch path %s is not allowed", joinJSONPointer(append(segments[:len(segments):len(segments)], k)))
+			}
+			if err := validateMergePatch(v[k], append(segments[:len(segments):len(segments)], k), allowed); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case []interface{}:
+		for _, elem := range v {
+			m, ok := elem.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("pod patch path %s is not allowed", joinJSONPointer(segments))
+			}
+			name, ok := m["name"].(string)
+			if !ok {
+				return fmt.Errorf("pod patch path %s is not allowed", joinJSONPointer(segments))
+			}
+			rest := make(map[string]interface{}, len(m))
+			for k := range m {
+				if k != "name" {
+					rest[k] = m[k]
+				}
+			}
+			elemSegments := append(segments[:len(segments):len(segments)], name)
+			if len(rest) == 0 {
+				// an element with only a name adds it to the list
+				return fmt.Errorf("pod patch path %s is not allowed", joinJSONPointer(elemSegments))
+			}
+			if err := validateMergePatch(rest, elemSegments, allowed); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("pod patch path %s is not allowed", joinJSONPointer(segments))
+	}
+}
+
+// applyPodPatch returns the pod with a JSON patch or strategic merge patch applied.  The patch may not add or
+// remove containers.
+func applyPodPatch(pod *corev1.Pod, patch string) (*corev1.Pod, error) {
+	original, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	var patched []byte
+	if isJSONPatch(patch) {
+		ops, err := jsonpatch.DecodePatch([]byte(patch))
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON patch: %s", err)
+		}
+		patched, err = ops.Apply(original)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply pod patch: %s", err)
+		}
+	} else {
+		patched, err = strategicpatch.StrategicMergePatch(original, []byte(patch), corev1.Pod{})
+		if err != nil {
+			return nil, fmt.Errorf("could not apply pod patch: %s", err)
+		}
+	}
+	result := &corev1.Pod{}
+	if err := json.Unmarshal(patched, result); err != nil {
+		return nil, fmt.Errorf("pod patch gave an invalid pod: %s", err)
+	}
+	if len(result.Spec.Containers) != len(pod.Spec.Containers) {
+		return nil, fmt.Errorf("pod patch may not add or remove containers")
+	}
+	for i := range pod.Spec.Containers {
+		if result.Spec.Containers[i].Name != pod.Spec.Containers[i].Name {
+			return nil, fmt.Errorf("pod patch may not add or remove containers")
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,135 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidatePodPatch(t *testing.T) {
+	allowed, err := parsePodPatchPaths([]string{
+		"/metadata/labels",
+		"/spec/nodeSelector",
+		"/spec/tolerations",
+		"/spec/containers/*/resources",
+		"/spec/containers/*/env",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		patch   string
+		wantErr bool
+	}{
+		{
+			name:  "merge patch of allowed paths",
+			patch: `{"metadata":{"labels":{"team":"a"}},"spec":{"nodeSelector":{"disk":"ssd"},"tolerations":[{"key":"gpu","operator":"Exists"}],"containers":[{"name":"worker","resources":{"limits":{"cpu":"2"}},"env":[{"name":"A","value":"1"}]}]}}`,
+		},
+		{
+			name:    "merge patch of container image",
+			patch:   `{"spec":{"containers":[{"name":"worker","image":"evil"}]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "merge patch of host network",
+			patch:   `{"spec":{"hostNetwork":true}}`,
+			wantErr: true,
+		},
+		{
+			name:    "merge patch directive",
+			patch:   `{"spec":{"$setElementOrder/containers":[{"name":"worker"}]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "merge patch adding a volume",
+			patch:   `{"spec":{"volumes":[{"name":"host"}]}}`,
+			wantErr: true,
+		},
+		{
+			name:  "JSON patch of allowed paths",
+			patch: `[{"op":"add","path":"/spec/nodeSelector","value":{"disk":"ssd"}},{"op":"replace","path":"/spec/containers/0/resources","value":{}}]`,
+		},
+		{
+			name:    "JSON patch of container image",
+			patch:   `[{"op":"replace","path":"/spec/containers/0/image","value":"evil"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "JSON patch copying from a disallowed path",
+			patch:   `[{"op":"copy","from":"/spec/serviceAccountName","path":"/metadata/labels/sa"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "not a patch",
+			patch:   `nodeSelector: ssd`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePodPatch(tt.patch, allowed)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePodPatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := parsePodPatchPaths([]string{"spec.nodeSelector"}); err == nil {
+		t.Error("expected an error for a path that is not a JSON pointer")
+	}
+}
+
+func TestApplyPodPatch(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "kube-", Labels: map[string]string{"app": "receptor"}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "worker",
+				Image: "busybox",
+				Env:   []corev1.EnvVar{{Name: "A", Value: "1"}},
+			}},
+			RestartPolicy: corev1.RestartPolicyNever,
+		},
+	}
+
+	patched, err := applyPodPatch(pod, `{"metadata":{"labels":{"team":"a"}},"spec":{"nodeSelector":{"disk":"ssd"},"containers":[{"name":"worker","resources":{"limits":{"memory":"1Gi"}},"env":[{"name":"B","value":"2"}]}]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Labels["app"] != "receptor" || patched.Labels["team"] != "a" {
+		t.Errorf("unexpected labels %v", patched.Labels)
+	}
+	if patched.Spec.NodeSelector["disk"] != "ssd" {
+		t.Errorf("unexpected node selector %v", patched.Spec.NodeSelector)
+	}
+	worker := patched.Spec.Containers[0]
+	if worker.Image != "busybox" || len(worker.Env) != 2 {
+		t.Errorf("expected the env of the worker container to be merged, got %v", worker)
+	}
+	if !worker.Resources.Limits.Memory().Equal(resource.MustParse("1Gi")) {
+		t.Errorf("unexpected resources %v", worker.Resources)
+	}
+
+	patched, err = applyPodPatch(pod, `[{"op":"add","path":"/spec/tolerations","value":[{"key":"gpu","operator":"Exists"}]}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patched.Spec.Tolerations) != 1 || patched.Spec.Tolerations[0].Key != "gpu" {
+		t.Errorf("unexpected tolerations %v", patched.Spec.Tolerations)
+	}
+
+	_, err = applyPodPatch(pod, `{"spec":{"containers":[{"name":"sidecar","image":"evil"}]}}`)
+	if err == nil {
+		t.Error("expected an error for a patch adding a container")
+	}
+	_, err = applyPodPatch(pod, `[{"op":"remove","path":"/spec/nodeSelector"}]`)
+	if err == nil {
+		t.Error("expected an error for a JSON patch removing a missing path")
+	}
+}
@@ -0,0 +1,403 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Labels identifying the node, work type and unit that created a pod or Job.  Label values are limited in length and
+// character set, so the node and work type are also recorded unaltered in annotations of the same name.
+const (
+	kubeNodeLabel     = "receptor.ansible.com/node"
+	kubeWorkTypeLabel = "receptor.ansible.com/worktype"
+	kubeUnitLabel     = "receptor.ansible.com/unit"
+)
+
+// kubeOrphanActions are what can be done with a pod whose unit no longer exists.
+var kubeOrphanActions = []string{"ignore", "delete", "adopt"}
+
+var kubeLabelInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// kubeLabelValue turns a string into a valid label value.
+func kubeLabelValue(s string) string {
+	v := kubeLabelInvalidChars.ReplaceAllString(s, "_")
+	if len(v) > 63 {
+		v = v[:63]
+	}
+
+	return strings.Trim(v, "_.-")
+}
+
+// labelPod records the node, work type and unit of a pod in its labels and annotations, so that the pod can be
+// found again if its unit is lost.
+func (kw *KubeUnit) labelPod(pod *corev1.Pod) {
+	nodeID := kw.GetWorkceptor().nodeID
+	workType := kw.Status().WorkType
+	if pod.Labels == nil {
+		pod.Labels = make(map[string]string)
+	}
+	pod.Labels[kubeNodeLabel] = kubeLabelValue(nodeID)
+	pod.Labels[kubeWorkTypeLabel] = kubeLabelValue(workType)
+	pod.Labels[kubeUnitLabel] = kubeLabelValue(kw.ID())
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[kubeNodeLabel] = nodeID
+	pod.Annotations[kubeWorkTypeLabel] = workType
+}
+
+// kubeReconciler finds the pods created by units of a work type on this node whose unit directory no longer exists.
+type kubeReconciler struct {
+	w        *Workceptor
+	workType string
+	action   string
+	clusters []*kubeCluster
+}
+
+// newKubeReconciler returns the reconciler of a work type.  A work type without clusters reconciles the pods of the
+// cluster and namespace it is configured with.
+func (cfg KubeWorkerCfg) newKubeReconciler(w *Workceptor) (*kubeReconciler, error) {
+	authMethod := strings.ToLower(cfg.AuthMethod)
+	clusters, err := parseKubeClusters(cfg.Clusters, authMethod, cfg.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(clusters) == 0 {
+		clusters = []*kubeCluster{{
+			authMethod: authMethod,
+			kubeConfig: cfg.KubeConfig,
+			namespace:  cfg.Namespace,
+		}}
+	}
+
+	return &kubeReconciler{
+		w:        w,
+		workType: cfg.WorkType,
+		action:   strings.ToLower(cfg.OrphanPods),
+		clusters: clusters,
+	}, nil
+}
+
+// connect returns a clientset for a cluster and the namespace to look for pods in.
+func (kr *kubeReconciler) connect(c *kubeCluster) (*kubernetes.Clientset, string, error) {
+	var config *rest.Config
+	var err error
+	namespace := c.namespace
+	switch {
+	case c.authMethod == "incluster":
+		config, err = KubeAPIWrapperInstance.InClusterConfig()
+	case c.kubeConfig == "":
+		clr := KubeAPIWrapperInstance.NewDefaultClientConfigLoadingRules()
+		config, err = KubeAPIWrapperInstance.BuildConfigFromFlags("", clr.GetDefaultFilename())
+		if err == nil && namespace == "" {
+			kc, err := clr.Load()
+			if err != nil {
+				return nil, "", err
+			}
+			curContext, ok := kc.Contexts[kc.CurrentContext]
+			if !ok || curContext == nil {
+				return nil, "", fmt.Errorf("could not determine namespace")
+			}
+			namespace = curContext.Namespace
+		}
+	default:
+		kubeConfig, err := readFileToString(c.kubeConfig)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not read kubeconfig: %s", err)
+		}
+		cc, err := KubeAPIWrapperInstance.NewClientConfigFromBytes([]byte(kubeConfig))
+		if err != nil {
+			return nil, "", err
+		}
+		if namespace == "" {
+			namespace, _, err = cc.Namespace()
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		config, err = cc.ClientConfig()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	clientset, err := KubeAPIWrapperInstance.NewForConfig(config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return clientset, namespace, nil
+}
+
+// kubeOrphan is a pod, or the Job owning it, whose unit no longer exists.
+type kubeOrphan struct {
+	pod *corev1.Pod
+	job *batchv1.Job
+}
+
+// describe returns the kind and name of an orphan.
+func (o kubeOrphan) describe() string {
+	if o.job != nil {
+		return fmt.Sprintf("job %s/%s", o.job.Namespace, o.job.Name)
+	}
+
+	return fmt.Sprintf("pod %s/%s", o.pod.Namespace, o.pod.Name)
+}
+
+// findOrphans lists the pods of the work type in a namespace whose unit directory does not exist.  The pods of a Job
+// are reported once, through their Job.
+func (kr *kubeReconciler) findOrphans(ctx context.Context, clientset *kubernetes.Clientset, namespace string) ([]kubeOrphan, error) {
+	nodeID := kr.w.nodeID
+	selector := fmt.Sprintf("%s=%s,%s=%s", kubeNodeLabel, kubeLabelValue(nodeID), kubeWorkTypeLabel, kubeLabelValue(kr.workType))
+	pods, err := KubeAPIWrapperInstance.List(ctx, clientset, namespace, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	orphans := make([]kubeOrphan, 0)
+	seenJobs := make(map[string]bool)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || pod.Annotations[kubeNodeLabel] != nodeID ||
+			pod.Annotations[kubeWorkTypeLabel] != kr.workType {
+			continue
+		}
+		unitID := pod.Labels[kubeUnitLabel]
+		if unitID == "" || kr.w.unitDirExists(unitID) {
+			continue
+		}
+		jobName := pod.Labels["job-name"]
+		if jobName == "" {
+			orphans = append(orphans, kubeOrphan{pod: pod})
+
+			continue
+		}
+		if seenJobs[jobName] {
+			continue
+		}
+		seenJobs[jobName] = true
+		job, err := KubeAPIWrapperInstance.GetJob(ctx, clientset, pod.Namespace, jobName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if job.DeletionTimestamp != nil || kr.w.unitDirExists(job.Labels[kubeUnitLabel]) {
+			continue
+		}
+		orphans = append(orphans, kubeOrphan{pod: pod, job: job})
+	}
+
+	return orphans, nil
+}
+
+// deleteOrphan deletes an orphaned pod, or its Job together with the pods of the Job.
+func (kr *kubeReconciler) deleteOrphan(ctx context.Context, clientset *kubernetes.Clientset, o kubeOrphan) error {
+	if o.job != nil {
+		propagation := metav1.DeletePropagationBackground
+
+		return KubeAPIWrapperInstance.DeleteJob(ctx, clientset, o.job.Namespace, o.job.Name, metav1.DeleteOptions{
+			PropagationPolicy: &propagation,
+		})
+	}
+
+	return KubeAPIWrapperInstance.Delete(ctx, clientset, o.pod.Namespace, o.pod.Name, metav1.DeleteOptions{})
+}
+
+// adoptOrphan creates a unit monitoring an orphaned pod and relabels the pod, and its Job, with the new unit.
+func (kr *kubeReconciler) adoptOrphan(ctx context.Context, clientset *kubernetes.Clientset, c *kubeCluster, o kubeOrphan) (string, error) {
+	kubeConfig, err := readFileToString(c.kubeConfig)
+	if err != nil {
+		return "", fmt.Errorf("could not read kubeconfig: %s", err)
+	}
+	worker, err := kr.w.AllocateUnit(kr.workType, map[string]string{})
+	if err != nil {
+		return "", err
+	}
+	worker.UpdateFullStatus(func(status *StatusFileData) {
+		status.State = WorkStateRunning
+		status.Detail = fmt.Sprintf("Adopted orphaned %s", o.describe())
+		ked := status.ExtraData.(*KubeExtraData)
+		ked.PodName = o.pod.Name
+		ked.KubeNamespace = o.pod.Namespace
+		if o.job != nil {
+			ked.JobName = o.job.Name
+		}
+		if c.name != "" {
+			ked.Cluster = c.name
+			ked.KubeConfig = kubeConfig
+		}
+	})
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{kubeUnitLabel: kubeLabelValue(worker.ID())},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if o.job != nil {
+		_, err = KubeAPIWrapperInstance.PatchJob(ctx, clientset, o.job.Namespace, o.job.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return "", err
+		}
+	}
+	_, err = KubeAPIWrapperInstance.Patch(ctx, clientset, o.pod.Namespace, o.pod.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return "", err
+	}
+	if err := worker.Restart(); err != nil {
+		return "", err
+	}
+
+	return worker.ID(), nil
+}
+
+// reconcile deletes or adopts the orphaned pods of the work type on every cluster.  With dryRun, it only reports
+// what it would do.
+func (kr *kubeReconciler) reconcile(dryRun bool) ([]string, error) {
+	ctx := kr.w.ctx
+	actions := make([]string, 0)
+	failures := make([]string, 0)
+	for _, c := range kr.clusters {
+		where := ""
+		if c.name != "" {
+			where = fmt.Sprintf(" on cluster %s", c.name)
+		}
+		clientset, namespace, err := kr.connect(c)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("could not connect%s: %s", where, err))
+
+			continue
+		}
+		orphans, err := kr.findOrphans(ctx, clientset, namespace)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("could not list pods%s: %s", where, err))
+
+			continue
+		}
+		for _, o := range orphans {
+			// a pending pod still waits for the stdin of its lost unit, so it cannot be adopted
+			adopt := kr.action == "adopt" && o.pod.Status.Phase != corev1.PodPending
+			switch {
+			case dryRun && adopt:
+				actions = append(actions, fmt.Sprintf("would adopt %s%s", o.describe(), where))
+			case dryRun:
+				actions = append(actions, fmt.Sprintf("would delete %s%s", o.describe(), where))
+			case adopt:
+				unitID, err := kr.adoptOrphan(ctx, clientset, c, o)
+				if err != nil {
+					failures = append(failures, fmt.Sprintf("could not adopt %s%s: %s", o.describe(), where, err))
+
+					continue
+				}
+				actions = append(actions, fmt.Sprintf("adopted %s%s as unit %s", o.describe(), where, unitID))
+			default:
+				if err := kr.deleteOrphan(ctx, clientset, o); err != nil {
+					failures = append(failures, fmt.Sprintf("could not delete %s%s: %s", o.describe(), where, err))
+
+					continue
+				}
+				actions = append(actions, fmt.Sprintf("deleted %s%s", o.describe(), where))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return actions, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+
+	return actions, nil
+}
+
+// run reconciles orphaned pods on startup, then at every interval until the Workceptor is shut down.
+func (kr *kubeReconciler) run(interval time.Duration, dryRun bool) {
+	logger := kr.w.nc.GetLogger()
+	for {
+		actions, err := kr.reconcile(dryRun)
+		for _, action := range actions {
+			logger.Info("Orphaned pods of work type %s: %s", kr.workType, action)
+		}
+		if err != nil {
+			logger.Warning("Error reconciling orphaned pods of work type %s: %s", kr.workType, err)
+		}
+		if interval <= 0 || sleepOrDone(kr.w.ctx.Done(), interval) {
+			return
+		}
+	}
+}
+
+// validateOrphanPods checks the orphaned pod settings of a work type.
+func (cfg KubeWorkerCfg) validateOrphanPods() error {
+	action := strings.ToLower(cfg.OrphanPods)
+	valid := action == ""
+	for _, a := range kubeOrphanActions {
+		if a == action {
+			valid = true
+
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("orphanpods must be one of %s", strings.Join(kubeOrphanActions, ", "))
+	}
+	if cfg.ReconcileInterval != "" {
+		interval, err := time.ParseDuration(cfg.ReconcileInterval)
+		if err != nil {
+			return fmt.Errorf("invalid reconcileinterval: %s", err)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("reconcileinterval must be positive")
+		}
+	}
+	if action == "" || action == "ignore" {
+		return nil
+	}
+	if cfg.AllowRuntimeAuth || strings.ToLower(cfg.AuthMethod) == "runtime" {
+		return fmt.Errorf("orphanpods cannot be used with allowruntimeauth or authmethod=runtime")
+	}
+	if action == "adopt" && strings.ToLower(cfg.StreamMethod) == "tcp" {
+		return fmt.Errorf("orphanpods adopt is not supported with stream mode tcp")
+	}
+
+	return nil
+}
+
+// startOrphanReconciler registers the orphan reconciler of a work type and runs it in the background.
+func (cfg KubeWorkerCfg) startOrphanReconciler(w *Workceptor) error {
+	var interval time.Duration
+	if cfg.ReconcileInterval != "" {
+		var err error
+		interval, err = time.ParseDuration(cfg.ReconcileInterval)
+		if err != nil {
+			return err
+		}
+	}
+	kr, err := cfg.newKubeReconciler(w)
+	if err != nil {
+		return err
+	}
+	if KubeAPIWrapperInstance == nil {
+		// no unit has been created yet
+		KubeAPIWrapperInstance = KubeAPIWrapper{}
+	}
+	err = w.SetOrphanReconciler(cfg.WorkType, kr.reconcile)
+	if err != nil {
+		return err
+	}
+	go kr.run(interval, cfg.OrphanDryRun)
+
+	return nil
+}
@@ -0,0 +1,49 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKubeLabelValue(t *testing.T) {
+	tests := map[string]string{
+		"node1":                 "node1",
+		"node 1/east":           "node_1_east",
+		"-node.":                "node",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+		"café":                  "caf",
+	}
+	for in, want := range tests {
+		if got := kubeLabelValue(in); got != want {
+			t.Errorf("kubeLabelValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestValidateOrphanPods(t *testing.T) {
+	valid := []KubeWorkerCfg{
+		{OrphanPods: "ignore", AllowRuntimeAuth: true},
+		{OrphanPods: "delete", ReconcileInterval: "1h"},
+		{OrphanPods: "adopt", StreamMethod: "logger"},
+	}
+	for _, cfg := range valid {
+		if err := cfg.validateOrphanPods(); err != nil {
+			t.Errorf("%+v: unexpected error %s", cfg, err)
+		}
+	}
+	invalid := []KubeWorkerCfg{
+		{OrphanPods: "keep"},
+		{OrphanPods: "delete", ReconcileInterval: "soon"},
+		{OrphanPods: "delete", ReconcileInterval: "-1h"},
+		{OrphanPods: "delete", AuthMethod: "runtime"},
+		{OrphanPods: "adopt", StreamMethod: "tcp"},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.validateOrphanPods(); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
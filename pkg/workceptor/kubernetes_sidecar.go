@@ -0,0 +1,293 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// kubeStreamContainer is the name of the sidecar container serving the output of the worker container.
+const kubeStreamContainer = "receptor-stream"
+
+// kubeStreamDir is where the volume shared by the worker container and the stream sidecar is mounted.
+const kubeStreamDir = "/receptor/stream"
+
+// KubeStreamDirEnv is the environment variable giving the worker container and the stream sidecar the directory of
+// their shared volume.
+const KubeStreamDirEnv = "RECEPTOR_STREAM_DIR"
+
+// pinnedImageRegex matches image references pinned to a digest.
+var pinnedImageRegex = regexp.MustCompile(`^[^\s@]+@sha256:[0-9a-f]{64}$`)
+
+// checkPinnedImage returns an error unless an image reference is pinned to a digest, so that the image run next to
+// every worker cannot be changed by pushing a new tag.
+func checkPinnedImage(image string) error {
+	if !pinnedImageRegex.MatchString(image) {
+		return fmt.Errorf("image %s must be pinned to a digest, as in name@sha256:<digest>", image)
+	}
+
+	return nil
+}
+
+// The stream sidecar reads lines from its stdin, which Receptor attaches to:
+//
+//   - an offset asks for the output of the worker from that byte offset, which the sidecar records in the offset
+//     file of the shared volume, following the output as it is written;
+//   - final tells it that the worker container has stopped, so once it has written all of the output it writes
+//     "end <size>" to its stderr;
+//   - done tells it that Receptor has received all of the output, so it exits.
+const (
+	kubeStreamFinal = "final"
+	kubeStreamDone  = "done"
+	kubeStreamEnd   = "end"
+)
+
+// offsetWriter counts the bytes of output received from the stream sidecar.
+type offsetWriter struct {
+	w      io.Writer
+	lock   sync.Mutex
+	offset int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.Write(p)
+	ow.lock.Lock()
+	ow.offset += int64(n)
+	ow.lock.Unlock()
+
+	return n, err
+}
+
+// Offset returns the number of bytes received so far.
+func (ow *offsetWriter) Offset() int64 {
+	ow.lock.Lock()
+	defer ow.lock.Unlock()
+
+	return ow.offset
+}
+
+// streamEndWriter reads the stderr of the stream sidecar, and sends the size of the output once the sidecar reports
+// that it has written all of it.
+type streamEndWriter struct {
+	buf  []byte
+	ends chan int64
+}
+
+func (ew *streamEndWriter) Write(p []byte) (int, error) {
+	ew.buf = append(ew.buf, p...)
+	for {
+		i := strings.IndexByte(string(ew.buf), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := strings.Fields(string(ew.buf[:i]))
+		ew.buf = ew.buf[i+1:]
+		if len(line) == 2 && line[0] == kubeStreamEnd {
+			size, err := strconv.ParseInt(line[1], 10, 64)
+			if err == nil {
+				select {
+				case ew.ends <- size:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// addStreamSidecar adds the stream sidecar to a pod, with a volume it shares with the worker container.  The worker
+// container is otherwise left as it is, so its output still goes to the pod's log.
+func addStreamSidecar(pod *corev1.Pod, image string) error {
+	var worker *corev1.Container
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == "worker" {
+			worker = &pod.Spec.Containers[i]
+
+			break
+		}
+	}
+	if worker == nil {
+		return fmt.Errorf("at least one container must be named worker")
+	}
+	mount := corev1.VolumeMount{Name: kubeStreamContainer, MountPath: kubeStreamDir}
+	env := corev1.EnvVar{Name: KubeStreamDirEnv, Value: kubeStreamDir}
+	worker.VolumeMounts = append(worker.VolumeMounts, mount)
+	worker.Env = append(worker.Env, env)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         kubeStreamContainer,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:         kubeStreamContainer,
+		Image:        image,
+		Stdin:        true,
+		Env:          []corev1.EnvVar{env},
+		VolumeMounts: []corev1.VolumeMount{mount},
+	})
+
+	return nil
+}
+
+// sidecarExited returns true if the stream sidecar of the pod has exited successfully.
+func (kw *KubeUnit) sidecarExited() bool {
+	pod, err := KubeAPIWrapperInstance.Get(kw.GetContext(), kw.clientset, kw.pod.Namespace, kw.pod.Name, metav1.GetOptions{})
+	if err != nil {
+		return false
+	}
+	for _, cstat := range pod.Status.ContainerStatuses {
+		if cstat.Name == kubeStreamContainer {
+			return cstat.State.Terminated != nil && cstat.State.Terminated.ExitCode == 0
+		}
+	}
+
+	return false
+}
+
+// attachToSidecar attaches to the stdio of the stream sidecar of the pod.
+func (kw *KubeUnit) attachToSidecar(ctx context.Context, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	req := KubeAPIWrapperInstance.SubResource(kw.clientset, kw.pod.Name, kw.pod.Namespace)
+	req.VersionedParams(
+		&corev1.PodAttachOptions{
+			Container: kubeStreamContainer,
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		},
+		scheme.ParameterCodec,
+	)
+	exec, err := KubeAPIWrapperInstance.NewSPDYExecutor(kw.config, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	return KubeAPIWrapperInstance.StreamWithContext(ctx, exec, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
+
+// driveSidecar sends the stream sidecar the offset to read from, tells it when the worker container has stopped, and
+// once all of the output has been received, tells it to exit.  It returns true if it did.
+func (kw *KubeUnit) driveSidecar(ctx context.Context, stdin io.Writer, offset int64, received *offsetWriter,
+	ends chan int64,
+) bool {
+	if _, err := fmt.Fprintf(stdin, "%d\n", offset); err != nil {
+		return false
+	}
+	for interval := time.Duration(0); ; interval = 5 * time.Second {
+		if sleepOrDone(ctx.Done(), interval) {
+			return false
+		}
+		pod, err := KubeAPIWrapperInstance.Get(ctx, kw.clientset, kw.pod.Namespace, kw.pod.Name, metav1.GetOptions{})
+		if err == nil && (workerTerminatedState(pod) != nil || pod.Status.Phase == corev1.PodFailed) {
+			break
+		}
+	}
+	if _, err := fmt.Fprintln(stdin, kubeStreamFinal); err != nil {
+		return false
+	}
+	var size int64
+	select {
+	case <-ctx.Done():
+		return false
+	case size = <-ends:
+	}
+	for received.Offset() < size {
+		if sleepOrDone(ctx.Done(), 100*time.Millisecond) {
+			return false
+		}
+	}
+	_, err := fmt.Fprintln(stdin, kubeStreamDone)
+
+	return err == nil
+}
+
+// kubeStreamFromSidecar copies the output of the worker container to stdout, reading it from the stream sidecar
+// starting at the offset already stored, so that a dropped connection or a restart of Receptor neither loses nor
+// repeats output.
+func (kw *KubeUnit) kubeStreamFromSidecar(streamWait *sync.WaitGroup, stdout *STDoutWriter, stdinErr *error, stdoutErr *error) {
+	defer streamWait.Done()
+	podNamespace := kw.pod.Namespace
+	podName := kw.pod.Name
+	received := &offsetWriter{w: stdout, offset: stdout.Size()}
+	remainingRetries := 5 // resets on each successful read from the sidecar
+	for {
+		if *stdinErr != nil {
+			// fail to send stdin to pod, no need to continue
+			return
+		}
+		if kw.sidecarExited() {
+			// the sidecar only exits once Receptor has received all of the output, as before a restart
+			return
+		}
+		offset := received.Offset()
+		ctx, cancel := context.WithCancel(kw.GetContext())
+		stdinReader, stdinWriter := io.Pipe()
+		ends := &streamEndWriter{ends: make(chan int64, 1)}
+		doneChan := make(chan bool, 1)
+		go func() {
+			done := kw.driveSidecar(ctx, stdinWriter, offset, received, ends.ends)
+			doneChan <- done
+			if done {
+				// the sidecar exits once it has read done, which ends the attach
+				sleepOrDone(ctx.Done(), 10*time.Second)
+				cancel()
+
+				return
+			}
+			stdinWriter.Close()
+		}()
+		err := kw.attachToSidecar(ctx, stdinReader, received, ends)
+		cancel()
+		stdinReader.Close()
+		if <-doneChan {
+			return
+		}
+		if kw.GetContext().Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("the stream sidecar ended before all of the output was received")
+		}
+		if received.Offset() > offset {
+			remainingRetries = 5
+		} else {
+			remainingRetries--
+		}
+		if remainingRetries == 0 {
+			*stdoutErr = err
+			kw.GetWorkceptor().nc.GetLogger().Error(
+				"Error reading output of pod %s/%s from the stream sidecar. Error: %s",
+				podNamespace,
+				podName,
+				err,
+			)
+
+			return
+		}
+		kw.GetWorkceptor().nc.GetLogger().Warning(
+			"Error reading output of pod %s/%s from offset %d. Will retry %d more times. Error: %s",
+			podNamespace,
+			podName,
+			received.Offset(),
+			remainingRetries,
+			err,
+		)
+		if sleepOrDone(kw.GetContext().Done(), time.Second) {
+			return
+		}
+	}
+}
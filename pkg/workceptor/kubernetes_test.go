@@ -1,9 +1,16 @@
 package workceptor_test
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -13,11 +20,13 @@ import (
 	"github.com/ansible/receptor/pkg/workceptor"
 	"github.com/ansible/receptor/pkg/workceptor/mock_workceptor"
 	"github.com/golang/mock/gomock"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -190,6 +199,7 @@ func (e *ex) StreamWithContext(_ context.Context, _ remotecommand.StreamOptions)
 
 func TestKubeStart(t *testing.T) {
 	ku, mockbwu, mockNet, w, mockKubeAPI, ctx := createKubernetesTestSetup(t)
+	finished := make(chan bool, 1)
 
 	startTestCases := []struct {
 		name          string
@@ -198,10 +208,19 @@ func TestKubeStart(t *testing.T) {
 		{
 			name: "test1",
 			expectedCalls: func() {
-				mockbwu.EXPECT().UpdateBasicStatus(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+				mockbwu.EXPECT().UpdateBasicStatus(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+					func(state int, _ string, _ int64) {
+						if workceptor.IsComplete(state) {
+							select {
+							case finished <- true:
+							default:
+							}
+						}
+					}).AnyTimes()
 				config := rest.Config{}
 				mockKubeAPI.EXPECT().InClusterConfig().Return(&config, nil)
 				mockbwu.EXPECT().GetWorkceptor().Return(w).AnyTimes()
+				mockbwu.EXPECT().ID().Return("unit").AnyTimes()
 				logger := logger.NewReceptorLogger("")
 				mockNet.EXPECT().GetLogger().Return(logger).AnyTimes()
 				clientset := kubernetes.Clientset{}
@@ -245,7 +264,748 @@ func TestKubeStart(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
+			// wait for the unit to stop, so that it does not call the mocks of later tests
+			select {
+			case <-finished:
+			case <-time.After(10 * time.Second):
+				t.Error("timed out waiting for the unit to finish")
+			}
+		})
+	}
+}
+
+func TestKubeStartJob(t *testing.T) {
+	_, mockbwu, mockNet, w, mockKubeAPI, ctx := createKubernetesTestSetup(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mockbwu.EXPECT().Init(w, "", "", workceptor.FileSystem{}, nil)
+	kubeConfig := workceptor.KubeWorkerCfg{
+		AuthMethod:              "incluster",
+		Namespace:               "default",
+		Image:                   "busybox",
+		Job:                     true,
+		BackoffLimit:            3,
+		ActiveDeadlineSeconds:   600,
+		TTLSecondsAfterFinished: 60,
+	}
+	ku := kubeConfig.NewkubeWorker(mockbwu, w, "", "", mockKubeAPI)
+
+	mockbwu.EXPECT().UpdateBasicStatus(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	config := rest.Config{}
+	mockKubeAPI.EXPECT().InClusterConfig().Return(&config, nil)
+	mockbwu.EXPECT().GetWorkceptor().Return(w).AnyTimes()
+	mockbwu.EXPECT().ID().Return("unit").AnyTimes()
+	logger := logger.NewReceptorLogger("")
+	mockNet.EXPECT().GetLogger().Return(logger).AnyTimes()
+	clientset := kubernetes.Clientset{}
+	mockKubeAPI.EXPECT().NewForConfig(gomock.Any()).Return(&clientset, nil)
+	mockbwu.EXPECT().MonitorLocalStatus().AnyTimes()
+	lock := &sync.RWMutex{}
+	mockbwu.EXPECT().GetStatusLock().Return(lock).AnyTimes()
+	kubeExtraData := workceptor.KubeExtraData{Image: "busybox", KubeNamespace: "default"}
+	status := workceptor.StatusFileData{ExtraData: &kubeExtraData}
+	mockbwu.EXPECT().GetStatusWithoutExtraData().Return(&status).AnyTimes()
+	mockbwu.EXPECT().GetStatusCopy().Return(status).AnyTimes()
+	mockbwu.EXPECT().GetContext().Return(ctx).AnyTimes()
+	mockbwu.EXPECT().UpdateFullStatus(gomock.Any()).AnyTimes()
+	mockbwu.EXPECT().UnitDir().Return("TestDir").AnyTimes()
+
+	jobs := make(chan *batchv1.Job, 1)
+	mockKubeAPI.EXPECT().CreateJob(gomock.Any(), gomock.Any(), "default", gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *kubernetes.Clientset, _ string, job *batchv1.Job, _ metav1.CreateOptions) (*batchv1.Job, error) {
+			jobs <- job
+			// stop the unit before it watches for the pod of the Job, which would outlive this test
+			cancel()
+			created := job.DeepCopy()
+			created.Name = "test-job"
+
+			return created, nil
 		})
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-job-1", Namespace: "default"}}
+	mockKubeAPI.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&pod, nil).AnyTimes()
+	field := hasTerm{}
+	mockKubeAPI.EXPECT().OneTermEqualSelector(gomock.Any(), gomock.Any()).Return(&field).AnyTimes()
+	apierr := apierrors.StatusError{}
+	mockKubeAPI.EXPECT().NewNotFound(gomock.Any(), gomock.Any()).Return(&apierr).AnyTimes()
+	ev := watch.Event{Object: &pod}
+	mockKubeAPI.EXPECT().UntilWithSync(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&ev, nil).AnyTimes()
+	c := rest.RESTClient{}
+	req := rest.NewRequest(&c)
+	mockKubeAPI.EXPECT().SubResource(gomock.Any(), gomock.Any(), gomock.Any()).Return(req).AnyTimes()
+	exec := ex{}
+	mockKubeAPI.EXPECT().NewSPDYExecutor(gomock.Any(), gomock.Any(), gomock.Any()).Return(&exec, nil).AnyTimes()
+
+	err := ku.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var job *batchv1.Job
+	select {
+	case job = <-jobs:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the Job to be created")
+	}
+	if job.Spec.BackoffLimit == nil || *job.Spec.BackoffLimit != 3 {
+		t.Errorf("expected backoffLimit 3, got %v", job.Spec.BackoffLimit)
+	}
+	if job.Spec.ActiveDeadlineSeconds == nil || *job.Spec.ActiveDeadlineSeconds != 600 {
+		t.Errorf("expected activeDeadlineSeconds 600, got %v", job.Spec.ActiveDeadlineSeconds)
+	}
+	if job.Spec.TTLSecondsAfterFinished == nil || *job.Spec.TTLSecondsAfterFinished != 60 {
+		t.Errorf("expected ttlSecondsAfterFinished 60, got %v", job.Spec.TTLSecondsAfterFinished)
+	}
+	spec := job.Spec.Template.Spec
+	if spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("expected restart policy Never, got %s", spec.RestartPolicy)
+	}
+	if len(spec.Containers) != 1 || spec.Containers[0].Name != "worker" || spec.Containers[0].Image != "busybox" {
+		t.Errorf("unexpected containers in Job template: %v", spec.Containers)
+	}
+	if job.GenerateName == "" || job.Namespace != "default" {
+		t.Errorf("unexpected Job metadata: %v", job.ObjectMeta)
+	}
+}
+
+func TestKubeStartJobWithStdin(t *testing.T) {
+	_, mockbwu, mockNet, w, mockKubeAPI, ctx := createKubernetesTestSetup(t)
+
+	mockbwu.EXPECT().Init(w, "", "", workceptor.FileSystem{}, nil)
+	kubeConfig := workceptor.KubeWorkerCfg{
+		AuthMethod:   "incluster",
+		Namespace:    "default",
+		Image:        "busybox",
+		Job:          true,
+		BackoffLimit: 3,
+	}
+	ku := kubeConfig.NewkubeWorker(mockbwu, w, "", "", mockKubeAPI)
+
+	unitDir := t.TempDir()
+	err := os.WriteFile(path.Join(unitDir, "stdin"), []byte("data"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := make(chan string, 1)
+	mockbwu.EXPECT().UpdateBasicStatus(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(state int, detail string, _ int64) {
+			if state == workceptor.WorkStateFailed {
+				failed <- detail
+			}
+		}).AnyTimes()
+	config := rest.Config{}
+	mockKubeAPI.EXPECT().InClusterConfig().Return(&config, nil)
+	mockbwu.EXPECT().GetWorkceptor().Return(w).AnyTimes()
+	mockbwu.EXPECT().ID().Return("unit").AnyTimes()
+	logger := logger.NewReceptorLogger("")
+	mockNet.EXPECT().GetLogger().Return(logger).AnyTimes()
+	clientset := kubernetes.Clientset{}
+	mockKubeAPI.EXPECT().NewForConfig(gomock.Any()).Return(&clientset, nil)
+	mockbwu.EXPECT().MonitorLocalStatus().AnyTimes()
+	lock := &sync.RWMutex{}
+	mockbwu.EXPECT().GetStatusLock().Return(lock).AnyTimes()
+	kubeExtraData := workceptor.KubeExtraData{Image: "busybox", KubeNamespace: "default"}
+	status := workceptor.StatusFileData{ExtraData: &kubeExtraData}
+	mockbwu.EXPECT().GetStatusWithoutExtraData().Return(&status).AnyTimes()
+	mockbwu.EXPECT().GetStatusCopy().Return(status).AnyTimes()
+	mockbwu.EXPECT().GetContext().Return(ctx).AnyTimes()
+	mockbwu.EXPECT().UpdateFullStatus(gomock.Any()).AnyTimes()
+	mockbwu.EXPECT().UnitDir().Return(unitDir).AnyTimes()
+	mockKubeAPI.EXPECT().CreateJob(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	err = ku.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case detail := <-failed:
+		if !strings.Contains(detail, "stdin") {
+			t.Errorf("expected the unit to fail because of stdin, got %s", detail)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the unit to fail")
+	}
+}
+
+func TestKubeWorkerCfgPrepareJob(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     workceptor.KubeWorkerCfg
+		wantErr bool
+	}{
+		{
+			name: "job with limits",
+			cfg:  workceptor.KubeWorkerCfg{Job: true, BackoffLimit: 2, ActiveDeadlineSeconds: 60, TTLSecondsAfterFinished: 30},
+		},
+		{
+			name:    "limits without job",
+			cfg:     workceptor.KubeWorkerCfg{BackoffLimit: 2},
+			wantErr: true,
+		},
+		{
+			name:    "negative backoff limit",
+			cfg:     workceptor.KubeWorkerCfg{Job: true, BackoffLimit: -1},
+			wantErr: true,
+		},
+		{
+			name:    "job with tcp",
+			cfg:     workceptor.KubeWorkerCfg{Job: true, StreamMethod: "tcp"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.AuthMethod = "incluster"
+			tt.cfg.Namespace = "default"
+			tt.cfg.Image = "busybox"
+			if tt.cfg.StreamMethod == "" {
+				tt.cfg.StreamMethod = "logger"
+			}
+			tt.cfg.StdoutOverflow = "truncate"
+			err := tt.cfg.Prepare()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Prepare() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKubeStartUnitVolumes(t *testing.T) {
+	_, mockbwu, mockNet, w, mockKubeAPI, ctx := createKubernetesTestSetup(t)
+
+	mockbwu.EXPECT().Init(w, "", "", workceptor.FileSystem{}, nil)
+	kubeConfig := workceptor.KubeWorkerCfg{
+		AuthMethod:      "incluster",
+		Namespace:       "default",
+		Image:           "busybox",
+		SecretInputs:    []string{"token"},
+		SecretMountPath: "/receptor/secrets",
+		ConfigInputs:    []string{"inventory", "vars"},
+		ConfigMountPath: "/receptor/config",
+	}
+	ku := kubeConfig.NewkubeWorker(mockbwu, w, "", "", mockKubeAPI)
+
+	mockbwu.EXPECT().UpdateBasicStatus(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	config := rest.Config{}
+	mockKubeAPI.EXPECT().InClusterConfig().Return(&config, nil)
+	mockbwu.EXPECT().GetWorkceptor().Return(w).AnyTimes()
+	mockbwu.EXPECT().ID().Return("unit").AnyTimes()
+	logger := logger.NewReceptorLogger("")
+	mockNet.EXPECT().GetLogger().Return(logger).AnyTimes()
+	clientset := kubernetes.Clientset{}
+	mockKubeAPI.EXPECT().NewForConfig(gomock.Any()).Return(&clientset, nil)
+	mockbwu.EXPECT().MonitorLocalStatus().AnyTimes()
+	lock := &sync.RWMutex{}
+	mockbwu.EXPECT().GetStatusLock().Return(lock).AnyTimes()
+	kubeExtraData := workceptor.KubeExtraData{Image: "busybox", KubeNamespace: "default"}
+	status := workceptor.StatusFileData{ExtraData: &kubeExtraData}
+	mockbwu.EXPECT().GetStatusWithoutExtraData().Return(&status).AnyTimes()
+	mockbwu.EXPECT().GetStatusCopy().Return(status).AnyTimes()
+	mockbwu.EXPECT().GetContext().Return(ctx).AnyTimes()
+	mockbwu.EXPECT().UpdateFullStatus(gomock.Any()).AnyTimes()
+	mockbwu.EXPECT().UnitDir().Return(t.TempDir()).AnyTimes()
+
+	err := ku.SetFromParams(map[string]string{"kube_secret_other": "x"})
+	if err == nil {
+		t.Error("expected an error for a secret param that is not configured")
+	}
+	err = ku.SetFromParams(map[string]string{
+		"kube_secret_token":     "s3cret",
+		"kube_config_inventory": "[all]",
+		"secret_other":          "x",
+		"config_other":          "x",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kubeExtraData.SecretData["token"] != "s3cret" || kubeExtraData.ConfigData["inventory"] != "[all]" {
+		t.Fatalf("unexpected unit data %v %v", kubeExtraData.SecretData, kubeExtraData.ConfigData)
+	}
+	if ku.Status().ExtraData.(*workceptor.KubeExtraData).SecretData != nil {
+		t.Error("expected secret data to be redacted from the status")
+	}
+
+	mockKubeAPI.EXPECT().CreateSecret(gomock.Any(), gomock.Any(), "default", gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *kubernetes.Clientset, _ string, secret *corev1.Secret, _ metav1.CreateOptions) (*corev1.Secret, error) {
+			if string(secret.Data["token"]) != "s3cret" || len(secret.Data) != 1 {
+				t.Errorf("unexpected secret data %v", secret.Data)
+			}
+			created := secret.DeepCopy()
+			created.Name = "unit-secret"
+
+			return created, nil
+		})
+	mockKubeAPI.EXPECT().CreateConfigMap(gomock.Any(), gomock.Any(), "default", gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *kubernetes.Clientset, _ string, configMap *corev1.ConfigMap, _ metav1.CreateOptions) (*corev1.ConfigMap, error) {
+			if string(configMap.BinaryData["inventory"]) != "[all]" || len(configMap.BinaryData) != 1 {
+				t.Errorf("unexpected config map data %v", configMap.BinaryData)
+			}
+			created := configMap.DeepCopy()
+			created.Name = "unit-config"
+
+			return created, nil
+		})
+	pods := make(chan *corev1.Pod, 10)
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "unit-pod", Namespace: "default", UID: "pod-uid"}}
+	mockKubeAPI.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *kubernetes.Clientset, _ string, p *corev1.Pod, _ metav1.CreateOptions) (*corev1.Pod, error) {
+			pods <- p
+
+			return &pod, nil
+		}).AnyTimes()
+	patches := make(chan string, 2)
+	mockKubeAPI.EXPECT().PatchSecret(gomock.Any(), gomock.Any(), "default", "unit-secret", types.MergePatchType, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *kubernetes.Clientset, _, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions) (*corev1.Secret, error) {
+			patches <- string(data)
+
+			return &corev1.Secret{}, nil
+		})
+	mockKubeAPI.EXPECT().PatchConfigMap(gomock.Any(), gomock.Any(), "default", "unit-config", types.MergePatchType, gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *kubernetes.Clientset, _, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions) (*corev1.ConfigMap, error) {
+			patches <- string(data)
+
+			return &corev1.ConfigMap{}, nil
+		})
+	field := hasTerm{}
+	mockKubeAPI.EXPECT().OneTermEqualSelector(gomock.Any(), gomock.Any()).Return(&field).AnyTimes()
+	ev := watch.Event{Object: &pod}
+	mockKubeAPI.EXPECT().UntilWithSync(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&ev, nil).AnyTimes()
+	apierr := apierrors.StatusError{}
+	mockKubeAPI.EXPECT().NewNotFound(gomock.Any(), gomock.Any()).Return(&apierr).AnyTimes()
+	c := rest.RESTClient{}
+	req := rest.NewRequest(&c)
+	mockKubeAPI.EXPECT().SubResource(gomock.Any(), gomock.Any(), gomock.Any()).Return(req).AnyTimes()
+	exec := ex{}
+	mockKubeAPI.EXPECT().NewSPDYExecutor(gomock.Any(), gomock.Any(), gomock.Any()).Return(&exec, nil).AnyTimes()
+
+	err = ku.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var created *corev1.Pod
+	for created == nil {
+		select {
+		case p := <-pods:
+			if len(p.Spec.Volumes) > 0 {
+				created = p
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the pod to be created")
+		}
+	}
+	volumes := make(map[string]corev1.Volume)
+	for _, v := range created.Spec.Volumes {
+		volumes[v.Name] = v
+	}
+	if v, ok := volumes["receptor-secret"]; !ok || v.Secret == nil || v.Secret.SecretName != "unit-secret" {
+		t.Errorf("expected the unit secret to be a volume, got %v", created.Spec.Volumes)
+	}
+	if v, ok := volumes["receptor-config"]; !ok || v.ConfigMap == nil || v.ConfigMap.Name != "unit-config" {
+		t.Errorf("expected the unit config map to be a volume, got %v", created.Spec.Volumes)
+	}
+	mounts := make(map[string]string)
+	for _, m := range created.Spec.Containers[0].VolumeMounts {
+		mounts[m.Name] = m.MountPath
+	}
+	if mounts["receptor-secret"] != "/receptor/secrets" || mounts["receptor-config"] != "/receptor/config" {
+		t.Errorf("unexpected volume mounts %v", mounts)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case patch := <-patches:
+			if !strings.Contains(patch, `"kind":"Pod"`) || !strings.Contains(patch, `"uid":"pod-uid"`) {
+				t.Errorf("expected the pod to be set as owner, got %s", patch)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the owner to be set")
+		}
+	}
+}
+
+func TestKubeStartClusters(t *testing.T) {
+	_, mockbwu, mockNet, w, mockKubeAPI, ctx := createKubernetesTestSetup(t)
+
+	mockbwu.EXPECT().Init(w, "", "", workceptor.FileSystem{}, nil)
+	kubeConfig := workceptor.KubeWorkerCfg{
+		AuthMethod: "incluster",
+		Image:      "busybox",
+		Clusters: []string{
+			"name=east,namespace=east-ns,label.region=us-east",
+			"name=west,namespace=west-ns,label.region=us-west",
+			"name=central,namespace=central-ns,label.region=us-east",
+		},
+		Placement: "leastpending",
+	}
+	ku := kubeConfig.NewkubeWorker(mockbwu, w, "", "", mockKubeAPI)
+
+	mockbwu.EXPECT().UpdateBasicStatus(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	config := rest.Config{}
+	mockKubeAPI.EXPECT().InClusterConfig().Return(&config, nil).AnyTimes()
+	mockbwu.EXPECT().GetWorkceptor().Return(w).AnyTimes()
+	logger := logger.NewReceptorLogger("")
+	mockNet.EXPECT().GetLogger().Return(logger).AnyTimes()
+	clientset := kubernetes.Clientset{}
+	mockKubeAPI.EXPECT().NewForConfig(gomock.Any()).Return(&clientset, nil).AnyTimes()
+	mockbwu.EXPECT().MonitorLocalStatus().AnyTimes()
+	lock := &sync.RWMutex{}
+	mockbwu.EXPECT().GetStatusLock().Return(lock).AnyTimes()
+	kubeExtraData := workceptor.KubeExtraData{Image: "busybox"}
+	status := workceptor.StatusFileData{ExtraData: &kubeExtraData}
+	mockbwu.EXPECT().GetStatusWithoutExtraData().Return(&status).AnyTimes()
+	mockbwu.EXPECT().GetStatusCopy().Return(status).AnyTimes()
+	mockbwu.EXPECT().GetContext().Return(ctx).AnyTimes()
+	mockbwu.EXPECT().UpdateFullStatus(gomock.Any()).Do(func(statusFunc func(*workceptor.StatusFileData)) {
+		lock.Lock()
+		defer lock.Unlock()
+		statusFunc(&workceptor.StatusFileData{ExtraData: &kubeExtraData})
+	}).AnyTimes()
+	mockbwu.EXPECT().UnitDir().Return(t.TempDir()).AnyTimes()
+	mockbwu.EXPECT().ID().Return("unit").AnyTimes()
+
+	err := ku.SetFromParams(map[string]string{"kube_cluster_selector": "region=eu-west"})
+	if err == nil {
+		t.Error("expected an error for a selector matching no cluster")
+	}
+	err = ku.SetFromParams(map[string]string{"kube_cluster_selector": "region=us-east"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mockKubeAPI.EXPECT().List(gomock.Any(), gomock.Any(), "east-ns", gomock.Any()).Return(nil, apierrors.NewServiceUnavailable("down"))
+	mockKubeAPI.EXPECT().List(gomock.Any(), gomock.Any(), "central-ns", gomock.Any()).Return(&corev1.PodList{Items: make([]corev1.Pod, 2)}, nil)
+	namespaces := make(chan string, 10)
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "unit-pod", Namespace: "central-ns"}}
+	mockKubeAPI.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *kubernetes.Clientset, namespace string, _ *corev1.Pod, _ metav1.CreateOptions) (*corev1.Pod, error) {
+			namespaces <- namespace
+
+			return &pod, nil
+		}).AnyTimes()
+	field := hasTerm{}
+	mockKubeAPI.EXPECT().OneTermEqualSelector(gomock.Any(), gomock.Any()).Return(&field).AnyTimes()
+	ev := watch.Event{Object: &pod}
+	mockKubeAPI.EXPECT().UntilWithSync(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&ev, nil).AnyTimes()
+	apierr := apierrors.StatusError{}
+	mockKubeAPI.EXPECT().NewNotFound(gomock.Any(), gomock.Any()).Return(&apierr).AnyTimes()
+	c := rest.RESTClient{}
+	req := rest.NewRequest(&c)
+	mockKubeAPI.EXPECT().SubResource(gomock.Any(), gomock.Any(), gomock.Any()).Return(req).AnyTimes()
+	exec := ex{}
+	mockKubeAPI.EXPECT().NewSPDYExecutor(gomock.Any(), gomock.Any(), gomock.Any()).Return(&exec, nil).AnyTimes()
+
+	err = ku.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ked := ku.UnredactedStatus().ExtraData.(*workceptor.KubeExtraData)
+	if ked.Cluster != "central" || ked.KubeNamespace != "central-ns" {
+		t.Errorf("expected the unit to be placed on cluster central, got %s in namespace %s", ked.Cluster, ked.KubeNamespace)
+	}
+	for {
+		select {
+		case namespace := <-namespaces:
+			if namespace == "central-ns" {
+				return
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the pod to be created on cluster central")
+		}
+	}
+}
+
+func TestKubeStartSidecar(t *testing.T) {
+	_, mockbwu, mockNet, w, mockKubeAPI, ctx := createKubernetesTestSetup(t)
+
+	mockbwu.EXPECT().Init(w, "", "", workceptor.FileSystem{}, nil)
+	kubeConfig := workceptor.KubeWorkerCfg{
+		AuthMethod:         "incluster",
+		Namespace:          "default",
+		Image:              "busybox",
+		StreamMethod:       "sidecar",
+		StreamSidecarImage: "quay.io/example/stream@sha256:" + strings.Repeat("0", 64),
+	}
+	ku := kubeConfig.NewkubeWorker(mockbwu, w, "", "", mockKubeAPI)
+
+	unitDir := t.TempDir()
+	err := os.WriteFile(path.Join(unitDir, "stdin"), nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := rest.Config{}
+	mockKubeAPI.EXPECT().InClusterConfig().Return(&config, nil)
+	mockbwu.EXPECT().GetWorkceptor().Return(w).AnyTimes()
+	mockbwu.EXPECT().ID().Return("unit").AnyTimes()
+	logger := logger.NewReceptorLogger("")
+	mockNet.EXPECT().GetLogger().Return(logger).AnyTimes()
+	clientset := kubernetes.Clientset{}
+	mockKubeAPI.EXPECT().NewForConfig(gomock.Any()).Return(&clientset, nil)
+	mockbwu.EXPECT().MonitorLocalStatus().AnyTimes()
+	lock := &sync.RWMutex{}
+	mockbwu.EXPECT().GetStatusLock().Return(lock).AnyTimes()
+	kubeExtraData := workceptor.KubeExtraData{Image: "busybox", KubeNamespace: "default"}
+	status := workceptor.StatusFileData{ExtraData: &kubeExtraData}
+	states := make(chan int, 10)
+	mockbwu.EXPECT().UpdateBasicStatus(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(state int, _ string, _ int64) {
+		lock.Lock()
+		status.State = state
+		lock.Unlock()
+		states <- state
+	}).AnyTimes()
+	mockbwu.EXPECT().GetStatusWithoutExtraData().Return(&status).AnyTimes()
+	mockbwu.EXPECT().GetStatusCopy().Return(status).AnyTimes()
+	mockbwu.EXPECT().GetContext().Return(ctx).AnyTimes()
+	mockbwu.EXPECT().UpdateFullStatus(gomock.Any()).AnyTimes()
+	mockbwu.EXPECT().UnitDir().Return(unitDir).AnyTimes()
+
+	pods := make(chan *corev1.Pod, 10)
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "sidecar-pod", Namespace: "default"}}
+	mockKubeAPI.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *kubernetes.Clientset, _ string, p *corev1.Pod, _ metav1.CreateOptions) (*corev1.Pod, error) {
+			pods <- p
+
+			return &pod, nil
+		}).AnyTimes()
+	finished := corev1.Pod{
+		ObjectMeta: pod.ObjectMeta,
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "worker",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+			}},
+		},
+	}
+	mockKubeAPI.EXPECT().Get(gomock.Any(), gomock.Any(), "default", "sidecar-pod", gomock.Any()).Return(&finished, nil).AnyTimes()
+	mockKubeAPI.EXPECT().ListEvents(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&corev1.EventList{}, nil).AnyTimes()
+	field := hasTerm{}
+	mockKubeAPI.EXPECT().OneTermEqualSelector(gomock.Any(), gomock.Any()).Return(&field).AnyTimes()
+	ev := watch.Event{Object: &pod}
+	mockKubeAPI.EXPECT().UntilWithSync(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&ev, nil).AnyTimes()
+	apierr := apierrors.StatusError{}
+	mockKubeAPI.EXPECT().NewNotFound(gomock.Any(), gomock.Any()).Return(&apierr).AnyTimes()
+	c, err := rest.NewRESTClient(&url.URL{}, "", rest.ClientContentConfig{GroupVersion: corev1.SchemeGroupVersion}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockKubeAPI.EXPECT().SubResource(gomock.Any(), "sidecar-pod", "default").DoAndReturn(
+		func(_ *kubernetes.Clientset, _ string, _ string) *rest.Request {
+			return rest.NewRequest(c)
+		}).AnyTimes()
+	containers := make([]string, 0)
+	mockKubeAPI.EXPECT().NewSPDYExecutor(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ *rest.Config, _ string, u *url.URL) (remotecommand.Executor, error) {
+			containers = append(containers, u.Query().Get("container"))
+
+			return &ex{}, nil
+		}).AnyTimes()
+	// The fake sidecar serves the output from the offset it is sent.  The first attach is dropped after part of
+	// the output, and the second resumes from where it stopped.
+	output := "hello world\n"
+	offsets := make([]string, 0)
+	mockKubeAPI.EXPECT().StreamWithContext(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ remotecommand.Executor, options remotecommand.StreamOptions) error {
+			scanner := bufio.NewScanner(options.Stdin)
+			for scanner.Scan() {
+				switch line := scanner.Text(); line {
+				case "final":
+					fmt.Fprintf(options.Stderr, "end %d\n", len(output))
+				case "done":
+					return nil
+				default:
+					offsets = append(offsets, line)
+					offset, err := strconv.Atoi(line)
+					if err != nil {
+						return err
+					}
+					if len(offsets) == 1 {
+						_, err = options.Stdout.Write([]byte(output[offset:6]))
+						if err != nil {
+							return err
+						}
+
+						return errors.New("connection reset")
+					}
+					_, err = options.Stdout.Write([]byte(output[offset:]))
+					if err != nil {
+						return err
+					}
+				}
+			}
+
+			return errors.New("stdin closed")
+		}).AnyTimes()
+
+	err = ku.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var created *corev1.Pod
+	select {
+	case created = <-pods:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the pod to be created")
+	}
+	if len(created.Spec.Containers) != 2 || created.Spec.Containers[1].Name != "receptor-stream" {
+		t.Fatalf("expected a stream sidecar, got %v", created.Spec.Containers)
+	}
+	worker := created.Spec.Containers[0]
+	if len(worker.Command) != 0 {
+		t.Errorf("expected the worker command to be left as it is, got %v", worker.Command)
+	}
+	sidecar := created.Spec.Containers[1]
+	if !sidecar.Stdin || len(sidecar.Command) != 0 || sidecar.Image != kubeConfig.StreamSidecarImage {
+		t.Errorf("unexpected stream sidecar %v", sidecar)
+	}
+
+	for state := workceptor.WorkStatePending; state != workceptor.WorkStateSucceeded; {
+		select {
+		case state = <-states:
+			if state == workceptor.WorkStateFailed {
+				t.Fatal("expected the unit to succeed")
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the unit to succeed")
+		}
+	}
+	content, err := os.ReadFile(path.Join(unitDir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello world\n" {
+		t.Errorf("expected stdout %q, got %q", "hello world\n", content)
+	}
+	if !reflect.DeepEqual(offsets, []string{"0", "6"}) {
+		t.Errorf("expected the second read to resume from offset 6, got %v", offsets)
+	}
+	// the output is read only by attaching to the sidecar, once before and once after the connection reset
+	sidecarAttaches := 0
+	for _, container := range containers {
+		if container == "receptor-stream" {
+			sidecarAttaches++
+		}
+	}
+	if sidecarAttaches != 2 {
+		t.Errorf("expected two attaches to the stream sidecar, got %v", containers)
+	}
+}
+
+func TestKubeReconcileOrphans(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mockNet := mock_workceptor.NewMockNetceptorForWorkceptor(ctrl)
+	mockNet.EXPECT().NodeID().Return("node 1")
+	mockNet.EXPECT().AddWorkCommand("kube", false).Return(nil)
+	mockNet.EXPECT().GetLogger().Return(logger.NewReceptorLogger("")).AnyTimes()
+	mockKubeAPI := mock_workceptor.NewMockKubeAPIer(ctrl)
+	dataDir := t.TempDir()
+	w, err := workceptor.New(context.Background(), mockNet, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	mainInstance, kubeAPI := workceptor.MainInstance, workceptor.KubeAPIWrapperInstance
+	defer func() {
+		workceptor.MainInstance, workceptor.KubeAPIWrapperInstance = mainInstance, kubeAPI
+	}()
+	workceptor.MainInstance = w
+	workceptor.KubeAPIWrapperInstance = mockKubeAPI
+
+	labelled := func(name string, node string, unit string, jobName string) corev1.Pod {
+		pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "ns",
+			Labels: map[string]string{
+				"receptor.ansible.com/node":     "node_1",
+				"receptor.ansible.com/worktype": "kube",
+				"receptor.ansible.com/unit":     unit,
+			},
+			Annotations: map[string]string{
+				"receptor.ansible.com/node":     node,
+				"receptor.ansible.com/worktype": "kube",
+			},
+		}}
+		if jobName != "" {
+			pod.Labels["job-name"] = jobName
+		}
+
+		return pod
+	}
+	pods := &corev1.PodList{Items: []corev1.Pod{
+		labelled("orphan", "node 1", "lost", ""),
+		labelled("kept", "node 1", "existing", ""),
+		labelled("other-node", "node_1", "lost", ""),
+		labelled("job-pod", "node 1", "lost", "job"),
+	}}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:      "job",
+		Namespace: "ns",
+		Labels:    map[string]string{"receptor.ansible.com/unit": "lostjob"},
+	}}
+	config := rest.Config{}
+	mockKubeAPI.EXPECT().InClusterConfig().Return(&config, nil).AnyTimes()
+	mockKubeAPI.EXPECT().NewForConfig(gomock.Any()).Return(&kubernetes.Clientset{}, nil).AnyTimes()
+	mockKubeAPI.EXPECT().List(gomock.Any(), gomock.Any(), "ns", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *kubernetes.Clientset, _ string, opts metav1.ListOptions) (*corev1.PodList, error) {
+			want := "receptor.ansible.com/node=node_1,receptor.ansible.com/worktype=kube"
+			if opts.LabelSelector != want {
+				t.Errorf("expected label selector %s, got %s", want, opts.LabelSelector)
+			}
+
+			return pods, nil
+		}).AnyTimes()
+	jobGets := make(chan struct{}, 10)
+	mockKubeAPI.EXPECT().GetJob(gomock.Any(), gomock.Any(), "ns", "job", gomock.Any()).DoAndReturn(
+		func(_ context.Context, _ *kubernetes.Clientset, _ string, _ string, _ metav1.GetOptions) (*batchv1.Job, error) {
+			jobGets <- struct{}{}
+
+			return job, nil
+		}).AnyTimes()
+
+	kubeConfig := workceptor.KubeWorkerCfg{
+		WorkType:     "kube",
+		AuthMethod:   "incluster",
+		Namespace:    "ns",
+		Image:        "busybox",
+		StreamMethod: "logger",
+		OrphanPods:   "delete",
+		OrphanDryRun: true,
+	}
+	if err := kubeConfig.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := kubeConfig.Run(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-jobGets:
+		// the startup pass has listed the pods
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for orphaned pods to be reconciled on startup")
+	}
+	if err := os.MkdirAll(path.Join(dataDir, "node 1", "existing"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := w.ReconcileOrphans("kube", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results["kube"]) != 2 || !strings.HasPrefix(results["kube"][0], "would delete") {
+		t.Errorf("expected 2 pods to be reported for deletion, got %v", results)
+	}
+
+	mockKubeAPI.EXPECT().Delete(gomock.Any(), gomock.Any(), "ns", "orphan", gomock.Any()).Return(nil)
+	mockKubeAPI.EXPECT().DeleteJob(gomock.Any(), gomock.Any(), "ns", "job", gomock.Any()).Return(nil)
+	results, err = w.ReconcileOrphans("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"deleted pod ns/orphan", "deleted job ns/job"}
+	if !reflect.DeepEqual(results["kube"], want) {
+		t.Errorf("expected %v, got %v", want, results["kube"])
 	}
 }
 
@@ -7,12 +7,14 @@ package mock_workceptor
 import (
 	context "context"
 	gomock "github.com/golang/mock/gomock"
+	v11 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	errors "k8s.io/apimachinery/pkg/api/errors"
 	v10 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	fields "k8s.io/apimachinery/pkg/fields"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
+	types "k8s.io/apimachinery/pkg/types"
 	watch "k8s.io/apimachinery/pkg/watch"
 	kubernetes "k8s.io/client-go/kubernetes"
 	rest "k8s.io/client-go/rest"
@@ -179,6 +181,168 @@ func (mr *MockKubeAPIerMockRecorder) Delete(arg0, arg1, arg2, arg3, arg4 interfa
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockKubeAPIer)(nil).Delete), arg0, arg1, arg2, arg3, arg4)
 }
 
+// CreateSecret mocks base method
+func (m *MockKubeAPIer) CreateSecret(arg0 context.Context, arg1 *kubernetes.Clientset, arg2 string, arg3 *v1.Secret, arg4 v10.CreateOptions) (*v1.Secret, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSecret", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(*v1.Secret)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateSecret indicates an expected call of CreateSecret
+func (mr *MockKubeAPIerMockRecorder) CreateSecret(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSecret", reflect.TypeOf((*MockKubeAPIer)(nil).CreateSecret), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Patch mocks base method
+func (m *MockKubeAPIer) Patch(arg0 context.Context, arg1 *kubernetes.Clientset, arg2, arg3 string, arg4 types.PatchType, arg5 []byte, arg6 v10.PatchOptions) (*v1.Pod, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Patch", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+	ret0, _ := ret[0].(*v1.Pod)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Patch indicates an expected call of Patch
+func (mr *MockKubeAPIerMockRecorder) Patch(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Patch", reflect.TypeOf((*MockKubeAPIer)(nil).Patch), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
+
+// PatchSecret mocks base method
+func (m *MockKubeAPIer) PatchSecret(arg0 context.Context, arg1 *kubernetes.Clientset, arg2, arg3 string, arg4 types.PatchType, arg5 []byte, arg6 v10.PatchOptions) (*v1.Secret, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PatchSecret", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+	ret0, _ := ret[0].(*v1.Secret)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PatchSecret indicates an expected call of PatchSecret
+func (mr *MockKubeAPIerMockRecorder) PatchSecret(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchSecret", reflect.TypeOf((*MockKubeAPIer)(nil).PatchSecret), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
+
+// CreateConfigMap mocks base method
+func (m *MockKubeAPIer) CreateConfigMap(arg0 context.Context, arg1 *kubernetes.Clientset, arg2 string, arg3 *v1.ConfigMap, arg4 v10.CreateOptions) (*v1.ConfigMap, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateConfigMap", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(*v1.ConfigMap)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateConfigMap indicates an expected call of CreateConfigMap
+func (mr *MockKubeAPIerMockRecorder) CreateConfigMap(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateConfigMap", reflect.TypeOf((*MockKubeAPIer)(nil).CreateConfigMap), arg0, arg1, arg2, arg3, arg4)
+}
+
+// PatchConfigMap mocks base method
+func (m *MockKubeAPIer) PatchConfigMap(arg0 context.Context, arg1 *kubernetes.Clientset, arg2, arg3 string, arg4 types.PatchType, arg5 []byte, arg6 v10.PatchOptions) (*v1.ConfigMap, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PatchConfigMap", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+	ret0, _ := ret[0].(*v1.ConfigMap)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PatchConfigMap indicates an expected call of PatchConfigMap
+func (mr *MockKubeAPIerMockRecorder) PatchConfigMap(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchConfigMap", reflect.TypeOf((*MockKubeAPIer)(nil).PatchConfigMap), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
+
+// DeleteConfigMap mocks base method
+func (m *MockKubeAPIer) DeleteConfigMap(arg0 context.Context, arg1 *kubernetes.Clientset, arg2, arg3 string, arg4 v10.DeleteOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteConfigMap", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteConfigMap indicates an expected call of DeleteConfigMap
+func (mr *MockKubeAPIerMockRecorder) DeleteConfigMap(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteConfigMap", reflect.TypeOf((*MockKubeAPIer)(nil).DeleteConfigMap), arg0, arg1, arg2, arg3, arg4)
+}
+
+// ListEvents mocks base method
+func (m *MockKubeAPIer) ListEvents(arg0 context.Context, arg1 *kubernetes.Clientset, arg2 string, arg3 v10.ListOptions) (*v1.EventList, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListEvents", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(*v1.EventList)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListEvents indicates an expected call of ListEvents
+func (mr *MockKubeAPIerMockRecorder) ListEvents(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListEvents", reflect.TypeOf((*MockKubeAPIer)(nil).ListEvents), arg0, arg1, arg2, arg3)
+}
+
+// CreateJob mocks base method
+func (m *MockKubeAPIer) CreateJob(arg0 context.Context, arg1 *kubernetes.Clientset, arg2 string, arg3 *v11.Job, arg4 v10.CreateOptions) (*v11.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateJob", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(*v11.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateJob indicates an expected call of CreateJob
+func (mr *MockKubeAPIerMockRecorder) CreateJob(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateJob", reflect.TypeOf((*MockKubeAPIer)(nil).CreateJob), arg0, arg1, arg2, arg3, arg4)
+}
+
+// GetJob mocks base method
+func (m *MockKubeAPIer) GetJob(arg0 context.Context, arg1 *kubernetes.Clientset, arg2, arg3 string, arg4 v10.GetOptions) (*v11.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetJob", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(*v11.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetJob indicates an expected call of GetJob
+func (mr *MockKubeAPIerMockRecorder) GetJob(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetJob", reflect.TypeOf((*MockKubeAPIer)(nil).GetJob), arg0, arg1, arg2, arg3, arg4)
+}
+
+// DeleteJob mocks base method
+func (m *MockKubeAPIer) DeleteJob(arg0 context.Context, arg1 *kubernetes.Clientset, arg2, arg3 string, arg4 v10.DeleteOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteJob", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteJob indicates an expected call of DeleteJob
+func (mr *MockKubeAPIerMockRecorder) DeleteJob(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteJob", reflect.TypeOf((*MockKubeAPIer)(nil).DeleteJob), arg0, arg1, arg2, arg3, arg4)
+}
+
+// DeleteSecret mocks base method
+func (m *MockKubeAPIer) DeleteSecret(arg0 context.Context, arg1 *kubernetes.Clientset, arg2, arg3 string, arg4 v10.DeleteOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteSecret", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteSecret indicates an expected call of DeleteSecret
+func (mr *MockKubeAPIerMockRecorder) DeleteSecret(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSecret", reflect.TypeOf((*MockKubeAPIer)(nil).DeleteSecret), arg0, arg1, arg2, arg3, arg4)
+}
+
 // SubResource mocks base method
 func (m *MockKubeAPIer) SubResource(arg0 *kubernetes.Clientset, arg1, arg2 string) *rest.Request {
 	m.ctrl.T.Helper()
@@ -193,6 +357,21 @@ func (mr *MockKubeAPIerMockRecorder) SubResource(arg0, arg1, arg2 interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubResource", reflect.TypeOf((*MockKubeAPIer)(nil).SubResource), arg0, arg1, arg2)
 }
 
+// PatchJob mocks base method
+func (m *MockKubeAPIer) PatchJob(arg0 context.Context, arg1 *kubernetes.Clientset, arg2, arg3 string, arg4 types.PatchType, arg5 []byte, arg6 v10.PatchOptions) (*v11.Job, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PatchJob", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+	ret0, _ := ret[0].(*v11.Job)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PatchJob indicates an expected call of PatchJob
+func (mr *MockKubeAPIerMockRecorder) PatchJob(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PatchJob", reflect.TypeOf((*MockKubeAPIer)(nil).PatchJob), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
+
 // InClusterConfig mocks base method
 func (m *MockKubeAPIer) InClusterConfig() (*rest.Config, error) {
 	m.ctrl.T.Helper()
@@ -107,3 +107,17 @@ func (mr *MockNetceptorForWorkceptorMockRecorder) DialContext(ctx, node, service
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DialContext", reflect.TypeOf((*MockNetceptorForWorkceptor)(nil).DialContext), ctx, node, service, tlscfg)
 }
+
+// Status mocks base method
+func (m *MockNetceptorForWorkceptor) Status() netceptor.Status {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Status")
+	ret0, _ := ret[0].(netceptor.Status)
+	return ret0
+}
+
+// Status indicates an expected call of Status
+func (mr *MockNetceptorForWorkceptorMockRecorder) Status() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Status", reflect.TypeOf((*MockNetceptorForWorkceptor)(nil).Status))
+}
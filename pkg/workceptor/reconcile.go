@@ -0,0 +1,80 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// OrphanReconciler finds the resources a work type created outside of Receptor, such as Kubernetes pods, whose unit
+// no longer exists, and deletes or adopts them.  With dryRun, it only reports what it would do.  It returns a
+// description of each action taken.
+type OrphanReconciler func(dryRun bool) ([]string, error)
+
+// SetOrphanReconciler sets the orphan reconciler of a registered work type.
+func (w *Workceptor) SetOrphanReconciler(typeName string, reconciler OrphanReconciler) error {
+	w.workTypesLock.Lock()
+	defer w.workTypesLock.Unlock()
+	wt, ok := w.workTypes[typeName]
+	if !ok {
+		return fmt.Errorf("unknown work type %s", typeName)
+	}
+	wt.reconciler = reconciler
+
+	return nil
+}
+
+// ReconcileOrphans runs the orphan reconciler of a work type, or of every work type having one if typeName is
+// empty.  It returns the actions taken for each work type.
+func (w *Workceptor) ReconcileOrphans(typeName string, dryRun bool) (map[string][]string, error) {
+	reconcilers := make(map[string]OrphanReconciler)
+	w.workTypesLock.RLock()
+	for name, wt := range w.workTypes {
+		if (typeName == "" || name == typeName) && wt.reconciler != nil {
+			reconcilers[name] = wt.reconciler
+		}
+	}
+	_, known := w.workTypes[typeName]
+	w.workTypesLock.RUnlock()
+	if typeName != "" && len(reconcilers) == 0 {
+		if !known {
+			return nil, fmt.Errorf("unknown work type %s", typeName)
+		}
+
+		return nil, fmt.Errorf("work type %s does not reconcile orphans", typeName)
+	}
+	names := make([]string, 0, len(reconcilers))
+	for name := range reconcilers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := make(map[string][]string)
+	failures := make([]string, 0)
+	for _, name := range names {
+		actions, err := reconcilers[name](dryRun)
+		results[name] = actions
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return results, fmt.Errorf("error reconciling orphans of %s", strings.Join(failures, "; "))
+	}
+
+	return results, nil
+}
+
+// unitDirExists returns true if the directory of a unit exists, whether or not the unit is loaded.
+func (w *Workceptor) unitDirExists(unitID string) bool {
+	if unitID == "" {
+		return false
+	}
+	fi, err := os.Stat(path.Join(w.dataDir, unitID))
+
+	return err == nil && fi.IsDir()
+}
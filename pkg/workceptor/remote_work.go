@@ -13,6 +13,7 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -42,6 +43,13 @@ type RemoteExtraData struct {
 	Expiration     time.Time
 }
 
+// maxUploadResumes is how many times in a row sending the input data of a remote unit is resumed without the remote
+// node receiving more of it, before giving up.
+const maxUploadResumes = 5
+
+// uploadResumeTimeout is how long to try reconnecting to the remote node to resume sending the input data.
+const uploadResumeTimeout = time.Minute
+
 type actionFunc func(context.Context, net.Conn, *bufio.Reader) error
 
 // connectToRemote establishes a control socket connection to a remote node.
@@ -152,23 +160,45 @@ func (rw *remoteUnit) getConnectionAndRun(ctx context.Context, firstTimeSync boo
 func (rw *remoteUnit) startRemoteUnit(ctx context.Context, conn net.Conn, reader *bufio.Reader) error {
 	defer conn.(interface{ CloseConnection() error }).CloseConnection()
 	red := rw.UnredactedStatus().ExtraData.(*RemoteExtraData)
-	workSubmitCmd := make(map[string]interface{})
+	submitParams := make(map[string]string)
 	for k, v := range red.RemoteParams {
-		workSubmitCmd[k] = v
+		submitParams[k] = v
 	}
-	workSubmitCmd["command"] = "work"
-	workSubmitCmd["subcommand"] = "submit"
-	workSubmitCmd["node"] = red.RemoteNode
-	workSubmitCmd["worktype"] = red.RemoteWorkType
-	workSubmitCmd["tlsclient"] = red.TLSClient
+	submitParams["command"] = "work"
+	submitParams["subcommand"] = "submit"
+	submitParams["node"] = red.RemoteNode
+	submitParams["worktype"] = red.RemoteWorkType
+	submitParams["tlsclient"] = red.TLSClient
+	inputFiles, err := listInputFiles(rw.UnitDir())
+	if err != nil {
+		return fmt.Errorf("error listing input files: %s", err)
+	}
+	if len(inputFiles) > 0 {
+		err = rw.GetWorkceptor().checkRemoteInputFiles(red.RemoteNode, red.RemoteWorkType)
+		if err != nil {
+			return err
+		}
+		submitParams["inputfiles"] = FormatInputManifest(inputFiles)
+	}
+	inputSize, err := inputDataSize(rw.UnitDir(), inputFiles)
+	if err != nil {
+		return fmt.Errorf("error opening stdin file: %s", err)
+	}
+	// Declaring the size makes the upload resumable if the connection drops.
+	submitParams["stdinsize"] = strconv.FormatInt(inputSize, 10)
 	if red.SignWork {
-		signature, err := rw.GetWorkceptor().createSignature(red.RemoteNode)
+		stdinSHA256, err := submitStdinSHA256(rw.UnitDir(), inputFiles)
+		if err != nil {
+			return fmt.Errorf("error hashing stdin file: %s", err)
+		}
+		signature, err := rw.GetWorkceptor().createSubmitSignature(red.RemoteNode, red.RemoteWorkType, submitParams,
+			stdinSHA256)
 		if err != nil {
 			return err
 		}
-		workSubmitCmd["signature"] = signature
+		submitParams["signature"] = signature
 	}
-	wscBytes, err := json.Marshal(workSubmitCmd)
+	wscBytes, err := json.Marshal(submitParams)
 	if err != nil {
 		return fmt.Errorf("error constructing work submit command: %s", err)
 	}
@@ -193,35 +223,163 @@ func (rw *remoteUnit) startRemoteUnit(ctx context.Context, conn net.Conn, reader
 		ed := status.ExtraData.(*RemoteExtraData)
 		ed.RemoteUnitID = red.RemoteUnitID
 	})
-	stdin, err := os.Open(path.Join(rw.UnitDir(), "stdin"))
+	err = rw.sendInputData(ctx, conn, reader, inputFiles, 0)
+	received := int64(0)
+	for attempt := 1; utils.ErrorIsKind(err, "connection"); attempt++ {
+		if attempt > maxUploadResumes {
+			return fmt.Errorf("giving up sending stdin to %s: %s", red.RemoteNode, err)
+		}
+		rw.GetWorkceptor().nc.GetLogger().Warning("Sending stdin of unit %s to %s was interrupted, resuming: %s",
+			rw.ID(), red.RemoteNode, err)
+		var nowReceived int64
+		nowReceived, err = rw.resumeInputData(ctx, inputFiles)
+		if nowReceived > received {
+			received = nowReceived
+			attempt = 0
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("error opening stdin file: %s", err)
+		return err
 	}
-	_, err = io.Copy(conn, stdin)
+	rw.UpdateFullStatus(func(status *StatusFileData) {
+		ed := status.ExtraData.(*RemoteExtraData)
+		ed.RemoteStarted = true
+	})
+
+	return nil
+}
+
+// sendInputData sends the input data of the unit from an offset to the remote unit, and reads the response of the
+// remote node once all of it is sent.  Errors caused by losing the connection are of kind "connection", so that the
+// upload can be resumed.
+func (rw *remoteUnit) sendInputData(ctx context.Context, conn net.Conn, reader *bufio.Reader, inputFiles []InputFile,
+	offset int64,
+) error {
+	red := rw.Status().ExtraData.(*RemoteExtraData)
+	data, err := openInputData(rw.UnitDir(), inputFiles, offset)
 	if err != nil {
-		return fmt.Errorf("error sending stdin file: %s", err)
+		return fmt.Errorf("error opening stdin file: %s", err)
+	}
+	defer data.Close()
+	_, err = io.Copy(conn, data)
+	if err == nil {
+		err = conn.Close()
 	}
-	err = conn.Close()
 	if err != nil {
-		return fmt.Errorf("error closing stdin file: %s", err)
+		return utils.WrapErrorWithKind(fmt.Errorf("error sending stdin to %s: %s", red.RemoteNode, err), "connection")
 	}
-	response, err = utils.ReadStringContext(ctx, reader, '\n')
+	response, err := utils.ReadStringContext(ctx, reader, '\n')
 	if err != nil {
-		return fmt.Errorf("read error reading from %s: %s", red.RemoteNode, err)
+		if ctx.Err() != nil {
+			return err
+		}
+
+		return utils.WrapErrorWithKind(fmt.Errorf("read error reading from %s: %s", red.RemoteNode, err), "connection")
 	}
 	resultErrorRegex := regexp.MustCompile("ERROR: (.*)")
-	match = resultErrorRegex.FindSubmatch([]byte(response))
+	match := resultErrorRegex.FindSubmatch([]byte(response))
 	if match != nil {
 		return fmt.Errorf("error from remote: %s", match[1])
 	}
-	rw.UpdateFullStatus(func(status *StatusFileData) {
-		ed := status.ExtraData.(*RemoteExtraData)
-		ed.RemoteStarted = true
-	})
 
 	return nil
 }
 
+// resumeInputData reconnects to the remote node, asks how much of the input data the remote unit has received, and
+// sends the rest.  It returns the number of bytes the remote unit had received.
+func (rw *remoteUnit) resumeInputData(ctx context.Context, inputFiles []InputFile) (int64, error) {
+	red := rw.UnredactedStatus().ExtraData.(*RemoteExtraData)
+	ctxReconnect, cancel := context.WithTimeout(ctx, uploadResumeTimeout)
+	defer cancel()
+	conn, reader := rw.getConnection(ctxReconnect)
+	if conn == nil {
+		return 0, fmt.Errorf("could not reconnect to %s to resume sending stdin", red.RemoteNode)
+	}
+	defer conn.(interface{ CloseConnection() error }).CloseConnection()
+	uploadStatusCmd := map[string]interface{}{
+		"command":    "work",
+		"subcommand": "upload-status",
+		"unitid":     red.RemoteUnitID,
+	}
+	if red.SignWork {
+		signature, err := rw.GetWorkceptor().createSignature(red.RemoteNode, red.RemoteWorkType,
+			workCommandSHA256("upload-status", red.RemoteUnitID))
+		if err != nil {
+			return 0, err
+		}
+		uploadStatusCmd["signature"] = signature
+	}
+	response, err := rw.sendRemoteCommand(ctx, conn, reader, uploadStatusCmd)
+	if err != nil {
+		return 0, err
+	}
+	if strings.Contains(response, "is not waiting for input data") {
+		// The remote unit received all of the input data before the connection was lost.  Monitoring it will report
+		// whether it started.
+		return 0, nil
+	}
+	if strings.HasPrefix(response, "ERROR: ") {
+		return 0, fmt.Errorf("error from remote: %s", strings.TrimPrefix(response, "ERROR: "))
+	}
+	uploadStatus := struct {
+		Received int64 `json:"received"`
+	}{}
+	err = json.Unmarshal([]byte(response), &uploadStatus)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse response: %s", response)
+	}
+	uploadCmd := map[string]interface{}{
+		"command":    "work",
+		"subcommand": "upload",
+		"unitid":     red.RemoteUnitID,
+		"offset":     uploadStatus.Received,
+	}
+	if red.SignWork {
+		signature, err := rw.GetWorkceptor().createSignature(red.RemoteNode, red.RemoteWorkType,
+			workCommandSHA256("upload", red.RemoteUnitID))
+		if err != nil {
+			return uploadStatus.Received, err
+		}
+		uploadCmd["signature"] = signature
+	}
+	response, err = rw.sendRemoteCommand(ctx, conn, reader, uploadCmd)
+	if err != nil {
+		return uploadStatus.Received, err
+	}
+	if strings.HasPrefix(response, "ERROR: ") {
+		return uploadStatus.Received, fmt.Errorf("error from remote: %s", strings.TrimPrefix(response, "ERROR: "))
+	}
+
+	return uploadStatus.Received, rw.sendInputData(ctx, conn, reader, inputFiles, uploadStatus.Received)
+}
+
+// sendRemoteCommand sends a control command to the remote node and returns the first line of the response.  Errors
+// caused by losing the connection are of kind "connection".
+func (rw *remoteUnit) sendRemoteCommand(ctx context.Context, conn net.Conn, reader *bufio.Reader,
+	cmd map[string]interface{},
+) (string, error) {
+	red := rw.Status().ExtraData.(*RemoteExtraData)
+	cmdBytes, err := json.Marshal(cmd)
+	if err != nil {
+		return "", fmt.Errorf("error constructing work %s command: %s", cmd["subcommand"], err)
+	}
+	cmdBytes = append(cmdBytes, '\n')
+	_, err = conn.Write(cmdBytes)
+	if err != nil {
+		return "", utils.WrapErrorWithKind(fmt.Errorf("write error sending to %s: %s", red.RemoteNode, err), "connection")
+	}
+	response, err := utils.ReadStringContext(ctx, reader, '\n')
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", err
+		}
+
+		return "", utils.WrapErrorWithKind(fmt.Errorf("read error reading from %s: %s", red.RemoteNode, err), "connection")
+	}
+
+	return strings.TrimRight(response, "\n"), nil
+}
+
 // cancelOrReleaseRemoteUnit makes a single attempt to cancel or release a remote unit.
 func (rw *remoteUnit) cancelOrReleaseRemoteUnit(ctx context.Context, conn net.Conn, reader *bufio.Reader,
 	release bool,
@@ -239,7 +397,8 @@ func (rw *remoteUnit) cancelOrReleaseRemoteUnit(ctx context.Context, conn net.Co
 	workSubmitCmd["subcommand"] = workCmd
 	workSubmitCmd["unitid"] = red.RemoteUnitID
 	if red.SignWork {
-		signature, err := rw.GetWorkceptor().createSignature(red.RemoteNode)
+		signature, err := rw.GetWorkceptor().createSignature(red.RemoteNode, red.RemoteWorkType,
+			workCommandSHA256(workCmd, red.RemoteUnitID))
 		if err != nil {
 			return err
 		}
@@ -289,6 +448,8 @@ func (rw *remoteUnit) monitorRemoteStatus(mw *utils.JobContext, forRelease bool)
 	if conn == nil {
 		return
 	}
+	mirroredEvents, mirrorHistory := rw.countMirroredEvents()
+	lastState, lastDetail := -1, ""
 	writeStatusFailures := 0
 	for {
 		if conn == nil {
@@ -335,14 +496,26 @@ func (rw *remoteUnit) monitorRemoteStatus(mw *utils.JobContext, forRelease bool)
 
 			return
 		}
-		si := StatusFileData{}
+		si := StatusFileData{ExitCode: -1}
 		err = json.Unmarshal([]byte(status), &si)
 		if err != nil {
 			rw.GetWorkceptor().nc.GetLogger().Error("Error unmarshalling JSON: %s\n", status)
 
 			return
 		}
-		rw.UpdateBasicStatus(si.State, si.Detail, si.StdoutSize)
+		mirrorStatus := func(status *StatusFileData) {
+			status.State = si.State
+			status.Detail = si.Detail
+			status.StdoutSize = si.StdoutSize
+			status.StderrSize = si.StderrSize
+			status.mirrorRunInfo(&si)
+		}
+		if mirrorHistory {
+			// The remote unit's own events are mirrored below, so the change is not recorded twice.
+			rw.updateStatusWithoutHistory(mirrorStatus)
+		} else {
+			rw.UpdateFullStatus(mirrorStatus)
+		}
 		if rw.LastUpdateError() != nil {
 			writeStatusFailures++
 			if writeStatusFailures > 3 {
@@ -358,13 +531,227 @@ func (rw *remoteUnit) monitorRemoteStatus(mw *utils.JobContext, forRelease bool)
 
 			return
 		}
+		if mirrorHistory && (si.State != lastState || si.Detail != lastDetail) {
+			mirroredEvents, mirrorHistory, err = rw.mirrorRemoteHistory(mw, conn, reader, red, mirroredEvents)
+			if err != nil {
+				rw.GetWorkceptor().nc.GetLogger().Debug("Error mirroring history from %s: %s\n", remoteNode, err)
+				cerr := conn.(interface{ CloseConnection() error }).CloseConnection()
+				if cerr != nil {
+					rw.GetWorkceptor().nc.GetLogger().Error("Error closing connection from node %s: %s", remoteNode, cerr)
+				}
+				conn = nil
+
+				continue
+			}
+			if !mirrorHistory {
+				// The remote node keeps no history, so the change is recorded here instead.
+				err = appendHistory(rw.UnitDir(), newWorkEvent(si.State, si.Detail))
+				if err != nil {
+					rw.GetWorkceptor().nc.GetLogger().Error("Could not record history of %s: %s", rw.ID(), err)
+				}
+			}
+			lastState, lastDetail = si.State, si.Detail
+		}
 		if sleepOrDone(mw.Done(), 1*time.Second) {
 			return
 		}
 	}
 }
 
-// monitorRemoteStdout copies the remote stdout stream to the local buffer.
+// updateStatusWithoutHistory updates the status of the unit without appending the change to its event history.
+func (rw *remoteUnit) updateStatusWithoutHistory(statusFunc func(*StatusFileData)) {
+	bwu, ok := rw.BaseWorkUnitForWorkUnit.(*BaseWorkUnit)
+	if !ok {
+		rw.UpdateFullStatus(statusFunc)
+
+		return
+	}
+	bwu.updateFullStatus(statusFunc, false)
+}
+
+// countMirroredEvents returns how many events of the remote unit's history have already been copied to the local
+// history, and whether history should be mirrored at all.
+func (rw *remoteUnit) countMirroredEvents() (int, bool) {
+	events, err := readHistory(rw.UnitDir())
+	if err != nil {
+		rw.GetWorkceptor().nc.GetLogger().Warning("Could not read history of %s, not mirroring remote history: %s", rw.ID(), err)
+
+		return 0, false
+	}
+	count := 0
+	for i := range events {
+		if events[i].RemoteNode != "" {
+			count++
+		}
+	}
+
+	return count, true
+}
+
+// mirrorRemoteHistory appends the events of the remote unit's history that have not been seen yet to the local
+// history.  It returns the new count of mirrored events, and false if the remote node does not keep a history.
+func (rw *remoteUnit) mirrorRemoteHistory(mw *utils.JobContext, conn net.Conn, reader *bufio.Reader,
+	red *RemoteExtraData, mirrored int,
+) (int, bool, error) {
+	_, err := conn.Write([]byte(fmt.Sprintf("work history %s %d\n", red.RemoteUnitID, mirrored)))
+	if err != nil {
+		return mirrored, true, err
+	}
+	response, err := utils.ReadStringContext(mw, reader, '\n')
+	if err != nil {
+		return mirrored, true, err
+	}
+	if strings.HasPrefix(response, "ERROR") {
+		rw.GetWorkceptor().nc.GetLogger().Debug("Not mirroring history of %s from %s: %s",
+			red.RemoteUnitID, red.RemoteNode, strings.TrimRight(response[5:], "\n"))
+
+		return mirrored, false, nil
+	}
+	history := struct {
+		Events []WorkEvent
+	}{}
+	err = json.Unmarshal([]byte(response), &history)
+	if err != nil {
+		rw.GetWorkceptor().nc.GetLogger().Error("Error unmarshalling JSON: %s\n", response)
+
+		return mirrored, false, nil
+	}
+	if len(history.Events) == 0 {
+		return mirrored, true, nil
+	}
+	for i := range history.Events {
+		history.Events[i].RemoteNode = red.RemoteNode
+	}
+	err = appendHistory(rw.UnitDir(), history.Events...)
+	if err != nil {
+		rw.GetWorkceptor().nc.GetLogger().Error("Could not record history of %s: %s", rw.ID(), err)
+
+		return mirrored, false, nil
+	}
+
+	return mirrored + len(history.Events), true, nil
+}
+
+// copyRemoteStream copies one result stream of the remote unit to the local buffer, starting at startPos.
+// It returns false if monitoring should stop.
+func (rw *remoteUnit) copyRemoteStream(mw *utils.JobContext, red *RemoteExtraData, stream string, startPos int64) bool {
+	remoteNode := red.RemoteNode
+	remoteUnitID := red.RemoteUnitID
+	localFilename := path.Join(rw.UnitDir(), stream)
+	conn, reader := rw.getConnection(mw)
+	defer func() {
+		if conn != nil {
+			cerr := conn.(interface{ CloseConnection() error }).CloseConnection()
+			if cerr != nil {
+				rw.GetWorkceptor().nc.GetLogger().Error("Error closing connection to %s: %s", remoteUnitID, cerr)
+			}
+		}
+	}()
+	if conn == nil {
+		return false
+	}
+	workSubmitCmd := make(map[string]interface{})
+	workSubmitCmd["command"] = "work"
+	workSubmitCmd["subcommand"] = "results"
+	workSubmitCmd["unitid"] = remoteUnitID
+	workSubmitCmd["startpos"] = startPos
+	if stream != StreamStdout {
+		workSubmitCmd["stream"] = stream
+	}
+	workSubmitCmd["compression"] = strings.Join(supportedCompression, ",")
+	if red.SignWork {
+		signature, err := rw.GetWorkceptor().createSignature(red.RemoteNode, red.RemoteWorkType,
+			workCommandSHA256("results", remoteUnitID))
+		if err != nil {
+			rw.GetWorkceptor().nc.GetLogger().Error("could not create signature to get results")
+
+			return false
+		}
+		workSubmitCmd["signature"] = signature
+	}
+	wscBytes, err := json.Marshal(workSubmitCmd)
+	if err != nil {
+		rw.GetWorkceptor().nc.GetLogger().Error("error constructing work results command: %s", err)
+
+		return false
+	}
+	wscBytes = append(wscBytes, '\n')
+	_, err = conn.Write(wscBytes)
+	if err != nil {
+		rw.GetWorkceptor().nc.GetLogger().Warning("Write error sending to %s: %s\n", remoteNode, err)
+
+		return true
+	}
+	status, err := utils.ReadStringContext(mw, reader, '\n')
+	if err != nil {
+		rw.GetWorkceptor().nc.GetLogger().Warning("Read error reading from %s: %s\n", remoteNode, err)
+
+		return true
+	}
+	if !strings.Contains(status, "Streaming results") {
+		rw.GetWorkceptor().nc.GetLogger().Warning("Remote node %s did not stream results\n", remoteNode)
+
+		return true
+	}
+	// Nodes that do not support compression ignore the offer and stream plain results.
+	compression := ""
+	_, negotiated, found := strings.Cut(strings.TrimSpace(status), " with ")
+	if found {
+		compression = strings.TrimSuffix(negotiated, " compression")
+	}
+	localFile, err := os.OpenFile(localFilename, os.O_CREATE+os.O_APPEND+os.O_WRONLY, 0o600)
+	if err != nil {
+		rw.GetWorkceptor().nc.GetLogger().Error("Could not open %s file %s: %s\n", stream, localFilename, err)
+
+		return false
+	}
+	defer localFile.Close()
+	doneChan := make(chan struct{})
+	go func() {
+		select {
+		case <-doneChan:
+			return
+		case <-mw.Done():
+			cr, ok := conn.(interface{ CancelRead() })
+			if ok {
+				cr.CancelRead()
+			}
+			cerr := conn.(interface{ CloseConnection() error }).CloseConnection()
+			if cerr != nil {
+				rw.GetWorkceptor().nc.GetLogger().Error("Error closing connection to %s: %s", remoteUnitID, cerr)
+			}
+
+			return
+		}
+	}()
+	var results io.Reader = reader
+	if compression != "" {
+		decoder, err := newStreamDecoder(reader, compression)
+		if err != nil {
+			close(doneChan)
+			rw.GetWorkceptor().nc.GetLogger().Warning("Could not decompress %s from %s: %s\n", stream, remoteNode, err)
+
+			return true
+		}
+		defer decoder.Close()
+		results = decoder
+	}
+	_, err = io.Copy(localFile, results)
+	close(doneChan)
+	if err != nil {
+		var errmsg string
+		if strings.HasSuffix(err.Error(), "error code 499") {
+			errmsg = "read operation cancelled"
+		} else {
+			errmsg = err.Error()
+		}
+		rw.GetWorkceptor().nc.GetLogger().Warning("Could not copy to %s file %s: %s\n", stream, localFilename, errmsg)
+	}
+
+	return true
+}
+
+// monitorRemoteStdout copies the remote stdout and stderr streams to the local buffers.
 func (rw *remoteUnit) monitorRemoteStdout(mw *utils.JobContext) {
 	defer func() {
 		mw.Cancel()
@@ -378,8 +765,6 @@ func (rw *remoteUnit) monitorRemoteStdout(mw *utils.JobContext) {
 
 		return
 	}
-	remoteNode := red.RemoteNode
-	remoteUnitID := red.RemoteUnitID
 	stdout, err := os.OpenFile(rw.StdoutFileName(), os.O_CREATE+os.O_APPEND+os.O_WRONLY, 0o600)
 	if err == nil {
 		err = stdout.Close()
@@ -405,98 +790,19 @@ func (rw *remoteUnit) monitorRemoteStdout(mw *utils.JobContext) {
 			return
 		}
 		status := rw.Status()
-		diskStdoutSize := stdoutSize(rw.UnitDir())
-		remoteStdoutSize := status.StdoutSize
-		if IsComplete(status.State) && diskStdoutSize >= remoteStdoutSize {
-			return
-		} else if diskStdoutSize < remoteStdoutSize {
-			conn, reader := rw.getConnection(mw)
-			defer func() {
-				if conn != nil {
-					cerr := conn.(interface{ CloseConnection() error }).CloseConnection()
-					if cerr != nil {
-						rw.GetWorkceptor().nc.GetLogger().Error("Error closing connection to %s: %s", remoteUnitID, cerr)
-					}
-				}
-			}()
-			if conn == nil {
-				return
-			}
-			workSubmitCmd := make(map[string]interface{})
-			workSubmitCmd["command"] = "work"
-			workSubmitCmd["subcommand"] = "results"
-			workSubmitCmd["unitid"] = remoteUnitID
-			workSubmitCmd["startpos"] = diskStdoutSize
-			if red.SignWork {
-				signature, err := rw.GetWorkceptor().createSignature(red.RemoteNode)
-				if err != nil {
-					rw.GetWorkceptor().nc.GetLogger().Error("could not create signature to get results")
-
-					return
-				}
-				workSubmitCmd["signature"] = signature
-			}
-			wscBytes, err := json.Marshal(workSubmitCmd)
-			if err != nil {
-				rw.GetWorkceptor().nc.GetLogger().Error("error constructing work results command: %s", err)
-
-				return
-			}
-			wscBytes = append(wscBytes, '\n')
-			_, err = conn.Write(wscBytes)
-			if err != nil {
-				rw.GetWorkceptor().nc.GetLogger().Warning("Write error sending to %s: %s\n", remoteNode, err)
-
+		caughtUp := true
+		for _, stream := range []string{StreamStdout, StreamStderr} {
+			diskSize := streamSize(rw.UnitDir(), stream)
+			if diskSize >= statusStreamSize(status, stream) {
 				continue
 			}
-			status, err := utils.ReadStringContext(mw, reader, '\n')
-			if err != nil {
-				rw.GetWorkceptor().nc.GetLogger().Warning("Read error reading from %s: %s\n", remoteNode, err)
-
-				continue
-			}
-			if !strings.Contains(status, "Streaming results") {
-				rw.GetWorkceptor().nc.GetLogger().Warning("Remote node %s did not stream results\n", remoteNode)
-
-				continue
-			}
-			stdout, err := os.OpenFile(rw.StdoutFileName(), os.O_CREATE+os.O_APPEND+os.O_WRONLY, 0o600)
-			if err != nil {
-				rw.GetWorkceptor().nc.GetLogger().Error("Could not open stdout file %s: %s\n", rw.StdoutFileName(), err)
-
+			caughtUp = false
+			if !rw.copyRemoteStream(mw, red, stream, diskSize) {
 				return
 			}
-			doneChan := make(chan struct{})
-			go func() {
-				select {
-				case <-doneChan:
-					return
-				case <-mw.Done():
-					cr, ok := conn.(interface{ CancelRead() })
-					if ok {
-						cr.CancelRead()
-					}
-					cerr := conn.(interface{ CloseConnection() error }).CloseConnection()
-					if cerr != nil {
-						rw.GetWorkceptor().nc.GetLogger().Error("Error closing connection to %s: %s", remoteUnitID, cerr)
-					}
-
-					return
-				}
-			}()
-			_, err = io.Copy(stdout, reader)
-			close(doneChan)
-			if err != nil {
-				var errmsg string
-				if strings.HasSuffix(err.Error(), "error code 499") {
-					errmsg = "read operation cancelled"
-				} else {
-					errmsg = err.Error()
-				}
-				rw.GetWorkceptor().nc.GetLogger().Warning("Could not copy to stdout file %s: %s\n", rw.StdoutFileName(), errmsg)
-
-				continue
-			}
+		}
+		if caughtUp && IsComplete(status.State) {
+			return
 		}
 	}
 }
@@ -531,7 +837,9 @@ func (rw *remoteUnit) Status() *StatusFileData {
 	if ok {
 		keysToDelete := make([]string, 0)
 		for k := range ed.RemoteParams {
-			if strings.HasPrefix(strings.ToLower(k), "secret_") {
+			lk := strings.ToLower(k)
+			if strings.HasPrefix(lk, "secret_") || strings.HasPrefix(lk, kubeSecretParamPrefix) ||
+				strings.HasPrefix(lk, runtimeEnvParamPrefix) {
 				keysToDelete = append(keysToDelete, k)
 			}
 		}
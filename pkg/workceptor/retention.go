@@ -0,0 +1,286 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// StdoutOverflowTruncate stops storing stdout once the maximum size is reached, and lets the unit run on.
+	StdoutOverflowTruncate = "truncate"
+	// StdoutOverflowFail cancels a unit and marks it failed once its stdout exceeds the maximum size.
+	StdoutOverflowFail = "fail"
+)
+
+// retentionScanInterval is how often work units are scanned to apply retention policies.
+var retentionScanInterval = 30 * time.Second
+
+// RetentionPolicy bounds the stdout kept by the units of a work type, and how long completed units are kept.
+type RetentionPolicy struct {
+	MaxStdoutSize   int64
+	FailOnMaxStdout bool
+	ReleaseAfter    time.Duration
+}
+
+// NewRetentionPolicy builds a retention policy from its configuration values.
+func NewRetentionPolicy(maxStdoutSize int64, stdoutOverflow string, releaseAfter string) (*RetentionPolicy, error) {
+	if maxStdoutSize < 0 {
+		return nil, fmt.Errorf("maximum stdout size must not be negative")
+	}
+	rp := &RetentionPolicy{
+		MaxStdoutSize: maxStdoutSize,
+	}
+	switch strings.ToLower(stdoutOverflow) {
+	case "", StdoutOverflowTruncate:
+	case StdoutOverflowFail:
+		rp.FailOnMaxStdout = true
+	default:
+		return nil, fmt.Errorf("stdout overflow must be %s or %s", StdoutOverflowTruncate, StdoutOverflowFail)
+	}
+	if releaseAfter != "" {
+		duration, err := time.ParseDuration(releaseAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid release after duration %s: %s", releaseAfter, err)
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("release after duration must be positive")
+		}
+		rp.ReleaseAfter = duration
+	}
+
+	return rp, nil
+}
+
+// SetRetentionPolicy sets the retention policy of a registered work type.
+func (w *Workceptor) SetRetentionPolicy(typeName string, policy *RetentionPolicy) error {
+	w.workTypesLock.Lock()
+	defer w.workTypesLock.Unlock()
+	wt, ok := w.workTypes[typeName]
+	if !ok {
+		return fmt.Errorf("unknown work type %s", typeName)
+	}
+	wt.retention = policy
+
+	return nil
+}
+
+// retentionPolicy returns the retention policy of a work type, or nil if it has none.
+func (w *Workceptor) retentionPolicy(typeName string) *RetentionPolicy {
+	w.workTypesLock.RLock()
+	defer w.workTypesLock.RUnlock()
+	wt, ok := w.workTypes[typeName]
+	if !ok {
+		return nil
+	}
+
+	return wt.retention
+}
+
+// stdoutLimit returns the number of bytes of stdout that a unit should store, or 0 if there is no limit, and the
+// function to call once its stdout exceeds that, or nil if the rest of its stdout should just be discarded.
+func (w *Workceptor) stdoutLimit(unit WorkUnit) (int64, func()) {
+	rp := w.retentionPolicy(unit.Status().WorkType)
+	if rp == nil {
+		return 0, nil
+	}
+	if !rp.FailOnMaxStdout {
+		return rp.MaxStdoutSize, nil
+	}
+
+	return rp.MaxStdoutSize, func() {
+		w.failStdoutExceeded(unit, rp.MaxStdoutSize)
+	}
+}
+
+// failStdoutExceeded cancels a unit whose stdout exceeded the maximum size, and marks it failed.
+func (w *Workceptor) failStdoutExceeded(unit WorkUnit, maxSize int64) {
+	w.nc.GetLogger().Warning("Work unit %s exceeded the maximum stdout size of %d bytes", unit.ID(), maxSize)
+	err := unit.Cancel()
+	if err != nil {
+		w.nc.GetLogger().Error("Error cancelling work unit %s: %s", unit.ID(), err)
+	}
+	unit.UpdateBasicStatus(WorkStateFailed, stdoutExceededDetail(maxSize), -1)
+}
+
+// stdoutExceededDetail is the status detail of a unit failed because its stdout exceeded the maximum size.
+func stdoutExceededDetail(maxSize int64) string {
+	return fmt.Sprintf("Stdout exceeded the maximum size of %d bytes", maxSize)
+}
+
+// monitorRetention periodically rescans the work units, applies retention policies to them, and fails units whose
+// resumable upload has stalled.
+func (w *Workceptor) monitorRetention() {
+	for {
+		if sleepOrDone(w.ctx.Done(), retentionScanInterval) {
+			return
+		}
+		w.scanForUnits()
+		w.applyRetention()
+		w.expireUploads()
+	}
+}
+
+// applyRetention fails units whose stdout is too large, releases units whose retention period has expired,
+// and releases the oldest completed units while the data directory is over quota.
+func (w *Workceptor) applyRetention() {
+	w.activeUnitsLock.RLock()
+	units := make(map[string]WorkUnit, len(w.activeUnits))
+	for id, unit := range w.activeUnits {
+		units[id] = unit
+	}
+	w.activeUnitsLock.RUnlock()
+
+	type completedUnit struct {
+		unit      WorkUnit
+		completed time.Time
+	}
+	completedUnits := make([]completedUnit, 0)
+	for id, unit := range units {
+		status := unit.Status()
+		rp := w.retentionPolicy(status.WorkType)
+		if !IsComplete(status.State) {
+			if rp != nil && rp.FailOnMaxStdout && rp.MaxStdoutSize > 0 &&
+				streamSize(unit.UnitDir(), StreamStdout) > rp.MaxStdoutSize {
+				w.failStdoutExceeded(unit, rp.MaxStdoutSize)
+			}
+
+			continue
+		}
+		completed := unitCompletionTime(unit, status)
+		if rp != nil && rp.ReleaseAfter > 0 && time.Since(completed) >= rp.ReleaseAfter {
+			w.nc.GetLogger().Info("Releasing work unit %s, which completed more than %s ago", id, rp.ReleaseAfter)
+			err := unit.Release(false)
+			if err != nil {
+				w.nc.GetLogger().Error("Error releasing work unit %s: %s", id, err)
+			}
+
+			continue
+		}
+		completedUnits = append(completedUnits, completedUnit{unit: unit, completed: completed})
+	}
+
+	if w.DataDirQuota <= 0 {
+		return
+	}
+	used := dirSize(w.dataDir)
+	if used <= w.DataDirQuota {
+		return
+	}
+	sort.Slice(completedUnits, func(i, j int) bool {
+		return completedUnits[i].completed.Before(completedUnits[j].completed)
+	})
+	for _, cu := range completedUnits {
+		if used <= w.DataDirQuota {
+			return
+		}
+		size := dirSize(cu.unit.UnitDir())
+		w.nc.GetLogger().Info("Releasing work unit %s to keep the data directory within its quota of %d bytes",
+			cu.unit.ID(), w.DataDirQuota)
+		err := cu.unit.Release(false)
+		if err != nil {
+			w.nc.GetLogger().Error("Error releasing work unit %s: %s", cu.unit.ID(), err)
+
+			continue
+		}
+		used -= size
+	}
+	if used > w.DataDirQuota {
+		w.nc.GetLogger().Warning("Data directory uses %d bytes, which exceeds its quota of %d bytes, "+
+			"but there are no more completed units to release", used, w.DataDirQuota)
+	}
+}
+
+// unitCompletionTime returns when a completed unit finished, falling back to the time its status was last written.
+func unitCompletionTime(unit WorkUnit, status *StatusFileData) time.Time {
+	if !status.EndTime.IsZero() {
+		return status.EndTime
+	}
+	fi, err := os.Stat(unit.StatusFileName())
+	if err != nil {
+		return time.Now()
+	}
+
+	return fi.ModTime()
+}
+
+// dirSize returns the total size of the files under a directory.
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			fi, err := d.Info()
+			if err == nil {
+				size += fi.Size()
+			}
+		}
+
+		return nil
+	})
+
+	return size
+}
+
+// errStdoutExceeded is returned by writers of stdout once the maximum size is exceeded, if that fails the unit.
+var errStdoutExceeded = errors.New("stdout exceeded the maximum size")
+
+// limitWriter stores at most a fixed number of bytes.  Unless it was given a function to call once the limit is
+// exceeded, it silently discards the rest so the producer can carry on.
+type limitWriter struct {
+	io.WriteCloser
+	remaining int64
+	onExceed  func()
+	exceeded  bool
+}
+
+// newLimitWriter wraps a writer so it stores at most limit bytes, or returns it unchanged if limit is 0.  If onExceed
+// is not nil, it is called once more than limit bytes have been written, and further writes fail.
+func newLimitWriter(w io.WriteCloser, limit int64, onExceed func()) io.WriteCloser {
+	if limit <= 0 {
+		return w
+	}
+
+	return &limitWriter{WriteCloser: w, remaining: limit, onExceed: onExceed}
+}
+
+// Write implements io.Writer.  Data beyond the limit is reported as written, or fails with errStdoutExceeded if
+// exceeding the limit is a failure.
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	keep := p
+	if int64(len(keep)) > lw.remaining {
+		keep = keep[:lw.remaining]
+	}
+	n := 0
+	if len(keep) > 0 {
+		var err error
+		n, err = lw.WriteCloser.Write(keep)
+		lw.remaining -= int64(n)
+		if err != nil {
+			return n, err
+		}
+	}
+	if len(keep) == len(p) {
+		return n, nil
+	}
+	if lw.onExceed == nil {
+		return len(p), nil
+	}
+	if !lw.exceeded {
+		lw.exceeded = true
+		lw.onExceed()
+	}
+
+	return n, errStdoutExceeded
+}
@@ -0,0 +1,161 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestNewRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxStdoutSize  int64
+		stdoutOverflow string
+		releaseAfter   string
+		want           RetentionPolicy
+		wantErr        bool
+	}{
+		{name: "defaults", want: RetentionPolicy{}},
+		{name: "truncate", maxStdoutSize: 100, stdoutOverflow: "truncate", want: RetentionPolicy{MaxStdoutSize: 100}},
+		{name: "fail", maxStdoutSize: 100, stdoutOverflow: "FAIL", want: RetentionPolicy{MaxStdoutSize: 100, FailOnMaxStdout: true}},
+		{name: "release after", releaseAfter: "24h", want: RetentionPolicy{ReleaseAfter: 24 * time.Hour}},
+		{name: "bad overflow", stdoutOverflow: "rotate", wantErr: true},
+		{name: "negative size", maxStdoutSize: -1, wantErr: true},
+		{name: "bad duration", releaseAfter: "tomorrow", wantErr: true},
+		{name: "zero duration", releaseAfter: "0s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRetentionPolicy(tt.maxStdoutSize, tt.stdoutOverflow, tt.releaseAfter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewRetentionPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("NewRetentionPolicy() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitWriter(t *testing.T) {
+	filename := path.Join(t.TempDir(), StreamStdout)
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lw := newLimitWriter(f, 5, nil)
+	for _, s := range []string{"abc", "defgh", "ijk"} {
+		n, err := lw.Write([]byte(s))
+		if err != nil || n != len(s) {
+			t.Fatalf("expected %d bytes written, got %d, %v", len(s), n, err)
+		}
+	}
+	err = lw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abcde" {
+		t.Errorf("expected stored data abcde, got %s", data)
+	}
+}
+
+func TestLimitWriterFail(t *testing.T) {
+	filename := path.Join(t.TempDir(), StreamStdout)
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exceeded := 0
+	lw := newLimitWriter(f, 5, func() {
+		exceeded++
+	})
+	_, err = lw.Write([]byte("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = lw.Write([]byte("defgh"))
+		if !errors.Is(err, errStdoutExceeded) {
+			t.Fatalf("expected errStdoutExceeded, got %v", err)
+		}
+	}
+	if exceeded != 1 {
+		t.Errorf("expected the limit to be reported exceeded once, got %d", exceeded)
+	}
+	err = lw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "abcde" {
+		t.Errorf("expected stored data abcde, got %s", data)
+	}
+}
+
+func newRetentionTestUnit(t *testing.T, w *Workceptor, completed time.Time, stdout int) WorkUnit {
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path.Join(unit.UnitDir(), StreamStdout), make([]byte, stdout), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateFullStatus(func(status *StatusFileData) {
+		status.State = WorkStateSucceeded
+		status.StdoutSize = int64(stdout)
+		status.EndTime = completed
+	})
+
+	return unit
+}
+
+func TestApplyRetention(t *testing.T) {
+	nc := netceptor.New(context.Background(), "test")
+	w, err := New(context.Background(), nc, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	err = w.RegisterWorker("command", newCommandWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.SetRetentionPolicy("command", &RetentionPolicy{ReleaseAfter: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := newRetentionTestUnit(t, w, time.Now().Add(-2*time.Hour), 10)
+	oldest := newRetentionTestUnit(t, w, time.Now().Add(-30*time.Minute), 4000)
+	newest := newRetentionTestUnit(t, w, time.Now().Add(-10*time.Minute), 4000)
+
+	w.DataDirQuota = 6000
+	w.applyRetention()
+
+	for _, unit := range []WorkUnit{expired, oldest} {
+		if _, err := w.findUnit(unit.ID()); err == nil {
+			t.Errorf("expected unit %s to be released", unit.ID())
+		}
+		if _, err := os.Stat(unit.UnitDir()); !os.IsNotExist(err) {
+			t.Errorf("expected unit directory %s to be removed", unit.UnitDir())
+		}
+	}
+	if _, err := w.findUnit(newest.ID()); err != nil {
+		t.Errorf("expected unit %s to be kept: %s", newest.ID(), err)
+	}
+}
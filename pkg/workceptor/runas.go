@@ -0,0 +1,190 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// WorkDirEnv is the environment variable giving a command run as another user or in a sandbox the directory it can
+// write to.
+const WorkDirEnv = "RECEPTOR_WORK_DIR"
+
+// workDirName is the directory in a unit directory owned by the user a command is run as.
+const workDirName = "work"
+
+// runAsUser is a user, other than the one running Receptor, that a command is run as.
+type runAsUser struct {
+	UID  uint32
+	GID  uint32
+	Name string
+	Home string
+}
+
+// lookupRunAsUser resolves a user given by name or uid, and a group given by name or gid.  Without a group, the
+// primary group of the user is used.  A uid that is not known to the system can only be used with a group.
+func lookupRunAsUser(userSpec string, groupSpec string) (*runAsUser, error) {
+	ru := &runAsUser{}
+	haveGID := false
+	u, err := user.Lookup(userSpec)
+	if err != nil {
+		u, err = user.LookupId(userSpec)
+	}
+	switch {
+	case err == nil:
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("user %s has non-numeric uid %s", userSpec, u.Uid)
+		}
+		gid, err := strconv.ParseUint(u.Gid, 10, 32)
+		if err == nil {
+			ru.GID = uint32(gid)
+			haveGID = true
+		}
+		ru.UID = uint32(uid)
+		ru.Name = u.Username
+		ru.Home = u.HomeDir
+	default:
+		uid, perr := strconv.ParseUint(userSpec, 10, 32)
+		if perr != nil {
+			return nil, fmt.Errorf("unknown user %s", userSpec)
+		}
+		ru.UID = uint32(uid)
+	}
+	if groupSpec != "" {
+		g, err := user.LookupGroup(groupSpec)
+		if err != nil {
+			g, err = user.LookupGroupId(groupSpec)
+		}
+		var gid uint64
+		if err == nil {
+			gid, err = strconv.ParseUint(g.Gid, 10, 32)
+		} else {
+			gid, err = strconv.ParseUint(groupSpec, 10, 32)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unknown group %s", groupSpec)
+		}
+		ru.GID = uint32(gid)
+		haveGID = true
+	}
+	if !haveGID {
+		return nil, fmt.Errorf("a group is required to run as uid %s", userSpec)
+	}
+	if ru.UID == 0 {
+		return nil, fmt.Errorf("commands cannot be run as root")
+	}
+
+	return ru, nil
+}
+
+// String returns the user as uid:gid, as passed to the command runner.
+func (ru *runAsUser) String() string {
+	return fmt.Sprintf("%d:%d", ru.UID, ru.GID)
+}
+
+// parseRunAs parses a uid:gid pair.
+func parseRunAs(runAs string) (uint32, uint32, error) {
+	uidStr, gidStr, ok := strings.Cut(runAs, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("run as user %s must be in uid:gid format", runAs)
+	}
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid %s", uidStr)
+	}
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid gid %s", gidStr)
+	}
+
+	return uint32(uid), uint32(gid), nil
+}
+
+// env returns the environment variables identifying the user to a command.
+func (ru *runAsUser) env(unitdir string) map[string]string {
+	env := map[string]string{
+		WorkDirEnv: path.Join(unitdir, workDirName),
+	}
+	if ru.Name != "" {
+		env["USER"] = ru.Name
+		env["LOGNAME"] = ru.Name
+	}
+	if ru.Home != "" {
+		env["HOME"] = ru.Home
+	}
+
+	return env
+}
+
+// prepareUnitDir gives the user access to the input files of a unit and a work directory of its own.  The unit
+// directory stays owned by Receptor; it and the node data directory are only made traversable by others.  The
+// directories above the node data directory are not Receptor's to change, so they must already be traversable.
+func (ru *runAsUser) prepareUnitDir(dataDir string, unitdir string) error {
+	for dir := path.Dir(dataDir); ; dir = path.Dir(dir) {
+		if err := checkTraverse(dir); err != nil {
+			return err
+		}
+		if dir == path.Dir(dir) {
+			break
+		}
+	}
+	for _, dir := range []string{dataDir, unitdir} {
+		if err := addTraverse(dir); err != nil {
+			return err
+		}
+	}
+	inputs, err := listInputFiles(unitdir)
+	if err != nil {
+		return err
+	}
+	if len(inputs) > 0 {
+		if err := addTraverse(path.Join(unitdir, inputsDirName)); err != nil {
+			return err
+		}
+	}
+	for _, f := range inputs {
+		if err := os.Lchown(path.Join(unitdir, inputsDirName, f.Name), int(ru.UID), int(ru.GID)); err != nil {
+			return err
+		}
+	}
+	workDir := path.Join(unitdir, workDirName)
+	err = os.Mkdir(workDir, 0o700)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	return os.Lchown(workDir, int(ru.UID), int(ru.GID))
+}
+
+// checkTraverse returns an error unless others can traverse a directory.
+func checkTraverse(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0o001 == 0 {
+		return fmt.Errorf("%s must be traversable by other users to run commands as another user", dir)
+	}
+
+	return nil
+}
+
+// addTraverse allows others to traverse, but not list, a directory.
+func addTraverse(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0o001 != 0 {
+		return nil
+	}
+
+	return os.Chmod(dir, fi.Mode().Perm()|0o001)
+}
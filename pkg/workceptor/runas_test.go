@@ -0,0 +1,127 @@
+//go:build !windows && !no_workceptor
+// +build !windows,!no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func TestLookupRunAsUser(t *testing.T) {
+	ru, err := lookupRunAsUser("54321", "54322")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ru.String() != "54321:54322" {
+		t.Errorf("unexpected user %s", ru)
+	}
+	uid, gid, err := parseRunAs(ru.String())
+	if err != nil || uid != 54321 || gid != 54322 {
+		t.Errorf("parseRunAs returned %d, %d, %v", uid, gid, err)
+	}
+	for _, spec := range [][2]string{{"54321", ""}, {"no-such-user-here", ""}, {"0", "0"}, {"54321", "no-such-group-here"}} {
+		if _, err := lookupRunAsUser(spec[0], spec[1]); err == nil {
+			t.Errorf("expected error for user %q group %q", spec[0], spec[1])
+		}
+	}
+	if _, _, err := parseRunAs("54321"); err == nil {
+		t.Error("expected error for run as user without a gid")
+	}
+}
+
+func TestRunAsUserParam(t *testing.T) {
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	cfg := CommandWorkerCfg{Command: "echo", User: "54321", Group: "54321", AllowedUsers: []string{"54322"}}
+	err = w.RegisterWorker("command", cfg.NewWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("command", map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user := unit.Status().ExtraData.(*CommandExtraData).User; user != "54321" {
+		t.Errorf("expected the configured user, got %q", user)
+	}
+	unit, err = w.AllocateUnit("command", map[string]string{"user": "54322"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user := unit.Status().ExtraData.(*CommandExtraData).User; user != "54322" {
+		t.Errorf("expected the requested user, got %q", user)
+	}
+	if _, err := w.AllocateUnit("command", map[string]string{"user": "54323"}); err == nil {
+		t.Error("expected error for a user that is not allowed")
+	}
+}
+
+func TestPrepareUnitDir(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing file ownership requires root")
+	}
+	base := t.TempDir()
+	parent := path.Join(base, "data")
+	dataDir := path.Join(parent, "node")
+	unitdir := path.Join(dataDir, "unit")
+	if err := os.MkdirAll(path.Join(unitdir, inputsDirName), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(unitdir, inputsDirName, "data"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ru := &runAsUser{UID: 54321, GID: 54322}
+	// The directories made by the test are not traversable by others, unlike the ones above them.
+	for _, dir := range []string{path.Dir(base), base} {
+		if err := os.Chmod(dir, 0o711); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ru.prepareUnitDir(dataDir, unitdir); err == nil {
+		t.Fatal("expected error for a data directory whose parent is not traversable")
+	}
+	fi, err := os.Stat(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o700 {
+		t.Errorf("the parent of the data directory was changed to %o", fi.Mode().Perm())
+	}
+	if err := os.Chmod(parent, 0o711); err != nil {
+		t.Fatal(err)
+	}
+	if err := ru.prepareUnitDir(dataDir, unitdir); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{dataDir, unitdir, path.Join(unitdir, inputsDirName)} {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm()&0o001 == 0 {
+			t.Errorf("%s is not traversable", dir)
+		}
+		if fi.Sys().(*syscall.Stat_t).Uid == ru.UID {
+			t.Errorf("%s should not be owned by the user", dir)
+		}
+	}
+	for _, owned := range []string{path.Join(unitdir, inputsDirName, "data"), path.Join(unitdir, workDirName)} {
+		fi, err := os.Stat(owned)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != ru.UID || st.Gid != ru.GID {
+			t.Errorf("%s is owned by %d:%d", owned, st.Uid, st.Gid)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ansible/receptor/pkg/certificates"
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// WorkClaims are the claims of the JWT signing a work command.  The issuer is the node ID of the signer, and the
+// audience the node ID of the node the command is sent to.
+type WorkClaims struct {
+	WorkType      string `json:"worktype,omitempty"`
+	PayloadSHA256 string `json:"payload_sha256,omitempty"`
+	StdinSHA256   string `json:"stdin_sha256,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// workCommandSHA256 returns the payload hash signed for a work command, which binds the signature to the subcommand
+// and to the unit, or for work submissions the work type, that it applies to.
+func workCommandSHA256(subcommand string, target string) string {
+	digest := sha256.Sum256([]byte(subcommand + "\n" + target))
+
+	return hex.EncodeToString(digest[:])
+}
+
+// workSubmitSHA256 returns the payload hash signed for a work submission, which binds the signature to the work type
+// and every parameter of the submit command except the signature itself.
+func workSubmitSHA256(params map[string]string) (string, error) {
+	signed := make(map[string]string, len(params))
+	for k, v := range params {
+		if k != "signature" {
+			signed[k] = v
+		}
+	}
+	// encoding/json sorts map keys, so this is the same for the sender and the receiver.
+	paramBytes, err := json.Marshal(signed)
+	if err != nil {
+		return "", err
+	}
+
+	return workCommandSHA256("submit", string(paramBytes)), nil
+}
+
+// workSelectorTarget returns the target signed for a command on several units, which binds the signature to the unit
+// IDs and selectors of the command as they were sent, rather than to each of the units they select.
+func workSelectorTarget(config map[string]interface{}) (string, error) {
+	selector := make(map[string]interface{})
+	for _, name := range append([]string{"unitid", "unitids"}, filterOptionNames...) {
+		if value, ok := config[name]; ok {
+			selector[name] = value
+		}
+	}
+	selectorBytes, err := json.Marshal(selector)
+	if err != nil {
+		return "", err
+	}
+
+	return string(selectorBytes), nil
+}
+
+// submitStdinSHA256 returns the hash of the data a remote unit sends after its submit command: its input files,
+// followed by its stdin.
+func submitStdinSHA256(unitdir string, files []InputFile) (string, error) {
+	hash := sha256.New()
+	err := sendInputFiles(hash, unitdir, files)
+	if err != nil {
+		return "", err
+	}
+	stdin, err := os.Open(path.Join(unitdir, "stdin"))
+	if err != nil {
+		return "", err
+	}
+	defer stdin.Close()
+	_, err = io.Copy(hash, stdin)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// signingMethodFor returns the JWT signing method used with a private or public key.
+func signingMethodFor(key interface{}) (jwt.SigningMethod, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return jwt.SigningMethodRS512, nil
+	case *ecdsa.PrivateKey:
+		return ecdsaSigningMethod(k.Curve.Params().BitSize)
+	case *ecdsa.PublicKey:
+		return ecdsaSigningMethod(k.Curve.Params().BitSize)
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// ecdsaSigningMethod returns the JWT signing method for an ECDSA curve.
+func ecdsaSigningMethod(bitSize int) (jwt.SigningMethod, error) {
+	switch bitSize {
+	case 256:
+		return jwt.SigningMethodES256, nil
+	case 384:
+		return jwt.SigningMethodES384, nil
+	case 521:
+		return jwt.SigningMethodES512, nil
+	}
+
+	return nil, fmt.Errorf("unsupported ECDSA curve of %d bits", bitSize)
+}
+
+// loadKeyFile loads a single private or public key from a PEM file.
+func loadKeyFile(filename string, private bool) (interface{}, error) {
+	data, err := certificates.LoadFromPEMFile(filename, &certificates.OsWrapper{})
+	if err != nil {
+		return nil, err
+	}
+	kind := "public"
+	if private {
+		kind = "private"
+	}
+	if len(data) != 1 {
+		return nil, fmt.Errorf("%s key file should contain exactly one item", kind)
+	}
+	key := data[0]
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		if private {
+			return key, nil
+		}
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		if !private {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s key file does not contain %s key data", kind, kind)
+}
+
+// verifyingKeyFunc returns a function giving a public key to the JWT parser, which rejects tokens signed with a
+// method other than the one of the key.
+func verifyingKeyFunc(key interface{}) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		method, err := signingMethodFor(key)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Header["alg"])
+		}
+
+		return key, nil
+	}
+}
+
+// keyCache holds the keys loaded from files.  The directories of the files are watched, and the keys of a directory
+// are dropped when anything in it changes, so that a replaced key is loaded again when it is next used.
+type keyCache struct {
+	ctx     context.Context
+	lock    sync.Mutex
+	keys    map[string]interface{}
+	gens    map[string]int
+	watcher *fsnotify.Watcher
+	watched map[string]bool
+}
+
+func newKeyCache(ctx context.Context) *keyCache {
+	return &keyCache{
+		ctx:     ctx,
+		keys:    make(map[string]interface{}),
+		gens:    make(map[string]int),
+		watched: make(map[string]bool),
+	}
+}
+
+// watch starts watching the directory of a key file, and returns false if it cannot be watched.  The lock must be
+// held.
+func (kc *keyCache) watch(dir string) bool {
+	if kc.watched[dir] {
+		return true
+	}
+	if kc.watcher == nil {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return false
+		}
+		kc.watcher = watcher
+		go kc.run(watcher)
+	}
+	if err := kc.watcher.Add(dir); err != nil {
+		return false
+	}
+	kc.watched[dir] = true
+
+	return true
+}
+
+// run drops the keys of a directory whenever a change to it is seen, until the context is done.
+func (kc *keyCache) run(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+	for {
+		select {
+		case <-kc.ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			dir := filepath.Dir(event.Name)
+			kc.lock.Lock()
+			kc.gens[dir]++
+			for filename := range kc.keys {
+				if filepath.Dir(filename) == dir {
+					delete(kc.keys, filename)
+				}
+			}
+			kc.lock.Unlock()
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// load returns the key in a file, loading it if it is not cached.  Keys whose directory cannot be watched are loaded
+// on every use.
+func (kc *keyCache) load(filename string, private bool) (interface{}, error) {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(filename)
+	kc.lock.Lock()
+	key, ok := kc.keys[filename]
+	cacheable := ok || kc.watch(dir)
+	gen := kc.gens[dir]
+	kc.lock.Unlock()
+	if ok {
+		return key, nil
+	}
+	key, err = loadKeyFile(filename, private)
+	if err != nil {
+		return nil, err
+	}
+	kc.lock.Lock()
+	if cacheable && kc.gens[dir] == gen {
+		kc.keys[filename] = key
+	}
+	kc.lock.Unlock()
+
+	return key, nil
+}
+
+// hasVerifyingKeys returns true if a key to verify signed work is configured.
+func (w *Workceptor) hasVerifyingKeys() bool {
+	return w.VerifyingKey != "" || len(w.VerifyingKeys) > 0
+}
+
+// verifyingKeyFiles returns the files of the verifying keys a signature is checked against: the key with the key ID
+// in its kid header, or all keys if it has none.  A publickey configured without a key ID matches any key ID.
+func (w *Workceptor) verifyingKeyFiles(signature string) ([]string, error) {
+	kid := ""
+	token, _, err := jwt.NewParser().ParseUnverified(signature, &WorkClaims{})
+	if err == nil {
+		kid, _ = token.Header["kid"].(string)
+	}
+	files := make([]string, 0)
+	if w.VerifyingKey != "" && (kid == "" || w.VerifyingKeyID == "" || kid == w.VerifyingKeyID) {
+		files = append(files, w.VerifyingKey)
+	}
+	if file, ok := w.VerifyingKeys[kid]; ok && kid != "" {
+		files = append(files, file)
+	}
+	if kid == "" {
+		ids := make([]string, 0, len(w.VerifyingKeys))
+		for id := range w.VerifyingKeys {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			files = append(files, w.VerifyingKeys[id])
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("could not verify signature: unknown key ID %s", kid)
+	}
+
+	return files, nil
+}
+
+// verifyWorkSignature checks the signature of a work command against the verifying keys and the node ID of this
+// node, and that its claims allow the command.  An empty workType or payloadSHA256 is not checked, and neither are
+// the corresponding claims if the signer did not set them, unless claims are required.
+func (w *Workceptor) verifyWorkSignature(signature string, workType string, payloadSHA256 string) error {
+	_, err := w.verifyWorkClaims(signature, workType, payloadSHA256)
+
+	return err
+}
+
+// verifyWorkClaims is verifyWorkSignature, returning the claims of the signature if it is valid.
+func (w *Workceptor) verifyWorkClaims(signature string, workType string, payloadSHA256 string) (*WorkClaims, error) {
+	if signature == "" {
+		return nil, fmt.Errorf("could not verify signature: signature is empty")
+	}
+	if !w.hasVerifyingKeys() {
+		return nil, fmt.Errorf("could not verify signature: verifying key not specified")
+	}
+	files, err := w.verifyingKeyFiles(signature)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, 0, len(files))
+	var loadErr error
+	for _, file := range files {
+		key, err := w.keys.load(file, false)
+		if err != nil {
+			if loadErr == nil {
+				loadErr = fmt.Errorf("could not load verifying key file: %s", err.Error())
+			}
+
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, loadErr
+	}
+	var claims *WorkClaims
+	for _, key := range keys {
+		claims = &WorkClaims{}
+		var token *jwt.Token
+		token, err = jwt.ParseWithClaims(signature, claims, verifyingKeyFunc(key))
+		if err == nil && !token.Valid {
+			err = fmt.Errorf("token not valid")
+		}
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not verify signature: %s", err.Error())
+	}
+	err = w.checkWorkClaims(claims, workType, payloadSHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// checkWorkClaims checks the claims of a verified work signature.
+func (w *Workceptor) checkWorkClaims(claims *WorkClaims, workType string, payloadSHA256 string) error {
+	if !claims.VerifyAudience(w.nodeID, true) {
+		return fmt.Errorf("token audience did not match node ID")
+	}
+	if !w.AllowLegacySignatures && (claims.Issuer == "" || claims.WorkType == "" || claims.PayloadSHA256 == "") {
+		return fmt.Errorf("token is missing the issuer, worktype or payload_sha256 claim")
+	}
+	if len(w.VerifyingIssuers) > 0 {
+		allowed := false
+		for _, issuer := range w.VerifyingIssuers {
+			if claims.Issuer == issuer {
+				allowed = true
+
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("token issuer %q is not allowed to sign work", claims.Issuer)
+		}
+	}
+	if workType != "" && claims.WorkType != "" && claims.WorkType != workType {
+		return fmt.Errorf("token was issued for work type %s, not %s", claims.WorkType, workType)
+	}
+	if payloadSHA256 != "" && claims.PayloadSHA256 != "" && claims.PayloadSHA256 != payloadSHA256 {
+		return fmt.Errorf("token was issued for a different command")
+	}
+
+	return nil
+}
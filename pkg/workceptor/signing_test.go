@@ -0,0 +1,244 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+// writeKeyPair writes a private key, in SEC1 format for ECDSA keys and PKCS8 otherwise, and its public key to dir.
+func writeKeyPair(t *testing.T, dir string, name string, private interface{}, public interface{}) (string, string) {
+	var block *pem.Block
+	if ecKey, ok := private.(*ecdsa.PrivateKey); ok {
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateFile := path.Join(dir, name+".pem")
+	publicFile := path.Join(dir, name+".pub")
+	if err := os.WriteFile(privateFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return privateFile, publicFile
+}
+
+func newSigningTestWorkceptor(t *testing.T, nodeID string) *Workceptor {
+	w, err := New(context.Background(), netceptor.New(context.Background(), nodeID), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Cancel)
+
+	return w
+}
+
+func TestWorkSignatureKeyTypes(t *testing.T) {
+	dir := t.TempDir()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPrivate, rsaPublic := writeTestSigningKeys(t)
+	ecPrivate, ecPublic := writeKeyPair(t, dir, "ec", ecKey, &ecKey.PublicKey)
+	edPrivateFile, edPublicFile := writeKeyPair(t, dir, "ed", edPrivate, edPublic)
+
+	signer := newSigningTestWorkceptor(t, "signer")
+	verifier := newSigningTestWorkceptor(t, "verifier")
+	for _, pair := range [][2]string{{rsaPrivate, rsaPublic}, {ecPrivate, ecPublic}, {edPrivateFile, edPublicFile}} {
+		signer.SigningKey = pair[0]
+		verifier.VerifyingKey = pair[1]
+		payload := workCommandSHA256("results", "unit1")
+		signature, err := signer.createSignature("verifier", "echo", payload)
+		if err != nil {
+			t.Fatalf("%s: %s", pair[0], err)
+		}
+		if err := verifier.verifyWorkSignature(signature, "echo", payload); err != nil {
+			t.Errorf("%s: %s", pair[0], err)
+		}
+	}
+
+	// a token must be verified with a key of the type it was signed with
+	verifier.VerifyingKey = rsaPublic
+	signer.SigningKey = ecPrivate
+	signature, err := signer.createSignature("verifier", "echo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifySignature(signature); err == nil || !strings.Contains(err.Error(), "unexpected signing method") {
+		t.Errorf("expected an unexpected signing method error, got %v", err)
+	}
+}
+
+func TestWorkSignatureClaims(t *testing.T) {
+	private, public := writeTestSigningKeys(t)
+	signer := newSigningTestWorkceptor(t, "signer")
+	signer.SigningKey = private
+	verifier := newSigningTestWorkceptor(t, "verifier")
+	verifier.VerifyingKey = public
+
+	payload := workCommandSHA256("release", "unit1")
+	signature, err := signer.createSignature("verifier", "echo", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		workType string
+		payload  string
+		issuers  []string
+		errorMsg string
+	}{
+		{name: "matching claims", workType: "echo", payload: payload},
+		{name: "allowed issuer", workType: "echo", payload: payload, issuers: []string{"other", "signer"}},
+		{name: "other work type", workType: "cat", payload: payload, errorMsg: "token was issued for work type echo, not cat"},
+		{name: "other unit", workType: "echo", payload: workCommandSHA256("release", "unit2"), errorMsg: "token was issued for a different command"},
+		{name: "other subcommand", workType: "echo", payload: workCommandSHA256("cancel", "unit1"), errorMsg: "token was issued for a different command"},
+		{name: "issuer not allowed", workType: "echo", payload: payload, issuers: []string{"other"}, errorMsg: `token issuer "signer" is not allowed to sign work`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier.VerifyingIssuers = tc.issuers
+			err := verifier.verifyWorkSignature(signature, tc.workType, tc.payload)
+			switch {
+			case tc.errorMsg == "" && err != nil:
+				t.Errorf("unexpected error %s", err)
+			case tc.errorMsg != "" && (err == nil || err.Error() != tc.errorMsg):
+				t.Errorf("expected error %s, got %v", tc.errorMsg, err)
+			}
+		})
+	}
+	verifier.VerifyingIssuers = nil
+
+	other := newSigningTestWorkceptor(t, "other")
+	other.VerifyingKey = public
+	if err := other.VerifySignature(signature); err == nil || err.Error() != "token audience did not match node ID" {
+		t.Errorf("expected an audience error, got %v", err)
+	}
+
+	signature, err = signer.createSignature("verifier", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.VerifySignature(signature); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("expected a missing claim error, got %v", err)
+	}
+	verifier.AllowLegacySignatures = true
+	if err := verifier.VerifySignature(signature); err != nil {
+		t.Errorf("expected a legacy signature to be allowed, got %v", err)
+	}
+}
+
+func TestWorkSignatureKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPrivate, oldPublic := writeKeyPair(t, dir, "old", oldKey, &oldKey.PublicKey)
+	newPrivate, newPublic := writeKeyPair(t, dir, "new", newKey, &newKey.PublicKey)
+
+	signer := newSigningTestWorkceptor(t, "signer")
+	verifier := newSigningTestWorkceptor(t, "verifier")
+	verifier.VerifyingKey = oldPublic
+	verifier.VerifyingKeyID = "old"
+	verifier.VerifyingKeys = map[string]string{"new": newPublic}
+
+	for _, tc := range []struct{ private, kid, errorMsg string }{
+		{private: oldPrivate, kid: "old"},
+		{private: newPrivate, kid: "new"},
+		{private: oldPrivate},
+		{private: newPrivate},
+		{private: newPrivate, kid: "old", errorMsg: "could not verify signature: crypto/ecdsa: verification error"},
+		{private: newPrivate, kid: "newer", errorMsg: "could not verify signature: unknown key ID newer"},
+	} {
+		signer.SigningKey = tc.private
+		signer.SigningKeyID = tc.kid
+		signature, err := signer.createSignature("verifier", "echo", workCommandSHA256("release", "unit1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = verifier.VerifySignature(signature)
+		switch {
+		case tc.errorMsg == "" && err != nil:
+			t.Errorf("kid %q: unexpected error %s", tc.kid, err)
+		case tc.errorMsg != "" && (err == nil || err.Error() != tc.errorMsg):
+			t.Errorf("kid %q: expected error %s, got %v", tc.kid, tc.errorMsg, err)
+		}
+	}
+}
+
+func TestKeyCacheReload(t *testing.T) {
+	dir := t.TempDir()
+	firstKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, public := writeKeyPair(t, dir, "key", firstKey, &firstKey.PublicKey)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kc := newKeyCache(ctx)
+	key, err := kc.load(public, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.(*ecdsa.PublicKey).Equal(&firstKey.PublicKey) {
+		t.Fatal("loaded the wrong key")
+	}
+	writeKeyPair(t, dir, "key", secondKey, &secondKey.PublicKey)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		key, err = kc.load(public, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.(*ecdsa.PublicKey).Equal(&secondKey.PublicKey) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the replaced key to be loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
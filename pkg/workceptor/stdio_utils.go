@@ -69,6 +69,9 @@ type STDoutWriter struct {
 	unitdir      string
 	writer       FileWriteCloser
 	bytesWritten int64
+	maxSize      int64
+	onExceed     func()
+	exceeded     bool
 }
 
 // NewStdoutWriter allocates a new stdoutWriter, which writes to both the stdout and status files.
@@ -85,10 +88,42 @@ func NewStdoutWriter(fs FileSystemer, unitdir string) (*STDoutWriter, error) {
 	}, nil
 }
 
+// NewAppendingStdoutWriter allocates a new stdoutWriter that adds to the end of an existing stdout file, for
+// resuming output from where it was left off.
+func NewAppendingStdoutWriter(fs FileSystemer, unitdir string) (*STDoutWriter, error) {
+	var size int64
+	info, err := fs.Stat(path.Join(unitdir, "stdout"))
+	switch {
+	case err == nil:
+		size = info.Size()
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	writer, err := fs.OpenFile(path.Join(unitdir, "stdout"), os.O_CREATE+os.O_WRONLY+os.O_APPEND+os.O_SYNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &STDoutWriter{
+		unitdir:      unitdir,
+		writer:       writer,
+		bytesWritten: size,
+	}, nil
+}
+
 // Write writes data to the stdout file and status file, implementing io.Writer.
+// Data beyond the maximum size, if one is set, is discarded, or fails with errStdoutExceeded if exceeding the
+// maximum size is a failure.
 func (sw *STDoutWriter) Write(p []byte) (n int, err error) {
-	wn, werr := sw.writer.Write(p)
-	var serr error
+	keep := p
+	if sw.maxSize > 0 && int64(len(keep)) > sw.maxSize-sw.bytesWritten {
+		keep = keep[:max(sw.maxSize-sw.bytesWritten, 0)]
+	}
+	var wn int
+	var werr, serr error
+	if len(keep) > 0 {
+		wn, werr = sw.writer.Write(keep)
+	}
 	if wn > 0 {
 		sw.bytesWritten += int64(wn)
 		serr = saveStdoutSize(sw.unitdir, sw.bytesWritten)
@@ -96,6 +131,17 @@ func (sw *STDoutWriter) Write(p []byte) (n int, err error) {
 	if werr != nil {
 		return wn, werr
 	}
+	if len(keep) < len(p) {
+		if sw.onExceed == nil {
+			return len(p), serr
+		}
+		if !sw.exceeded {
+			sw.exceeded = true
+			sw.onExceed()
+		}
+
+		return wn, errStdoutExceeded
+	}
 
 	return wn, serr
 }
@@ -105,6 +151,13 @@ func (sw *STDoutWriter) Size() int64 {
 	return sw.bytesWritten
 }
 
+// SetMaxSize sets the maximum number of bytes to store, or 0 for no limit, and the function to call once it is
+// exceeded, or nil to silently discard the rest.
+func (sw *STDoutWriter) SetMaxSize(maxSize int64, onExceed func()) {
+	sw.maxSize = maxSize
+	sw.onExceed = onExceed
+}
+
 // SetWriter sets the writer var.
 func (sw *STDoutWriter) SetWriter(writer FileWriteCloser) {
 	sw.writer = writer
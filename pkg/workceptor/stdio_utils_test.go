@@ -213,6 +213,30 @@ func TestNewStdoutWriter(t *testing.T) {
 	}
 }
 
+func TestNewAppendingStdoutWriter(t *testing.T) {
+	_, mockfilesystemer := setup(t)
+
+	mockfilesystemer.EXPECT().Stat(gomock.Any()).Return(NewInfo("stdout", 42, 0, time.Now()), nil)
+	mockfilesystemer.EXPECT().OpenFile(gomock.Any(), gomock.Any(), gomock.Any()).Return(&os.File{}, nil)
+	wc, err := workceptor.NewAppendingStdoutWriter(mockfilesystemer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wc.Size() != 42 {
+		t.Errorf("Expected size to be 42, got: %d", wc.Size())
+	}
+
+	mockfilesystemer.EXPECT().Stat(gomock.Any()).Return(nil, os.ErrNotExist)
+	mockfilesystemer.EXPECT().OpenFile(gomock.Any(), gomock.Any(), gomock.Any()).Return(&os.File{}, nil)
+	wc, err = workceptor.NewAppendingStdoutWriter(mockfilesystemer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wc.Size() != 0 {
+		t.Errorf("Expected size to be 0, got: %d", wc.Size())
+	}
+}
+
 func checkErrorsReader(err error, expectedStatErr string, expectedOpenErr string, expectedStatSize int, t *testing.T) {
 	switch {
 	case expectedStatErr == "" && expectedOpenErr == "" && expectedStatSize > 0 && err != nil:
@@ -0,0 +1,356 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ansible/receptor/pkg/controlsvc"
+)
+
+// uploadFileName is the file in a unit directory receiving the input data of a resumable submission.  Once all of
+// the data has arrived, it is split into the input files and stdin of the unit, and removed.
+const uploadFileName = "upload"
+
+// uploadStateFileName is the file in a unit directory describing the input data a resumable submission expects.
+const uploadStateFileName = "upload.json"
+
+// uploadIdleTimeout is how long a unit waits for more of the input data of a resumable submission, while no upload to
+// it is in progress, before it is failed.  It outlasts the attempts of a remote sender to reconnect and resume.
+var uploadIdleTimeout = 15 * time.Minute
+
+// errInputMismatch is returned when the input data of a unit does not match the hash in the signature of its
+// submission.
+var errInputMismatch = fmt.Errorf("input data does not match the signature")
+
+// uploadState is the input data a resumable submission expects: its size, the manifest of the input files it starts
+// with, and the signed hash it must match, if any.
+type uploadState struct {
+	Size        int64
+	InputFiles  string `json:",omitempty"`
+	StdinSHA256 string `json:",omitempty"`
+}
+
+// writeUploadState marks a unit as waiting for the input data of a resumable submission.
+func writeUploadState(unitdir string, state *uploadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path.Join(unitdir, uploadStateFileName), data, 0o600)
+}
+
+// readUploadState returns the input data a unit is waiting for, or an error if it is not waiting for a resumable
+// upload.
+func readUploadState(unitdir string, unitID string) (*uploadState, error) {
+	data, err := os.ReadFile(path.Join(unitdir, uploadStateFileName))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("unit %s is not waiting for input data", unitID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &uploadState{}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, fmt.Errorf("error reading upload state of unit %s: %s", unitID, err)
+	}
+
+	return state, nil
+}
+
+// uploadReceived returns the number of bytes of a resumable upload received so far.
+func uploadReceived(unitdir string) (int64, error) {
+	fi, err := os.Stat(path.Join(unitdir, uploadFileName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+// uploadLastActivity returns when a resumable upload last received data, or when the submission began if it has
+// received none.
+func uploadLastActivity(unitdir string) (time.Time, error) {
+	fi, err := os.Stat(path.Join(unitdir, uploadFileName))
+	if os.IsNotExist(err) {
+		fi, err = os.Stat(path.Join(unitdir, uploadStateFileName))
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return fi.ModTime(), nil
+}
+
+// unitInput writes the input data of a unit, its input files followed by its stdin, to the unit directory.  If a
+// signed hash is given, the data is checked against it when the unitInput is closed.
+type unitInput struct {
+	io.Writer
+	stdin       *os.File
+	inputs      *inputWriter
+	hash        hash.Hash
+	stdinSHA256 string
+}
+
+func openUnitInput(unitdir string, inputFiles []InputFile, stdinSHA256 string) (*unitInput, error) {
+	stdin, err := os.OpenFile(path.Join(unitdir, "stdin"), os.O_CREATE+os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	ui := &unitInput{
+		Writer:      stdin,
+		stdin:       stdin,
+		stdinSHA256: stdinSHA256,
+	}
+	if len(inputFiles) > 0 {
+		ui.inputs, err = newInputWriter(unitdir, inputFiles, stdin)
+		if err != nil {
+			stdin.Close()
+
+			return nil, err
+		}
+		ui.Writer = ui.inputs
+	}
+	if stdinSHA256 != "" {
+		ui.hash = sha256.New()
+		ui.Writer = io.MultiWriter(ui.Writer, ui.hash)
+	}
+
+	return ui, nil
+}
+
+// Close finishes writing the input data, and returns errInputMismatch if it does not match the signed hash.
+func (ui *unitInput) Close() error {
+	var err error
+	if ui.inputs != nil {
+		err = ui.inputs.Close()
+	}
+	closeErr := ui.stdin.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if ui.hash != nil && hex.EncodeToString(ui.hash.Sum(nil)) != ui.stdinSHA256 {
+		return errInputMismatch
+	}
+
+	return nil
+}
+
+// finishUpload writes the completed input data of a resumable submission to the input files and stdin of the unit,
+// and removes the upload.
+func finishUpload(unitdir string, state *uploadState) error {
+	inputFiles, err := ParseInputManifest(state.InputFiles)
+	if err != nil {
+		return err
+	}
+	upload, err := os.Open(path.Join(unitdir, uploadFileName))
+	if err != nil {
+		return err
+	}
+	defer upload.Close()
+	input, err := openUnitInput(unitdir, inputFiles, state.StdinSHA256)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(input, upload)
+	closeErr := input.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path.Join(unitdir, uploadFileName))
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path.Join(unitdir, uploadStateFileName))
+}
+
+// inputDataSize returns the size of the input data sent to a remote unit: its input files followed by its stdin.
+func inputDataSize(unitdir string, inputFiles []InputFile) (int64, error) {
+	fi, err := os.Stat(path.Join(unitdir, "stdin"))
+	if err != nil {
+		return 0, err
+	}
+	size := fi.Size()
+	for _, f := range inputFiles {
+		size += f.Size
+	}
+
+	return size, nil
+}
+
+// inputData reads the input data sent to a remote unit from an offset.
+type inputData struct {
+	io.Reader
+	files []*os.File
+}
+
+func openInputData(unitdir string, inputFiles []InputFile, offset int64) (*inputData, error) {
+	data := &inputData{}
+	readers := make([]io.Reader, 0)
+	add := func(filename string, size int64) error {
+		if offset >= size {
+			offset -= size
+
+			return nil
+		}
+		file, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		data.files = append(data.files, file)
+		readers = append(readers, io.NewSectionReader(file, offset, size-offset))
+		offset = 0
+
+		return nil
+	}
+	for _, f := range inputFiles {
+		if err := add(path.Join(unitdir, inputsDirName, f.Name), f.Size); err != nil {
+			data.Close()
+
+			return nil, err
+		}
+	}
+	fi, err := os.Stat(path.Join(unitdir, "stdin"))
+	if err == nil {
+		err = add(path.Join(unitdir, "stdin"), fi.Size())
+	}
+	if err != nil {
+		data.Close()
+
+		return nil, err
+	}
+	data.Reader = io.MultiReader(readers...)
+
+	return data, nil
+}
+
+// Close closes the files the input data is read from.
+func (d *inputData) Close() error {
+	var err error
+	for _, file := range d.files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// findUpload returns a unit waiting for the input data of a resumable submission, and the data it expects.
+func (w *Workceptor) findUpload(unitID string) (WorkUnit, *uploadState, error) {
+	unit, err := w.findUnit(unitID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if unit.Status().State != WorkStatePending {
+		return nil, nil, fmt.Errorf("unit %s is not waiting for input data", unitID)
+	}
+	state, err := readUploadState(unit.UnitDir(), unitID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return unit, state, nil
+}
+
+// uploadInFlight is an upload to a unit in progress.
+type uploadInFlight struct {
+	cfo  controlsvc.ControlFuncOperations
+	done chan struct{}
+}
+
+// beginUpload marks an upload to a unit as in progress.  An upload already in progress is most likely from a sender
+// that lost its connection and reconnected, so its connection is closed, and it is waited for.
+func (w *Workceptor) beginUpload(unitID string, cfo controlsvc.ControlFuncOperations) {
+	for {
+		w.uploadsLock.Lock()
+		other, ok := w.uploadsInFlight[unitID]
+		if !ok {
+			w.uploadsInFlight[unitID] = &uploadInFlight{cfo: cfo, done: make(chan struct{})}
+			w.uploadsLock.Unlock()
+
+			return
+		}
+		w.uploadsLock.Unlock()
+		_ = other.cfo.Close()
+		<-other.done
+	}
+}
+
+// endUpload marks an upload to a unit as no longer in progress.
+func (w *Workceptor) endUpload(unitID string) {
+	w.uploadsLock.Lock()
+	defer w.uploadsLock.Unlock()
+	upload, ok := w.uploadsInFlight[unitID]
+	if ok {
+		close(upload.done)
+		delete(w.uploadsInFlight, unitID)
+	}
+}
+
+// expireUploads fails units whose resumable upload is incomplete and has received no data for uploadIdleTimeout.
+func (w *Workceptor) expireUploads() {
+	w.activeUnitsLock.RLock()
+	units := make(map[string]WorkUnit, len(w.activeUnits))
+	for id, unit := range w.activeUnits {
+		units[id] = unit
+	}
+	w.activeUnitsLock.RUnlock()
+
+	for id, unit := range units {
+		if unit.Status().State != WorkStatePending {
+			continue
+		}
+		lastActivity, err := uploadLastActivity(unit.UnitDir())
+		if err != nil || time.Since(lastActivity) < uploadIdleTimeout {
+			continue
+		}
+		w.expireUpload(id, unit)
+	}
+}
+
+// expireUpload fails a unit waiting for the input data of a resumable submission, unless an upload to it is in
+// progress, and removes the data received.
+func (w *Workceptor) expireUpload(unitID string, unit WorkUnit) {
+	w.uploadsLock.Lock()
+	defer w.uploadsLock.Unlock()
+	if _, ok := w.uploadsInFlight[unitID]; ok {
+		return
+	}
+	// An upload may have completed the unit since it was checked.
+	_, state, err := w.findUpload(unitID)
+	if err != nil {
+		return
+	}
+	received, _ := uploadReceived(unit.UnitDir())
+	w.nc.GetLogger().Info("Failing work unit %s, which received no input data for %s", unitID, uploadIdleTimeout)
+	unit.UpdateBasicStatus(WorkStateFailed,
+		fmt.Sprintf("Input data incomplete: received %d of %d bytes before timing out", received, state.Size), 0)
+	for _, name := range []string{uploadFileName, uploadStateFileName} {
+		err := os.Remove(path.Join(unit.UnitDir(), name))
+		if err != nil && !os.IsNotExist(err) {
+			w.nc.GetLogger().Error("Error removing %s of work unit %s: %s", name, unitID, err)
+		}
+	}
+}
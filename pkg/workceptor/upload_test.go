@@ -0,0 +1,100 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestOpenInputData(t *testing.T) {
+	unitdir := t.TempDir()
+	files := []InputFile{{"first", 5}, {"empty", 0}, {"second", 3}}
+	if err := os.Mkdir(path.Join(unitdir, inputsDirName), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"inputs/first": "hello", "inputs/empty": "", "inputs/second": "bye", "stdin": "stdin"} {
+		if err := os.WriteFile(path.Join(unitdir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size, err := inputDataSize(unitdir, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 13 {
+		t.Errorf("expected 13 bytes of input data, got %d", size)
+	}
+	for offset, want := range map[int64]string{0: "hellobyestdin", 2: "llobyestdin", 5: "byestdin", 8: "stdin", 10: "din", 13: ""} {
+		data, err := openInputData(unitdir, files, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := data.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("from offset %d, read %q, want %q", offset, got, want)
+		}
+	}
+}
+
+func TestExpireUploads(t *testing.T) {
+	w := newSigningTestWorkceptor(t, "test")
+	err := w.RegisterWorker("started", func(bwu BaseWorkUnitForWorkUnit, w *Workceptor, unitID, workType string) WorkUnit {
+		return &startedUnit{newCommandWorker(bwu, w, unitID, workType)}
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newUpload := func(received string, idle time.Duration) WorkUnit {
+		unit, err := w.AllocateUnit("started", map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		unit.UpdateBasicStatus(WorkStatePending, "Waiting for Input Data", 0)
+		if err := writeUploadState(unit.UnitDir(), &uploadState{Size: 8}); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path.Join(unit.UnitDir(), uploadFileName), []byte(received), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{uploadFileName, uploadStateFileName} {
+			lastActivity := time.Now().Add(-idle)
+			if err := os.Chtimes(path.Join(unit.UnitDir(), name), lastActivity, lastActivity); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return unit
+	}
+	stalled := newUpload("abc", uploadIdleTimeout+time.Minute)
+	active := newUpload("abc", time.Minute)
+	inFlight := newUpload("abcde", uploadIdleTimeout+time.Minute)
+	w.beginUpload(inFlight.ID(), nil)
+	w.expireUploads()
+	w.endUpload(inFlight.ID())
+
+	status := stalled.Status()
+	if status.State != WorkStateFailed || status.Detail != "Input data incomplete: received 3 of 8 bytes before timing out" {
+		t.Errorf("expected the stalled upload to fail, got %s: %s", WorkStateToString(status.State), status.Detail)
+	}
+	for _, name := range []string{uploadFileName, uploadStateFileName} {
+		if _, err := os.Stat(path.Join(stalled.UnitDir(), name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", name, err)
+		}
+	}
+	for _, unit := range []WorkUnit{active, inFlight} {
+		if state := unit.Status().State; state != WorkStatePending {
+			t.Errorf("expected unit %s to still wait for input data, got %s", unit.ID(), WorkStateToString(state))
+		}
+	}
+}
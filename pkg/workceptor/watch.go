@@ -0,0 +1,208 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// StatusEvent reports a change of state or detail of a work unit, or its release.
+type StatusEvent struct {
+	UnitID     string
+	Time       time.Time
+	WorkType   string
+	State      int
+	StateName  string
+	Detail     string
+	StdoutSize int64
+	Released   bool `json:",omitempty"`
+}
+
+// newStatusEvent returns an event describing the current status of a unit.
+func newStatusEvent(unitID string, status *StatusFileData) StatusEvent {
+	return StatusEvent{
+		UnitID:     unitID,
+		Time:       time.Now(),
+		WorkType:   status.WorkType,
+		State:      status.State,
+		StateName:  WorkStateToString(status.State),
+		Detail:     status.Detail,
+		StdoutSize: status.StdoutSize,
+	}
+}
+
+// Subscription delivers status events for a set of work units, or for all units on the node.
+type Subscription struct {
+	w         *Workceptor
+	unitIDs   map[string]bool
+	events    chan StatusEvent
+	lock      sync.Mutex
+	queue     []StatusEvent
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Subscribe returns a subscription to status changes of the given units, or of all units if none are given.
+// Events are queued without limit, so a slow reader never holds up the work units.  The subscription must be
+// closed when it is no longer needed.
+func (w *Workceptor) Subscribe(unitIDs ...string) *Subscription {
+	s := &Subscription{
+		w:      w,
+		events: make(chan StatusEvent),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	if len(unitIDs) > 0 {
+		s.unitIDs = make(map[string]bool)
+		for _, id := range unitIDs {
+			s.unitIDs[id] = true
+		}
+	}
+	w.subscribersLock.Lock()
+	w.subscribers[s] = struct{}{}
+	w.subscribersLock.Unlock()
+	go s.deliver()
+
+	return s
+}
+
+// Events returns the channel on which events are delivered.  It is closed when the subscription is closed.
+func (s *Subscription) Events() <-chan StatusEvent {
+	return s.events
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		s.w.subscribersLock.Lock()
+		delete(s.w.subscribers, s)
+		s.w.subscribersLock.Unlock()
+		close(s.done)
+	})
+}
+
+// wants returns true if the subscription covers a unit.
+func (s *Subscription) wants(unitID string) bool {
+	return s.unitIDs == nil || s.unitIDs[unitID]
+}
+
+// enqueue adds an event to the queue without blocking.
+func (s *Subscription) enqueue(event StatusEvent) {
+	s.lock.Lock()
+	s.queue = append(s.queue, event)
+	s.lock.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver moves queued events to the events channel until the subscription is closed.
+func (s *Subscription) deliver() {
+	defer close(s.events)
+	for {
+		s.lock.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.lock.Unlock()
+		for _, event := range queue {
+			select {
+			case s.events <- event:
+			case <-s.done:
+				return
+			}
+		}
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// publishStatus sends an event to every subscription that covers its unit.
+func (w *Workceptor) publishStatus(event StatusEvent) {
+	w.subscribersLock.RLock()
+	defer w.subscribersLock.RUnlock()
+	for s := range w.subscribers {
+		if s.wants(event.UnitID) {
+			s.enqueue(event)
+		}
+	}
+}
+
+// watchUnits streams status events as JSON lines, starting with the current status of each watched unit.
+// When specific units are watched, the stream ends once all of them have completed or been released.
+func (w *Workceptor) watchUnits(ctx context.Context, unitIDs []string) (chan []byte, error) {
+	sub := w.Subscribe(unitIDs...)
+	initial := make([]StatusEvent, 0)
+	remaining := make(map[string]bool)
+	watched := unitIDs
+	if len(watched) == 0 {
+		watched = w.ListKnownUnitIDs()
+	}
+	for _, unitID := range watched {
+		status, err := w.UnitStatus(unitID)
+		if err != nil {
+			if len(unitIDs) > 0 {
+				sub.Close()
+
+				return nil, err
+			}
+
+			continue
+		}
+		initial = append(initial, newStatusEvent(unitID, status))
+		if len(unitIDs) > 0 && !IsComplete(status.State) {
+			remaining[unitID] = true
+		}
+	}
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		send := func(event StatusEvent) bool {
+			data, err := json.Marshal(&event)
+			if err != nil {
+				return false
+			}
+			select {
+			case out <- append(data, '\n'):
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for _, event := range initial {
+			if !send(event) {
+				return
+			}
+		}
+		if len(unitIDs) > 0 && len(remaining) == 0 {
+			return
+		}
+		for {
+			select {
+			case event, ok := <-sub.Events():
+				if !ok || !send(event) {
+					return
+				}
+				if len(unitIDs) > 0 && (event.Released || IsComplete(event.State)) {
+					delete(remaining, event.UnitID)
+					if len(remaining) == 0 {
+						return
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,118 @@
+//go:build !no_workceptor
+// +build !no_workceptor
+
+package workceptor
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ansible/receptor/pkg/netceptor"
+)
+
+func nextStatusEvent(t *testing.T, events <-chan StatusEvent) StatusEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for status event")
+	}
+
+	return StatusEvent{}
+}
+
+func TestSubscribe(t *testing.T) {
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	err = w.RegisterWorker("command", newCommandWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watched, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := w.Subscribe()
+	defer all.Close()
+	one := w.Subscribe(watched.ID())
+	defer one.Close()
+
+	other.UpdateBasicStatus(WorkStateRunning, "Running", 0)
+	watched.UpdateBasicStatus(WorkStateRunning, "Running", 0)
+	watched.UpdateBasicStatus(WorkStateRunning, "Running", 10)
+	watched.UpdateBasicStatus(WorkStateSucceeded, "Done", 20)
+	err = watched.Release(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantAll := []string{other.ID(), watched.ID(), watched.ID(), watched.ID()}
+	for _, unitID := range wantAll {
+		event := nextStatusEvent(t, all.Events())
+		if event.UnitID != unitID {
+			t.Fatalf("expected event for %s, got %+v", unitID, event)
+		}
+	}
+	event := nextStatusEvent(t, one.Events())
+	if event.State != WorkStateRunning || event.StateName != "Running" {
+		t.Errorf("unexpected first event %+v", event)
+	}
+	event = nextStatusEvent(t, one.Events())
+	if event.State != WorkStateSucceeded || event.Detail != "Done" || event.StdoutSize != 20 {
+		t.Errorf("unexpected second event %+v", event)
+	}
+	event = nextStatusEvent(t, one.Events())
+	if !event.Released {
+		t.Errorf("expected release event, got %+v", event)
+	}
+	one.Close()
+	if _, ok := <-one.Events(); ok {
+		t.Error("expected events channel to be closed")
+	}
+}
+
+func TestWatchUnits(t *testing.T) {
+	w, err := New(context.Background(), netceptor.New(context.Background(), "test"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cancel()
+	err = w.RegisterWorker("command", newCommandWorker, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, err := w.AllocateUnit("command", make(map[string]string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.watchUnits(context.Background(), []string{"nonexistent"})
+	if err == nil {
+		t.Fatal("expected error watching an unknown unit")
+	}
+	out, err := w.watchUnits(context.Background(), []string{unit.ID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit.UpdateBasicStatus(WorkStateFailed, "Failed", 0)
+	states := make([]int, 0)
+	for data := range out {
+		event := StatusEvent{}
+		err := json.Unmarshal(data, &event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, event.State)
+	}
+	if len(states) != 2 || states[0] != WorkStatePending || states[1] != WorkStateFailed {
+		t.Errorf("expected pending then failed, got %v", states)
+	}
+}
@@ -17,7 +17,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/ansible/receptor/pkg/certificates"
 	"github.com/ansible/receptor/pkg/controlsvc"
 	"github.com/ansible/receptor/pkg/logger"
 	"github.com/ansible/receptor/pkg/netceptor"
@@ -34,6 +33,7 @@ type NetceptorForWorkceptor interface {
 	GetClientTLSConfig(name string, expectedHostName string, expectedHostNameType netceptor.ExpectedHostnameType) (*tls.Config, error) // have a common pkg for types
 	GetLogger() *logger.ReceptorLogger
 	DialContext(ctx context.Context, node string, service string, tlscfg *tls.Config) (*netceptor.Conn, error) // create an interface for Conn
+	Status() netceptor.Status
 }
 
 type ServerForWorkceptor interface {
@@ -49,23 +49,40 @@ type ServerForWorkceptor interface {
 
 // Workceptor is the main object that handles unit-of-work management.
 type Workceptor struct {
-	ctx               context.Context
-	Cancel            context.CancelFunc
-	nc                NetceptorForWorkceptor
-	dataDir           string
-	workTypesLock     *sync.RWMutex
-	workTypes         map[string]*workType
-	activeUnitsLock   *sync.RWMutex
-	activeUnits       map[string]WorkUnit
-	SigningKey        string
-	SigningExpiration time.Duration
-	VerifyingKey      string
+	ctx                   context.Context
+	Cancel                context.CancelFunc
+	nc                    NetceptorForWorkceptor
+	nodeID                string
+	dataDir               string
+	workTypesLock         *sync.RWMutex
+	workTypes             map[string]*workType
+	activeUnitsLock       *sync.RWMutex
+	activeUnits           map[string]WorkUnit
+	SigningKey            string
+	SigningKeyID          string
+	SigningExpiration     time.Duration
+	VerifyingKey          string
+	VerifyingKeyID        string
+	VerifyingKeys         map[string]string
+	VerifyingIssuers      []string
+	AllowLegacySignatures bool
+	keys                  *keyCache
+	DataDirQuota          int64
+	CallbackHosts         []string
+	subscribersLock       *sync.RWMutex
+	subscribers           map[*Subscription]struct{}
+	callbacksLock         *sync.Mutex
+	callbacksInFlight     map[string]bool
+	uploadsLock           *sync.Mutex
+	uploadsInFlight       map[string]*uploadInFlight
 }
 
 // workType is the record for a registered type of work.
 type workType struct {
 	newWorkerFunc   NewWorkerFunc
 	verifySignature bool
+	retention       *RetentionPolicy
+	reconciler      OrphanReconciler
 }
 
 // New constructs a new Workceptor instance.
@@ -73,12 +90,14 @@ func New(ctx context.Context, nc NetceptorForWorkceptor, dataDir string) (*Workc
 	if dataDir == "" {
 		dataDir = path.Join(os.TempDir(), "receptor")
 	}
-	dataDir = path.Join(dataDir, nc.NodeID())
+	nodeID := nc.NodeID()
+	dataDir = path.Join(dataDir, nodeID)
 	c, cancel := context.WithCancel(ctx)
 	w := &Workceptor{
 		ctx:               c,
 		Cancel:            cancel,
 		nc:                nc,
+		nodeID:            nodeID,
 		dataDir:           dataDir,
 		workTypesLock:     &sync.RWMutex{},
 		workTypes:         make(map[string]*workType),
@@ -87,11 +106,20 @@ func New(ctx context.Context, nc NetceptorForWorkceptor, dataDir string) (*Workc
 		SigningKey:        "",
 		SigningExpiration: 5 * time.Minute,
 		VerifyingKey:      "",
+		keys:              newKeyCache(c),
+		subscribersLock:   &sync.RWMutex{},
+		subscribers:       make(map[*Subscription]struct{}),
+		callbacksLock:     &sync.Mutex{},
+		callbacksInFlight: make(map[string]bool),
+		uploadsLock:       &sync.Mutex{},
+		uploadsInFlight:   make(map[string]*uploadInFlight),
 	}
 	err := w.RegisterWorker("remote", newRemoteWorker, false)
 	if err != nil {
 		return nil, fmt.Errorf("could not register remote worker function: %s", err)
 	}
+	go w.monitorRetention()
+	go w.monitorCallbacks(w.Subscribe())
 
 	return w, nil
 }
@@ -99,9 +127,30 @@ func New(ctx context.Context, nc NetceptorForWorkceptor, dataDir string) (*Workc
 // MainInstance is the global instance of Workceptor instantiated by the command-line main() function.
 var MainInstance *Workceptor
 
+// Result stream names.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
 // stdoutSize returns size of stdout, if it exists, or 0 otherwise.
 func stdoutSize(unitdir string) int64 {
-	stat, err := os.Stat(path.Join(unitdir, "stdout"))
+	return streamSize(unitdir, StreamStdout)
+}
+
+// stderrSize returns size of stderr, if it exists, or 0 otherwise.
+func stderrSize(unitdir string) int64 {
+	return streamSize(unitdir, StreamStderr)
+}
+
+// streamSize returns the uncompressed size of a result stream file, if it exists, or 0 otherwise.
+func streamSize(unitdir string, stream string) int64 {
+	filename := path.Join(unitdir, stream)
+	size, ok := indexedStreamSize(filename)
+	if ok {
+		return size
+	}
+	stat, err := os.Stat(filename)
 	if err != nil {
 		return 0
 	}
@@ -109,6 +158,15 @@ func stdoutSize(unitdir string) int64 {
 	return stat.Size()
 }
 
+// statusStreamSize returns the size of a result stream as recorded in a status.
+func statusStreamSize(status *StatusFileData, stream string) int64 {
+	if stream == StreamStderr {
+		return status.StderrSize
+	}
+
+	return status.StdoutSize
+}
+
 // RegisterWithControlService registers this workceptor instance with a control service instance.
 func (w *Workceptor) RegisterWithControlService(cs ServerForWorkceptor) error {
 	err := cs.AddControlFunc("work", &workceptorCommandType{
@@ -174,22 +232,71 @@ func (w *Workceptor) generateUnitID(lock bool) (string, error) {
 	}
 }
 
-func (w *Workceptor) createSignature(nodeID string) (string, error) {
+// createSignature returns a JWT authorising a work command on a node.  payloadSHA256 is the hash of the command given
+// by workCommandSHA256.
+func (w *Workceptor) createSignature(nodeID string, workType string, payloadSHA256 string) (string, error) {
+	if w.SigningKey == "" {
+		return "", fmt.Errorf("cannot sign work: signing key is empty")
+	}
+	exp := time.Now().Add(w.SigningExpiration)
+
+	claims := &WorkClaims{
+		WorkType:      workType,
+		PayloadSHA256: payloadSHA256,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    w.nodeID,
+			ExpiresAt: jwt.NewNumericDate(exp),
+			Audience:  []string{nodeID},
+		},
+	}
+
+	return w.signClaims(claims)
+}
+
+// createSubmitSignature returns a JWT authorising the submission of work to a node, with the given submit command
+// parameters and the hash of the data sent after the command, as given by submitStdinSHA256.
+func (w *Workceptor) createSubmitSignature(nodeID string, workType string, params map[string]string,
+	stdinSHA256 string,
+) (string, error) {
 	if w.SigningKey == "" {
 		return "", fmt.Errorf("cannot sign work: signing key is empty")
 	}
+	payloadSHA256, err := workSubmitSHA256(params)
+	if err != nil {
+		return "", err
+	}
 	exp := time.Now().Add(w.SigningExpiration)
 
-	claims := &jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(exp),
-		Audience:  []string{nodeID},
+	claims := &WorkClaims{
+		WorkType:      workType,
+		PayloadSHA256: payloadSHA256,
+		StdinSHA256:   stdinSHA256,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    w.nodeID,
+			ExpiresAt: jwt.NewNumericDate(exp),
+			Audience:  []string{nodeID},
+		},
 	}
-	rsaPrivateKey, err := certificates.LoadPrivateKey(w.SigningKey, &certificates.OsWrapper{})
+
+	return w.signClaims(claims)
+}
+
+// signClaims returns a JWT of the claims, signed with the work-signing key.  The signing method follows from the
+// type of the key.
+func (w *Workceptor) signClaims(claims jwt.Claims) (string, error) {
+	privateKey, err := w.keys.load(w.SigningKey, true)
 	if err != nil {
 		return "", fmt.Errorf("could not load signing key file: %s", err.Error())
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
-	tokenString, err := token.SignedString(rsaPrivateKey)
+	method, err := signingMethodFor(privateKey)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(method, claims)
+	if w.SigningKeyID != "" {
+		token.Header["kid"] = w.SigningKeyID
+	}
+	tokenString, err := token.SignedString(privateKey)
 	if err != nil {
 		return "", err
 	}
@@ -213,33 +320,9 @@ func (w *Workceptor) ShouldVerifySignature(workType string, signWork bool) bool
 	return false
 }
 
+// VerifySignature checks that a signature was made with one of the verifying keys for this node.
 func (w *Workceptor) VerifySignature(signature string) error {
-	if signature == "" {
-		return fmt.Errorf("could not verify signature: signature is empty")
-	}
-	if w.VerifyingKey == "" {
-		return fmt.Errorf("could not verify signature: verifying key not specified")
-	}
-	rsaPublicKey, err := certificates.LoadPublicKey(w.VerifyingKey, &certificates.OsWrapper{})
-	if err != nil {
-		return fmt.Errorf("could not load verifying key file: %s", err.Error())
-	}
-	token, err := jwt.ParseWithClaims(signature, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return rsaPublicKey, nil
-	})
-	if err != nil {
-		return fmt.Errorf("could not verify signature: %s", err.Error())
-	}
-	if !token.Valid {
-		return fmt.Errorf("token not valid")
-	}
-	claims := token.Claims.(*jwt.RegisteredClaims)
-	ok := claims.VerifyAudience(w.nc.NodeID(), true)
-	if !ok {
-		return fmt.Errorf("token audience did not match node ID")
-	}
-
-	return nil
+	return w.verifyWorkSignature(signature, "", "")
 }
 
 // AllocateUnit creates a new local work unit and generates an identifier for it.
@@ -279,7 +362,8 @@ func (w *Workceptor) AllocateRemoteUnit(remoteNode, remoteWorkType, tlsClient, t
 	}
 	hasSecrets := false
 	for k := range params {
-		if strings.HasPrefix(strings.ToLower(k), "secret_") {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "secret_") || strings.HasPrefix(lk, kubeSecretParamPrefix) {
 			hasSecrets = true
 
 			break
@@ -362,6 +446,12 @@ func (w *Workceptor) scanForUnit(unitID string) {
 			w.nc.GetLogger().Warning("Failed to restart worker %s: %s", unitdir, err)
 			worker.UpdateBasicStatus(WorkStateFailed, fmt.Sprintf("Failed to restart: %s", err), stdoutSize(unitdir))
 		}
+		if state := worker.Status().State; !IsComplete(state) {
+			err = appendHistory(unitdir, newWorkEvent(state, "Restarted monitoring after node restart"))
+			if err != nil {
+				w.nc.GetLogger().Warning("Could not record restart of %s: %s", ident, err)
+			}
+		}
 		w.activeUnitsLock.Lock()
 		defer w.activeUnitsLock.Unlock()
 		w.activeUnits[ident] = worker
@@ -477,6 +567,14 @@ func sleepOrDone(doneChan <-chan struct{}, interval time.Duration) bool {
 
 // GetResults returns a live stream of the results of a unit.
 func (w *Workceptor) GetResults(ctx context.Context, unitID string, startPos int64) (chan []byte, error) {
+	return w.GetResultsStream(ctx, unitID, StreamStdout, startPos)
+}
+
+// GetResultsStream returns a live stream of the stdout or stderr results of a unit.
+func (w *Workceptor) GetResultsStream(ctx context.Context, unitID string, stream string, startPos int64) (chan []byte, error) {
+	if stream != StreamStdout && stream != StreamStderr {
+		return nil, fmt.Errorf("unknown result stream %s", stream)
+	}
 	unit, err := w.findUnit(unitID)
 	if err != nil {
 		return nil, err
@@ -489,14 +587,16 @@ func (w *Workceptor) GetResults(ctx context.Context, unitID string, startPos int
 		})
 	}
 	unitdir := path.Join(w.dataDir, unitID)
-	stdoutFilename := path.Join(unitdir, "stdout")
-	var stdout *os.File
+	stdoutFilename := path.Join(unitdir, stream)
+	var stdout io.ReadSeekCloser
 	ctxChild, cancel := context.WithCancel(ctx)
 	go func() {
 		defer func() {
-			err = stdout.Close()
-			if err != nil {
-				w.nc.GetLogger().Error("Error closing stdout %s", stdoutFilename)
+			if stdout != nil {
+				err = stdout.Close()
+				if err != nil {
+					w.nc.GetLogger().Error("Error closing stdout %s", stdoutFilename)
+				}
 			}
 			resultClose()
 			cancel()
@@ -504,12 +604,12 @@ func (w *Workceptor) GetResults(ctx context.Context, unitID string, startPos int
 
 		// Wait for stdout file to exist
 		for {
-			stdout, err = os.Open(stdoutFilename)
+			stdout, err = openResultStream(stdoutFilename)
 			switch {
 			case err == nil:
 			case os.IsNotExist(err):
 				if IsComplete(unit.Status().State) {
-					w.nc.GetLogger().Warning("Unit completed without producing any stdout\n")
+					w.nc.GetLogger().Warning("Unit completed without producing any %s\n", stream)
 
 					return
 				}
@@ -591,8 +691,8 @@ func (w *Workceptor) GetResults(ctx context.Context, unitID string, startPos int
 			}
 			if err == io.EOF {
 				unitStatus := unit.Status()
-				if IsComplete(unitStatus.State) && filePos >= unitStatus.StdoutSize {
-					w.nc.GetLogger().Debug("Stdout complete - closing channel for: %s \n", unitID)
+				if IsComplete(unitStatus.State) && filePos >= statusStreamSize(unitStatus, stream) {
+					w.nc.GetLogger().Debug("%s complete - closing channel for: %s \n", stream, unitID)
 
 					return
 				}
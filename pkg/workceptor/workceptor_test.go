@@ -326,6 +326,15 @@ func TestAllocateRemoteUnit(t *testing.T) {
 				// For testing purposes
 			},
 		},
+		{
+			name:      "sending kube secrets over non tls connection error",
+			tlsClient: "",
+			params:    map[string]string{"kube_secret_token": "secret"},
+			errorMsg:  "cannot send secrets over a non-TLS connection",
+			expectedCalls: func() {
+				// For testing purposes
+			},
+		},
 		{
 			name:      "invalid duration error",
 			tlsClient: "",
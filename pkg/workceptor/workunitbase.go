@@ -102,6 +102,9 @@ type BaseWorkUnit struct {
 	cancel              context.CancelFunc
 	fs                  FileSystemer
 	watcher             WatcherWrapper
+	notified            bool
+	notifiedState       int
+	notifiedDetail      string
 }
 
 // Init initializes the basic work unit data, in memory only.
@@ -111,6 +114,8 @@ func (bwu *BaseWorkUnit) Init(w *Workceptor, unitID string, workType string, fs
 	bwu.status.Detail = "Unit Created"
 	bwu.status.StdoutSize = 0
 	bwu.status.WorkType = workType
+	bwu.status.ExitCode = -1
+	bwu.status.CreateTime = time.Now()
 	bwu.unitID = unitID
 	bwu.unitDir = path.Join(w.dataDir, unitID)
 	bwu.statusFileName = path.Join(bwu.unitDir, "status")
@@ -249,6 +254,11 @@ func (sfd *StatusFileData) loadFromFile(file io.Reader) error {
 	if err != nil {
 		return err
 	}
+	// Fields that are absent from older status files must not keep the values they were initialized with.
+	sfd.CreateTime = time.Time{}
+	sfd.Labels = nil
+	sfd.Artifacts = nil
+	sfd.ResourceUsage = nil
 
 	return json.Unmarshal(jsonBytes, sfd)
 }
@@ -282,12 +292,43 @@ func (bwu *BaseWorkUnit) Load() error {
 	bwu.statusLock.Lock()
 	defer bwu.statusLock.Unlock()
 
-	return bwu.status.Load(bwu.statusFileName)
+	err := bwu.status.Load(bwu.statusFileName)
+	if err == nil {
+		bwu.notifyStatus()
+	}
+
+	return err
+}
+
+// notifyStatus publishes the status to subscribers if its state or detail changed since it was last published.
+// The caller must already hold the statusLock.
+func (bwu *BaseWorkUnit) notifyStatus() {
+	if bwu.w == nil {
+		return
+	}
+	if bwu.notified && bwu.status.State == bwu.notifiedState && bwu.status.Detail == bwu.notifiedDetail {
+		return
+	}
+	bwu.notified = true
+	bwu.notifiedState = bwu.status.State
+	bwu.notifiedDetail = bwu.status.Detail
+	bwu.w.publishStatus(newStatusEvent(bwu.unitID, &bwu.status))
 }
 
 // UpdateFullStatus atomically updates the status metadata file.  Changes should be made in the callback function.
-// Errors are logged rather than returned.
 func (sfd *StatusFileData) UpdateFullStatus(filename string, statusFunc func(*StatusFileData)) error {
+	return sfd.updateStatus(filename, "", statusFunc)
+}
+
+// updateUnitStatus atomically updates the status file of a unit, and appends changes of state or detail to the
+// event history of the unit.
+func (sfd *StatusFileData) updateUnitStatus(unitdir string, statusFunc func(*StatusFileData)) error {
+	return sfd.updateStatus(path.Join(unitdir, "status"), unitdir, statusFunc)
+}
+
+// updateStatus atomically updates a status file, appending changes of state or detail to the event history in
+// historyDir if it is not empty.
+func (sfd *StatusFileData) updateStatus(filename string, historyDir string, statusFunc func(*StatusFileData)) error {
 	lockFile, err := sfd.lockStatusFile(filename)
 	if err != nil {
 		return err
@@ -317,7 +358,12 @@ func (sfd *StatusFileData) UpdateFullStatus(filename string, statusFunc func(*St
 			return err
 		}
 	}
+	prevState, prevDetail := sfd.State, sfd.Detail
 	statusFunc(sfd)
+	if historyDir != "" && (sfd.State != prevState || sfd.Detail != prevDetail) {
+		// The history is informational, so failing to record it does not fail the status update.
+		_ = appendHistory(historyDir, newWorkEvent(sfd.State, sfd.Detail))
+	}
 	_, err = file.Seek(0, 0)
 	if err != nil {
 		return err
@@ -337,10 +383,21 @@ func (sfd *StatusFileData) UpdateFullStatus(filename string, statusFunc func(*St
 // UpdateFullStatus atomically updates the whole status record.  Changes should be made in the callback function.
 // Errors are logged rather than returned.
 func (bwu *BaseWorkUnit) UpdateFullStatus(statusFunc func(*StatusFileData)) {
+	bwu.updateFullStatus(statusFunc, true)
+}
+
+// updateFullStatus is UpdateFullStatus, appending changes of state or detail to the event history of the unit only if
+// recordHistory is set.
+func (bwu *BaseWorkUnit) updateFullStatus(statusFunc func(*StatusFileData), recordHistory bool) {
 	bwu.statusLock.Lock()
 	defer bwu.statusLock.Unlock()
 
-	err := bwu.status.UpdateFullStatus(bwu.statusFileName, statusFunc)
+	historyDir := ""
+	if recordHistory {
+		historyDir = bwu.unitDir
+	}
+	err := bwu.status.updateStatus(bwu.statusFileName, historyDir, statusFunc)
+	bwu.notifyStatus()
 	bwu.lastUpdateErrorLock.Lock()
 	defer bwu.lastUpdateErrorLock.Unlock()
 	bwu.lastUpdateError = err
@@ -353,13 +410,23 @@ func (bwu *BaseWorkUnit) UpdateFullStatus(statusFunc func(*StatusFileData)) {
 // UpdateBasicStatus atomically updates key fields in the status metadata file.  Errors are logged rather than returned.
 // Passing -1 as stdoutSize leaves it unchanged.
 func (sfd *StatusFileData) UpdateBasicStatus(filename string, state int, detail string, stdoutSize int64) error {
-	return sfd.UpdateFullStatus(filename, func(status *StatusFileData) {
+	return sfd.UpdateFullStatus(filename, basicStatusFunc(state, detail, stdoutSize))
+}
+
+// updateUnitBasicStatus is UpdateBasicStatus for the status file of a unit, recording changes in its event history.
+func (sfd *StatusFileData) updateUnitBasicStatus(unitdir string, state int, detail string, stdoutSize int64) error {
+	return sfd.updateUnitStatus(unitdir, basicStatusFunc(state, detail, stdoutSize))
+}
+
+// basicStatusFunc returns the status function setting the key fields of a status.
+func basicStatusFunc(state int, detail string, stdoutSize int64) func(*StatusFileData) {
+	return func(status *StatusFileData) {
 		status.State = state
 		status.Detail = detail
 		if stdoutSize >= 0 {
 			status.StdoutSize = stdoutSize
 		}
-	})
+	}
 }
 
 // UpdateBasicStatus atomically updates key fields in the status metadata file.  Errors are logged rather than returned.
@@ -368,7 +435,8 @@ func (bwu *BaseWorkUnit) UpdateBasicStatus(state int, detail string, stdoutSize
 	bwu.statusLock.Lock()
 	defer bwu.statusLock.Unlock()
 
-	err := bwu.status.UpdateBasicStatus(bwu.statusFileName, state, detail, stdoutSize)
+	err := bwu.status.updateUnitBasicStatus(bwu.unitDir, state, detail, stdoutSize)
+	bwu.notifyStatus()
 	bwu.lastUpdateErrorLock.Lock()
 	defer bwu.lastUpdateErrorLock.Unlock()
 	bwu.lastUpdateError = err
@@ -378,6 +446,28 @@ func (bwu *BaseWorkUnit) UpdateBasicStatus(state int, detail string, stdoutSize
 	}
 }
 
+// beginAttempt resets the exit information and records the start of a new attempt at running the work.
+func (sfd *StatusFileData) beginAttempt() {
+	sfd.Attempt++
+	sfd.StartTime = time.Now()
+	sfd.EndTime = time.Time{}
+	sfd.ExitCode = -1
+	sfd.Signal = ""
+	sfd.ResourceUsage = nil
+}
+
+// mirrorRunInfo copies the exit information, timestamps, attempt number, resource usage and artifacts from another
+// status.
+func (sfd *StatusFileData) mirrorRunInfo(from *StatusFileData) {
+	sfd.ExitCode = from.ExitCode
+	sfd.Signal = from.Signal
+	sfd.StartTime = from.StartTime
+	sfd.EndTime = from.EndTime
+	sfd.Attempt = from.Attempt
+	sfd.ResourceUsage = from.ResourceUsage
+	sfd.Artifacts = from.Artifacts
+}
+
 // LastUpdateError returns the last error (including nil) resulting from an UpdateBasicStatus or UpdateFullStatus.
 func (bwu *BaseWorkUnit) LastUpdateError() error {
 	bwu.lastUpdateErrorLock.RLock()
@@ -493,6 +583,9 @@ func (bwu *BaseWorkUnit) Release(force bool) error {
 	bwu.w.activeUnitsLock.Lock()
 	defer bwu.w.activeUnitsLock.Unlock()
 	delete(bwu.w.activeUnits, bwu.unitID)
+	event := newStatusEvent(bwu.unitID, &bwu.status)
+	event.Released = true
+	bwu.w.publishStatus(event)
 
 	return nil
 }
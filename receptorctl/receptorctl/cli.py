@@ -7,6 +7,7 @@ import tty
 import termios
 import click
 import json
+import shutil
 from functools import partial
 import dateutil.parser
 import importlib.metadata
@@ -376,15 +377,77 @@ def version(ctx):
     default="",
     help="TLS client config name used when connecting to remote node",
 )
+@click.option(
+    "--label",
+    "labels",
+    multiple=True,
+    help="Only list units with this label (key=value format)",
+)
+@click.option("--worktype", type=str, default="", help="Only list units of this work type")
+@click.option(
+    "--state",
+    "states",
+    multiple=True,
+    help="Only list units in this state, e.g. Running or Failed",
+)
+@click.option(
+    "--older-than",
+    "olderthan",
+    type=str,
+    default="",
+    help="Only list units created at least this long ago, e.g. 1h30m",
+)
+@click.option(
+    "--newer-than",
+    "newerthan",
+    type=str,
+    default="",
+    help="Only list units created less than this long ago, e.g. 10m",
+)
+@click.option("--limit", type=int, default=0, help="List at most this many units")
+@click.option(
+    "--after",
+    type=str,
+    default="",
+    help="Only list units whose IDs sort after this one, to fetch the next page",
+)
 @click.pass_context
-def list_units(ctx, unit_id, node, tlsclient, quiet):
+def list_units(
+    ctx,
+    unit_id,
+    node,
+    tlsclient,
+    quiet,
+    labels,
+    worktype,
+    states,
+    olderthan,
+    newerthan,
+    limit,
+    after,
+):
     rc = get_rc(ctx)
     if node:
         rc.connect_to_service(node, "control", tlsclient)
         rc.handshake()
+    command = ["work list"]
     if unit_id:
-        unit_id = " " + unit_id
-    work = rc.simple_command("work list" + unit_id)
+        command.append(unit_id)
+    if labels:
+        command.append("labels=" + ",".join(labels))
+    if worktype:
+        command.append("worktype=" + worktype)
+    if states:
+        command.append("state=" + ",".join(states))
+    if olderthan:
+        command.append("olderthan=" + olderthan)
+    if newerthan:
+        command.append("newerthan=" + newerthan)
+    if limit:
+        command.append(f"limit={limit}")
+    if after:
+        command.append("after=" + after)
+    work = rc.simple_command(" ".join(command))
     if quiet:
         for k in work.keys():
             print_message(k)
@@ -427,6 +490,24 @@ def list_units(ctx, unit_id, node, tlsclient, quiet):
     help="Time to live until remote work must start, e.g. 1h20m30s or 30m10s",
 )
 @click.option("--signwork", help="Digitally sign remote work submissions", is_flag=True)
+@click.option(
+    "--label",
+    "labels",
+    multiple=True,
+    help="Label to attach to the unit (key=value format)",
+)
+@click.option(
+    "--input-file",
+    "input_files",
+    multiple=True,
+    help="File to submit with the unit, in name=path format",
+)
+@click.option(
+    "--callback",
+    type=str,
+    default="",
+    help="HTTP URL or receptor://node/service address to notify when the work completes",
+)
 @click.option(
     "--follow",
     "-f",
@@ -451,6 +532,9 @@ def submit(
     tlsclient,
     ttl,
     signwork,
+    labels,
+    input_files,
+    callback,
     follow,
     rm,
     param,
@@ -506,6 +590,9 @@ def submit(
             ttl=ttl,
             signwork=signwork,
             params=params,
+            callback=callback,
+            labels=dict(s.split("=", 1) for s in labels),
+            input_files=dict(s.split("=", 1) for s in input_files),
         )
         result = work.pop("result")
         unitid = work.pop("unitid")
@@ -525,9 +612,16 @@ def submit(
 @work.command(help="Get results for a previously or currently running unit of work.")
 @click.pass_context
 @click.argument("unit_id", type=str, required=True)
-def results(ctx, unit_id):
+@click.option(
+    "--stream",
+    type=click.Choice(["stdout", "stderr"]),
+    default="stdout",
+    help="Result stream to read. stderr is only captured by workers configured to separate it.",
+    show_default=True,
+)
+def results(ctx, unit_id, stream):
     rc = get_rc(ctx)
-    resultsfile = rc.get_work_results(unit_id)
+    resultsfile = rc.get_work_results(unit_id, stream=stream)
     for text in iter(partial(resultsfile.readline, 256), b""):
         sys.stdout.buffer.write(text)
         sys.stdout.buffer.flush()
@@ -540,26 +634,149 @@ def results(ctx, unit_id):
         sys.exit(1)
 
 
-def op_on_unit_ids(ctx, op, unit_ids):
+@work.command(help="Show the event history of a unit of work.")
+@click.argument("unit_id", type=str, required=True)
+@click.pass_context
+def history(ctx, unit_id):
+    rc = get_rc(ctx)
+    history = rc.simple_command(f"work history {unit_id}")
+    print_json(history.get("Events", []))
+
+
+@work.command(
+    help="List the artifacts of a unit of work, or download one of them if a name is given."
+)
+@click.argument("unit_id", type=str, required=True)
+@click.argument("name", type=str, required=False)
+@click.option(
+    "--output",
+    "-o",
+    type=str,
+    default="",
+    help="File to save the artifact to, instead of writing it to stdout",
+)
+@click.pass_context
+def artifacts(ctx, unit_id, name, output):
     rc = get_rc(ctx)
-    for unit_id in unit_ids:
+    if not name:
+        artifacts = rc.simple_command(f"work artifacts {unit_id}")
+        print_json(artifacts.get("Artifacts", []))
+        return
+    try:
+        artifactfile = rc.get_work_artifact(unit_id, name)
+    except RuntimeError as e:
+        print_error(str(e))
+        sys.exit(1)
+    if output:
+        with open(output, "wb") as f:
+            shutil.copyfileobj(artifactfile, f)
+    else:
+        shutil.copyfileobj(artifactfile, sys.stdout.buffer)
+        sys.stdout.buffer.flush()
+    artifactfile.close()
+
+
+@work.command(
+    help="Print status changes of units of work as they happen. "
+    "Watches all units if none are given."
+)
+@click.argument("unit_ids", nargs=-1)
+@click.pass_context
+def watch(ctx, unit_ids):
+    rc = get_rc(ctx)
+    try:
+        for event in rc.watch_work(unit_ids):
+            print(json.dumps(event))
+            sys.stdout.flush()
+    except RuntimeError as e:
+        print_error(str(e))
+        sys.exit(1)
+
+
+@work.command(
+    help="Delete or adopt pods left behind by units of work that no longer exist."
+)
+@click.option(
+    "--worktype",
+    type=str,
+    default="",
+    help="Reconcile only this work type, instead of all work types",
+)
+@click.option(
+    "--dry-run",
+    help="Only report what would be done",
+    is_flag=True,
+)
+@click.pass_context
+def reconcile(ctx, worktype, dry_run):
+    rc = get_rc(ctx)
+    command = ["work reconcile"]
+    if worktype:
+        command.append(f"worktype={worktype}")
+    if dry_run:
+        command.append("dryrun=true")
+    try:
+        results = rc.simple_command(" ".join(command))
+    except RuntimeError as e:
+        print_error(str(e))
+        sys.exit(1)
+    print_json(results)
+
+
+def op_on_unit_ids(ctx, op, unit_ids, selectors=()):
+    rc = get_rc(ctx)
+    unit_ids = list(unit_ids)
+    if len(unit_ids) == 1 and not selectors:
         try:
-            res = list(rc.simple_command(f"work {op} {unit_id}").items())[0]
+            res = list(rc.simple_command(f"work {op} {unit_ids[0]}").items())[0]
             print_message(f"({res[1]}, {res[0]})")
         except Exception as e:
-            print_error(f"{unit_id}: ERROR: {e}")
+            print_error(f"{unit_ids[0]}: ERROR: {e}")
             sys.exit(1)
+        return
+    results = rc.simple_command(" ".join([f"work {op}", *unit_ids, *selectors]))
+    failed = False
+    for unit_id, res in results.items():
+        if "Error" in res:
+            print_error(f"{unit_id}: ERROR: {res['Error']}")
+            failed = True
+        else:
+            print_message(f"({unit_id}, {res['Result']})")
+    if failed:
+        sys.exit(1)
+
+
+def selector_options(labels, states):
+    selectors = []
+    if labels:
+        selectors.append("labels=" + ",".join(labels))
+    if states:
+        selectors.append("state=" + ",".join(states))
+    return selectors
 
 
 @work.command(help="Cancel (kill) one or more units of work.")
+@click.option(
+    "--label",
+    "labels",
+    multiple=True,
+    help="Cancel units with this label (key=value format)",
+)
+@click.option(
+    "--state",
+    "states",
+    multiple=True,
+    help="Cancel units in this state, e.g. Running",
+)
 @click.argument("unit_ids", nargs=-1)
 @click.pass_context
-def cancel(ctx, unit_ids):
-    if len(unit_ids) == 0:
+def cancel(ctx, labels, states, unit_ids):
+    selectors = selector_options(labels, states)
+    if len(unit_ids) == 0 and not selectors:
         print_warning("No unit IDs supplied: Not doing anything")
         return
     print_message("Cancelled:")
-    op_on_unit_ids(ctx, "cancel", unit_ids)
+    op_on_unit_ids(ctx, "cancel", unit_ids, selectors)
 
 
 @work.command(help="Release (delete) one or more units of work.")
@@ -569,10 +786,23 @@ def cancel(ctx, unit_ids):
     is_flag=True,
 )
 @click.option("--all", help="Delete all work units", is_flag=True)
+@click.option(
+    "--label",
+    "labels",
+    multiple=True,
+    help="Release units with this label (key=value format)",
+)
+@click.option(
+    "--state",
+    "states",
+    multiple=True,
+    help="Release units in this state, e.g. Succeeded",
+)
 @click.argument("unit_ids", nargs=-1)
 @click.pass_context
-def release(ctx, force, all, unit_ids):
-    if len(unit_ids) == 0 and not all:
+def release(ctx, force, all, labels, states, unit_ids):
+    selectors = selector_options(labels, states)
+    if len(unit_ids) == 0 and not all and not selectors:
         print_warning("No unit IDs supplied: Not doing anything")
         return
     op = "release" if not force else "force-release"
@@ -580,9 +810,10 @@ def release(ctx, force, all, unit_ids):
     if all:
         rc = get_rc(ctx)
         work = rc.simple_command("work list")
-        op_on_unit_ids(ctx, op, work.keys())
+        if work:
+            op_on_unit_ids(ctx, op, work.keys())
     else:
-        op_on_unit_ids(ctx, op, unit_ids)
+        op_on_unit_ids(ctx, op, unit_ids, selectors)
 
 
 def run():
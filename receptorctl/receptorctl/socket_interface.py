@@ -181,6 +181,9 @@ class ReceptorControl:
         ttl=None,
         signwork=False,
         params=None,
+        callback=None,
+        labels=None,
+        input_files=None,
     ):
         self.connect()
         if node is None:
@@ -202,6 +205,20 @@ class ReceptorControl:
         if signwork:
             commandMap["signwork"] = "true"
 
+        if callback:
+            commandMap["callback"] = callback
+
+        if labels:
+            commandMap["labels"] = ",".join(f"{k}={v}" for k, v in labels.items())
+
+        input_sizes = []
+        if input_files:
+            for name, fname in input_files.items():
+                if not os.path.exists(fname):
+                    raise FileNotFoundError("{} does not exist".format(fname))
+                input_sizes.append(f"{name}={os.path.getsize(fname)}")
+            commandMap["inputfiles"] = ",".join(input_sizes)
+
         if params:
             for k, v in params.items():
                 if k not in commandMap:
@@ -231,6 +248,10 @@ class ReceptorControl:
             if str.startswith(text, "ERROR: "):
                 errmsg = errmsg + ": " + text[7:]
             raise RuntimeError(errmsg)
+        if input_files:
+            for fname in input_files.values():
+                with open(fname, "rb") as f:
+                    shutil.copyfileobj(f, self._sockfile)
         if isinstance(payload, io.IOBase):
             shutil.copyfileobj(payload, self._sockfile)
         elif isinstance(payload, str):
@@ -248,11 +269,63 @@ class ReceptorControl:
         result = json.loads(text)
         return result
 
+    def watch_work(self, unit_ids=()):
+        self.connect()
+        self.writestr(" ".join(["work watch", *unit_ids]) + "\n")
+        text = self.readstr()
+        if not str.startswith(text, "Watching"):
+            errmsg = "Failed to watch work units"
+            if str.startswith(text, "ERROR: "):
+                errmsg = errmsg + ": " + text[7:]
+            raise RuntimeError(errmsg)
+        shutdown_write(self._socket)
+        try:
+            while True:
+                text = self.readstr()
+                if not text:
+                    break
+                yield json.loads(text)
+        finally:
+            self.close()
+
+    def get_work_artifact(self, unit_id, name):
+        self.connect()
+        commandMap = {
+            "command": "work",
+            "subcommand": "artifacts",
+            "unitid": unit_id,
+            "name": name,
+        }
+        self.writestr(json.dumps(commandMap) + "\n")
+        text = self.readstr()
+        m = re.compile("Streaming artifact (.+) of work unit (.+)").fullmatch(text)
+        if not m:
+            errmsg = "Failed to get artifact"
+            if str.startswith(text, "ERROR: "):
+                errmsg = errmsg + ": " + text[7:]
+            raise RuntimeError(errmsg)
+        shutdown_write(self._socket)
+        sockfile = self._sockfile
+        try:
+            self._socket.close()
+        finally:
+            self._socket = None
+            self._sockfile = None
+        return sockfile
+
     def get_work_results(
-        self, unit_id, startpos=0, return_socket=False, return_sockfile=True
+        self,
+        unit_id,
+        startpos=0,
+        return_socket=False,
+        return_sockfile=True,
+        stream="stdout",
     ):
         self.connect()
-        self.writestr(f"work results {unit_id} {startpos}\n")
+        if stream == "stdout":
+            self.writestr(f"work results {unit_id} {startpos}\n")
+        else:
+            self.writestr(f"work results {unit_id} {startpos} {stream}\n")
         text = self.readstr()
         m = re.compile("Streaming results for work unit (.+)").fullmatch(text)
         if not m:
@@ -698,6 +698,7 @@ func (n *LibNode) configureWorkSigning() error {
 		}
 
 		n.workceptorInstance.SigningKey = n.WorkSigningKey.PrivateKey
+		n.workceptorInstance.SigningKeyID = n.WorkSigningKey.KeyID
 	}
 
 	if n.WorkVerificationKey != nil {
@@ -707,6 +708,10 @@ func (n *LibNode) configureWorkSigning() error {
 		}
 
 		n.workceptorInstance.VerifyingKey = n.WorkVerificationKey.PublicKey
+		n.workceptorInstance.VerifyingKeyID = n.WorkVerificationKey.KeyID
+		n.workceptorInstance.VerifyingKeys = n.WorkVerificationKey.Keys
+		n.workceptorInstance.VerifyingIssuers = n.WorkVerificationKey.Issuers
+		n.workceptorInstance.AllowLegacySignatures = n.WorkVerificationKey.AllowLegacyTokens
 	}
 
 	return nil